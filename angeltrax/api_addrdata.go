@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -17,7 +18,7 @@ type CenterGroup struct {
 	GroupFatherID int    `json:"groupfatherid"`
 	GroupID       int    `json:"groupid"`
 	GroupName     string `json:"groupname"`
-	Remark        string `json:"string"`
+	Remark        string `json:"remark"`
 }
 
 type GetCenterDevicesResponse struct {
@@ -44,6 +45,15 @@ type CenterDevice struct {
 	TransmitPort   int    `json:"transmitport"`
 }
 
+// CameraName returns the name of the given (one-indexed) channel, or an empty string if it doesn't have one.
+func (d CenterDevice) CameraName(channel int) string {
+	names := strings.Split(d.CameraNames, ",")
+	if channel < 1 || channel > len(names) {
+		return ""
+	}
+	return strings.TrimSpace(names[channel-1])
+}
+
 func (c *Client) GetCenterGroups(ctx context.Context) (*GetCenterGroupsResponse, error) {
 	c.init()
 
@@ -75,3 +85,235 @@ func (c *Client) GetCenterDevices(ctx context.Context) (*GetCenterDevicesRespons
 
 	return &output, nil
 }
+
+// CenterActionResponse is the response to the requests that change groups and devices.
+type CenterActionResponse struct {
+	ErrorCode int    `json:"errorcode"`
+	Message   string `json:"message"`
+	Data      struct {
+		GroupID int `json:"groupid"` // This is only set when a group is created.
+	} `json:"data"`
+}
+
+// CenterGroupInput is the payload for creating and editing a group.
+type CenterGroupInput struct {
+	GroupID       int     `json:"groupid,omitempty"` // This is empty when creating a group.
+	GroupFatherID int     `json:"groupfatherid"`     // The parent group; zero for a top-level group.
+	GroupName     string  `json:"groupname"`
+	Remark        *string `json:"remark,omitempty"` // If this isn't set, an edit leaves the remark alone.
+}
+
+// UpdateCenterDeviceInput is the payload for editing a device; only the fields that are set are changed.
+type UpdateCenterDeviceInput struct {
+	DeviceID    string  `json:"deviceid"`
+	GroupID     *int    `json:"groupid,omitempty"`
+	CarLicense  *string `json:"carlicence,omitempty"`
+	Remark      *string `json:"remark,omitempty"`
+	CameraNames *string `json:"cname,omitempty"` // A comma-separated list of channel names.
+}
+
+// CreateCenterGroup creates a group; the new group's ID is in the response.
+func (c *Client) CreateCenterGroup(ctx context.Context, input CenterGroupInput) (*CenterActionResponse, error) {
+	input.GroupID = 0
+	return c.centerAction(ctx, "/center/group/add", input)
+}
+
+// UpdateCenterGroup renames a group, changes its remark, or moves it to a different parent group.  Every field
+// is sent, so start from the existing group.
+func (c *Client) UpdateCenterGroup(ctx context.Context, input CenterGroupInput) (*CenterActionResponse, error) {
+	if input.GroupID == 0 {
+		return nil, fmt.Errorf("missing group ID")
+	}
+	if input.GroupFatherID == input.GroupID {
+		return nil, fmt.Errorf("group %d cannot be its own parent", input.GroupID)
+	}
+	return c.centerAction(ctx, "/center/group/edit", input)
+}
+
+// DeleteCenterGroup deletes a group.  The CMS refuses to delete a group that still has devices or subgroups.
+func (c *Client) DeleteCenterGroup(ctx context.Context, groupID int) (*CenterActionResponse, error) {
+	return c.centerAction(ctx, "/center/group/delete", CenterGroupInput{GroupID: groupID})
+}
+
+// UpdateCenterDevice moves a device to a different group or changes its plate, remark, or camera names.
+func (c *Client) UpdateCenterDevice(ctx context.Context, input UpdateCenterDeviceInput) (*CenterActionResponse, error) {
+	if input.DeviceID == "" {
+		return nil, fmt.Errorf("missing device ID")
+	}
+	return c.centerAction(ctx, "/center/device/edit", input)
+}
+
+// centerAction sends a change to the addrdata service (or writes it to DryRunOutput).
+func (c *Client) centerAction(ctx context.Context, path string, input interface{}) (*CenterActionResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityCenterManagement); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("key", c.Key)
+	values.Set("random", fmt.Sprintf("%d", time.Now().Unix()))
+
+	var output CenterActionResponse
+	if dryRun, err := c.dryRunServiceRequest("addrdata", http.MethodPost, path, values, input); dryRun {
+		if err != nil {
+			return nil, err
+		}
+		return &output, nil
+	}
+	err := c.RawServiceJSONRequest(ctx, "addrdata", http.MethodPost, path, values, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	if output.ErrorCode != 0 {
+		if output.Message != "" {
+			return nil, fmt.Errorf("error code %d: %s", output.ErrorCode, output.Message)
+		}
+		return nil, fmt.Errorf("error code %d", output.ErrorCode)
+	}
+
+	return &output, nil
+}
+
+// CameraNamesWith returns the device's CameraNames with the given (one-indexed) channel renamed.
+func (d CenterDevice) CameraNamesWith(channel int, name string) (string, error) {
+	if channel < 1 {
+		return "", fmt.Errorf("invalid channel %d: channels start from 1", channel)
+	}
+	if strings.Contains(name, ",") {
+		return "", fmt.Errorf("camera names cannot contain commas")
+	}
+	var names []string
+	if d.CameraNames != "" {
+		names = strings.Split(d.CameraNames, ",")
+	}
+	count := d.ChannelCount
+	if channel > count {
+		count = channel
+	}
+	for len(names) < count {
+		names = append(names, "")
+	}
+	names[channel-1] = name
+	return strings.Join(names, ","), nil
+}
+
+// NetworkType is how a device is connected.
+type NetworkType int
+
+const (
+	NetworkTypeUnknown NetworkType = 0
+	NetworkTypeWired   NetworkType = 1
+	NetworkTypeWiFi    NetworkType = 2
+	NetworkType3G      NetworkType = 3
+	NetworkType4G      NetworkType = 4
+	NetworkType5G      NetworkType = 5
+)
+
+func (t NetworkType) String() string {
+	switch t {
+	case NetworkTypeUnknown:
+		return "unknown"
+	case NetworkTypeWired:
+		return "wired"
+	case NetworkTypeWiFi:
+		return "wifi"
+	case NetworkType3G:
+		return "3g"
+	case NetworkType4G:
+		return "4g"
+	case NetworkType5G:
+		return "5g"
+	}
+	return fmt.Sprintf("unknown (%d)", int(t))
+}
+
+// StorageStatus is the state of a device's storage.
+type StorageStatus int
+
+const (
+	StorageStatusNone   StorageStatus = 0 // There is no disk (or it was not detected).
+	StorageStatusNormal StorageStatus = 1
+	StorageStatusFault  StorageStatus = 2
+	StorageStatusFull   StorageStatus = 3
+)
+
+func (s StorageStatus) String() string {
+	switch s {
+	case StorageStatusNone:
+		return "none"
+	case StorageStatusNormal:
+		return "normal"
+	case StorageStatusFault:
+		return "fault"
+	case StorageStatusFull:
+		return "full"
+	}
+	return fmt.Sprintf("unknown (%d)", int(s))
+}
+
+type GetDeviceStatusResponse struct {
+	ErrorCode int            `json:"errorcode"`
+	Data      []DeviceStatus `json:"data"`
+}
+
+// DeviceStatus is the current state of a device.
+type DeviceStatus struct {
+	DeviceID        string        `json:"deviceid"`
+	Online          int           `json:"online"`         // 1 if the device is connected.
+	LastOnlineTime  string        `json:"lastonlinetime"` // yyyy-mm-dd hh:mm:ss; the last heartbeat.
+	NetworkType     NetworkType   `json:"nettype"`
+	StorageStatus   StorageStatus `json:"diskstatus"`
+	RecordingStatus int           `json:"recordstatus"` // A bit field of the channels that are recording; bit 0 is channel 1.
+}
+
+func (s DeviceStatus) IsOnline() bool {
+	return s.Online == 1
+}
+
+// LastSeen returns the time of the last heartbeat, in the given location (which should be the server's).
+func (s DeviceStatus) LastSeen(location *time.Location) (time.Time, error) {
+	if s.LastOnlineTime == "" {
+		return time.Time{}, fmt.Errorf("the device has never been seen")
+	}
+	return time.ParseInLocation(RecordingTimeFormat, s.LastOnlineTime, location)
+}
+
+// RecordingChannels returns the one-indexed channels that are recording.
+func (s DeviceStatus) RecordingChannels() []int {
+	var channels []int
+	for i := 0; i < 32; i++ {
+		if s.RecordingStatus&(1<<uint(i)) != 0 {
+			channels = append(channels, i+1)
+		}
+	}
+	return channels
+}
+
+// GetDeviceStatus returns the current status of the devices; if no device IDs are given, every device is returned.
+func (c *Client) GetDeviceStatus(ctx context.Context, deviceIDs ...string) (*GetDeviceStatusResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityDeviceStatus); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("key", c.Key)
+	values.Set("random", fmt.Sprintf("%d", time.Now().Unix()))
+	if len(deviceIDs) > 0 {
+		values.Set("deviceid", strings.Join(deviceIDs, ","))
+	}
+
+	var output GetDeviceStatusResponse
+	err := c.RawServiceRequest(ctx, "addrdata", http.MethodGet, "/center/devicestatus", values, nil, &output)
+	if err != nil {
+		return nil, err
+	}
+	if output.ErrorCode != 0 {
+		return nil, fmt.Errorf("error code %d", output.ErrorCode)
+	}
+
+	return &output, nil
+}
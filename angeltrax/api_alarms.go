@@ -0,0 +1,218 @@
+package angeltrax
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AlarmType is the type code of an alarm, as configured on the device.
+type AlarmType int
+
+// AlarmHandleStatus is whether someone has dealt with an alarm.
+type AlarmHandleStatus int
+
+const (
+	AlarmHandleStatusUnhandled    AlarmHandleStatus = 0
+	AlarmHandleStatusHandled      AlarmHandleStatus = 1
+	AlarmHandleStatusAcknowledged AlarmHandleStatus = 2
+)
+
+func (s AlarmHandleStatus) String() string {
+	switch s {
+	case AlarmHandleStatusUnhandled:
+		return "unhandled"
+	case AlarmHandleStatusHandled:
+		return "handled"
+	case AlarmHandleStatusAcknowledged:
+		return "acknowledged"
+	}
+	return fmt.Sprintf("unknown (%d)", int(s))
+}
+
+type QueryAlarmsInput struct {
+	DeviceIDs    []string // If empty, the alarms for every device are returned.
+	GroupID      int      // If non-zero, only alarms from devices in this group are returned.
+	AlarmTypes   []AlarmType
+	HandleStatus *AlarmHandleStatus
+	Start        time.Time // The times are sent as-is in their own location, which should be the device's.
+	End          time.Time
+	Page         int // The one-indexed page; this defaults to 1.
+	Rows         int // The number of rows per page; this defaults to DefaultRowCount.
+}
+
+type QueryAlarmsResponse struct {
+	Total int     `json:"total"`
+	Rows  []Alarm `json:"rows"`
+}
+
+// Alarm is an alarm raised by a device.
+type Alarm struct {
+	AlarmID      string            `json:"AlarmID"`
+	DeviceID     string            `json:"Device"`
+	CarLicense   string            `json:"Carlicense"`
+	GroupID      int               `json:"GroupID"`
+	Channel      int               `json:"Channel"` // The one-index of the channel; zero if the alarm isn't tied to a channel.
+	AlarmType    AlarmType         `json:"AlarmType"`
+	AlarmName    string            `json:"AlarmName"`
+	StartTime    string            `json:"StartTime"` // yyyy-mm-dd hh:mm:ss
+	EndTime      string            `json:"EndTime"`   // yyyy-mm-dd hh:mm:ss
+	Latitude     float64           `json:"Lat"`
+	Longitude    float64           `json:"Lng"`
+	Speed        float64           `json:"Speed"` // In km/h.
+	Content      string            `json:"Content"`
+	HandleStatus AlarmHandleStatus `json:"HandleStatus"`
+	HandleUser   string            `json:"HandleUser"`
+	HandleTime   string            `json:"HandleTime"` // yyyy-mm-dd hh:mm:ss
+	HandleRemark string            `json:"HandleRemark"`
+}
+
+// QueryAlarms returns a single page of alarms from the alarm center.
+//
+// This requires RegisterLogin to have been called first.
+func (c *Client) QueryAlarms(ctx context.Context, input QueryAlarmsInput) (*QueryAlarmsResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityAlarmCenter); err != nil {
+		return nil, err
+	}
+
+	if input.Page < 1 {
+		input.Page = 1
+	}
+	if input.Rows < 1 {
+		input.Rows = DefaultRowCount
+	}
+
+	values := url.Values{}
+
+	var alarmTypeStrings []string
+	for _, alarmType := range input.AlarmTypes {
+		alarmTypeStrings = append(alarmTypeStrings, fmt.Sprintf("%d", alarmType))
+	}
+	inputValues := url.Values{}
+	inputValues.Set("action", "queryAlarm")
+	inputValues.Set("Device", strings.Join(input.DeviceIDs, ","))
+	if input.GroupID != 0 {
+		inputValues.Set("GroupID", fmt.Sprintf("%d", input.GroupID))
+	}
+	inputValues.Set("AlarmType", strings.Join(alarmTypeStrings, ","))
+	if input.HandleStatus != nil {
+		inputValues.Set("HandleStatus", fmt.Sprintf("%d", *input.HandleStatus))
+	}
+	if !input.Start.IsZero() {
+		inputValues.Set("StartTime", input.Start.Format(RecordingTimeFormat))
+	}
+	if !input.End.IsZero() {
+		inputValues.Set("EndTime", input.End.Format(RecordingTimeFormat))
+	}
+	inputValues.Set("page", fmt.Sprintf("%d", input.Page))
+	inputValues.Set("rows", fmt.Sprintf("%d", input.Rows))
+	inputValuesString := inputValues.Encode()
+
+	var output QueryAlarmsResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AlarmCenter/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// QueryAllAlarms returns every alarm matching the query, fetching one page at a time.
+//
+// The limit is the maximum number of alarms to return; zero means no limit.
+func (c *Client) QueryAllAlarms(ctx context.Context, input QueryAlarmsInput, limit int) ([]Alarm, error) {
+	if input.Page < 1 {
+		input.Page = 1
+	}
+	var alarms []Alarm
+	for {
+		output, err := c.QueryAlarms(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		alarms = append(alarms, output.Rows...)
+		if limit > 0 && len(alarms) >= limit {
+			return alarms[:limit], nil
+		}
+		if len(output.Rows) == 0 || len(alarms) >= output.Total {
+			return alarms, nil
+		}
+		input.Page++
+	}
+}
+
+type GetAlarmResponse struct {
+	Result bool  `json:"result"`
+	Data   Alarm `json:"data"`
+}
+
+// GetAlarm returns a single alarm.
+func (c *Client) GetAlarm(ctx context.Context, alarmID string) (*Alarm, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityAlarmCenter); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+
+	inputValues := url.Values{}
+	inputValues.Set("action", "getAlarm")
+	inputValues.Set("AlarmID", alarmID)
+	inputValuesString := inputValues.Encode()
+
+	var output GetAlarmResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AlarmCenter/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+	if !output.Result {
+		return nil, fmt.Errorf("alarm %s was not found", alarmID)
+	}
+
+	return &output.Data, nil
+}
+
+// AlarmActionResponse is the response to the actions that modify alarms.
+type AlarmActionResponse struct {
+	Result bool `json:"result"`
+}
+
+// AcknowledgeAlarms marks the alarms as seen, with an optional remark.
+func (c *Client) AcknowledgeAlarms(ctx context.Context, alarmIDs []string, remark string) (*AlarmActionResponse, error) {
+	return c.alarmAction(ctx, "ackAlarm", alarmIDs, remark)
+}
+
+// HandleAlarms marks the alarms as dealt with, with a remark describing what was done.
+func (c *Client) HandleAlarms(ctx context.Context, alarmIDs []string, remark string) (*AlarmActionResponse, error) {
+	return c.alarmAction(ctx, "handleAlarm", alarmIDs, remark)
+}
+
+func (c *Client) alarmAction(ctx context.Context, action string, alarmIDs []string, remark string) (*AlarmActionResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityAlarmCenter); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+
+	inputValues := url.Values{}
+	inputValues.Set("action", action)
+	inputValues.Set("AlarmID", strings.Join(alarmIDs, ","))
+	inputValues.Set("Remark", remark)
+	inputValuesString := inputValues.Encode()
+
+	var output AlarmActionResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AlarmCenter/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
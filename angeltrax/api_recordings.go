@@ -0,0 +1,209 @@
+package angeltrax
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecordingTimeFormat is the format that the CMS uses for recording timestamps (in the device's local time).
+const RecordingTimeFormat = "2006-01-02 15:04:05"
+
+type SearchRecordingsInput struct {
+	DeviceID  string
+	Channels  []int     // One-indexed; if empty, all channels are searched.
+	Start     time.Time // The times are sent as-is in their own location, which should be the device's.
+	End       time.Time
+	VideoType VideoType
+}
+
+type SearchRecordingsResponse struct {
+	Total int                `json:"total"`
+	Rows  []RecordingSegment `json:"rows"`
+}
+
+// RecordingSegment is a span of video that exists on the device.
+type RecordingSegment struct {
+	Channel   int       `json:"Channel"`   // The one-index of the channel.
+	StartTime string    `json:"StartTime"` // yyyy-mm-dd hh:mm:ss
+	EndTime   string    `json:"EndTime"`   // yyyy-mm-dd hh:mm:ss
+	VideoType VideoType `json:"VideoType"`
+	FileSize  int64     `json:"FileSize"` // In bytes.
+
+	// These are filled in from StartTime and EndTime by SearchRecordings.
+	Start time.Time `json:"-"`
+	End   time.Time `json:"-"`
+}
+
+// TimeRange is a span of time.
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (r TimeRange) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// SearchRecordings searches the device's storage for the video segments in the given window.
+//
+// This is the same search that the web client runs when previewing a new AutoDownload task.
+func (c *Client) SearchRecordings(ctx context.Context, input SearchRecordingsInput) (*SearchRecordingsResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityAutoDownload); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+
+	var channelStrings []string
+	for _, channel := range input.Channels {
+		channelStrings = append(channelStrings, fmt.Sprintf("%d", channel))
+	}
+	inputValues := url.Values{}
+	inputValues.Set("action", "searchFile")
+	inputValues.Set("nodeType", "1")
+	inputValues.Set("nodeName", input.DeviceID)
+	inputValues.Set("Channel", strings.Join(channelStrings, ","))
+	inputValues.Set("StartTime", input.Start.Format(RecordingTimeFormat))
+	inputValues.Set("EndTime", input.End.Format(RecordingTimeFormat))
+	inputValues.Set("VideoType", fmt.Sprintf("%d", input.VideoType))
+	inputValuesString := inputValues.Encode()
+
+	var output SearchRecordingsResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AutoDownload/Task/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range output.Rows {
+		output.Rows[i].Start, err = time.ParseInLocation(RecordingTimeFormat, output.Rows[i].StartTime, input.Start.Location())
+		if err != nil {
+			return nil, fmt.Errorf("could not parse start time %q: %w", output.Rows[i].StartTime, err)
+		}
+		output.Rows[i].End, err = time.ParseInLocation(RecordingTimeFormat, output.Rows[i].EndTime, input.Start.Location())
+		if err != nil {
+			return nil, fmt.Errorf("could not parse end time %q: %w", output.Rows[i].EndTime, err)
+		}
+	}
+	sort.SliceStable(output.Rows, func(i, j int) bool {
+		if output.Rows[i].Channel != output.Rows[j].Channel {
+			return output.Rows[i].Channel < output.Rows[j].Channel
+		}
+		return output.Rows[i].Start.Before(output.Rows[j].Start)
+	})
+
+	return &output, nil
+}
+
+// RecordingGaps returns the parts of the window that are not covered by any segment, for each of the given channels.
+//
+// Gaps shorter than the tolerance are ignored, since segments usually have a second or two between them.
+func RecordingGaps(segments []RecordingSegment, channels []int, start time.Time, end time.Time, tolerance time.Duration) map[int][]TimeRange {
+	gaps := map[int][]TimeRange{}
+	for _, channel := range channels {
+		var channelSegments []RecordingSegment
+		for _, segment := range segments {
+			if segment.Channel == channel {
+				channelSegments = append(channelSegments, segment)
+			}
+		}
+		sort.Slice(channelSegments, func(i, j int) bool {
+			return channelSegments[i].Start.Before(channelSegments[j].Start)
+		})
+
+		covered := start
+		for _, segment := range channelSegments {
+			if segment.Start.Sub(covered) > tolerance {
+				gaps[channel] = append(gaps[channel], TimeRange{Start: covered, End: segment.Start})
+			}
+			if segment.End.After(covered) {
+				covered = segment.End
+			}
+		}
+		if end.Sub(covered) > tolerance {
+			gaps[channel] = append(gaps[channel], TimeRange{Start: covered, End: end})
+		}
+	}
+	return gaps
+}
+
+// RecordingTaskInputs turns found segments into the inputs to download them.
+//
+// Tasks cover a daily time window, so this creates one task per day, covering every segment on that day (segments
+// that cross midnight are split).  Only the channels that have segments on a given day are included.
+func RecordingTaskInputs(deviceID string, taskName string, segments []RecordingSegment, videoType VideoType) []CreateAutoDownloadTaskInput {
+	type day struct {
+		start    time.Time
+		end      time.Time
+		channels map[int]bool
+	}
+	days := map[string]*day{}
+	var dayKeys []string
+	add := func(channel int, start time.Time, end time.Time) {
+		key := start.Format("2006-01-02")
+		d, ok := days[key]
+		if !ok {
+			d = &day{start: start, end: end, channels: map[int]bool{}}
+			days[key] = d
+			dayKeys = append(dayKeys, key)
+		}
+		if start.Before(d.start) {
+			d.start = start
+		}
+		if end.After(d.end) {
+			d.end = end
+		}
+		d.channels[channel] = true
+	}
+	for _, segment := range segments {
+		start := segment.Start
+		for start.Before(segment.End) {
+			midnight := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, start.Location())
+			end := segment.End
+			if end.After(midnight) {
+				// The day's window ends at the last second of the day.
+				add(segment.Channel, start, midnight.Add(-time.Second))
+				start = midnight
+				continue
+			}
+			add(segment.Channel, start, end)
+			break
+		}
+	}
+	sort.Strings(dayKeys)
+
+	var inputs []CreateAutoDownloadTaskInput
+	for _, key := range dayKeys {
+		d := days[key]
+		var channels []int
+		for channel := range d.channels {
+			channels = append(channels, channel)
+		}
+		sort.Ints(channels)
+
+		name := taskName
+		if len(dayKeys) > 1 {
+			name = fmt.Sprintf("%s (%s)", taskName, key)
+		}
+		inputs = append(inputs, CreateAutoDownloadTaskInput{
+			TaskName:      name,
+			DeviceID:      deviceID,
+			StartExecute:  key,
+			EndExecute:    key,
+			StartTime:     d.start.Format("15:04:05"),
+			EndTime:       d.end.Format("15:04:05"),
+			TaskType:      TaskTypeVideo,
+			Period:        TaskPeriodOnce,
+			TaskChannels:  channels,
+			EffectiveDays: 7,
+			VideoType:     videoType,
+		})
+	}
+	return inputs
+}
@@ -0,0 +1,241 @@
+package angeltrax
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SnapshotPollInterval is how often Snapshot checks whether the device has sent the images yet.
+var SnapshotPollInterval = 2 * time.Second
+
+// CaptureStatus is the status of a single channel's capture.
+type CaptureStatus int
+
+const (
+	CaptureStatusPending  CaptureStatus = 0
+	CaptureStatusFinished CaptureStatus = 1
+	CaptureStatusFailed   CaptureStatus = 2
+)
+
+func (s CaptureStatus) String() string {
+	switch s {
+	case CaptureStatusPending:
+		return "pending"
+	case CaptureStatusFinished:
+		return "finished"
+	case CaptureStatusFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("%d", int(s))
+}
+
+type CaptureResponse struct {
+	Result    bool   `json:"result"`
+	CommandID string `json:"CommandID"` // This is used to look up the result.
+	Message   string `json:"msg"`
+}
+
+type QueryCaptureResponse struct {
+	Total int          `json:"total"`
+	Rows  []CaptureRow `json:"rows"`
+}
+
+type CaptureRow struct {
+	CommandID    string        `json:"CommandID"`
+	Device       string        `json:"Device"`
+	Channel      int           `json:"Channel"` // The one-index of the channel.
+	Status       CaptureStatus `json:"Status"`
+	CaptureTime  string        `json:"CaptureTime"` // yyyy-mm-dd hh:mm:ss (in the device's time)
+	FileSource   string        `json:"FileSource"`  // The path of the image on the CMS.
+	ErrorMessage string        `json:"ErrorMessage"`
+}
+
+// Snapshot is a still image from a single channel.
+type Snapshot struct {
+	DeviceID string
+	Channel  int       // The one-index of the channel.
+	Time     time.Time // When the image was captured (in the local time zone); this is zero if unknown.
+	Data     []byte    // The JPEG image.
+	Err      error     // This is set if the capture failed.
+}
+
+// Capture asks the device to capture a still image from each of the given channels.  The images are
+// collected with QueryCapture using the returned command ID.
+//
+// This requires RegisterLogin to have been called first.
+func (c *Client) Capture(ctx context.Context, deviceID string, channels []int) (*CaptureResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilitySnapshot); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+
+	var channelStrings []string
+	for _, channel := range channels {
+		channelStrings = append(channelStrings, fmt.Sprintf("%d", channel))
+	}
+	inputValues := url.Values{}
+	inputValues.Set("action", "capture")
+	inputValues.Set("Device", deviceID)
+	inputValues.Set("Channel", strings.Join(channelStrings, ","))
+	inputValuesString := inputValues.Encode()
+
+	var output CaptureResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/Snapshot/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+	if !output.Result {
+		return nil, fmt.Errorf("capture failed: %s", output.Message)
+	}
+
+	return &output, nil
+}
+
+// QueryCapture returns the status of each channel of a capture.
+func (c *Client) QueryCapture(ctx context.Context, commandID string) (*QueryCaptureResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilitySnapshot); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+
+	inputValues := url.Values{}
+	inputValues.Set("action", "queryCapture")
+	inputValues.Set("CommandID", commandID)
+	inputValuesString := inputValues.Encode()
+
+	var output QueryCaptureResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/Snapshot/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// DownloadCapture returns the image of a finished capture.
+func (c *Client) DownloadCapture(ctx context.Context, row CaptureRow) ([]byte, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilitySnapshot); err != nil {
+		return nil, err
+	}
+
+	base, err := c.ServiceBaseURL("wcms")
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	values.Set("file", row.FileSource)
+	downloadURL := base + "/Plugin/Snapshot/Download.ashx?" + values.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Downloading: %s", downloadURL)
+
+	response, err := c.doRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status %d", response.StatusCode)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// Snapshot captures a still image from each of the given (one-indexed) channels of a device.
+//
+// The CMS relays the request to the device and the device uploads the images when it gets around to it, so
+// this polls until every channel has either finished or failed.  The context should have a deadline; if it
+// ends first, the channels that are still pending have the context's error.
+//
+// There is one result per channel, in the same order as the channels (a channel that is given more than once
+// is only captured once); the error is only for failures that affect every channel.
+//
+// This requires RegisterLogin to have been called first.
+func (c *Client) Snapshot(ctx context.Context, deviceID string, channels []int) ([]Snapshot, error) {
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no channels")
+	}
+	// The results are matched up by channel, so each channel can only be pending once.
+	seen := map[int]bool{}
+	var uniqueChannels []int
+	for _, channel := range channels {
+		if !seen[channel] {
+			seen[channel] = true
+			uniqueChannels = append(uniqueChannels, channel)
+		}
+	}
+	channels = uniqueChannels
+
+	captureResponse, err := c.Capture(ctx, deviceID, channels)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Capture command: %s", captureResponse.CommandID)
+
+	snapshots := make([]Snapshot, len(channels))
+	pending := map[int]int{} // This maps a channel to its index in the output.
+	for i, channel := range channels {
+		snapshots[i] = Snapshot{DeviceID: deviceID, Channel: channel}
+		pending[channel] = i
+	}
+
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			for _, i := range pending {
+				snapshots[i].Err = ctx.Err()
+			}
+			return snapshots, nil
+		case <-time.After(SnapshotPollInterval):
+		}
+
+		queryResponse, err := c.QueryCapture(ctx, captureResponse.CommandID)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			logrus.Warnf("Could not check on capture %s: [%T] %v", captureResponse.CommandID, err, err)
+			continue
+		}
+		for _, row := range queryResponse.Rows {
+			i, ok := pending[row.Channel]
+			if !ok {
+				continue
+			}
+			switch row.Status {
+			case CaptureStatusFinished:
+				delete(pending, row.Channel)
+				if captureTime, err := time.ParseInLocation(RecordingTimeFormat, row.CaptureTime, time.Local); err == nil {
+					snapshots[i].Time = captureTime
+				}
+				snapshots[i].Data, snapshots[i].Err = c.DownloadCapture(ctx, row)
+			case CaptureStatusFailed:
+				delete(pending, row.Channel)
+				message := row.ErrorMessage
+				if message == "" {
+					message = "unknown error"
+				}
+				snapshots[i].Err = fmt.Errorf("capture failed: %s", message)
+			}
+		}
+	}
+	return snapshots, nil
+}
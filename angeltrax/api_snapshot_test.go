@@ -0,0 +1,95 @@
+package angeltrax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// snapshotServer is a stand-in for the Snapshot plugin; every channel finishes on the second query.
+type snapshotServer struct {
+	t *testing.T
+
+	mutex    sync.Mutex
+	channels string // The channels of the capture.
+	queries  int
+}
+
+func (s *snapshotServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/Plugin/Snapshot/Download.ashx" {
+		_, _ = fmt.Fprintf(w, "image %s", r.URL.Query().Get("file"))
+		return
+	}
+	form, err := readTestForm(r)
+	if err != nil {
+		s.t.Errorf("could not read the request: %v", err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var output interface{}
+	switch r.URL.Path + "|" + form.Get("action") {
+	case "/Plugin/Snapshot/Default.ashx|capture":
+		s.channels = form.Get("Channel")
+		output = CaptureResponse{Result: true, CommandID: "C1"}
+	case "/Plugin/Snapshot/Default.ashx|queryCapture":
+		s.queries++
+		var rows []CaptureRow
+		for _, channel := range []int{1, 2} {
+			row := CaptureRow{CommandID: "C1", Channel: channel, Status: CaptureStatusPending}
+			if s.queries > 1 {
+				row.Status = CaptureStatusFinished
+				row.CaptureTime = "2023-04-05 06:00:00"
+				row.FileSource = fmt.Sprintf("channel%d.jpg", channel)
+			}
+			rows = append(rows, row)
+		}
+		output = QueryCaptureResponse{Total: len(rows), Rows: rows}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(output)
+}
+
+func TestSnapshot(t *testing.T) {
+	interval := SnapshotPollInterval
+	SnapshotPollInterval = time.Millisecond
+	defer func() { SnapshotPollInterval = interval }()
+
+	server := &snapshotServer{t: t}
+	client := newTestClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	snapshots, err := client.Snapshot(ctx, "D1", []int{2, 1, 2})
+	if err != nil {
+		t.Fatalf("could not take the snapshots: %v", err)
+	}
+
+	if server.channels != "2,1" {
+		t.Errorf("wrong channels captured: %q", server.channels)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("wrong number of snapshots: %d", len(snapshots))
+	}
+	for i, channel := range []int{2, 1} {
+		snapshot := snapshots[i]
+		if snapshot.Err != nil {
+			t.Errorf("snapshot %d: %v", i, snapshot.Err)
+			continue
+		}
+		if snapshot.DeviceID != "D1" || snapshot.Channel != channel || snapshot.Time.IsZero() {
+			t.Errorf("snapshot %d: wrong snapshot: %+v", i, snapshot)
+		}
+		if expected := fmt.Sprintf("image channel%d.jpg", channel); string(snapshot.Data) != expected {
+			t.Errorf("snapshot %d: wrong data: %q", i, snapshot.Data)
+		}
+	}
+}
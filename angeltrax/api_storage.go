@@ -0,0 +1,372 @@
+package angeltrax
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+type ListStoredFilesInput struct {
+	TaskID   string `form:"TaskID"`
+	DeviceID string `form:"Device"`
+	Channel  int    `form:"Channel"` // The one-index of the channel; zero means all channels.
+	Date     string `form:"Date"`    // yyyy-mm-dd (optional)
+}
+
+type ListStoredFilesResponse struct {
+	Total int          `json:"total"`
+	Rows  []StoredFile `json:"rows"`
+}
+
+// StoredFile is a recording that the CMS has finished downloading from a device.
+type StoredFile struct {
+	TaskID     int    `json:"TaskID"`
+	DeviceID   string `json:"Device"`
+	Channel    int    `json:"Channel"`   // The one-index of the channel.
+	Date       string `json:"Date"`      // yyyy-mm-dd
+	StartTime  string `json:"StartTime"` // hh:mm:ss
+	EndTime    string `json:"EndTime"`   // hh:mm:ss
+	FileName   string `json:"FileName"`
+	FileSource string `json:"FileSource"` // This matches GlobalReportAutoDownloadTaskRow.FileSource.
+	FileSize   int64  `json:"FileSize"`   // In bytes.
+	MD5        string `json:"MD5"`        // Hex-encoded; this may be empty.
+}
+
+// LocalPath returns the path of the file relative to a download directory: <plate>/<date>/<channel>/<file name>.
+//
+// If the plate is empty, the device ID is used instead.
+func (f StoredFile) LocalPath(carLicense string) string {
+	if carLicense == "" {
+		carLicense = f.DeviceID
+	}
+	fileName := f.FileName
+	if fileName == "" {
+		fileName = filepath.Base(strings.ReplaceAll(f.FileSource, "\\", "/"))
+	}
+	return filepath.Join(safePathComponent(carLicense), safePathComponent(f.Date), fmt.Sprintf("%d", f.Channel), safePathComponent(fileName))
+}
+
+// safePathComponent makes a value safe to use as a single path component.
+func safePathComponent(value string) string {
+	value = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "\x00", "_").Replace(value)
+	if value == "" || value == "." || value == ".." {
+		value = "_"
+	}
+	return value
+}
+
+// ListStoredFiles lists the files that the CMS has stored for a task, fetching one page at a time until every
+// file has been returned.
+//
+// This uses the same handler as GlobalReportAutoDownloadTask, but the "queryFile" action returns the
+// stored files rather than the download progress.
+func (c *Client) ListStoredFiles(ctx context.Context, input ListStoredFilesInput) (*ListStoredFilesResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityAutoDownload); err != nil {
+		return nil, err
+	}
+
+	output := &ListStoredFilesResponse{}
+	for page := 1; ; page++ {
+		pageOutput, err := c.listStoredFilesPage(ctx, input, page)
+		if err != nil {
+			return nil, err
+		}
+		output.Total = pageOutput.Total
+		output.Rows = append(output.Rows, pageOutput.Rows...)
+		if len(pageOutput.Rows) == 0 || len(output.Rows) >= output.Total {
+			return output, nil
+		}
+	}
+}
+
+func (c *Client) listStoredFilesPage(ctx context.Context, input ListStoredFilesInput, page int) (*ListStoredFilesResponse, error) {
+	values := url.Values{}
+
+	inputValues := url.Values{}
+	inputValues.Set("action", "queryFile")
+	inputValues.Set("Device", input.DeviceID)
+	inputValues.Set("Date", input.Date)
+	inputValues.Set("TaskID", input.TaskID)
+	if input.Channel > 0 {
+		inputValues.Set("Channel", fmt.Sprintf("%d", input.Channel))
+	}
+	inputValues.Set("page", fmt.Sprintf("%d", page))
+	inputValues.Set("rows", fmt.Sprintf("%d", DefaultRowCount))
+	inputValuesString := inputValues.Encode()
+
+	var output ListStoredFilesResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AutoDownload/GlobalReport/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// DownloadStoredFile downloads a stored file to the given local filename.
+//
+// The data is written to "<filename>.part" first; if that already exists, the download resumes from where
+// it left off using an HTTP range request.  Once complete, the size and MD5 are verified and the file is renamed
+// into place.  If the listing doesn't give the size, the total size from the response is used; a file whose
+// size can't be determined at all is never treated as complete.  If the file already exists and can be
+// verified against the listing, nothing is downloaded.
+//
+// Note that the download handler ("/Plugin/AutoDownload/GlobalReport/Download.ashx", given the file source)
+// is a guess based on the naming of the other handlers; it has not been verified against a real server.
+func (c *Client) DownloadStoredFile(ctx context.Context, file StoredFile, filename string) error {
+	c.init()
+
+	if err := c.requireCapability(CapabilityAutoDownload); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filename); err == nil {
+		err = verifyStoredFile(file, filename, file.FileSize)
+		if err == nil {
+			return nil
+		}
+		logrus.Debugf("Existing file %q could not be verified; downloading again: %v", filename, err)
+	}
+
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+
+	base, err := c.ServiceBaseURL("wcms")
+	if err != nil {
+		return err
+	}
+	values := url.Values{}
+	values.Set("file", file.FileSource)
+	downloadURL := base + "/Plugin/AutoDownload/GlobalReport/Download.ashx?" + values.Encode()
+
+	partFilename := filename + ".part"
+	var offset int64
+	if info, err := os.Stat(partFilename); err == nil {
+		offset = info.Size()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	logrus.Debugf("Downloading: %s (offset %d)", downloadURL, offset)
+
+	response, err := c.doRequest(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// The total size of the file, according to the response; -1 means unknown.
+	total := int64(-1)
+	flags := os.O_CREATE | os.O_WRONLY
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		var start int64
+		start, total, err = parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if start != offset {
+			return fmt.Errorf("asked for the range from %d, but got the range from %d", offset, start)
+		}
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// The server ignored the range (or there wasn't one), so start over.
+		total = response.ContentLength
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// We may already have everything; the verification will tell.
+		_, total, _ = parseContentRange(response.Header.Get("Content-Range"))
+		flags = 0
+	default:
+		return fmt.Errorf("http status %d", response.StatusCode)
+	}
+
+	if flags != 0 {
+		handle, err := os.OpenFile(partFilename, flags, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(handle, response.Body)
+		closeErr := handle.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+
+	expectedSize := file.FileSize
+	if expectedSize <= 0 {
+		expectedSize = total
+	}
+	err = verifyStoredFile(file, partFilename, expectedSize)
+	if err != nil {
+		// A bad part file can't be resumed, so get rid of it.
+		_ = os.Remove(partFilename)
+		return err
+	}
+
+	return os.Rename(partFilename, filename)
+}
+
+// parseContentRange parses a "Content-Range" header ("bytes <start>-<end>/<total>" or "bytes */<total>"), and
+// returns the start and the total size; the total is -1 if it is unknown ("*").
+func parseContentRange(value string) (int64, int64, error) {
+	var start int64
+	var total int64 = -1
+	invalid := fmt.Errorf("invalid content range %q", value)
+
+	rangeString, totalString, found := strings.Cut(strings.TrimPrefix(strings.TrimSpace(value), "bytes "), "/")
+	if !found {
+		return 0, -1, invalid
+	}
+	if rangeString != "*" {
+		startString, _, found := strings.Cut(rangeString, "-")
+		if !found {
+			return 0, -1, invalid
+		}
+		var err error
+		start, err = strconv.ParseInt(startString, 10, 64)
+		if err != nil {
+			return 0, -1, invalid
+		}
+	}
+	if totalString != "*" {
+		var err error
+		total, err = strconv.ParseInt(totalString, 10, 64)
+		if err != nil {
+			return 0, -1, invalid
+		}
+	}
+	return start, total, nil
+}
+
+// ErrChecksumMismatch is returned when a downloaded file does not match its expected size or MD5.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrUnverifiable is returned when neither the size nor the MD5 of a file is known, so a download can't be
+// told apart from a truncated one.
+var ErrUnverifiable = errors.New("the file cannot be verified")
+
+// verifyStoredFile checks the file against the expected size (if positive) and the MD5 (if known).
+func verifyStoredFile(file StoredFile, filename string, expectedSize int64) error {
+	if expectedSize <= 0 && file.MD5 == "" {
+		return fmt.Errorf("%w: %s: neither the size nor the MD5 is known", ErrUnverifiable, filename)
+	}
+
+	handle, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(hash, handle)
+	if err != nil {
+		return err
+	}
+	if expectedSize > 0 && size != expectedSize {
+		return fmt.Errorf("%w: %s: expected %d bytes, got %d", ErrChecksumMismatch, filename, expectedSize, size)
+	}
+	if file.MD5 != "" {
+		sum := hex.EncodeToString(hash.Sum(nil))
+		if !strings.EqualFold(sum, file.MD5) {
+			return fmt.Errorf("%w: %s: expected MD5 %s, got %s", ErrChecksumMismatch, filename, file.MD5, sum)
+		}
+	}
+	return nil
+}
+
+// FetchStoredFilesOptions controls FetchStoredFiles.
+type FetchStoredFilesOptions struct {
+	Directory   string            // The base directory; the files are laid out according to StoredFile.LocalPath.
+	Parallelism int               // The maximum number of simultaneous downloads; this defaults to 4.
+	CarLicenses map[string]string // Device ID to plate, for the directory layout.
+	OnResult    func(FetchStoredFileResult)
+}
+
+// FetchStoredFileResult is the outcome of downloading a single file.
+type FetchStoredFileResult struct {
+	File     StoredFile
+	Filename string
+	Error    error
+}
+
+// FetchStoredFiles downloads the files, a few at a time, and returns the result for each one (in the same order).
+func (c *Client) FetchStoredFiles(ctx context.Context, files []StoredFile, options FetchStoredFilesOptions) []FetchStoredFileResult {
+	if options.Parallelism < 1 {
+		options.Parallelism = 4
+	}
+
+	results := make([]FetchStoredFileResult, len(files))
+
+	// Two files may end up with the same local path (such as when the same file is listed twice), so only one
+	// download at a time may use a path; otherwise they would both write to the same part file.
+	sources := map[string]string{}
+	pathMutexes := map[string]*sync.Mutex{}
+	for i, file := range files {
+		results[i] = FetchStoredFileResult{
+			File:     file,
+			Filename: filepath.Join(options.Directory, file.LocalPath(options.CarLicenses[file.DeviceID])),
+		}
+		if source, ok := sources[results[i].Filename]; ok {
+			if source != file.FileSource {
+				results[i].Error = fmt.Errorf("%s: the local path is already used by %s", file.FileSource, source)
+			}
+			continue
+		}
+		sources[results[i].Filename] = file.FileSource
+		pathMutexes[results[i].Filename] = &sync.Mutex{}
+	}
+
+	var waitGroup sync.WaitGroup
+	var mutex sync.Mutex
+	semaphore := make(chan struct{}, options.Parallelism)
+	for i := range files {
+		waitGroup.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer waitGroup.Done()
+			defer func() { <-semaphore }()
+
+			result := results[i]
+			if result.Error == nil {
+				pathMutex := pathMutexes[result.Filename]
+				pathMutex.Lock()
+				result.Error = c.DownloadStoredFile(ctx, result.File, result.Filename)
+				pathMutex.Unlock()
+			}
+			results[i] = result
+
+			if options.OnResult != nil {
+				mutex.Lock()
+				options.OnResult(result)
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	waitGroup.Wait()
+	return results
+}
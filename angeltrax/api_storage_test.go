@@ -0,0 +1,278 @@
+package angeltrax
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// downloadServer serves stored files by their source, with range support.
+type downloadServer struct {
+	files    map[string][]byte
+	noLength bool // If set, the files are sent without a length (and ranges are ignored).
+
+	mutex  sync.Mutex
+	ranges []string // The range header of each download.
+}
+
+func (s *downloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/Plugin/AutoDownload/GlobalReport/Download.ashx" {
+		http.NotFound(w, r)
+		return
+	}
+	contents, ok := s.files[r.URL.Query().Get("file")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mutex.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.mutex.Unlock()
+
+	if s.noLength {
+		// Flushing before writing anything forces a chunked response.
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(contents)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+}
+
+func testFileContents() []byte {
+	return bytes.Repeat([]byte("0123456789"), 1000)
+}
+
+func md5String(contents []byte) string {
+	sum := md5.Sum(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+func readTestFile(t *testing.T, filename string) []byte {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("could not read %s: %v", filename, err)
+	}
+	return contents
+}
+
+func TestDownloadStoredFile(t *testing.T) {
+	contents := testFileContents()
+	listed := StoredFile{FileSource: "a.264", FileSize: int64(len(contents)), MD5: md5String(contents)}
+	unlisted := StoredFile{FileSource: "a.264"} // The listing gave neither the size nor the MD5.
+
+	rows := []struct {
+		name     string
+		file     StoredFile
+		existing []byte // The file that is already in place.
+		part     []byte // The part file that is already there.
+		noLength bool
+		ranges   []string // The expected range header of each download.
+		err      error
+	}{
+		{
+			name:   "listed",
+			file:   listed,
+			ranges: []string{""},
+		},
+		{
+			name:   "unlisted size from the response",
+			file:   unlisted,
+			ranges: []string{""},
+		},
+		{
+			name:     "existing file",
+			file:     listed,
+			existing: contents,
+			ranges:   nil,
+		},
+		{
+			name:     "truncated existing file",
+			file:     listed,
+			existing: contents[:100],
+			ranges:   []string{""},
+		},
+		{
+			name:     "existing file that can't be verified",
+			file:     unlisted,
+			existing: contents[:100],
+			ranges:   []string{""},
+		},
+		{
+			name:   "resume",
+			file:   listed,
+			part:   contents[:4000],
+			ranges: []string{"bytes=4000-"},
+		},
+		{
+			name:   "resume without a listed size",
+			file:   unlisted,
+			part:   contents[:4000],
+			ranges: []string{"bytes=4000-"},
+		},
+		{
+			name:   "complete part file",
+			file:   unlisted,
+			part:   contents,
+			ranges: []string{"bytes=10000-"},
+		},
+		{
+			name:   "part file that is too long",
+			file:   unlisted,
+			part:   append(append([]byte{}, contents...), "extra"...),
+			ranges: []string{"bytes=10005-"},
+			err:    ErrChecksumMismatch,
+		},
+		{
+			name:     "no size anywhere",
+			file:     unlisted,
+			noLength: true,
+			ranges:   []string{""},
+			err:      ErrUnverifiable,
+		},
+		{
+			name:     "MD5 without a size",
+			file:     StoredFile{FileSource: "a.264", MD5: md5String(contents)},
+			noLength: true,
+			ranges:   []string{""},
+		},
+		{
+			name:   "wrong MD5",
+			file:   StoredFile{FileSource: "a.264", MD5: md5String([]byte("other"))},
+			ranges: []string{""},
+			err:    ErrChecksumMismatch,
+		},
+	}
+	for _, row := range rows {
+		t.Run(row.name, func(t *testing.T) {
+			server := &downloadServer{files: map[string][]byte{"a.264": contents}, noLength: row.noLength}
+			client := newTestClient(t, server)
+
+			filename := filepath.Join(t.TempDir(), "BUS-1", "a.264")
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				t.Fatalf("could not make the directory: %v", err)
+			}
+			if row.existing != nil {
+				if err := os.WriteFile(filename, row.existing, 0644); err != nil {
+					t.Fatalf("could not write the existing file: %v", err)
+				}
+			}
+			if row.part != nil {
+				if err := os.WriteFile(filename+".part", row.part, 0644); err != nil {
+					t.Fatalf("could not write the part file: %v", err)
+				}
+			}
+
+			err := client.DownloadStoredFile(context.Background(), row.file, filename)
+			if row.err != nil {
+				if !errors.Is(err, row.err) {
+					t.Errorf("expected %v; got: %v", row.err, err)
+				}
+				if _, err := os.Stat(filename + ".part"); !os.IsNotExist(err) {
+					t.Errorf("the bad part file was kept")
+				}
+				if row.existing == nil {
+					if _, err := os.Stat(filename); !os.IsNotExist(err) {
+						t.Errorf("an unverified file was put in place")
+					}
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("could not download: %v", err)
+				}
+				if !bytes.Equal(readTestFile(t, filename), contents) {
+					t.Errorf("wrong contents")
+				}
+				if _, err := os.Stat(filename + ".part"); !os.IsNotExist(err) {
+					t.Errorf("the part file was left behind")
+				}
+			}
+			if fmt.Sprintf("%q", server.ranges) != fmt.Sprintf("%q", row.ranges) {
+				t.Errorf("wrong downloads: %q (expected %q)", server.ranges, row.ranges)
+			}
+		})
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	rows := []struct {
+		value string
+		start int64
+		total int64
+		err   bool
+	}{
+		{"bytes 0-99/100", 0, 100, false},
+		{"bytes 40-99/100", 40, 100, false},
+		{"bytes 40-99/*", 40, -1, false},
+		{"bytes */100", 0, 100, false},
+		{"", 0, -1, true},
+		{"bytes 40/100", 0, -1, true},
+		{"bytes x-99/100", 0, -1, true},
+	}
+	for _, row := range rows {
+		start, total, err := parseContentRange(row.value)
+		if (err != nil) != row.err || start != row.start || total != row.total {
+			t.Errorf("%q: got %d, %d, %v", row.value, start, total, err)
+		}
+	}
+}
+
+func TestFetchStoredFiles(t *testing.T) {
+	contents := testFileContents()
+	other := []byte("other")
+	server := &downloadServer{files: map[string][]byte{
+		`D:\a\a.264`: contents,
+		`D:\b\a.264`: other,
+		`D:\c\c.264`: other,
+	}}
+	client := newTestClient(t, server)
+
+	file := StoredFile{DeviceID: "D1", Channel: 1, Date: "2023-04-05", FileSource: `D:\a\a.264`, FileSize: int64(len(contents))}
+	files := []StoredFile{
+		file,
+		file, // The same file listed twice.
+		file,
+		{DeviceID: "D1", Channel: 1, Date: "2023-04-05", FileSource: `D:\b\a.264`, FileSize: int64(len(other))}, // A different file with the same name.
+		{DeviceID: "D1", Channel: 2, Date: "2023-04-05", FileSource: `D:\c\c.264`, FileSize: int64(len(other))},
+	}
+	directory := t.TempDir()
+	results := client.FetchStoredFiles(context.Background(), files, FetchStoredFilesOptions{
+		Directory:   directory,
+		Parallelism: 4,
+		CarLicenses: map[string]string{"D1": "BUS-1"},
+	})
+
+	if len(results) != len(files) {
+		t.Fatalf("wrong number of results: %d", len(results))
+	}
+	for i, result := range results {
+		if i == 3 {
+			if result.Error == nil {
+				t.Errorf("result %d: expected a conflict", i)
+			}
+			continue
+		}
+		if result.Error != nil {
+			t.Errorf("result %d: %v", i, result.Error)
+		}
+	}
+	if filename := filepath.Join(directory, "BUS-1", "2023-04-05", "1", "a.264"); !bytes.Equal(readTestFile(t, filename), contents) {
+		t.Errorf("wrong contents for %s", filename)
+	}
+	if filename := filepath.Join(directory, "BUS-1", "2023-04-05", "2", "c.264"); !bytes.Equal(readTestFile(t, filename), other) {
+		t.Errorf("wrong contents for %s", filename)
+	}
+	// The duplicates found the first download in place.
+	if len(server.ranges) != 2 {
+		t.Errorf("wrong number of downloads: %d", len(server.ranges))
+	}
+}
@@ -0,0 +1,146 @@
+package angeltrax
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// TrackChunkDuration is the longest range that GetTrack asks for at once; longer ranges are split up.
+var TrackChunkDuration = 6 * time.Hour
+
+// TrackStatus is the status bit field of a track point.
+type TrackStatus uint32
+
+const (
+	TrackStatusACC      TrackStatus = 1 << 0 // The ignition is on.
+	TrackStatusGPSValid TrackStatus = 1 << 1 // The position is from a GPS fix.
+	TrackStatusAlarm    TrackStatus = 1 << 2 // An alarm is active.
+)
+
+func (s TrackStatus) ACC() bool {
+	return s&TrackStatusACC != 0
+}
+
+func (s TrackStatus) GPSValid() bool {
+	return s&TrackStatusGPSValid != 0
+}
+
+func (s TrackStatus) Alarm() bool {
+	return s&TrackStatusAlarm != 0
+}
+
+type getTrackResponse struct {
+	ErrorCode int        `json:"errorcode"`
+	Data      []trackRow `json:"data"`
+}
+
+type trackRow struct {
+	GPSTime   string      `json:"gpstime"` // yyyy-mm-dd hh:mm:ss
+	Latitude  float64     `json:"lat"`
+	Longitude float64     `json:"lng"`
+	Speed     float64     `json:"speed"`     // In km/h.
+	Direction float64     `json:"direction"` // In degrees clockwise from north.
+	Altitude  float64     `json:"altitude"`  // In meters.
+	Status    TrackStatus `json:"status"`
+}
+
+// TrackPoint is a single recorded position of a vehicle.
+type TrackPoint struct {
+	Time      time.Time   `json:"time"`
+	Latitude  float64     `json:"latitude"`
+	Longitude float64     `json:"longitude"`
+	Altitude  float64     `json:"altitude"` // In meters.
+	Speed     float64     `json:"speed"`    // In km/h.
+	Heading   float64     `json:"heading"`  // In degrees clockwise from north.
+	Status    TrackStatus `json:"status"`
+}
+
+// GetTrack returns the recorded positions of a device between the two times, in order.
+//
+// The times are sent as-is in their own location, which should be the device's.  Ranges longer than
+// TrackChunkDuration are fetched a chunk at a time.
+func (c *Client) GetTrack(ctx context.Context, deviceID string, from time.Time, to time.Time) ([]TrackPoint, error) {
+	if err := c.requireCapability(CapabilityTrack); err != nil {
+		return nil, err
+	}
+
+	if !to.After(from) {
+		return nil, fmt.Errorf("the end must be after the start")
+	}
+
+	var points []TrackPoint
+	seen := map[time.Time]bool{}
+	for start := from; start.Before(to); start = start.Add(TrackChunkDuration) {
+		end := start.Add(TrackChunkDuration)
+		if end.After(to) {
+			end = to
+		}
+
+		chunk, err := c.getTrackChunk(ctx, deviceID, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, point := range chunk {
+			// The chunks share their boundaries, so a point can show up twice.
+			if seen[point.Time] {
+				continue
+			}
+			seen[point.Time] = true
+			points = append(points, point)
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+	return points, nil
+}
+
+func (c *Client) getTrackChunk(ctx context.Context, deviceID string, from time.Time, to time.Time) ([]TrackPoint, error) {
+	c.init()
+
+	values := url.Values{}
+	values.Set("key", c.Key)
+	values.Set("deviceid", deviceID)
+	values.Set("begintime", from.Format(RecordingTimeFormat))
+	values.Set("endtime", to.Format(RecordingTimeFormat))
+
+	var output getTrackResponse
+	err := c.RawServiceRequest(ctx, "webclient", http.MethodGet, "/api/v1/basic/track/query", values, nil, &output)
+	if err != nil {
+		return nil, err
+	}
+	if output.ErrorCode != 0 {
+		return nil, fmt.Errorf("error code %d", output.ErrorCode)
+	}
+
+	points := make([]TrackPoint, 0, len(output.Data))
+	for _, row := range output.Data {
+		point, err := row.point(from.Location())
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// point converts the row into a track point, with the time in the given location.
+func (r trackRow) point(location *time.Location) (TrackPoint, error) {
+	t, err := time.ParseInLocation(RecordingTimeFormat, r.GPSTime, location)
+	if err != nil {
+		return TrackPoint{}, fmt.Errorf("could not parse GPS time %q: %w", r.GPSTime, err)
+	}
+	return TrackPoint{
+		Time:      t,
+		Latitude:  r.Latitude,
+		Longitude: r.Longitude,
+		Altitude:  r.Altitude,
+		Speed:     r.Speed,
+		Heading:   r.Direction,
+		Status:    r.Status,
+	}, nil
+}
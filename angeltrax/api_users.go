@@ -0,0 +1,202 @@
+package angeltrax
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+type ListUsersResponse struct {
+	ErrorCode int    `json:"errorcode"`
+	Data      []User `json:"data"`
+}
+
+// User is a CMS user.
+type User struct {
+	UserID        int      `json:"userid"`
+	UserName      string   `json:"username"` // This matches GlobalReportAutoDownloadRow.Username.
+	RealName      string   `json:"realname"`
+	RoleID        int      `json:"roleid"`
+	RoleName      string   `json:"rolename"`
+	Enable        int      `json:"enable"`    // 1 if the user may log in.
+	AllDevices    int      `json:"alldevice"` // 1 if the user can see every device (such as the admin).
+	GroupIDs      []int    `json:"groupids"`  // The groups that the user can see (along with their subgroups).
+	DeviceIDs     []string `json:"deviceids"` // The devices that the user can see in addition to the groups.
+	Email         string   `json:"email"`
+	Phone         string   `json:"phone"`
+	ValidTime     string   `json:"validtime"`     // yyyy-mm-dd hh:mm:ss; the account expires after this.  This is empty if it never expires.
+	LastLoginTime string   `json:"lastlogintime"` // yyyy-mm-dd hh:mm:ss
+}
+
+func (u User) IsEnabled() bool {
+	return u.Enable == 1
+}
+
+// VisibleDevices returns the devices that the user can see, sorted by device ID.
+func (u User) VisibleDevices(groups []CenterGroup, devices []CenterDevice) []CenterDevice {
+	visible := map[string]bool{}
+	if u.AllDevices == 1 {
+		for _, device := range devices {
+			visible[device.DeviceID] = true
+		}
+	}
+	for _, groupID := range u.GroupIDs {
+		groupIDs := map[int]bool{groupID: true}
+		for changed := true; changed; {
+			changed = false
+			for _, group := range groups {
+				if groupIDs[group.GroupFatherID] && !groupIDs[group.GroupID] {
+					groupIDs[group.GroupID] = true
+					changed = true
+				}
+			}
+		}
+		for _, device := range devices {
+			if groupIDs[device.GroupID] {
+				visible[device.DeviceID] = true
+			}
+		}
+	}
+	for _, deviceID := range u.DeviceIDs {
+		visible[deviceID] = true
+	}
+
+	var output []CenterDevice
+	for _, device := range devices {
+		if visible[device.DeviceID] {
+			output = append(output, device)
+		}
+	}
+	sort.SliceStable(output, func(i, j int) bool {
+		return output[i].DeviceID < output[j].DeviceID
+	})
+	return output
+}
+
+// CreateUserInput is the payload for creating a user.
+type CreateUserInput struct {
+	UserName  string   `json:"username"`
+	Password  string   `json:"password"`
+	RealName  string   `json:"realname"`
+	RoleID    int      `json:"roleid"`
+	GroupIDs  []int    `json:"groupids"`
+	DeviceIDs []string `json:"deviceids"`
+	Email     string   `json:"email,omitempty"`
+	Phone     string   `json:"phone,omitempty"`
+	ValidTime string   `json:"validtime,omitempty"` // yyyy-mm-dd hh:mm:ss (optional)
+}
+
+// UserActionResponse is the response to the requests that change users.
+type UserActionResponse struct {
+	ErrorCode int    `json:"errorcode"`
+	Message   string `json:"message"`
+	Data      struct {
+		UserID int `json:"userid"` // This is only set when a user is created.
+	} `json:"data"`
+}
+
+// ListUsers returns every CMS user, along with their roles and permissions.
+func (c *Client) ListUsers(ctx context.Context) (*ListUsersResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityUserManagement); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("key", c.Key)
+
+	var output ListUsersResponse
+	err := c.RawServiceRequest(ctx, "webclient", http.MethodGet, "/api/v1/basic/user/query", values, nil, &output)
+	if err != nil {
+		return nil, err
+	}
+	if output.ErrorCode != 0 {
+		return nil, fmt.Errorf("error code %d", output.ErrorCode)
+	}
+
+	return &output, nil
+}
+
+// CreateUser creates a user; the new user's ID is in the response.
+func (c *Client) CreateUser(ctx context.Context, input CreateUserInput) (*UserActionResponse, error) {
+	if input.UserName == "" {
+		return nil, fmt.Errorf("missing user name")
+	}
+	if input.Password == "" {
+		return nil, fmt.Errorf("missing password")
+	}
+	return c.userAction(ctx, "/api/v1/basic/user/add", input)
+}
+
+// EnableUser allows a user to log in again.
+func (c *Client) EnableUser(ctx context.Context, userID int) (*UserActionResponse, error) {
+	return c.setUserEnabled(ctx, userID, true)
+}
+
+// DisableUser stops a user from logging in; the user (and the tasks that they created) are kept.
+func (c *Client) DisableUser(ctx context.Context, userID int) (*UserActionResponse, error) {
+	return c.setUserEnabled(ctx, userID, false)
+}
+
+func (c *Client) setUserEnabled(ctx context.Context, userID int, enabled bool) (*UserActionResponse, error) {
+	input := struct {
+		UserID int `json:"userid"`
+		Enable int `json:"enable"`
+	}{
+		UserID: userID,
+	}
+	if enabled {
+		input.Enable = 1
+	}
+	return c.userAction(ctx, "/api/v1/basic/user/enable", input)
+}
+
+// ResetUserPassword sets a new password for a user.
+func (c *Client) ResetUserPassword(ctx context.Context, userID int, password string) (*UserActionResponse, error) {
+	if password == "" {
+		return nil, fmt.Errorf("missing password")
+	}
+	input := struct {
+		UserID   int    `json:"userid"`
+		Password string `json:"password"`
+	}{
+		UserID:   userID,
+		Password: password,
+	}
+	return c.userAction(ctx, "/api/v1/basic/user/password", input)
+}
+
+// userAction sends a change to the webclient service (or writes it to DryRunOutput).
+func (c *Client) userAction(ctx context.Context, path string, input interface{}) (*UserActionResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityUserManagement); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("key", c.Key)
+
+	var output UserActionResponse
+	if dryRun, err := c.dryRunServiceRequest("webclient", http.MethodPost, path, values, input); dryRun {
+		if err != nil {
+			return nil, err
+		}
+		return &output, nil
+	}
+	err := c.RawServiceJSONRequest(ctx, "webclient", http.MethodPost, path, values, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	if output.ErrorCode != 0 {
+		if output.Message != "" {
+			return nil, fmt.Errorf("error code %d: %s", output.ErrorCode, output.Message)
+		}
+		return nil, fmt.Errorf("error code %d", output.ErrorCode)
+	}
+
+	return &output, nil
+}
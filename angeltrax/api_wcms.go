@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -65,6 +66,26 @@ func (t TaskStatus) String() string {
 	return fmt.Sprintf("%s(%d)", value, t)
 }
 
+// IsTerminal returns true if the status will not change on its own.
+func (t TaskStatus) IsTerminal() bool {
+	switch t {
+	case TaskStatusNoFiles, TaskStatusFinished, TaskStatusDownloadFailed, TaskStatusDelete, TaskStatusDownloadFailed2, TaskStatusTimeout:
+		return true
+	}
+	return false
+}
+
+// IsFailure returns true if the status indicates a problem.
+//
+// Note that not all failures are terminal; the server keeps retrying when it runs out of disk or connections.
+func (t TaskStatus) IsFailure() bool {
+	switch t {
+	case TaskStatusConnectionLimit, TaskStatusInsufficientDisk, TaskStatusDownloadFailed, TaskStatusDownloadFailed2, TaskStatusTimeout:
+		return true
+	}
+	return false
+}
+
 type TaskPeriod int
 
 const (
@@ -83,6 +104,14 @@ const (
 	TaskTypeBlackBoxVideo TaskType = 2 // This was "default" in a switch statement.
 )
 
+type VideoType int
+
+const (
+	VideoTypeAll    VideoType = 0
+	VideoTypeNormal VideoType = 1
+	VideoTypeAlarm  VideoType = 2
+)
+
 type RegisterLoginResponse struct {
 	Code   int  `json:"Code"`
 	Result bool `json:"Result"`
@@ -93,23 +122,25 @@ type MonitorAutoDownloadInput struct {
 }
 
 type MonitorAutoDownloadResponse struct {
-	Total int `json:"total"`
-	Rows  []struct {
-		TaskID      int        `json:"TaskID"`
-		Status      TaskStatus `json:"Status"`
-		DeviceID    string     `json:"Device"`
-		CarLicense  string     `json:"Carlicense"`
-		TaskName    string     `json:"TaskName"`
-		Period      TaskPeriod `json:"Period"`
-		TaskType    TaskType   `json:"TaskType"`
-		Date        string     `json:"Date"`       // yyyy-mm-dd
-		StartTime   string     `json:"StartTime"`  // hh:mm:ss
-		EndTime     string     `json:"EndTime"`    // hh:mm:ss
-		ChannelList string     `json:"Channel"`    // CSV of channel numbers, starting from "1".
-		CreateTime  string     `json:"CreateTime"` // yyyy-mm-dd hh:mm:ss
-
-		NetMode string `json:"NetMode"`
-	} `json:"rows"`
+	Total int                      `json:"total"`
+	Rows  []MonitorAutoDownloadRow `json:"rows"`
+}
+
+type MonitorAutoDownloadRow struct {
+	TaskID      int        `json:"TaskID"`
+	Status      TaskStatus `json:"Status"`
+	DeviceID    string     `json:"Device"`
+	CarLicense  string     `json:"Carlicense"`
+	TaskName    string     `json:"TaskName"`
+	Period      TaskPeriod `json:"Period"`
+	TaskType    TaskType   `json:"TaskType"`
+	Date        string     `json:"Date"`       // yyyy-mm-dd
+	StartTime   string     `json:"StartTime"`  // hh:mm:ss
+	EndTime     string     `json:"EndTime"`    // hh:mm:ss
+	ChannelList string     `json:"Channel"`    // CSV of channel numbers, starting from "1".
+	CreateTime  string     `json:"CreateTime"` // yyyy-mm-dd hh:mm:ss
+
+	NetMode string `json:"NetMode"`
 }
 
 type MonitorAutoDownloadTaskResponse struct {
@@ -130,8 +161,8 @@ type MonitorAutoDownloadTaskResponse struct {
 	CarLicense   string        `json:"Carlicense"`
 	NetMode      string        `json:"NetMode"` // 1: lan, 2: wifi, 3: wifiandlan, 4: 3G, 7: all
 	Effective    int           `json:"Effective"`
-	Stream       int           `json:"Stream"`    // 0: sub, 1: main
-	VideoType    int           `json:"VideoType"` // 0: all, 1: normal, 2: alarm
+	Stream       int           `json:"Stream"` // 0: sub, 1: main
+	VideoType    VideoType     `json:"VideoType"`
 	StoreType    int           `json:"Storetype"` // 0: main, 1: sub, 2: both
 }
 
@@ -140,27 +171,31 @@ type GlobalReportAutoDownloadInput struct {
 	Status    TaskStatus `form:"Status"`
 	StartDate string     `form:"StartTime"` // yyyy-mm-dd
 	EndDate   string     `form:"EndTime"`   // yyyy-mm-dd
+	Page      int        `form:"page"`      // The one-indexed page; this defaults to 1.
+	Rows      int        `form:"rows"`      // The number of rows per page; this defaults to DefaultRowCount.
 }
 
 type GlobalReportAutoDownloadResponse struct {
-	Total int `json:"total"`
-	Rows  []struct {
-		TaskID      int        `json:"TaskID"`
-		Status      TaskStatus `json:"Status"`
-		DeviceID    string     `json:"Device"`
-		CarLicense  string     `json:"Carlicense"`
-		TaskName    string     `json:"TaskName"`
-		Period      TaskPeriod `json:"Period"`
-		TaskType    TaskType   `json:"TaskType"`
-		Date        string     `json:"Date"`       // yyyy-mm-dd
-		StartTime   string     `json:"StartTime"`  // hh:mm:ss
-		EndTime     string     `json:"EndTime"`    // hh:mm:ss
-		ChannelList string     `json:"Channel"`    // CSV of channel numbers, starting from "1".
-		CreateTime  string     `json:"CreateTime"` // yyyy-mm-dd hh:mm:ss
-
-		FinishTime string `json:"FinishTime"` // yyyy-mm-dd hh:mm:ss
-		Username   string `json:"UserName"`
-	} `json:"rows"`
+	Total int                           `json:"total"`
+	Rows  []GlobalReportAutoDownloadRow `json:"rows"`
+}
+
+type GlobalReportAutoDownloadRow struct {
+	TaskID      int        `json:"TaskID"`
+	Status      TaskStatus `json:"Status"`
+	DeviceID    string     `json:"Device"`
+	CarLicense  string     `json:"Carlicense"`
+	TaskName    string     `json:"TaskName"`
+	Period      TaskPeriod `json:"Period"`
+	TaskType    TaskType   `json:"TaskType"`
+	Date        string     `json:"Date"`       // yyyy-mm-dd
+	StartTime   string     `json:"StartTime"`  // hh:mm:ss
+	EndTime     string     `json:"EndTime"`    // hh:mm:ss
+	ChannelList string     `json:"Channel"`    // CSV of channel numbers, starting from "1".
+	CreateTime  string     `json:"CreateTime"` // yyyy-mm-dd hh:mm:ss
+
+	FinishTime string `json:"FinishTime"` // yyyy-mm-dd hh:mm:ss
+	Username   string `json:"UserName"`
 }
 
 type GlobalReportAutoDownloadTaskInput struct {
@@ -170,24 +205,54 @@ type GlobalReportAutoDownloadTaskInput struct {
 }
 
 type GlobalReportAutoDownloadTaskResponse struct {
-	Total int `json:"total"`
-	Rows  []struct {
-		DeviceID    string     `json:"Device"`
-		Status      TaskStatus `json:"Status"`
-		Percent     string     `json:"Percent"`   // This appears to be a float encoded as a string.
-		Speed       string     `json:"Speed"`     // This appears to be a float encoded as a string.
-		Date        string     `json:"Date"`      // yyyy-mm-dd
-		StartTime   string     `json:"StartTime"` // hh:mm:ss
-		EndTime     string     `json:"EndTime"`   // hh:mm:ss
-		TotalSize   string     `json:"TotalSize"` // This appears to be a float encoded as a string.
-		CurrentSize string     `json:"CurSize"`   // This appears to be a float encoded as a string.
-		Channel     int        `json:"Channel"`   // The one-index of the channel.
-		Error       string     `json:"Error"`
-		TaskID      int        `json:"TaskID"`
-		FileSource  string     `json:"FileSource"`
-		PreAlarm    int        `json:"PreAlarm"`
-		NextAlarm   int        `json:"NextAlarm"`
-	} `json:"rows"`
+	Total int                               `json:"total"`
+	Rows  []GlobalReportAutoDownloadTaskRow `json:"rows"`
+}
+
+type GlobalReportAutoDownloadTaskRow struct {
+	DeviceID    string     `json:"Device"`
+	Status      TaskStatus `json:"Status"`
+	Percent     string     `json:"Percent"`   // This appears to be a float encoded as a string.
+	Speed       string     `json:"Speed"`     // This appears to be a float encoded as a string.
+	Date        string     `json:"Date"`      // yyyy-mm-dd
+	StartTime   string     `json:"StartTime"` // hh:mm:ss
+	EndTime     string     `json:"EndTime"`   // hh:mm:ss
+	TotalSize   string     `json:"TotalSize"` // This appears to be a float encoded as a string.
+	CurrentSize string     `json:"CurSize"`   // This appears to be a float encoded as a string.
+	Channel     int        `json:"Channel"`   // The one-index of the channel.
+	Error       string     `json:"Error"`
+	TaskID      int        `json:"TaskID"`
+	FileSource  string     `json:"FileSource"`
+	PreAlarm    int        `json:"PreAlarm"`
+	NextAlarm   int        `json:"NextAlarm"`
+}
+
+// PercentValue returns the percent complete (0-100), or zero if it could not be parsed.
+func (r GlobalReportAutoDownloadTaskRow) PercentValue() float64 {
+	return parseFloatString(r.Percent)
+}
+
+// SpeedValue returns the download speed, or zero if it could not be parsed.
+func (r GlobalReportAutoDownloadTaskRow) SpeedValue() float64 {
+	return parseFloatString(r.Speed)
+}
+
+// TotalSizeValue returns the total size, or zero if it could not be parsed.
+func (r GlobalReportAutoDownloadTaskRow) TotalSizeValue() float64 {
+	return parseFloatString(r.TotalSize)
+}
+
+// CurrentSizeValue returns the size downloaded so far, or zero if it could not be parsed.
+func (r GlobalReportAutoDownloadTaskRow) CurrentSizeValue() float64 {
+	return parseFloatString(r.CurrentSize)
+}
+
+func parseFloatString(value string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "%")), 64)
+	if err != nil {
+		return 0
+	}
+	return f
 }
 
 type CreateAutoDownloadTaskInput struct {
@@ -204,16 +269,55 @@ type CreateAutoDownloadTaskInput struct {
 	//TaskIO string `form:"TaskIO"`
 	//TaskEvent string `form:"TaskEvent"` // []
 	//NetMode int `form:"NetMode"` // 7
-	EffectiveDays int `form:"EffectiveDays"`
-	Stream        int `form:"Stream"`    // 1
-	Storetype     int `form:"StoreType"` // 2
-	VideoType     int `form:"VideoType"` // 0
+	EffectiveDays int       `form:"EffectiveDays"`
+	Stream        int       `form:"Stream"`    // 1
+	Storetype     int       `form:"StoreType"` // 2
+	VideoType     VideoType `form:"VideoType"` // 0
 }
 
 type CreateAutoDownloadTaskResponse struct {
 	Result bool `json:"result"`
 }
 
+// UpdateAutoDownloadTaskInput replaces the settings of a task; it is best built with UpdateInput.
+//
+// If it is built by hand, a zero Stream and Storetype mean the defaults that CreateAutoDownloadTask uses (the
+// main stream, and both store types), since zero is indistinguishable from "not set".
+type UpdateAutoDownloadTaskInput struct {
+	TaskID int `form:"TaskID"`
+	CreateAutoDownloadTaskInput
+
+	fromTask bool // This is set by UpdateInput, in which case the stream and store type are the task's own.
+}
+
+// AutoDownloadTaskActionResponse is the response to the actions that modify an existing task.
+type AutoDownloadTaskActionResponse struct {
+	Result bool `json:"result"`
+}
+
+// UpdateInput returns the input needed to update this task, pre-populated with its current settings.
+func (r MonitorAutoDownloadTaskResponse) UpdateInput() UpdateAutoDownloadTaskInput {
+	return UpdateAutoDownloadTaskInput{
+		TaskID: r.TaskID,
+		CreateAutoDownloadTaskInput: CreateAutoDownloadTaskInput{
+			TaskName:      r.TaskName,
+			DeviceID:      r.DeviceID,
+			StartTime:     r.StartTime,
+			EndTime:       r.EndTime,
+			TaskType:      r.TaskType,
+			StartExecute:  r.StartExecute,
+			EndExecute:    r.EndExecute,
+			Period:        r.Period,
+			TaskChannels:  append([]int{}, r.TaskChannel...),
+			EffectiveDays: r.Effective,
+			Stream:        r.Stream,
+			Storetype:     r.StoreType,
+			VideoType:     r.VideoType,
+		},
+		fromTask: true,
+	}
+}
+
 func (c *Client) RegisterLogin(ctx context.Context) (*GetCenterGroupsResponse, error) {
 	c.init()
 
@@ -283,6 +387,13 @@ func (c *Client) MonitorAutoDownloadTask(ctx context.Context, taskID string) (*M
 func (c *Client) GlobalReportAutoDownload(ctx context.Context, input GlobalReportAutoDownloadInput) (*GlobalReportAutoDownloadResponse, error) {
 	c.init()
 
+	if input.Page < 1 {
+		input.Page = 1
+	}
+	if input.Rows < 1 {
+		input.Rows = DefaultRowCount
+	}
+
 	values := url.Values{}
 
 	inputValues := url.Values{}
@@ -293,8 +404,8 @@ func (c *Client) GlobalReportAutoDownload(ctx context.Context, input GlobalRepor
 	inputValues.Set("EndTime", input.EndDate)
 	inputValues.Set("Status", fmt.Sprintf("%d", input.Status))
 	inputValues.Set("Type", "1")
-	inputValues.Set("page", "1")
-	inputValues.Set("rows", fmt.Sprintf("%d", DefaultRowCount))
+	inputValues.Set("page", fmt.Sprintf("%d", input.Page))
+	inputValues.Set("rows", fmt.Sprintf("%d", input.Rows))
 	inputValuesString := inputValues.Encode()
 
 	var output GlobalReportAutoDownloadResponse
@@ -306,6 +417,26 @@ func (c *Client) GlobalReportAutoDownload(ctx context.Context, input GlobalRepor
 	return &output, nil
 }
 
+// GlobalReportAllAutoDownload returns every task in the global report matching the query, fetching one page at
+// a time.
+func (c *Client) GlobalReportAllAutoDownload(ctx context.Context, input GlobalReportAutoDownloadInput) ([]GlobalReportAutoDownloadRow, error) {
+	if input.Page < 1 {
+		input.Page = 1
+	}
+	var rows []GlobalReportAutoDownloadRow
+	for {
+		output, err := c.GlobalReportAutoDownload(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, output.Rows...)
+		if len(output.Rows) == 0 || len(rows) >= output.Total {
+			return rows, nil
+		}
+		input.Page++
+	}
+}
+
 func (c *Client) GlobalReportAutoDownloadTask(ctx context.Context, input GlobalReportAutoDownloadTaskInput) (*GlobalReportAutoDownloadTaskResponse, error) {
 	c.init()
 
@@ -334,6 +465,94 @@ func (c *Client) CreateAutoDownloadTask(ctx context.Context, input CreateAutoDow
 
 	values := url.Values{}
 
+	inputValues := autoDownloadTaskValues(input)
+	inputValuesString := inputValues.Encode()
+
+	var output CreateAutoDownloadTaskResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AutoDownload/Task/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// UpdateAutoDownloadTask replaces the settings of an existing task.
+//
+// The web client uses the same "saveTask" action as for creation, but includes the task ID.
+func (c *Client) UpdateAutoDownloadTask(ctx context.Context, input UpdateAutoDownloadTaskInput) (*AutoDownloadTaskActionResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityAutoDownload); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+
+	inputValues := autoDownloadTaskValues(input.CreateAutoDownloadTaskInput)
+	inputValues.Set("TaskID", fmt.Sprintf("%d", input.TaskID))
+	if input.fromTask || input.Stream != 0 {
+		inputValues.Set("Stream", fmt.Sprintf("%d", input.Stream))
+	}
+	if input.fromTask || input.Storetype != 0 {
+		inputValues.Set("Storetype", fmt.Sprintf("%d", input.Storetype))
+	}
+	inputValuesString := inputValues.Encode()
+
+	var output AutoDownloadTaskActionResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AutoDownload/Task/Default.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// PauseAutoDownloadTask pauses a task; it will show up as TaskStatusPaused.
+func (c *Client) PauseAutoDownloadTask(ctx context.Context, taskID string) (*AutoDownloadTaskActionResponse, error) {
+	return c.autoDownloadTaskAction(ctx, "/Plugin/AutoDownload/Monitor/Default.ashx", "pauseTask", taskID)
+}
+
+// ResumeAutoDownloadTask resumes a paused task.
+func (c *Client) ResumeAutoDownloadTask(ctx context.Context, taskID string) (*AutoDownloadTaskActionResponse, error) {
+	return c.autoDownloadTaskAction(ctx, "/Plugin/AutoDownload/Monitor/Default.ashx", "resumeTask", taskID)
+}
+
+// RetryAutoDownloadTask restarts the downloads of a task that failed or timed out.
+func (c *Client) RetryAutoDownloadTask(ctx context.Context, taskID string) (*AutoDownloadTaskActionResponse, error) {
+	return c.autoDownloadTaskAction(ctx, "/Plugin/AutoDownload/Monitor/Default.ashx", "retryTask", taskID)
+}
+
+// DeleteAutoDownloadTask deletes a task; it will show up as TaskStatusDelete until the CMS prunes it.
+func (c *Client) DeleteAutoDownloadTask(ctx context.Context, taskID string) (*AutoDownloadTaskActionResponse, error) {
+	return c.autoDownloadTaskAction(ctx, "/Plugin/AutoDownload/Task/Default.ashx", "deleteTask", taskID)
+}
+
+func (c *Client) autoDownloadTaskAction(ctx context.Context, path string, action string, taskID string) (*AutoDownloadTaskActionResponse, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityAutoDownload); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+
+	inputValues := url.Values{}
+	inputValues.Set("action", action)
+	inputValues.Set("id", taskID)
+	inputValuesString := inputValues.Encode()
+
+	var output AutoDownloadTaskActionResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, path, values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// autoDownloadTaskValues returns the form values for the "saveTask" action.
+func autoDownloadTaskValues(input CreateAutoDownloadTaskInput) url.Values {
 	var taskChannelStrings []string
 	for _, channel := range input.TaskChannels {
 		taskChannelStrings = append(taskChannelStrings, fmt.Sprintf("%d", channel))
@@ -357,14 +576,6 @@ func (c *Client) CreateAutoDownloadTask(ctx context.Context, input CreateAutoDow
 	inputValues.Set("Effective", fmt.Sprintf("%d", input.EffectiveDays))
 	inputValues.Set("Stream", "1")
 	inputValues.Set("Storetype", "2")
-	inputValues.Set("VideoType", "0")
-	inputValuesString := inputValues.Encode()
-
-	var output CreateAutoDownloadTaskResponse
-	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AutoDownload/Task/Default.ashx", values, inputValuesString, &output)
-	if err != nil {
-		return nil, err
-	}
-
-	return &output, nil
+	inputValues.Set("VideoType", fmt.Sprintf("%d", input.VideoType))
+	return inputValues
 }
@@ -0,0 +1,295 @@
+package angeltrax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Capability is a feature that some CMS builds have and others don't.
+type Capability string
+
+const (
+	CapabilityAutoDownload     Capability = "autodownload"     // Download tasks, the global report, and stored files.
+	CapabilityAlarmCenter      Capability = "alarmcenter"      // Alarm queries and actions.
+	CapabilityPush             Capability = "push"             // The event stream (Subscribe).
+	CapabilityTrack            Capability = "track"            // GPS tracks.
+	CapabilitySnapshot         Capability = "snapshot"         // On-demand still images.
+	CapabilityLive             Capability = "live"             // Live and playback streams through the transmit server.
+	CapabilityDeviceStatus     Capability = "devicestatus"     // Device status.
+	CapabilityCenterManagement Capability = "centermanagement" // Creating and editing groups and devices.
+	CapabilityUserManagement   Capability = "usermanagement"   // Listing and managing users.
+)
+
+// ErrUnsupported is returned (wrapped in an UnsupportedError) when the server doesn't have the capability that a
+// method needs.
+var ErrUnsupported = errors.New("not supported by this server")
+
+// UnsupportedError is the error returned when the server doesn't have a capability.
+type UnsupportedError struct {
+	Capability Capability
+	Version    string // The server's version.
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is %v (version %s)", e.Capability, ErrUnsupported, e.Version)
+}
+
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// CapabilitySource is how a capability was decided.
+type CapabilitySource string
+
+const (
+	CapabilitySourceSupport CapabilitySource = "support" // The server's GetServersResponse.Support said so.
+	CapabilitySourceProbe   CapabilitySource = "probe"   // The endpoint was tried.
+	CapabilitySourceAssumed CapabilitySource = "assumed" // There was nothing to go on, so it's assumed to be there.
+)
+
+// CapabilityStatus is whether the server has a capability and how that was decided.
+type CapabilityStatus struct {
+	Capability Capability
+	Supported  bool
+	Source     CapabilitySource
+}
+
+// capabilityRule describes how to tell whether a server has a capability.
+type capabilityRule struct {
+	capability Capability
+	support    []string // The names in GetServersResponse.Support that mean that the server has this (case-insensitive).
+	probe      *capabilityProbe
+}
+
+// capabilityProbe is a harmless request that fails with a 404 (or a non-JSON page) if the server doesn't have the
+// capability.
+type capabilityProbe struct {
+	server string
+	method string
+	path   string
+	action string // For the wcms plugins; this is sent in the form.
+}
+
+// capabilityRules are the known capabilities, in the order that they're listed.
+var capabilityRules = []capabilityRule{
+	{
+		capability: CapabilityAutoDownload,
+		support:    []string{"autodownload", "download"},
+		probe:      &capabilityProbe{server: "wcms", method: http.MethodPost, path: "/Plugin/AutoDownload/Monitor/Default.ashx", action: "refreshTask"},
+	},
+	{
+		capability: CapabilityAlarmCenter,
+		support:    []string{"alarmcenter", "alarm"},
+		probe:      &capabilityProbe{server: "wcms", method: http.MethodPost, path: "/Plugin/AlarmCenter/Default.ashx", action: "queryAlarm"},
+	},
+	{
+		capability: CapabilityPush,
+		support:    []string{"push", "alarmpush"},
+	},
+	{
+		capability: CapabilityTrack,
+		support:    []string{"track", "gps"},
+		probe:      &capabilityProbe{server: "webclient", method: http.MethodGet, path: "/api/v1/basic/track/query"},
+	},
+	{
+		capability: CapabilitySnapshot,
+		support:    []string{"snapshot", "capture"},
+		probe:      &capabilityProbe{server: "wcms", method: http.MethodPost, path: "/Plugin/Snapshot/Default.ashx", action: "queryCapture"},
+	},
+	{
+		capability: CapabilityLive,
+		support:    []string{"live", "realvideo", "transmit"},
+	},
+	{
+		capability: CapabilityDeviceStatus,
+		support:    []string{"devicestatus", "status"},
+		probe:      &capabilityProbe{server: "addrdata", method: http.MethodGet, path: "/center/devicestatus"},
+	},
+	{
+		capability: CapabilityCenterManagement,
+		support:    []string{"centermanagement", "manage"},
+	},
+	{
+		capability: CapabilityUserManagement,
+		support:    []string{"usermanagement", "user"},
+		probe:      &capabilityProbe{server: "webclient", method: http.MethodGet, path: "/api/v1/basic/user/query"},
+	},
+}
+
+// Capabilities is what a server supports.
+type Capabilities struct {
+	Version  string // The server's version; this is only for messages, since nothing is known about which versions have what.
+	statuses map[Capability]CapabilityStatus
+}
+
+// NewCapabilities decides what a server supports from its list of supported features (from GetServersResponse).
+//
+// The names in the list aren't documented, so a capability is only marked as unsupported if every name in the list
+// is one that we recognize (and none of them are for that capability).  Otherwise, anything that isn't listed is
+// assumed to be supported until a probe (see ProbeCapabilities) says otherwise.  The server's version doesn't
+// come into it; set Version for the error messages.
+func NewCapabilities(support []string) *Capabilities {
+	supportMap := map[string]bool{}
+	for _, name := range support {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			supportMap[name] = true
+		}
+	}
+
+	knownNames := map[string]bool{}
+	for _, rule := range capabilityRules {
+		for _, name := range rule.support {
+			knownNames[name] = true
+		}
+	}
+	recognized := len(supportMap) > 0
+	for name := range supportMap {
+		if !knownNames[name] {
+			recognized = false
+		}
+	}
+
+	c := &Capabilities{
+		statuses: map[Capability]CapabilityStatus{},
+	}
+	for _, rule := range capabilityRules {
+		status := CapabilityStatus{
+			Capability: rule.capability,
+			Supported:  true,
+			Source:     CapabilitySourceAssumed,
+		}
+		if recognized {
+			status.Supported = false
+			status.Source = CapabilitySourceSupport
+		}
+		for _, name := range rule.support {
+			if supportMap[name] {
+				status.Supported = true
+				status.Source = CapabilitySourceSupport
+			}
+		}
+		c.statuses[rule.capability] = status
+	}
+	return c
+}
+
+// Supports returns true if the server has the capability; unknown capabilities are assumed to be supported.
+func (c *Capabilities) Supports(capability Capability) bool {
+	status, ok := c.statuses[capability]
+	return !ok || status.Supported
+}
+
+// List returns the status of every known capability.
+func (c *Capabilities) List() []CapabilityStatus {
+	var output []CapabilityStatus
+	for _, rule := range capabilityRules {
+		output = append(output, c.statuses[rule.capability])
+	}
+	return output
+}
+
+// Capabilities returns what the server supports; this is built by GetServers (which Login calls), and it is nil
+// until then.
+func (c *Client) Capabilities() *Capabilities {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.capabilities
+}
+
+// ProbeCapabilities tries the endpoints of the capabilities that the server didn't list, and updates the client's
+// capabilities to match.  Capabilities without a harmless endpoint to try are left alone.
+//
+// This requires Login (and, for the wcms plugins, RegisterLogin) to have been called first.
+func (c *Client) ProbeCapabilities(ctx context.Context) (*Capabilities, error) {
+	capabilities := c.Capabilities()
+	if capabilities == nil {
+		return nil, fmt.Errorf("the server information has not been loaded")
+	}
+
+	probed := &Capabilities{
+		Version:  capabilities.Version,
+		statuses: map[Capability]CapabilityStatus{},
+	}
+	for _, rule := range capabilityRules {
+		status := capabilities.statuses[rule.capability]
+		if rule.probe != nil && status.Source != CapabilitySourceSupport {
+			supported, err := c.probe(ctx, *rule.probe)
+			if err != nil {
+				return nil, fmt.Errorf("could not probe %s: %w", rule.capability, err)
+			}
+			status.Supported = supported
+			status.Source = CapabilitySourceProbe
+		}
+		probed.statuses[rule.capability] = status
+	}
+
+	c.mutex.Lock()
+	c.capabilities = probed
+	c.mutex.Unlock()
+	return probed, nil
+}
+
+// probe tries an endpoint; it returns false if the server doesn't have it.
+//
+// Only a 404 (or 501, or a page that isn't JSON) means that the endpoint isn't there; any other status means that
+// something answered, even if it didn't like the request.  The error is for when the server couldn't be reached.
+func (c *Client) probe(ctx context.Context, probe capabilityProbe) (bool, error) {
+	if _, err := c.ServiceBaseURL(probe.server); err != nil {
+		// The server doesn't even have the service.
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	values := url.Values{}
+	var requestData interface{}
+	if probe.action != "" {
+		inputValues := url.Values{}
+		inputValues.Set("action", probe.action)
+		inputValues.Set("page", "1")
+		inputValues.Set("rows", "1")
+		requestData = inputValues.Encode()
+	} else {
+		values.Set("key", c.Key)
+		values.Set("random", fmt.Sprintf("%d", time.Now().Unix()))
+	}
+
+	var output json.RawMessage
+	err := c.RawServiceRequest(ctx, probe.server, probe.method, probe.path, values, requestData, &output)
+	if err != nil {
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) {
+			if statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusNotImplemented {
+				return false, nil
+			}
+			logrus.Debugf("Probe of %s answered with %v; assuming that it is there.", probe.path, err)
+			return true, nil
+		}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// This is usually an HTML error page.
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// requireCapability returns an UnsupportedError if the server is known not to have the capability.
+func (c *Client) requireCapability(capability Capability) error {
+	capabilities := c.Capabilities()
+	if capabilities == nil || capabilities.Supports(capability) {
+		return nil
+	}
+	return &UnsupportedError{Capability: capability, Version: capabilities.Version}
+}
@@ -0,0 +1,111 @@
+package angeltrax
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestNewCapabilities(t *testing.T) {
+	rows := []struct {
+		name        string
+		support     []string
+		unsupported []Capability
+		source      CapabilitySource // The source of the capabilities that weren't listed.
+	}{
+		{
+			name:   "nothing listed",
+			source: CapabilitySourceAssumed,
+		},
+		{
+			name:    "every name recognized",
+			support: []string{"autodownload", " Snapshot ", "GPS"},
+			unsupported: []Capability{
+				CapabilityAlarmCenter,
+				CapabilityPush,
+				CapabilityLive,
+				CapabilityDeviceStatus,
+				CapabilityCenterManagement,
+				CapabilityUserManagement,
+			},
+			source: CapabilitySourceSupport,
+		},
+		{
+			name:    "an unrecognized name",
+			support: []string{"autodownload", "snapshot", "gps", "somethingnew"},
+			source:  CapabilitySourceAssumed,
+		},
+	}
+	for _, row := range rows {
+		t.Run(row.name, func(t *testing.T) {
+			capabilities := NewCapabilities(row.support)
+			unsupported := map[Capability]bool{}
+			for _, capability := range row.unsupported {
+				unsupported[capability] = true
+			}
+			listed := map[Capability]bool{}
+			if len(row.support) > 0 {
+				listed = map[Capability]bool{CapabilityAutoDownload: true, CapabilitySnapshot: true, CapabilityTrack: true}
+			}
+
+			for _, status := range capabilities.List() {
+				if status.Supported == unsupported[status.Capability] {
+					t.Errorf("%s: supported: %t", status.Capability, status.Supported)
+				}
+				if capabilities.Supports(status.Capability) != status.Supported {
+					t.Errorf("%s: Supports doesn't match", status.Capability)
+				}
+				source := row.source
+				if listed[status.Capability] {
+					source = CapabilitySourceSupport
+				}
+				if status.Source != source {
+					t.Errorf("%s: wrong source: %s (expected %s)", status.Capability, status.Source, source)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeCapabilities(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Plugin/AutoDownload/Monitor/Default.ashx":
+			_, _ = w.Write([]byte(`{"total":0,"rows":[]}`))
+		case "/Plugin/AlarmCenter/Default.ashx":
+			// The plugin is there, but it didn't like the request.
+			http.Error(w, "bad request", http.StatusBadRequest)
+		case "/Plugin/Snapshot/Default.ashx":
+			http.Error(w, "server error", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	client.capabilities = NewCapabilities(nil)
+
+	capabilities, err := client.ProbeCapabilities(context.Background())
+	if err != nil {
+		t.Fatalf("could not probe: %v", err)
+	}
+	if client.Capabilities() != capabilities {
+		t.Errorf("the client's capabilities were not updated")
+	}
+
+	expected := map[Capability]CapabilityStatus{
+		CapabilityAutoDownload:     {Supported: true, Source: CapabilitySourceProbe},
+		CapabilityAlarmCenter:      {Supported: true, Source: CapabilitySourceProbe},
+		CapabilityPush:             {Supported: true, Source: CapabilitySourceAssumed},
+		CapabilityTrack:            {Supported: false, Source: CapabilitySourceProbe}, // There's no webclient service.
+		CapabilitySnapshot:         {Supported: true, Source: CapabilitySourceProbe},
+		CapabilityLive:             {Supported: true, Source: CapabilitySourceAssumed},
+		CapabilityDeviceStatus:     {Supported: false, Source: CapabilitySourceProbe},
+		CapabilityCenterManagement: {Supported: true, Source: CapabilitySourceAssumed},
+		CapabilityUserManagement:   {Supported: false, Source: CapabilitySourceProbe},
+	}
+	for _, status := range capabilities.List() {
+		want := expected[status.Capability]
+		if status.Supported != want.Supported || status.Source != want.Source {
+			t.Errorf("%s: wrong status: %t (%s)", status.Capability, status.Supported, status.Source)
+		}
+	}
+}
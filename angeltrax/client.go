@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
@@ -19,12 +20,19 @@ type Client struct {
 	Username      string
 	Password      string
 	Key           string
+	DryRunOutput  io.Writer           // If this is set, requests that change anything are written here instead of being sent.
+	servers       *GetServersResponse // This is the last response from GetServers.
+	capabilities  *Capabilities       // This is built from the last response from GetServers.
 	serviceMap    map[string]ClientService
 	hostCookieMap map[string][]string
 	httpClient    http.Client
+	mutex         sync.Mutex // This protects the service and cookie maps so that requests may be made concurrently.
 }
 
 func (c *Client) init() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if c.ServerPort == 0 {
 		c.ServerPort = 7264
 	}
@@ -48,45 +56,198 @@ func (c *Client) GetServers(ctx context.Context, server string) (*GetServersResp
 		return nil, err
 	}
 
+	c.mutex.Lock()
+	c.servers = &output
+	c.capabilities = NewCapabilities(output.Support)
+	c.capabilities.Version = output.Version
+	c.mutex.Unlock()
+
 	return &output, nil
 }
 
+// Servers returns the server information (the version, license, and services) from the last call to GetServers
+// (which Login calls); this is nil if it hasn't been called.
+func (c *Client) Servers() *GetServersResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.servers
+}
+
 func (c *Client) RawServiceRequest(ctx context.Context, server, method, path string, values url.Values, requestData, responseData interface{}) error {
 	c.init()
 
+	base, err := c.ServiceBaseURL(server)
+	if err != nil {
+		return err
+	}
+
+	return c.RawRequest(ctx, method, base+"/"+strings.TrimPrefix(path, "/"), values, requestData, responseData)
+}
+
+// RawServiceJSONRequest is like RawServiceRequest, but see RawJSONRequest.
+func (c *Client) RawServiceJSONRequest(ctx context.Context, server, method, path string, values url.Values, requestData, responseData interface{}) error {
+	c.init()
+
+	base, err := c.ServiceBaseURL(server)
+	if err != nil {
+		return err
+	}
+
+	return c.RawJSONRequest(ctx, method, base+"/"+strings.TrimPrefix(path, "/"), values, requestData, responseData)
+}
+
+// dryRunServiceRequest writes the request to DryRunOutput instead of sending it; if dry runs aren't enabled,
+// this returns false and the request should be sent as usual.
+//
+// The key and any passwords are masked so that the output can be shared.
+func (c *Client) dryRunServiceRequest(server, method, path string, values url.Values, requestData interface{}) (bool, error) {
+	if c.DryRunOutput == nil {
+		return false, nil
+	}
+
+	base, err := c.ServiceBaseURL(server)
+	if err != nil {
+		return true, err
+	}
+	path = base + "/" + strings.TrimPrefix(path, "/")
+	if len(values) > 0 {
+		path = path + "?" + redactValues(values).Encode()
+	}
+
+	var body string
+	switch v := requestData.(type) {
+	case nil:
+	case string:
+		body = v
+		if formValues, err := url.ParseQuery(v); err == nil {
+			body = redactValues(formValues).Encode()
+		}
+	default:
+		contents, err := json.Marshal(requestData)
+		if err != nil {
+			return true, err
+		}
+		var decoded interface{}
+		err = json.Unmarshal(contents, &decoded)
+		if err != nil {
+			return true, err
+		}
+		contents, err = json.MarshalIndent(redactJSON(decoded), "", "   ")
+		if err != nil {
+			return true, err
+		}
+		body = string(contents)
+	}
+	_, err = fmt.Fprintf(c.DryRunOutput, "%s %s\n%s\n", method, path, body)
+	return true, err
+}
+
+// isSecretName returns true if a field with this name holds a secret: the key or a password.
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	return name == "key" || strings.Contains(name, "password")
+}
+
+// redactValues returns a copy of the values with the secrets replaced.
+func redactValues(values url.Values) url.Values {
+	output := url.Values{}
+	for key, value := range values {
+		if isSecretName(key) && len(value) > 0 {
+			value = []string{"REDACTED"}
+		}
+		output[key] = value
+	}
+	return output
+}
+
+// redactJSON replaces the secrets in a decoded JSON value.
+func redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSecretName(key) {
+				v[key] = "REDACTED"
+				continue
+			}
+			v[key] = redactJSON(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+	}
+	return value
+}
+
+// SetService overrides the address of a service (normally discovered by Login); this is mostly useful for
+// pointing the client at a local server.
+func (c *Client) SetService(server string, service ClientService) {
+	c.init()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.serviceMap[server] = service
+}
+
+// ServiceBaseURL returns the base URL (without a trailing slash) for the given service.
+func (c *Client) ServiceBaseURL(server string) (string, error) {
+	c.mutex.Lock()
 	info, ok := c.serviceMap[server]
+	c.mutex.Unlock()
 	if !ok {
-		return fmt.Errorf("no server info for: %s", server)
+		return "", fmt.Errorf("no server info for: %s", server)
 	}
 	logrus.Debugf("Server %q: %+v", server, info)
 
-	var base string
-	if info.UseSecure > 0 {
-		base = "https://"
-		if info.SecureAddress == "0.0.0.0" {
-			base += c.Server
-		} else {
-			base += info.SecureAddress
-		}
-		base += ":" + fmt.Sprintf("%d", info.SecurePort)
-	} else {
-		base = "http://"
-		if info.Address == "0.0.0.0" {
-			base += c.Server
-		} else {
-			base += info.Address
-		}
-		base += ":" + fmt.Sprintf("%d", info.Port)
+	return info.BaseURL(c.Server), nil
+}
+
+// doRequest sends the request with the cookies for its host and remembers any cookies that come back.
+func (c *Client) doRequest(request *http.Request) (*http.Response, error) {
+	c.mutex.Lock()
+	for _, cookie := range c.hostCookieMap[request.Host] {
+		request.Header.Add("Cookie", cookie)
 	}
+	c.mutex.Unlock()
 
-	return c.RawRequest(ctx, method, base+"/"+strings.TrimPrefix(path, "/"), values, requestData, responseData)
+	for key, values := range request.Header {
+		logrus.WithContext(request.Context()).Debugf("> %s: %v", key, values)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if cookies := response.Header.Values("Set-Cookie"); len(cookies) > 0 {
+		c.mutex.Lock()
+		c.hostCookieMap[request.Host] = cookies
+		c.mutex.Unlock()
+	}
+
+	for key, values := range response.Header {
+		logrus.WithContext(request.Context()).Debugf("< %s: %v", key, values)
+	}
+
+	return response, nil
+}
+
+// HTTPStatusError is returned when the server responds with an HTTP error.
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("http status %d", e.StatusCode)
 }
+
+// RawRequest sends a request and decodes the JSON response into responseData (if given).
+//
+// A string request body is sent as form data.  Note that the request is always sent as a GET, whatever the
+// method; that's what the wcms handlers have always been called with.  Use RawJSONRequest for the APIs that
+// need the method.
 func (c *Client) RawRequest(ctx context.Context, method, path string, values url.Values, requestData, responseData interface{}) error {
 	c.init()
-	if len(values) > 0 {
-		path = path + "?" + values.Encode()
-	}
-	logrus.Debugf("Making request: %s %s", method, path)
 
 	var contentType string
 
@@ -104,6 +265,35 @@ func (c *Client) RawRequest(ctx context.Context, method, path string, values url
 			requestBody = contents
 		}
 	}
+	return c.sendRequest(ctx, http.MethodGet, path, values, requestBody, contentType, responseData)
+}
+
+// RawJSONRequest sends a request with the given method and the request data (if given) as a JSON body, and
+// decodes the JSON response into responseData (if given).
+func (c *Client) RawJSONRequest(ctx context.Context, method, path string, values url.Values, requestData, responseData interface{}) error {
+	c.init()
+
+	var contentType string
+
+	var requestBody []byte
+	if requestData != nil {
+		logrus.WithContext(ctx).Debugf("Request: requestData: [%T]", requestData)
+		contents, err := json.Marshal(requestData)
+		if err != nil {
+			return err
+		}
+		requestBody = contents
+		contentType = "application/json"
+	}
+	return c.sendRequest(ctx, method, path, values, requestBody, contentType, responseData)
+}
+
+func (c *Client) sendRequest(ctx context.Context, method, path string, values url.Values, requestBody []byte, contentType string, responseData interface{}) error {
+	if len(values) > 0 {
+		path = path + "?" + values.Encode()
+	}
+	logrus.Debugf("Making request: %s %s", method, path)
+
 	var requestBodyReader io.Reader
 	if requestBody != nil {
 		logrus.WithContext(ctx).Debugf("Request: Body length: %d", len(requestBody))
@@ -111,7 +301,7 @@ func (c *Client) RawRequest(ctx context.Context, method, path string, values url
 		requestBodyReader = bytes.NewReader(requestBody)
 	}
 
-	request, err := http.NewRequest(http.MethodGet, path, requestBodyReader)
+	request, err := http.NewRequestWithContext(ctx, method, path, requestBodyReader)
 	if err != nil {
 		return err
 	}
@@ -119,31 +309,15 @@ func (c *Client) RawRequest(ctx context.Context, method, path string, values url
 	if contentType != "" {
 		request.Header.Set("Content-Type", contentType)
 	}
-	for _, cookie := range c.hostCookieMap[request.Host] {
-		request.Header.Add("Cookie", cookie)
-	}
-
-	for key, values := range request.Header {
-		logrus.WithContext(ctx).Debugf("> %s: %v", key, values)
-	}
-
-	response, err := c.httpClient.Do(request)
+	response, err := c.doRequest(request)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
 
-	if cookies := response.Header.Values("Set-Cookie"); len(cookies) > 0 {
-		c.hostCookieMap[request.Host] = cookies
-	}
-
-	for key, values := range response.Header {
-		logrus.WithContext(ctx).Debugf("< %s: %v", key, values)
-	}
-
 	logrus.Debugf("Response status: %d", response.StatusCode)
 	if response.StatusCode > 299 {
-		return fmt.Errorf("http status %d", response.StatusCode)
+		return &HTTPStatusError{StatusCode: response.StatusCode}
 	}
 
 	contents, err := io.ReadAll(response.Body)
@@ -169,7 +343,9 @@ func (c *Client) Login(ctx context.Context, server, username, password string) e
 	if err != nil {
 		return err
 	}
+	c.mutex.Lock()
 	c.serviceMap = getServersResponse.ServiceMap
+	c.mutex.Unlock()
 
 	values := url.Values{}
 	values.Set("username", username)
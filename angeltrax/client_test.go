@@ -0,0 +1,40 @@
+package angeltrax
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// newTestClient returns a client whose wcms service is the given handler.
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	host, portString, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("could not split the address: %v", err)
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		t.Fatalf("could not parse the port: %v", err)
+	}
+
+	client := &Client{Key: "test"}
+	client.SetService("wcms", ClientService{Address: host, Port: port, Enable: 1})
+	return client
+}
+
+// readTestForm reads the form body of a wcms request; the wcms handlers are called with a GET and a form body,
+// so the body has to be parsed by hand.
+func readTestForm(r *http.Request) (url.Values, error) {
+	contents, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(contents))
+}
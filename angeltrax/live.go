@@ -0,0 +1,239 @@
+package angeltrax
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/mdvr"
+	"github.com/tekkamanendless/angeltrax/mp4"
+)
+
+// These are the magic values that start the handshake messages with the transmit server.
+var (
+	mediaRequestMagic  = [4]byte{'H', 'X', 'R', 'Q'}
+	mediaResponseMagic = [4]byte{'H', 'X', 'R', 'S'}
+)
+
+// maxMediaMessageSize guards against garbage lengths in the handshake.
+const maxMediaMessageSize = 64 * 1024
+
+// mediaHandshakeTimeout is how long the transmit server has to answer the handshake (and send the stream's
+// header); once the stream is flowing, there is no deadline.
+const mediaHandshakeTimeout = 15 * time.Second
+
+type MediaSessionInput struct {
+	Channel   int       // The one-index of the channel.
+	SubStream bool      // Use the device's lower-quality sub stream (which is easier on the cellular data).
+	Playback  bool      // Play back a recording instead of streaming live.
+	Start     time.Time // For playback; the times are sent as-is in their own location, which should be the device's.
+	End       time.Time
+}
+
+// MediaRequest is the handshake request sent to the transmit server.
+type MediaRequest struct {
+	Key       string `json:"key"`
+	DeviceID  string `json:"deviceid"`
+	Channel   int    `json:"channel"`   // Zero-indexed.
+	Stream    int    `json:"stream"`    // 0 for the main stream; 1 for the sub stream.
+	Mode      string `json:"mode"`      // "live" or "playback".
+	StartTime string `json:"starttime"` // yyyy-mm-dd hh:mm:ss (playback only).
+	EndTime   string `json:"endtime"`   // yyyy-mm-dd hh:mm:ss (playback only).
+}
+
+// MediaResponse is the handshake response from the transmit server.
+type MediaResponse struct {
+	ErrorCode int    `json:"errorcode"`
+	Message   string `json:"message"`
+}
+
+// MediaSession is an open media stream for a single channel of a device.
+type MediaSession struct {
+	conn   net.Conn
+	reader *mdvr.Reader
+	stop   func()
+
+	VideoCodec mp4.VideoCodec
+	Width      int
+	Height     int
+}
+
+// OpenMediaSession opens a live (or playback) stream from a device through the CMS transmit server.
+//
+// The client connects to the device's TransmitIP and TransmitPort over TCP and sends a handshake: four magic
+// bytes ("HXRQ"), a little-endian 32-bit length, and a JSON MediaRequest.  The server answers the same way
+// ("HXRS" and a JSON MediaResponse), and then sends the stream in the same container that recordings use
+// (see the mdvr package).
+//
+// This handshake has not been verified against a real transmit server; the only thing that confirms it is
+// the fake server in this package's tests.  A server that doesn't answer it in time is an error.
+//
+// Canceling the context closes the session.
+func (c *Client) OpenMediaSession(ctx context.Context, device CenterDevice, input MediaSessionInput) (*MediaSession, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityLive); err != nil {
+		return nil, err
+	}
+
+	if input.Channel < 1 {
+		return nil, fmt.Errorf("invalid channel %d: channels start from 1", input.Channel)
+	}
+	host := device.TransmitIP
+	if host == "" || host == "0.0.0.0" {
+		host = c.Server
+	}
+	if device.TransmitPort == 0 {
+		return nil, fmt.Errorf("device %s has no transmit port", device.DeviceID)
+	}
+	address := net.JoinHostPort(host, fmt.Sprintf("%d", device.TransmitPort))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(done) })
+	}
+
+	session, err := openMediaSession(conn, c.Key, device.DeviceID, input, mediaHandshakeTimeout)
+	if err != nil {
+		stop()
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	session.stop = stop
+	return session, nil
+}
+
+func openMediaSession(conn net.Conn, key string, deviceID string, input MediaSessionInput, timeout time.Duration) (*MediaSession, error) {
+	request := MediaRequest{
+		Key:      key,
+		DeviceID: deviceID,
+		Channel:  input.Channel - 1,
+		Mode:     "live",
+	}
+	if input.SubStream {
+		request.Stream = 1
+	}
+	if input.Playback {
+		request.Mode = "playback"
+		request.StartTime = input.Start.Format(RecordingTimeFormat)
+		request.EndTime = input.End.Format(RecordingTimeFormat)
+	}
+	err := conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+	err = writeMediaMessage(conn, mediaRequestMagic, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response MediaResponse
+	err = readMediaMessage(conn, mediaResponseMagic, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.ErrorCode != 0 {
+		return nil, fmt.Errorf("error code %d: %s", response.ErrorCode, response.Message)
+	}
+
+	reader, err := mdvr.NewReader(conn)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return &MediaSession{
+		conn:       conn,
+		reader:     reader,
+		VideoCodec: reader.VideoCodec,
+		Width:      reader.Width,
+		Height:     reader.Height,
+	}, nil
+}
+
+// WriteMediaMessage writes a handshake message; this is exported so that a transmit server can be faked.
+func WriteMediaMessage(w io.Writer, response bool, message interface{}) error {
+	magic := mediaRequestMagic
+	if response {
+		magic = mediaResponseMagic
+	}
+	return writeMediaMessage(w, magic, message)
+}
+
+// ReadMediaMessage reads a handshake message; this is exported so that a transmit server can be faked.
+func ReadMediaMessage(r io.Reader, response bool, message interface{}) error {
+	magic := mediaRequestMagic
+	if response {
+		magic = mediaResponseMagic
+	}
+	return readMediaMessage(r, magic, message)
+}
+
+func writeMediaMessage(w io.Writer, magic [4]byte, message interface{}) error {
+	contents, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 8, 8+len(contents))
+	copy(header, magic[:])
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(contents)))
+	_, err = w.Write(append(header, contents...))
+	return err
+}
+
+func readMediaMessage(r io.Reader, magic [4]byte, message interface{}) error {
+	var header [8]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return fmt.Errorf("could not read the handshake: %w", err)
+	}
+	if [4]byte{header[0], header[1], header[2], header[3]} != magic {
+		return fmt.Errorf("unexpected handshake magic %q", header[0:4])
+	}
+	length := binary.LittleEndian.Uint32(header[4:])
+	if length > maxMediaMessageSize {
+		return fmt.Errorf("handshake message is too long (%d bytes)", length)
+	}
+	contents := make([]byte, length)
+	_, err = io.ReadFull(r, contents)
+	if err != nil {
+		return fmt.Errorf("could not read the handshake: %w", err)
+	}
+	return json.Unmarshal(contents, message)
+}
+
+// ReadFrame returns the next frame of the stream, or io.EOF when the stream ends.
+func (s *MediaSession) ReadFrame() (*mdvr.Frame, error) {
+	return s.reader.ReadFrame()
+}
+
+// Close closes the session.
+func (s *MediaSession) Close() error {
+	if s.stop != nil {
+		s.stop()
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,304 @@
+package angeltrax
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/mdvr"
+	"github.com/tekkamanendless/angeltrax/mp4"
+)
+
+// These are the NAL units of a 320x240 H.264 baseline stream (with Annex-B start codes).
+var (
+	testSPS          = []byte{0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4}
+	testShortSPS     = []byte{0, 0, 0, 1, 0x67, 0x42}
+	testPPS          = []byte{0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80}
+	testIDR          = []byte{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00, 0x33}
+	testNonIDR       = []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x04}
+	testAudioSamples = bytes.Repeat([]byte{0xd5}, 160)
+)
+
+// transmitServer is a stand-in for the CMS transmit server; it answers a single handshake.
+type transmitServer struct {
+	listener net.Listener
+	response MediaResponse
+	stream   []byte // This is sent after a successful handshake.
+
+	done    chan struct{}
+	request MediaRequest
+	err     error
+}
+
+func newTransmitServer(t *testing.T, response MediaResponse, stream []byte) *transmitServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &transmitServer{
+		listener: listener,
+		response: response,
+		stream:   stream,
+		done:     make(chan struct{}),
+	}
+	go server.serve()
+	return server
+}
+
+func (s *transmitServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		s.err = err
+		return
+	}
+	defer conn.Close()
+
+	s.err = ReadMediaMessage(conn, false, &s.request)
+	if s.err != nil {
+		return
+	}
+	s.err = WriteMediaMessage(conn, true, s.response)
+	if s.err != nil || s.response.ErrorCode != 0 {
+		return
+	}
+	_, s.err = conn.Write(s.stream)
+}
+
+func (s *transmitServer) device(t *testing.T) CenterDevice {
+	host, portString, err := net.SplitHostPort(s.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("could not split the address: %v", err)
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		t.Fatalf("could not parse the port: %v", err)
+	}
+	return CenterDevice{DeviceID: "D1", TransmitIP: host, TransmitPort: port}
+}
+
+// mdvrStream builds a stream in the container that the transmit server sends.
+type mdvrStream struct {
+	bytes.Buffer
+}
+
+func newMDVRStream(width, height int) *mdvrStream {
+	var s mdvrStream
+	header := make([]byte, 16)
+	copy(header, "HXVS")
+	binary.LittleEndian.PutUint32(header[4:], uint32(width))
+	binary.LittleEndian.PutUint32(header[8:], uint32(height))
+	s.Write(header)
+	return &s
+}
+
+func (s *mdvrStream) frame(tag string, timestamp time.Duration, data []byte) {
+	header := make([]byte, 16)
+	copy(header, tag)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[8:], uint32(timestamp/time.Millisecond))
+	s.Write(header)
+	s.Write(data)
+}
+
+func (s *mdvrStream) video(timestamp time.Duration, nalus ...[]byte) {
+	s.frame("HXVF", timestamp, bytes.Join(nalus, nil))
+}
+
+func (s *mdvrStream) audio(timestamp time.Duration, samples []byte) {
+	s.frame("HXAF", timestamp, append(make([]byte, 4), samples...))
+}
+
+func TestOpenMediaSession(t *testing.T) {
+	stream := newMDVRStream(320, 240)
+	stream.video(1000*time.Millisecond, testShortSPS, testPPS, testIDR) // The SPS is damaged, so this can't start the stream.
+	stream.audio(1010*time.Millisecond, testAudioSamples)
+	stream.video(1040*time.Millisecond, testSPS, testPPS, testIDR)
+	stream.video(1080*time.Millisecond, testNonIDR)
+	stream.audio(1090*time.Millisecond, testAudioSamples)
+	stream.video(1120*time.Millisecond, testSPS, testPPS, testIDR)
+	stream.video(1160*time.Millisecond, testNonIDR)
+	stream.Write([]byte("HXFI"))
+
+	server := newTransmitServer(t, MediaResponse{}, stream.Bytes())
+	client := &Client{Key: "secret-key"}
+
+	session, err := client.OpenMediaSession(context.Background(), server.device(t), MediaSessionInput{Channel: 2, SubStream: true})
+	if err != nil {
+		t.Fatalf("could not open the session: %v", err)
+	}
+	defer session.Close()
+
+	if session.VideoCodec != mp4.VideoCodecH264 || session.Width != 320 || session.Height != 240 {
+		t.Errorf("wrong stream: %s %dx%d", session.VideoCodec, session.Width, session.Height)
+	}
+
+	fragmenter := mp4.NewFragmenter(session.VideoCodec)
+	var fragments []*mp4.Fragment
+	var videoFrames, audioFrames int
+	for {
+		frame, err := session.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read a frame: %v", err)
+		}
+		if frame.Type != mdvr.FrameTypeVideo {
+			audioFrames++
+			if !bytes.Equal(frame.Data, testAudioSamples) {
+				t.Errorf("wrong audio samples: %x", frame.Data)
+			}
+			continue
+		}
+		videoFrames++
+		fragment, err := fragmenter.WriteVideo(frame.Timestamp, frame.Data)
+		if err != nil {
+			t.Fatalf("could not write frame %d: %v", videoFrames, err)
+		}
+		if videoFrames == 1 && fragmenter.InitSegment() != nil {
+			t.Errorf("the stream started with a damaged SPS")
+		}
+		if fragment != nil {
+			fragments = append(fragments, fragment)
+		}
+	}
+	if fragment := fragmenter.Flush(); fragment != nil {
+		fragments = append(fragments, fragment)
+	}
+	<-server.done
+
+	if server.err != nil {
+		t.Errorf("server error: %v", server.err)
+	}
+	expectedRequest := MediaRequest{Key: "secret-key", DeviceID: "D1", Channel: 1, Stream: 1, Mode: "live"}
+	if server.request != expectedRequest {
+		t.Errorf("wrong request: %+v", server.request)
+	}
+	if videoFrames != 5 || audioFrames != 2 {
+		t.Errorf("wrong frames: %d video and %d audio", videoFrames, audioFrames)
+	}
+
+	init := fragmenter.InitSegment()
+	if len(init) < 8 || string(init[4:8]) != "ftyp" || !bytes.Contains(init, []byte("avcC")) {
+		t.Errorf("bad initialization segment: %x", init)
+	}
+	if codec := fragmenter.Codec(); codec != "avc1.42c01e" {
+		t.Errorf("wrong codec: %s", codec)
+	}
+	if len(fragments) != 2 {
+		t.Fatalf("wrong number of fragments: %d", len(fragments))
+	}
+	for i, fragment := range fragments {
+		if fragment.Sequence != uint32(i+1) {
+			t.Errorf("fragment %d: wrong sequence: %d", i, fragment.Sequence)
+		}
+		if fragment.Start != time.Duration(i)*80*time.Millisecond || fragment.Duration != 80*time.Millisecond {
+			t.Errorf("fragment %d: wrong times: %s + %s", i, fragment.Start, fragment.Duration)
+		}
+		if len(fragment.Data) < 8 || string(fragment.Data[4:8]) != "moof" || !bytes.Contains(fragment.Data, []byte("mdat")) {
+			t.Errorf("fragment %d: bad data: %x", i, fragment.Data)
+		}
+	}
+}
+
+func TestOpenMediaSessionPlayback(t *testing.T) {
+	server := newTransmitServer(t, MediaResponse{}, newMDVRStream(640, 480).Bytes())
+	client := &Client{Key: "secret-key"}
+
+	input := MediaSessionInput{
+		Channel:  1,
+		Playback: true,
+		Start:    time.Date(2023, 4, 5, 6, 0, 0, 0, time.UTC),
+		End:      time.Date(2023, 4, 5, 7, 0, 0, 0, time.UTC),
+	}
+	session, err := client.OpenMediaSession(context.Background(), server.device(t), input)
+	if err != nil {
+		t.Fatalf("could not open the session: %v", err)
+	}
+	defer session.Close()
+	if _, err := session.ReadFrame(); err != io.EOF {
+		t.Errorf("expected the end of the stream; got: %v", err)
+	}
+	<-server.done
+
+	expectedRequest := MediaRequest{
+		Key:       "secret-key",
+		DeviceID:  "D1",
+		Channel:   0,
+		Mode:      "playback",
+		StartTime: "2023-04-05 06:00:00",
+		EndTime:   "2023-04-05 07:00:00",
+	}
+	if server.request != expectedRequest {
+		t.Errorf("wrong request: %+v", server.request)
+	}
+}
+
+func TestOpenMediaSessionError(t *testing.T) {
+	server := newTransmitServer(t, MediaResponse{ErrorCode: 3, Message: "device offline"}, nil)
+	client := &Client{Key: "secret-key"}
+
+	_, err := client.OpenMediaSession(context.Background(), server.device(t), MediaSessionInput{Channel: 1})
+	if err == nil || !strings.Contains(err.Error(), "device offline") {
+		t.Errorf("expected the server's error; got: %v", err)
+	}
+	<-server.done
+}
+
+func TestOpenMediaSessionBadHandshake(t *testing.T) {
+	server, conn := net.Pipe()
+	defer conn.Close()
+	go func() {
+		defer server.Close()
+		var request MediaRequest
+		if err := ReadMediaMessage(server, false, &request); err != nil {
+			return
+		}
+		// Answer with the request's magic.
+		_ = WriteMediaMessage(server, false, MediaResponse{})
+	}()
+
+	_, err := openMediaSession(conn, "secret-key", "D1", MediaSessionInput{Channel: 1}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "magic") {
+		t.Errorf("expected a handshake error; got: %v", err)
+	}
+}
+
+func TestOpenMediaSessionNoAnswer(t *testing.T) {
+	server, conn := net.Pipe()
+	defer conn.Close()
+	go func() {
+		defer server.Close()
+		var request MediaRequest
+		if err := ReadMediaMessage(server, false, &request); err != nil {
+			return
+		}
+		// Never answer.
+		_, _ = io.Copy(io.Discard, server)
+	}()
+
+	_, err := openMediaSession(conn, "secret-key", "D1", MediaSessionInput{Channel: 1}, 50*time.Millisecond)
+	var netError net.Error
+	if !errors.As(err, &netError) || !netError.Timeout() {
+		t.Errorf("expected a timeout; got: %v", err)
+	}
+}
+
+func TestOpenMediaSessionInvalidChannel(t *testing.T) {
+	client := &Client{Key: "secret-key"}
+	_, err := client.OpenMediaSession(context.Background(), CenterDevice{DeviceID: "D1", TransmitPort: 1}, MediaSessionInput{Channel: 0})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,132 @@
+package angeltrax
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type AutoDownloadTaskChangeAction string
+
+const (
+	AutoDownloadTaskChangeCreate AutoDownloadTaskChangeAction = "create"
+	AutoDownloadTaskChangeUpdate AutoDownloadTaskChangeAction = "update"
+	AutoDownloadTaskChangeDelete AutoDownloadTaskChangeAction = "delete"
+)
+
+// AutoDownloadTaskChange is a single step needed to turn the current tasks into the desired tasks.
+type AutoDownloadTaskChange struct {
+	Action      AutoDownloadTaskChangeAction
+	Desired     *CreateAutoDownloadTaskInput     // This is set for creates and updates.
+	Current     *MonitorAutoDownloadTaskResponse // This is set for updates and deletes.
+	Differences []string                         // For updates, this is a human-readable list of the fields that differ.
+}
+
+// UpdateInput returns the input to apply an update; settings that the desired task does not manage are kept.
+func (c AutoDownloadTaskChange) UpdateInput() UpdateAutoDownloadTaskInput {
+	input := c.Current.UpdateInput()
+	input.TaskName = c.Desired.TaskName
+	input.StartExecute = c.Desired.StartExecute
+	input.EndExecute = c.Desired.EndExecute
+	input.StartTime = c.Desired.StartTime
+	input.EndTime = c.Desired.EndTime
+	input.TaskType = c.Desired.TaskType
+	input.Period = c.Desired.Period
+	input.TaskChannels = append([]int{}, c.Desired.TaskChannels...)
+	input.EffectiveDays = c.Desired.EffectiveDays
+	return input
+}
+
+// PlanAutoDownloadTasks compares the desired tasks to the current ones and returns the changes needed.
+//
+// Tasks are matched up by their device ID and task name, so the task name is the task's identity and should
+// not be derived from its settings; any other difference (such as the window or the channels) results in an
+// update.  The desired tasks must not reuse a name on a device.  If the server has more than one task with the
+// same name on a device, the one with the lowest task ID is kept and the rest are deleted.  Every current task
+// that is not desired results in a delete; it is up to the caller to decide whether to apply those.
+func PlanAutoDownloadTasks(desired []CreateAutoDownloadTaskInput, current []MonitorAutoDownloadTaskResponse) ([]AutoDownloadTaskChange, error) {
+	key := func(deviceID string, taskName string) string {
+		return deviceID + "|" + taskName
+	}
+
+	desiredMap := map[string]bool{}
+	for _, task := range desired {
+		k := key(task.DeviceID, task.TaskName)
+		if desiredMap[k] {
+			return nil, fmt.Errorf("task name %q is used more than once for device %s", task.TaskName, task.DeviceID)
+		}
+		desiredMap[k] = true
+	}
+
+	currentMap := map[string]*MonitorAutoDownloadTaskResponse{}
+	for i := range current {
+		k := key(current[i].DeviceID, current[i].TaskName)
+		if existing, ok := currentMap[k]; ok && existing.TaskID < current[i].TaskID {
+			continue
+		}
+		currentMap[k] = &current[i]
+	}
+
+	var changes []AutoDownloadTaskChange
+	matched := map[*MonitorAutoDownloadTaskResponse]bool{}
+	for i := range desired {
+		currentTask, ok := currentMap[key(desired[i].DeviceID, desired[i].TaskName)]
+		if !ok {
+			changes = append(changes, AutoDownloadTaskChange{
+				Action:  AutoDownloadTaskChangeCreate,
+				Desired: &desired[i],
+			})
+			continue
+		}
+		matched[currentTask] = true
+
+		differences := autoDownloadTaskDifferences(desired[i], *currentTask)
+		if len(differences) == 0 {
+			continue
+		}
+		changes = append(changes, AutoDownloadTaskChange{
+			Action:      AutoDownloadTaskChangeUpdate,
+			Desired:     &desired[i],
+			Current:     currentTask,
+			Differences: differences,
+		})
+	}
+	for i := range current {
+		if matched[&current[i]] {
+			continue
+		}
+		changes = append(changes, AutoDownloadTaskChange{
+			Action:  AutoDownloadTaskChangeDelete,
+			Current: &current[i],
+		})
+	}
+	return changes, nil
+}
+
+func autoDownloadTaskDifferences(desired CreateAutoDownloadTaskInput, current MonitorAutoDownloadTaskResponse) []string {
+	var differences []string
+	compare := func(name string, currentValue interface{}, desiredValue interface{}) {
+		if fmt.Sprintf("%v", currentValue) != fmt.Sprintf("%v", desiredValue) {
+			differences = append(differences, fmt.Sprintf("%s: %v -> %v", name, currentValue, desiredValue))
+		}
+	}
+	channelString := func(channels []int) string {
+		sorted := append([]int{}, channels...)
+		sort.Ints(sorted)
+		var parts []string
+		for _, channel := range sorted {
+			parts = append(parts, fmt.Sprintf("%d", channel))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	compare("StartExecute", current.StartExecute, desired.StartExecute)
+	compare("EndExecute", current.EndExecute, desired.EndExecute)
+	compare("StartTime", current.StartTime, desired.StartTime)
+	compare("EndTime", current.EndTime, desired.EndTime)
+	compare("TaskType", current.TaskType, desired.TaskType)
+	compare("Period", current.Period, desired.Period)
+	compare("TaskChannel", channelString(current.TaskChannel), channelString(desired.TaskChannels))
+	compare("Effective", current.Effective, desired.EffectiveDays)
+	return differences
+}
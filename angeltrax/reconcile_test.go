@@ -0,0 +1,172 @@
+package angeltrax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPlanAutoDownloadTasks(t *testing.T) {
+	desiredTask := func(deviceID, name, startTime string, channels ...int) CreateAutoDownloadTaskInput {
+		return CreateAutoDownloadTaskInput{
+			TaskName:     name,
+			DeviceID:     deviceID,
+			StartTime:    startTime,
+			EndTime:      "07:00:00",
+			StartExecute: "2023-04-05",
+			EndExecute:   "2023-04-05",
+			TaskChannels: channels,
+		}
+	}
+	currentTask := func(taskID int, deviceID, name, startTime string, channels ...int) MonitorAutoDownloadTaskResponse {
+		return MonitorAutoDownloadTaskResponse{
+			TaskID:       taskID,
+			TaskName:     name,
+			DeviceID:     deviceID,
+			StartTime:    startTime,
+			EndTime:      "07:00:00",
+			StartExecute: "2023-04-05",
+			EndExecute:   "2023-04-05",
+			TaskChannel:  channels,
+		}
+	}
+	// describe summarizes a change as "action:device:name:task ID".
+	describe := func(change AutoDownloadTaskChange) string {
+		switch change.Action {
+		case AutoDownloadTaskChangeCreate:
+			return fmt.Sprintf("create:%s:%s", change.Desired.DeviceID, change.Desired.TaskName)
+		case AutoDownloadTaskChangeUpdate:
+			return fmt.Sprintf("update:%s:%s:%d", change.Desired.DeviceID, change.Desired.TaskName, change.Current.TaskID)
+		case AutoDownloadTaskChangeDelete:
+			return fmt.Sprintf("delete:%s:%s:%d", change.Current.DeviceID, change.Current.TaskName, change.Current.TaskID)
+		}
+		return string(change.Action)
+	}
+
+	rows := []struct {
+		name        string
+		desired     []CreateAutoDownloadTaskInput
+		current     []MonitorAutoDownloadTaskResponse
+		changes     []string
+		differences []string // The differences of the first update.
+		err         string
+	}{
+		{
+			name:    "nothing",
+			changes: nil,
+		},
+		{
+			name:    "create",
+			desired: []CreateAutoDownloadTaskInput{desiredTask("D1", "morning", "06:00:00", 1)},
+			changes: []string{"create:D1:morning"},
+		},
+		{
+			name:    "same name on another device",
+			desired: []CreateAutoDownloadTaskInput{desiredTask("D2", "morning", "06:00:00", 1)},
+			current: []MonitorAutoDownloadTaskResponse{currentTask(1, "D1", "morning", "06:00:00", 1)},
+			changes: []string{"create:D2:morning", "delete:D1:morning:1"},
+		},
+		{
+			name:    "unchanged",
+			desired: []CreateAutoDownloadTaskInput{desiredTask("D1", "morning", "06:00:00", 2, 1)},
+			current: []MonitorAutoDownloadTaskResponse{currentTask(1, "D1", "morning", "06:00:00", 1, 2)},
+			changes: nil,
+		},
+		{
+			name:        "window change",
+			desired:     []CreateAutoDownloadTaskInput{desiredTask("D1", "morning", "05:00:00", 1)},
+			current:     []MonitorAutoDownloadTaskResponse{currentTask(1, "D1", "morning", "06:00:00", 1)},
+			changes:     []string{"update:D1:morning:1"},
+			differences: []string{"StartTime: 06:00:00 -> 05:00:00"},
+		},
+		{
+			name:        "channel change",
+			desired:     []CreateAutoDownloadTaskInput{desiredTask("D1", "morning", "06:00:00", 1, 3)},
+			current:     []MonitorAutoDownloadTaskResponse{currentTask(1, "D1", "morning", "06:00:00", 1)},
+			changes:     []string{"update:D1:morning:1"},
+			differences: []string{"TaskChannel: 1 -> 1,3"},
+		},
+		{
+			name:    "delete",
+			current: []MonitorAutoDownloadTaskResponse{currentTask(1, "D1", "morning", "06:00:00", 1)},
+			changes: []string{"delete:D1:morning:1"},
+		},
+		{
+			name:    "duplicates on the server",
+			desired: []CreateAutoDownloadTaskInput{desiredTask("D1", "morning", "05:00:00", 1)},
+			current: []MonitorAutoDownloadTaskResponse{
+				currentTask(7, "D1", "morning", "06:00:00", 1),
+				currentTask(3, "D1", "morning", "06:00:00", 1),
+				currentTask(9, "D1", "morning", "05:00:00", 1),
+			},
+			changes:     []string{"update:D1:morning:3", "delete:D1:morning:7", "delete:D1:morning:9"},
+			differences: []string{"StartTime: 06:00:00 -> 05:00:00"},
+		},
+		{
+			name: "undesired duplicates on the server",
+			current: []MonitorAutoDownloadTaskResponse{
+				currentTask(1, "D1", "morning", "06:00:00", 1),
+				currentTask(2, "D1", "morning", "06:00:00", 1),
+			},
+			changes: []string{"delete:D1:morning:1", "delete:D1:morning:2"},
+		},
+		{
+			name: "duplicates in the desired tasks",
+			desired: []CreateAutoDownloadTaskInput{
+				desiredTask("D1", "morning", "06:00:00", 1),
+				desiredTask("D1", "morning", "08:00:00", 1),
+			},
+			err: `task name "morning" is used more than once for device D1`,
+		},
+	}
+	for _, row := range rows {
+		t.Run(row.name, func(t *testing.T) {
+			changes, err := PlanAutoDownloadTasks(row.desired, row.current)
+			if row.err != "" {
+				if err == nil || !strings.Contains(err.Error(), row.err) {
+					t.Fatalf("expected error %q; got: %v", row.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not plan: %v", err)
+			}
+
+			var descriptions []string
+			var differences []string
+			for _, change := range changes {
+				descriptions = append(descriptions, describe(change))
+				if change.Action == AutoDownloadTaskChangeUpdate && differences == nil {
+					differences = change.Differences
+				}
+			}
+			if strings.Join(descriptions, " ") != strings.Join(row.changes, " ") {
+				t.Errorf("wrong changes:\n%v\nexpected:\n%v", descriptions, row.changes)
+			}
+			if strings.Join(differences, "; ") != strings.Join(row.differences, "; ") {
+				t.Errorf("wrong differences: %v (expected %v)", differences, row.differences)
+			}
+		})
+	}
+}
+
+func TestAutoDownloadTaskChangeUpdateInput(t *testing.T) {
+	current := MonitorAutoDownloadTaskResponse{
+		TaskID:    5,
+		TaskName:  "morning",
+		DeviceID:  "D1",
+		StartTime: "06:00:00",
+		Stream:    0,
+		StoreType: 1,
+	}
+	desired := CreateAutoDownloadTaskInput{TaskName: "morning", DeviceID: "D1", StartTime: "05:00:00", TaskChannels: []int{2}}
+	input := AutoDownloadTaskChange{Action: AutoDownloadTaskChangeUpdate, Desired: &desired, Current: &current}.UpdateInput()
+
+	if input.TaskID != 5 || input.StartTime != "05:00:00" || len(input.TaskChannels) != 1 || input.TaskChannels[0] != 2 {
+		t.Errorf("wrong input: %+v", input)
+	}
+	// The settings that the desired task doesn't manage are kept.
+	if input.Stream != 0 || input.Storetype != 1 || !input.fromTask {
+		t.Errorf("the stream settings were not kept: %+v", input)
+	}
+}
@@ -3,14 +3,17 @@ package angeltrax
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 )
 
 type GetServersResponse struct {
 	ServiceMap        map[string]ClientService `json:"-"`
-	ClienPath         string                   `json:"clienpath"`      // This is probably a typo on their part.
-	LicenseTimeout    string                   `json:"licensetimeout"` // This is probably a date.
-	LicenseTimeoutTip int                      `json:"licensetimeouttip"`
-	ServerDate        string                   `json:"serverdate"`
+	ClienPath         string                   `json:"clienpath"`         // This is probably a typo on their part.
+	LicenseTimeout    string                   `json:"licensetimeout"`    // The date that the license expires; see LicenseExpiry.
+	LicenseTimeoutTip int                      `json:"licensetimeouttip"` // This is probably the number of days before the expiry that the CMS starts warning about it.
+	ServerDate        string                   `json:"serverdate"`        // The server's current time; see ServerTime.
 	Support           []string                 `json:"support"`
 	Upgrade           int                      `json:"upgrade"`
 	Version           string                   `json:"version"`
@@ -73,6 +76,51 @@ func (r *GetServersResponse) UnmarshalJSON(contents []byte) error {
 	return nil
 }
 
+// serverTimeFormats are the formats that the dates in GetServersResponse have been seen in.
+var serverTimeFormats = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	"20060102",
+}
+
+// parseServerTime parses a date from GetServersResponse in the given location.
+func parseServerTime(value string, location *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, format := range serverTimeFormats {
+		t, err := time.ParseInLocation(format, value, location)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse date %q", value)
+}
+
+// LicenseExpiry returns when the CMS license expires, in the given location (which should be the server's).  This is
+// zero if the server didn't give an expiry date (which presumably means that the license doesn't expire).
+func (r GetServersResponse) LicenseExpiry(location *time.Location) (time.Time, error) {
+	if r.LicenseTimeout == "" || r.LicenseTimeout == "0" {
+		return time.Time{}, nil
+	}
+	return parseServerTime(r.LicenseTimeout, location)
+}
+
+// ServerTime returns the server's current time, in the given location (which should be the server's).
+func (r GetServersResponse) ServerTime(location *time.Location) (time.Time, error) {
+	return parseServerTime(r.ServerDate, location)
+}
+
+// ServiceNames returns the names of the services in the service map, sorted.
+func (r GetServersResponse) ServiceNames() []string {
+	var names []string
+	for name := range r.ServiceMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type ClientService struct {
 	Address       string `json:"ip,omitempty"`
 	Port          int    `json:"port,omitempty"`
@@ -81,3 +129,28 @@ type ClientService struct {
 	Enable        int    `json:"enable"`
 	UseSecure     int    `json:"usesecure,omitempty"`
 }
+
+// BaseURL returns the base URL (without a trailing slash) of the service.
+//
+// An address of "0.0.0.0" means the server itself, so the given server is used instead.
+func (s ClientService) BaseURL(server string) string {
+	var base string
+	if s.UseSecure > 0 {
+		base = "https://"
+		if s.SecureAddress == "0.0.0.0" {
+			base += server
+		} else {
+			base += s.SecureAddress
+		}
+		base += ":" + fmt.Sprintf("%d", s.SecurePort)
+	} else {
+		base = "http://"
+		if s.Address == "0.0.0.0" {
+			base += server
+		} else {
+			base += s.Address
+		}
+		base += ":" + fmt.Sprintf("%d", s.Port)
+	}
+	return base
+}
@@ -0,0 +1,242 @@
+package angeltrax
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EventType is the kind of event delivered by Subscribe.
+type EventType string
+
+const (
+	EventTypeAlarm   EventType = "alarm"
+	EventTypeOnline  EventType = "online"
+	EventTypeOffline EventType = "offline"
+	EventTypeGPS     EventType = "gps"
+)
+
+// Event is something that happened on a device.
+type Event struct {
+	Type       EventType   `json:"type"`
+	Time       time.Time   `json:"time"`
+	DeviceID   string      `json:"deviceId"`
+	CarLicense string      `json:"carLicense,omitempty"`
+	Cursor     string      `json:"cursor"`             // Pass this as SubscribeFilter.Cursor to resume after this event.
+	Alarm      *Alarm      `json:"alarm,omitempty"`    // For alarm events.
+	Position   *TrackPoint `json:"position,omitempty"` // For GPS events.
+}
+
+// SubscribeFilter controls Subscribe.
+type SubscribeFilter struct {
+	DeviceIDs  []string    // If empty, events for every device are delivered.
+	EventTypes []EventType // If empty, every type of event is delivered.
+	Cursor     string      // If set, the subscription resumes after the event with this cursor.
+
+	PollTimeout      time.Duration // How long the server may hold each poll open; this defaults to 30 seconds.
+	RetryInterval    time.Duration // The first delay after an error; this defaults to 1 second.
+	MaxRetryInterval time.Duration // The delay after repeated errors is doubled up to this; this defaults to 30 seconds.
+	Buffer           int           // The size of the channel's buffer.
+
+	OnError func(error) // This is called (from the subscription's goroutine) whenever a poll fails.
+}
+
+type pushResponse struct {
+	Cursor string      `json:"cursor"`
+	Events []pushEvent `json:"events"`
+}
+
+type pushEvent struct {
+	Cursor     string    `json:"cursor"`
+	Type       EventType `json:"type"`
+	Time       string    `json:"time"` // yyyy-mm-dd hh:mm:ss
+	DeviceID   string    `json:"device"`
+	CarLicense string    `json:"carlicense"`
+	Alarm      *Alarm    `json:"alarm"`
+	GPS        *trackRow `json:"gps"`
+}
+
+// Subscribe delivers the events from the alarm center as they happen.
+//
+// The alarm center pushes events to the web client by long-polling: each poll names the cursor of the last
+// event it has seen, and the server answers as soon as there is something newer (or when the poll times
+// out).  If a poll fails, the subscription logs in to the alarm center again and resumes from the last
+// cursor, backing off while the failures continue.
+//
+// The channel is closed once the context is done.  This requires RegisterLogin to have been called first.
+func (c *Client) Subscribe(ctx context.Context, filter SubscribeFilter) (<-chan Event, error) {
+	c.init()
+
+	if err := c.requireCapability(CapabilityPush); err != nil {
+		return nil, err
+	}
+
+	if filter.PollTimeout <= 0 {
+		filter.PollTimeout = 30 * time.Second
+	}
+	if filter.RetryInterval <= 0 {
+		filter.RetryInterval = time.Second
+	}
+	if filter.MaxRetryInterval <= 0 {
+		filter.MaxRetryInterval = 30 * time.Second
+	}
+	if filter.MaxRetryInterval < filter.RetryInterval {
+		filter.MaxRetryInterval = filter.RetryInterval
+	}
+	for _, eventType := range filter.EventTypes {
+		switch eventType {
+		case EventTypeAlarm, EventTypeOnline, EventTypeOffline, EventTypeGPS:
+		default:
+			return nil, fmt.Errorf("invalid event type %q", eventType)
+		}
+	}
+
+	events := make(chan Event, filter.Buffer)
+	go func() {
+		defer close(events)
+
+		cursor := filter.Cursor
+		retryInterval := filter.RetryInterval
+		var failing bool
+		for ctx.Err() == nil {
+			if failing {
+				// The session may have expired, so log in again before resuming.
+				_, err := c.RegisterLogin(ctx)
+				if err != nil && filter.OnError != nil && ctx.Err() == nil {
+					filter.OnError(fmt.Errorf("could not log in again: %w", err))
+				}
+			}
+
+			output, err := c.poll(ctx, filter, cursor)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if filter.OnError != nil {
+					filter.OnError(err)
+				}
+				failing = true
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryInterval):
+				}
+				retryInterval *= 2
+				if retryInterval > filter.MaxRetryInterval {
+					retryInterval = filter.MaxRetryInterval
+				}
+				continue
+			}
+			failing = false
+			retryInterval = filter.RetryInterval
+
+			for _, rawEvent := range output.Events {
+				event, err := rawEvent.event()
+				if err != nil {
+					if filter.OnError != nil {
+						filter.OnError(err)
+					}
+				} else if filter.matches(event) {
+					select {
+					case <-ctx.Done():
+						return
+					case events <- event:
+					}
+				}
+				// Not every server names the cursor of the batch, so keep track of the last event's.
+				if rawEvent.Cursor != "" {
+					cursor = rawEvent.Cursor
+				}
+			}
+			if output.Cursor != "" {
+				cursor = output.Cursor
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (c *Client) poll(ctx context.Context, filter SubscribeFilter, cursor string) (*pushResponse, error) {
+	// Give the server a little longer than the poll timeout before giving up on it.
+	ctx, cancel := context.WithTimeout(ctx, filter.PollTimeout+15*time.Second)
+	defer cancel()
+
+	values := url.Values{}
+
+	var eventTypeStrings []string
+	for _, eventType := range filter.EventTypes {
+		eventTypeStrings = append(eventTypeStrings, string(eventType))
+	}
+	inputValues := url.Values{}
+	inputValues.Set("action", "poll")
+	inputValues.Set("cursor", cursor)
+	inputValues.Set("Device", strings.Join(filter.DeviceIDs, ","))
+	inputValues.Set("types", strings.Join(eventTypeStrings, ","))
+	inputValues.Set("timeout", fmt.Sprintf("%d", int(filter.PollTimeout.Seconds())))
+	inputValuesString := inputValues.Encode()
+
+	var output pushResponse
+	err := c.RawServiceRequest(ctx, "wcms", http.MethodPost, "/Plugin/AlarmCenter/Push.ashx", values, inputValuesString, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// matches returns true if the event passes the filter; the server is asked to do the filtering, but not every
+// version does.
+func (f SubscribeFilter) matches(event Event) bool {
+	if len(f.DeviceIDs) > 0 {
+		var found bool
+		for _, deviceID := range f.DeviceIDs {
+			if deviceID == event.DeviceID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.EventTypes) > 0 {
+		var found bool
+		for _, eventType := range f.EventTypes {
+			if eventType == event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// event converts the raw event; the times are taken to be in the local time zone.
+func (e pushEvent) event() (Event, error) {
+	t, err := time.ParseInLocation(RecordingTimeFormat, e.Time, time.Local)
+	if err != nil {
+		return Event{}, fmt.Errorf("could not parse event time %q: %w", e.Time, err)
+	}
+	event := Event{
+		Type:       e.Type,
+		Time:       t,
+		DeviceID:   e.DeviceID,
+		CarLicense: e.CarLicense,
+		Cursor:     e.Cursor,
+		Alarm:      e.Alarm,
+	}
+	if e.GPS != nil {
+		point, err := e.GPS.point(time.Local)
+		if err != nil {
+			return Event{}, err
+		}
+		event.Position = &point
+	}
+	return event, nil
+}
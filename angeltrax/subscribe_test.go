@@ -0,0 +1,184 @@
+package angeltrax
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushServer is a stand-in for the alarm center; each poll is answered by the next of its responses.
+type pushServer struct {
+	t         *testing.T
+	mutex     sync.Mutex
+	responses []func(w http.ResponseWriter, form url.Values)
+	cursors   []string // The cursor of each poll.
+	types     []string // The types of each poll.
+	logins    int
+}
+
+func (s *pushServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	form, err := readTestForm(r)
+	if err != nil {
+		s.t.Errorf("could not read the request: %v", err)
+		return
+	}
+
+	s.mutex.Lock()
+	switch r.URL.Path {
+	case "/Plugin/RegisterLogin/default.ashx":
+		s.logins++
+		s.mutex.Unlock()
+		fmt.Fprint(w, `{"errorcode":200,"data":[]}`)
+	case "/Plugin/AlarmCenter/Push.ashx":
+		s.cursors = append(s.cursors, form.Get("cursor"))
+		s.types = append(s.types, form.Get("types"))
+		var respond func(w http.ResponseWriter, form url.Values)
+		if len(s.responses) > 0 {
+			respond = s.responses[0]
+			s.responses = s.responses[1:]
+		}
+		s.mutex.Unlock()
+		if respond == nil {
+			// Hold the poll open until the client gives up on it.
+			<-r.Context().Done()
+			return
+		}
+		respond(w, form)
+	default:
+		s.mutex.Unlock()
+		http.NotFound(w, r)
+	}
+}
+
+func receiveEvents(t *testing.T, events <-chan Event, count int) []Event {
+	var output []Event
+	for len(output) < count {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("the channel was closed after %d events", len(output))
+			}
+			output = append(output, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d events", len(output))
+		}
+	}
+	return output
+}
+
+func TestSubscribe(t *testing.T) {
+	respondWith := func(body string) func(w http.ResponseWriter, form url.Values) {
+		return func(w http.ResponseWriter, form url.Values) {
+			fmt.Fprint(w, body)
+		}
+	}
+	server := &pushServer{
+		t: t,
+		responses: []func(w http.ResponseWriter, form url.Values){
+			// This server doesn't name the cursor of the batch.
+			respondWith(`{"events":[` +
+				`{"cursor":"c1","type":"alarm","time":"2023-04-05 06:07:08","device":"D1","alarm":{"AlarmID":"A1"}},` +
+				`{"cursor":"c2","type":"gps","time":"2023-04-05 06:07:09","device":"D1","gps":{"gpstime":"2023-04-05 06:07:09","lat":1.5,"lng":2.5}}` +
+				`]}`),
+			func(w http.ResponseWriter, form url.Values) {
+				http.Error(w, "session expired", http.StatusInternalServerError)
+			},
+			// This server ignores the event types.
+			respondWith(`{"cursor":"c4","events":[` +
+				`{"cursor":"c3","type":"online","time":"2023-04-05 06:07:10","device":"D1"},` +
+				`{"cursor":"c4","type":"alarm","time":"2023-04-05 06:07:11","device":"D1","alarm":{"AlarmID":"A2"}}` +
+				`]}`),
+		},
+	}
+	client := newTestClient(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var errorMutex sync.Mutex
+	var pollErrors []error
+	events, err := client.Subscribe(ctx, SubscribeFilter{
+		EventTypes:    []EventType{EventTypeAlarm, EventTypeGPS},
+		Cursor:        "c0",
+		PollTimeout:   time.Second,
+		RetryInterval: 10 * time.Millisecond,
+		OnError: func(err error) {
+			errorMutex.Lock()
+			defer errorMutex.Unlock()
+			pollErrors = append(pollErrors, err)
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+
+	received := receiveEvents(t, events, 3)
+	var cursors []string
+	for _, event := range received {
+		cursors = append(cursors, event.Cursor)
+	}
+	if fmt.Sprint(cursors) != "[c1 c2 c4]" {
+		t.Errorf("wrong events: %v", cursors)
+	}
+	if received[0].Alarm == nil || received[0].Alarm.AlarmID != "A1" {
+		t.Errorf("wrong alarm: %+v", received[0].Alarm)
+	}
+	if received[1].Position == nil || received[1].Position.Latitude != 1.5 || received[1].Position.Longitude != 2.5 {
+		t.Errorf("wrong position: %+v", received[1].Position)
+	}
+	if want := time.Date(2023, 4, 5, 6, 7, 8, 0, time.Local); !received[0].Time.Equal(want) {
+		t.Errorf("wrong time: %v (expected %v)", received[0].Time, want)
+	}
+
+	// Wait for the client to start the poll after the last batch.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		server.mutex.Lock()
+		polls := len(server.cursors)
+		server.mutex.Unlock()
+		if polls >= 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the fourth poll")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	for range events {
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	// The subscription resumes from the given cursor, then from the last event when the batch has no cursor,
+	// retries from the same place after the failure, and then uses the batch's cursor.
+	if fmt.Sprint(server.cursors) != "[c0 c2 c2 c4]" {
+		t.Errorf("wrong cursors: %v", server.cursors)
+	}
+	for _, types := range server.types {
+		if types != "alarm,gps" {
+			t.Errorf("wrong types: %q", types)
+		}
+	}
+	if server.logins != 1 {
+		t.Errorf("wrong number of logins after the failure: %d", server.logins)
+	}
+	errorMutex.Lock()
+	defer errorMutex.Unlock()
+	if len(pollErrors) != 1 {
+		t.Errorf("wrong errors: %v", pollErrors)
+	}
+}
+
+func TestSubscribeInvalidEventType(t *testing.T) {
+	client := newTestClient(t, http.NotFoundHandler())
+
+	_, err := client.Subscribe(context.Background(), SubscribeFilter{EventTypes: []EventType{"bogus"}})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,304 @@
+package angeltrax
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// WaitForTaskOptions controls how WaitForTask polls.
+type WaitForTaskOptions struct {
+	DeviceID    string        // If empty, this is looked up from the task.
+	Date        string        // yyyy-mm-dd (optional)
+	Interval    time.Duration // The initial poll interval; this defaults to 10 seconds.
+	MaxInterval time.Duration // The longest poll interval; this defaults to 1 minute.
+	Backoff     float64       // The interval is multiplied by this every time nothing changes; this defaults to 1.5.
+
+	// OnProgress, if set, is called for every progress event.
+	OnProgress func(TaskProgressEvent)
+	// Events, if set, receives every progress event.  It is never closed by WaitForTask.
+	Events chan<- TaskProgressEvent
+}
+
+// TaskProgressEvent describes a change to a single channel (or a single part of a channel) of a task, or to the
+// task as a whole while it has no channels (such as when the device never comes online).
+type TaskProgressEvent struct {
+	Time           time.Time
+	TaskID         int
+	TaskName       string
+	DeviceID       string
+	CarLicense     string
+	Channel        int // The one-index of the channel; this is zero for an event about the task as a whole.
+	Status         TaskStatus
+	PreviousStatus TaskStatus // This is only meaningful if Initial is false.
+	Initial        bool       // This is true for the first event for the channel (or the task).
+	StatusChanged  bool       // This is true if the status differs from the previous poll (or this is the first poll).
+	Percent        float64
+	CurrentSize    float64
+	TotalSize      float64
+	Speed          float64
+	Error          string
+	Row            GlobalReportAutoDownloadTaskRow
+}
+
+// TaskSummary is the final state of a task.
+type TaskSummary struct {
+	TaskID     int
+	TaskName   string
+	DeviceID   string
+	CarLicense string
+	Status     TaskStatus           // The status of the task as a whole, from the monitor listing; see StatusKnown.
+	Channels   []TaskChannelSummary // These are sorted by channel and time.
+	Polls      int
+	Started    time.Time
+	Finished   time.Time
+
+	StatusKnown bool // This is true if Status was found in the monitor listing.
+}
+
+// TaskChannelSummary is the final state of a single channel of a task.
+//
+// A channel may have more than one of these if the server split its download up.
+type TaskChannelSummary struct {
+	Channel     int    // The one-index of the channel.
+	Date        string // yyyy-mm-dd
+	StartTime   string // hh:mm:ss
+	EndTime     string // hh:mm:ss
+	Status      TaskStatus
+	Error       string
+	CurrentSize float64
+	TotalSize   float64
+	FileSource  string
+}
+
+// Failed returns true if any channel ended in failure, or if the task as a whole did.
+func (s TaskSummary) Failed() bool {
+	if s.StatusKnown && s.Status.IsFailure() {
+		return true
+	}
+	for _, channel := range s.Channels {
+		if channel.Status.IsFailure() {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns the error text for every channel that has one, keyed by channel.
+func (s TaskSummary) Errors() map[int]string {
+	output := map[int]string{}
+	for _, channel := range s.Channels {
+		if channel.Error != "" {
+			output[channel.Channel] = channel.Error
+		}
+	}
+	return output
+}
+
+// WaitForTask polls a task until every one of its channels reaches a terminal status, or until the task as a
+// whole does (a task that failed or found nothing may not have any channels).  The channels only show up once
+// the server starts on them, so a channel of the task that hasn't shown up yet is not done.
+//
+// The poll interval backs off while nothing changes and resets as soon as something does.  If the
+// context is cancelled, the summary so far is returned along with the context's error.
+func (c *Client) WaitForTask(ctx context.Context, taskID string, options WaitForTaskOptions) (*TaskSummary, error) {
+	if options.Interval <= 0 {
+		options.Interval = 10 * time.Second
+	}
+	if options.MaxInterval < options.Interval {
+		options.MaxInterval = time.Minute
+		if options.MaxInterval < options.Interval {
+			options.MaxInterval = options.Interval
+		}
+	}
+	if options.Backoff < 1 {
+		options.Backoff = 1.5
+	}
+
+	summary := &TaskSummary{
+		DeviceID: options.DeviceID,
+		Started:  time.Now(),
+	}
+	summary.TaskID, _ = strconv.Atoi(taskID)
+
+	// The rows are keyed by channel and time, since the server may split a channel into multiple rows.
+	rowKey := func(row GlobalReportAutoDownloadTaskRow) string {
+		return fmt.Sprintf("%d|%s|%s|%s", row.Channel, row.Date, row.StartTime, row.EndTime)
+	}
+	previousRows := map[string]GlobalReportAutoDownloadTaskRow{}
+	var taskChannels []int
+	emit := func(event TaskProgressEvent) error {
+		if options.OnProgress != nil {
+			options.OnProgress(event)
+		}
+		if options.Events != nil {
+			select {
+			case options.Events <- event:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	interval := options.Interval
+	for {
+		summary.Polls++
+
+		// The task details only change if someone edits the task, so we only need them until we have some progress.
+		if len(previousRows) == 0 {
+			task, err := c.MonitorAutoDownloadTask(ctx, taskID)
+			if err != nil {
+				return summary, fmt.Errorf("could not get task %s: %w", taskID, err)
+			}
+			summary.TaskID = task.TaskID
+			summary.TaskName = task.TaskName
+			summary.CarLicense = task.CarLicense
+			taskChannels = task.TaskChannel
+			if summary.DeviceID == "" {
+				summary.DeviceID = task.DeviceID
+			}
+		}
+
+		output, err := c.GlobalReportAutoDownloadTask(ctx, GlobalReportAutoDownloadTaskInput{
+			DeviceID: summary.DeviceID,
+			TaskID:   taskID,
+			Date:     options.Date,
+		})
+		if err != nil {
+			return summary, fmt.Errorf("could not get the progress of task %s: %w", taskID, err)
+		}
+
+		now := time.Now()
+		changed := false
+		done := len(output.Rows) > 0
+		seenChannels := map[int]bool{}
+		summary.Channels = nil
+		for _, row := range output.Rows {
+			summary.Channels = append(summary.Channels, TaskChannelSummary{
+				Channel:     row.Channel,
+				Date:        row.Date,
+				StartTime:   row.StartTime,
+				EndTime:     row.EndTime,
+				Status:      row.Status,
+				Error:       row.Error,
+				CurrentSize: row.CurrentSizeValue(),
+				TotalSize:   row.TotalSizeValue(),
+				FileSource:  row.FileSource,
+			})
+			seenChannels[row.Channel] = true
+			if !row.Status.IsTerminal() {
+				done = false
+			}
+
+			previousRow, seen := previousRows[rowKey(row)]
+			if seen && previousRow == row {
+				continue
+			}
+			previousRows[rowKey(row)] = row
+			changed = true
+
+			event := TaskProgressEvent{
+				Time:           now,
+				TaskID:         row.TaskID,
+				TaskName:       summary.TaskName,
+				DeviceID:       row.DeviceID,
+				CarLicense:     summary.CarLicense,
+				Channel:        row.Channel,
+				Status:         row.Status,
+				PreviousStatus: previousRow.Status,
+				Initial:        !seen,
+				StatusChanged:  !seen || previousRow.Status != row.Status,
+				Percent:        row.PercentValue(),
+				CurrentSize:    row.CurrentSizeValue(),
+				TotalSize:      row.TotalSizeValue(),
+				Speed:          row.SpeedValue(),
+				Error:          row.Error,
+				Row:            row,
+			}
+			if err := emit(event); err != nil {
+				return summary, err
+			}
+		}
+
+		// The channels only show up once the server has started on them, so a channel that hasn't shown up yet
+		// isn't done; the task's own status is needed to tell when a task ended without getting to it.
+		for _, channel := range taskChannels {
+			if !seenChannels[channel] {
+				done = false
+			}
+		}
+		if !done {
+			monitorOutput, err := c.MonitorAutoDownload(ctx, MonitorAutoDownloadInput{DeviceID: summary.DeviceID})
+			if err != nil {
+				return summary, fmt.Errorf("could not get the status of task %s: %w", taskID, err)
+			}
+			for _, row := range monitorOutput.Rows {
+				if row.TaskID != summary.TaskID {
+					continue
+				}
+				if !summary.StatusKnown || summary.Status != row.Status {
+					changed = true
+					if len(output.Rows) == 0 {
+						// Without any channels, the task's own status is all the progress there is.
+						event := TaskProgressEvent{
+							Time:           now,
+							TaskID:         summary.TaskID,
+							TaskName:       summary.TaskName,
+							DeviceID:       summary.DeviceID,
+							CarLicense:     summary.CarLicense,
+							Status:         row.Status,
+							PreviousStatus: summary.Status,
+							Initial:        !summary.StatusKnown,
+							StatusChanged:  true,
+						}
+						if err := emit(event); err != nil {
+							return summary, err
+						}
+					}
+				}
+				summary.Status = row.Status
+				summary.StatusKnown = true
+				if row.Status.IsTerminal() {
+					done = true
+				}
+				break
+			}
+		}
+
+		sort.Slice(summary.Channels, func(i, j int) bool {
+			if summary.Channels[i].Channel != summary.Channels[j].Channel {
+				return summary.Channels[i].Channel < summary.Channels[j].Channel
+			}
+			return summary.Channels[i].Date+summary.Channels[i].StartTime < summary.Channels[j].Date+summary.Channels[j].StartTime
+		})
+
+		if done {
+			summary.Finished = now
+			return summary, nil
+		}
+
+		interval = nextWaitInterval(interval, changed, options)
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return summary, ctx.Err()
+		}
+	}
+}
+
+// nextWaitInterval returns the interval before the next poll: it starts over if something changed, and backs
+// off otherwise.
+func nextWaitInterval(interval time.Duration, changed bool, options WaitForTaskOptions) time.Duration {
+	if changed {
+		return options.Interval
+	}
+	interval = time.Duration(float64(interval) * options.Backoff)
+	if interval > options.MaxInterval {
+		interval = options.MaxInterval
+	}
+	return interval
+}
@@ -0,0 +1,246 @@
+package angeltrax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// taskServer is a stand-in for the AutoDownload plugin with a single task.  Each progress query is answered
+// by the next set of rows (the last one repeats), and likewise for the task's status in the monitor listing.
+type taskServer struct {
+	t        *testing.T
+	task     MonitorAutoDownloadTaskResponse
+	progress [][]GlobalReportAutoDownloadTaskRow
+	statuses []TaskStatus
+
+	mutex         sync.Mutex
+	progressPolls int
+	statusPolls   int
+	devices       []string // The device of each progress and status query.
+}
+
+func (s *taskServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	form, err := readTestForm(r)
+	if err != nil {
+		s.t.Errorf("could not read the request: %v", err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var output interface{}
+	switch r.URL.Path + "|" + form.Get("action") {
+	case "/Plugin/AutoDownload/Monitor/Default.ashx|getTask":
+		output = s.task
+	case "/Plugin/AutoDownload/Monitor/Default.ashx|refreshTask":
+		s.devices = append(s.devices, form.Get("id"))
+		status := s.statuses[len(s.statuses)-1]
+		if s.statusPolls < len(s.statuses) {
+			status = s.statuses[s.statusPolls]
+		}
+		s.statusPolls++
+		output = MonitorAutoDownloadResponse{
+			Total: 2,
+			Rows: []MonitorAutoDownloadRow{
+				{TaskID: s.task.TaskID + 1, Status: TaskStatusFinished},
+				{TaskID: s.task.TaskID, Status: status},
+			},
+		}
+	case "/Plugin/AutoDownload/GlobalReport/Default.ashx|queryVideo":
+		s.devices = append(s.devices, form.Get("Device"))
+		rows := s.progress[len(s.progress)-1]
+		if s.progressPolls < len(s.progress) {
+			rows = s.progress[s.progressPolls]
+		}
+		s.progressPolls++
+		output = GlobalReportAutoDownloadTaskResponse{Total: len(rows), Rows: rows}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(output)
+}
+
+func testTask() MonitorAutoDownloadTaskResponse {
+	return MonitorAutoDownloadTaskResponse{
+		TaskID:      12,
+		TaskName:    "nightly",
+		DeviceID:    "D1",
+		CarLicense:  "BUS-1",
+		TaskChannel: []int{1, 2},
+	}
+}
+
+func progressRow(channel int, status TaskStatus, percent string) GlobalReportAutoDownloadTaskRow {
+	return GlobalReportAutoDownloadTaskRow{
+		DeviceID:  "D1",
+		TaskID:    12,
+		Channel:   channel,
+		Date:      "2023-04-05",
+		StartTime: "06:00:00",
+		EndTime:   "07:00:00",
+		Status:    status,
+		Percent:   percent,
+	}
+}
+
+func waitForTestTask(t *testing.T, server *taskServer) (*TaskSummary, []TaskProgressEvent) {
+	client := newTestClient(t, server)
+
+	var events []TaskProgressEvent
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	summary, err := client.WaitForTask(ctx, "12", WaitForTaskOptions{
+		Interval:    time.Millisecond,
+		MaxInterval: time.Millisecond,
+		OnProgress: func(event TaskProgressEvent) {
+			events = append(events, event)
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not wait for the task: %v", err)
+	}
+	return summary, events
+}
+
+func TestWaitForTask(t *testing.T) {
+	server := &taskServer{
+		t:    t,
+		task: testTask(),
+		progress: [][]GlobalReportAutoDownloadTaskRow{
+			{progressRow(1, TaskStatusDownloading, "50")},
+			{progressRow(1, TaskStatusDownloading, "50")},
+			{progressRow(1, TaskStatusFinished, "100")},
+			// Channel 2 hasn't started yet, so the task isn't done.
+			{progressRow(1, TaskStatusFinished, "100")},
+			{progressRow(2, TaskStatusFinished, "100"), progressRow(1, TaskStatusFinished, "100")},
+		},
+		statuses: []TaskStatus{TaskStatusDownloading},
+	}
+	summary, events := waitForTestTask(t, server)
+
+	if summary.Polls != 5 {
+		t.Errorf("wrong number of polls: %d", summary.Polls)
+	}
+	if summary.TaskName != "nightly" || summary.CarLicense != "BUS-1" || summary.DeviceID != "D1" {
+		t.Errorf("wrong task: %+v", summary)
+	}
+	if len(summary.Channels) != 2 || summary.Channels[0].Channel != 1 || summary.Channels[1].Channel != 2 {
+		t.Errorf("wrong channels: %+v", summary.Channels)
+	}
+	if summary.Failed() {
+		t.Errorf("the task failed")
+	}
+
+	// An unchanged row doesn't produce another event.
+	type eventSummary struct {
+		channel        int
+		status         TaskStatus
+		previousStatus TaskStatus
+		initial        bool
+		statusChanged  bool
+	}
+	expected := []eventSummary{
+		{1, TaskStatusDownloading, 0, true, true},
+		{1, TaskStatusFinished, TaskStatusDownloading, false, true},
+		{2, TaskStatusFinished, 0, true, true},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("wrong number of events: %d: %+v", len(events), events)
+	}
+	for i, event := range events {
+		actual := eventSummary{event.Channel, event.Status, event.PreviousStatus, event.Initial, event.StatusChanged}
+		if actual != expected[i] {
+			t.Errorf("event %d: wrong event: %+v (expected %+v)", i, actual, expected[i])
+		}
+		if event.TaskName != "nightly" || event.CarLicense != "BUS-1" {
+			t.Errorf("event %d: wrong task: %+v", i, event)
+		}
+	}
+	if events[0].Percent != 50 {
+		t.Errorf("wrong percent: %f", events[0].Percent)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, device := range server.devices {
+		if device != "D1" {
+			t.Errorf("the task was queried with device %q", device)
+		}
+	}
+}
+
+func TestWaitForTaskWithoutChannels(t *testing.T) {
+	server := &taskServer{
+		t:        t,
+		task:     testTask(),
+		progress: [][]GlobalReportAutoDownloadTaskRow{nil},
+		statuses: []TaskStatus{TaskStatusWaiting, TaskStatusWaiting, TaskStatusDownloadFailed},
+	}
+	summary, events := waitForTestTask(t, server)
+
+	if summary.Polls != 3 {
+		t.Errorf("wrong number of polls: %d", summary.Polls)
+	}
+	if !summary.StatusKnown || summary.Status != TaskStatusDownloadFailed || !summary.Failed() {
+		t.Errorf("wrong status: %+v", summary)
+	}
+	if len(summary.Channels) != 0 {
+		t.Errorf("unexpected channels: %+v", summary.Channels)
+	}
+
+	// Without any channels, the task's own status changes are the events.
+	if len(events) != 2 {
+		t.Fatalf("wrong number of events: %d: %+v", len(events), events)
+	}
+	if event := events[0]; event.Channel != 0 || event.Status != TaskStatusWaiting || !event.Initial || event.TaskName != "nightly" || event.DeviceID != "D1" {
+		t.Errorf("wrong first event: %+v", event)
+	}
+	if event := events[1]; event.Channel != 0 || event.Status != TaskStatusDownloadFailed || event.PreviousStatus != TaskStatusWaiting || event.Initial {
+		t.Errorf("wrong second event: %+v", event)
+	}
+}
+
+func TestWaitForTaskTimeout(t *testing.T) {
+	server := &taskServer{
+		t:        t,
+		task:     testTask(),
+		progress: [][]GlobalReportAutoDownloadTaskRow{{progressRow(1, TaskStatusDownloading, "10")}},
+		statuses: []TaskStatus{TaskStatusDownloading},
+	}
+	client := newTestClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	summary, err := client.WaitForTask(ctx, "12", WaitForTaskOptions{Interval: time.Millisecond, MaxInterval: 5 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context's error; got: %v", err)
+	}
+	if summary == nil || summary.Polls < 2 || len(summary.Channels) != 1 {
+		t.Errorf("wrong summary: %+v", summary)
+	}
+}
+
+func TestNextWaitInterval(t *testing.T) {
+	options := WaitForTaskOptions{Interval: 10 * time.Second, MaxInterval: time.Minute, Backoff: 2}
+
+	var intervals []time.Duration
+	interval := options.Interval
+	for _, changed := range []bool{false, false, false, false, true, false} {
+		interval = nextWaitInterval(interval, changed, options)
+		intervals = append(intervals, interval)
+	}
+	expected := []time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute, 10 * time.Second, 20 * time.Second}
+	for i := range expected {
+		if intervals[i] != expected[i] {
+			t.Errorf("wrong intervals: %v (expected %v)", intervals, expected)
+			break
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package clips
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/mdvr"
+	"github.com/tekkamanendless/angeltrax/mp4"
+)
+
+// ErrNotCovered is returned by Cut when no segment covers any part of the window.
+var ErrNotCovered = errors.New("no recording covers the requested window")
+
+// CutOptions controls Cut.
+type CutOptions struct {
+	GapTolerance time.Duration // Gaps (or overlaps) shorter than this are ignored; this defaults to DefaultGapTolerance.
+}
+
+// CutSource describes the part of a segment that went into a cut.
+type CutSource struct {
+	TaskID       int     `json:"taskId"`
+	FileSource   string  `json:"fileSource"`
+	Filename     string  `json:"filename"`
+	SegmentStart string  `json:"segmentStart"` // yyyy-mm-dd hh:mm:ss
+	SegmentEnd   string  `json:"segmentEnd"`   // yyyy-mm-dd hh:mm:ss
+	Start        string  `json:"start"`        // The first frame used (yyyy-mm-dd hh:mm:ss.sss).
+	End          string  `json:"end"`          // The end of the last frame used (yyyy-mm-dd hh:mm:ss.sss).
+	Offset       float64 `json:"offset"`       // The position in the clip, in seconds.
+	VideoFrames  int     `json:"videoFrames"`
+}
+
+// CutClip describes a clip cut from a window of time.
+type CutClip struct {
+	DeviceID        string          `json:"deviceId"`
+	CarLicense      string          `json:"carLicense,omitempty"` // This is up to the caller.
+	Channel         int             `json:"channel"`
+	Filename        string          `json:"filename,omitempty"`
+	RequestedStart  string          `json:"requestedStart"` // yyyy-mm-dd hh:mm:ss
+	RequestedEnd    string          `json:"requestedEnd"`   // yyyy-mm-dd hh:mm:ss
+	Start           string          `json:"start"`          // The actual start (the key frame at or before the requested start).
+	End             string          `json:"end"`            // The actual end.
+	Duration        float64         `json:"duration"`       // In seconds.
+	Sources         []CutSource     `json:"sources"`
+	Discontinuities []Discontinuity `json:"discontinuities"`
+}
+
+// cutTimeFormat is RecordingTimeFormat with milliseconds, since cuts don't land on whole seconds.
+const cutTimeFormat = angeltrax.RecordingTimeFormat + ".000"
+
+// Cut writes the part of a channel's segments between from and to into an MP4 file, without re-encoding.
+//
+// The wall-clock time of each frame comes from the segment's start time plus the frame's timestamp within the
+// stream.  Since frames can't be decoded without their key frame, the clip starts at the last key frame at or
+// before "from" (or the first key frame after it, if the window starts in the middle of a recording's first
+// group of pictures); it ends with the last frame before "to".  The clip's timeline follows real time, so any
+// gaps in the recordings remain gaps in the clip.  Where segments overlap, the time is only written once: a
+// later segment picks up at its first key frame after the last frame written, and the overlap is recorded.
+func Cut(segments []Segment, from time.Time, to time.Time, w io.WriteSeeker, options CutOptions) (*CutClip, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("the end must be after the start")
+	}
+	if options.GapTolerance <= 0 {
+		options.GapTolerance = DefaultGapTolerance
+	}
+
+	var covering []Segment
+	for _, segment := range segments {
+		if segment.Start.Before(to) && !segment.End.Before(from) {
+			covering = append(covering, segment)
+		}
+	}
+	if len(covering) == 0 {
+		return nil, ErrNotCovered
+	}
+	sort.SliceStable(covering, func(i, j int) bool {
+		if !covering[i].Start.Equal(covering[j].Start) {
+			return covering[i].Start.Before(covering[j].Start)
+		}
+		return covering[i].End.Before(covering[j].End)
+	})
+
+	writer, err := mp4.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+
+	cut := &cutter{
+		writer: writer,
+		from:   from,
+		to:     to,
+	}
+	clip := &CutClip{
+		DeviceID:        covering[0].Row.DeviceID,
+		Channel:         covering[0].Row.Channel,
+		RequestedStart:  from.Format(angeltrax.RecordingTimeFormat),
+		RequestedEnd:    to.Format(angeltrax.RecordingTimeFormat),
+		Sources:         []CutSource{},
+		Discontinuities: []Discontinuity{},
+	}
+
+	// The offsets of the discontinuities are only known once the start of the clip is known.
+	type pendingDiscontinuity struct {
+		discontinuity Discontinuity
+		time          time.Time
+	}
+	var discontinuities []pendingDiscontinuity
+	addDiscontinuity := func(discontinuityType DiscontinuityType, start time.Time, end time.Time, filename string, reason string) {
+		discontinuities = append(discontinuities, pendingDiscontinuity{
+			discontinuity: Discontinuity{
+				Type:     discontinuityType,
+				Start:    start.Format(angeltrax.RecordingTimeFormat),
+				End:      end.Format(angeltrax.RecordingTimeFormat),
+				Duration: end.Sub(start).Seconds(),
+				Filename: filename,
+				Reason:   reason,
+			},
+			time: start,
+		})
+	}
+
+	covered := from
+	for i, segment := range covering {
+		if segment.Start.Sub(covered) > options.GapTolerance {
+			addDiscontinuity(DiscontinuityTypeGap, covered, segment.Start, "", "")
+		} else if i > 0 && covered.Sub(segment.Start) > options.GapTolerance {
+			overlapEnd := covered
+			if segment.End.Before(overlapEnd) {
+				overlapEnd = segment.End
+			}
+			addDiscontinuity(DiscontinuityTypeOverlap, segment.Start, overlapEnd, segment.Filename, "")
+		}
+		if segment.End.After(covered) {
+			covered = segment.End
+		}
+
+		source, reason, err := cut.segment(segment)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", segment.Filename, err)
+		}
+		if reason != "" {
+			addDiscontinuity(DiscontinuityTypeMissing, segment.Start, segment.End, segment.Filename, reason)
+			continue
+		}
+		if source != nil {
+			clip.Sources = append(clip.Sources, *source)
+		}
+	}
+	if to.Sub(covered) > options.GapTolerance {
+		addDiscontinuity(DiscontinuityTypeGap, covered, to, "", "")
+	}
+
+	if !cut.started {
+		return nil, ErrNotCovered
+	}
+
+	for i := range clip.Sources {
+		start, _ := time.Parse(cutTimeFormat, clip.Sources[i].Start)
+		clip.Sources[i].Offset = start.Sub(cut.start).Seconds()
+	}
+	for _, pending := range discontinuities {
+		offset := pending.time.Sub(cut.start)
+		if offset < 0 {
+			offset = 0
+		}
+		pending.discontinuity.Offset = offset.Seconds()
+		clip.Discontinuities = append(clip.Discontinuities, pending.discontinuity)
+	}
+	clip.Start = cut.start.Format(cutTimeFormat)
+	clip.End = cut.end.Format(cutTimeFormat)
+	clip.Duration = cut.end.Sub(cut.start).Seconds()
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return clip, nil
+}
+
+// cutter keeps track of a cut across segments.
+type cutter struct {
+	writer  *mp4.Writer
+	from    time.Time
+	to      time.Time
+	codec   mp4.VideoCodec
+	width   int
+	height  int
+	started bool
+	start   time.Time // The wall-clock time of the first frame.
+	end     time.Time // The wall-clock time of the end of the last frame.
+
+	// These are the wall-clock times of the last frames written; anything at or before them was already
+	// covered by an earlier segment.
+	lastVideo time.Time
+	lastAudio time.Time
+}
+
+type cutFrame struct {
+	frame *mdvr.Frame
+	time  time.Time
+	key   bool
+}
+
+// segment copies the frames of a segment that fall in the window.  If the segment can't be used, then the
+// reason is returned.
+func (c *cutter) segment(segment Segment) (*CutSource, string, error) {
+	if segment.Filename == "" {
+		return nil, "not downloaded", nil
+	}
+	handle, err := os.Open(segment.Filename)
+	if err != nil {
+		return nil, err.Error(), nil
+	}
+	defer handle.Close()
+
+	reader, err := mdvr.NewReader(handle)
+	if err != nil {
+		return nil, err.Error(), nil
+	}
+	if c.codec == 0 {
+		c.codec, c.width, c.height = reader.VideoCodec, reader.Width, reader.Height
+	} else if reader.VideoCodec != c.codec || reader.Width != c.width || reader.Height != c.height {
+		return nil, fmt.Sprintf("%s %dx%d does not match %s %dx%d", reader.VideoCodec, reader.Width, reader.Height, c.codec, c.width, c.height), nil
+	}
+
+	source := &CutSource{
+		TaskID:       segment.Row.TaskID,
+		FileSource:   segment.Row.FileSource,
+		Filename:     segment.Filename,
+		SegmentStart: segment.Start.Format(angeltrax.RecordingTimeFormat),
+		SegmentEnd:   segment.End.Format(angeltrax.RecordingTimeFormat),
+	}
+
+	var haveBase bool
+	var base time.Duration // The stream timestamp of the first frame, which is at the segment's start time.
+	var segmentStarted bool
+	var pending []cutFrame // The frames since the last key frame, before the window starts.
+	var first, last time.Time
+	var frameDuration time.Duration
+	var resync bool // Frames were skipped because of an overlap, so the next frame written must be a key frame.
+
+	writeVideo := func(frame cutFrame) error {
+		if !frame.time.After(c.lastVideo) {
+			resync = true
+			return nil
+		}
+		if resync {
+			if !frame.key {
+				return nil
+			}
+			resync = false
+		}
+		written, err := c.writer.WriteVideo(reader.VideoCodec, frame.time.Sub(c.start), frame.frame.Data)
+		if err != nil {
+			return err
+		}
+		if !written {
+			return nil
+		}
+		c.lastVideo = frame.time
+		if source.VideoFrames == 0 {
+			first = frame.time
+		} else if frame.time.After(last) {
+			frameDuration = frame.time.Sub(last)
+		}
+		if frame.time.After(last) {
+			last = frame.time
+		}
+		source.VideoFrames++
+		return nil
+	}
+
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if !haveBase {
+			base = frame.Timestamp
+			haveBase = true
+		}
+		wallTime := segment.Start.Add(frame.Timestamp - base)
+		if !wallTime.Before(c.to) {
+			break
+		}
+
+		switch frame.Type {
+		case mdvr.FrameTypeVideo:
+			key := mp4.IsKeyFrame(reader.VideoCodec, frame.Data)
+			if !segmentStarted {
+				if key {
+					pending = []cutFrame{{frame: frame, time: wallTime, key: true}}
+				} else if len(pending) > 0 {
+					pending = append(pending, cutFrame{frame: frame, time: wallTime})
+				}
+				if len(pending) == 0 || wallTime.Before(c.from) {
+					continue
+				}
+				if !c.started {
+					c.started = true
+					c.start = pending[0].time
+				}
+				segmentStarted = true
+				for _, pendingFrame := range pending {
+					err = writeVideo(pendingFrame)
+					if err != nil {
+						return nil, "", err
+					}
+				}
+				pending = nil
+				continue
+			}
+			err = writeVideo(cutFrame{frame: frame, time: wallTime, key: key})
+			if err != nil {
+				return nil, "", err
+			}
+		case mdvr.FrameTypeAudio:
+			if !segmentStarted || !wallTime.After(c.lastAudio) {
+				continue
+			}
+			err = c.writer.WriteAudio(mp4.AudioCodecG711ALaw, mdvr.AudioSampleRate, wallTime.Sub(c.start), frame.Data)
+			if err != nil {
+				return nil, "", err
+			}
+			c.lastAudio = wallTime
+		}
+	}
+
+	if source.VideoFrames == 0 {
+		// The segment only overlapped the window by a little, and there was no key frame in that part.
+		return nil, "", nil
+	}
+	if frameDuration <= 0 {
+		frameDuration = defaultFrameDuration
+	}
+	end := last.Add(frameDuration)
+	if end.After(c.end) {
+		c.end = end
+	}
+	source.Start = first.Format(cutTimeFormat)
+	source.End = end.Format(cutTimeFormat)
+	return source, "", nil
+}
@@ -0,0 +1,160 @@
+package clips
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// These are the NAL units of a 320x240 H.264 baseline stream (with Annex-B start codes).
+var (
+	testKeyFrame = []byte{
+		0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4, // SPS
+		0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80, // PPS
+		0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00, 0x33, // IDR
+	}
+	testFrame = []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x04}
+)
+
+// writeRecording writes a two-second recording with a frame every 200ms and a key frame every second.
+func writeRecording(t *testing.T, filename string) {
+	var b bytes.Buffer
+	header := make([]byte, 16)
+	copy(header, "HXVS")
+	binary.LittleEndian.PutUint32(header[4:], 320)
+	binary.LittleEndian.PutUint32(header[8:], 240)
+	b.Write(header)
+	for i := 0; i < 10; i++ {
+		data := testFrame
+		if i%5 == 0 {
+			data = testKeyFrame
+		}
+		frameHeader := make([]byte, 16)
+		copy(frameHeader, "HXVF")
+		binary.LittleEndian.PutUint32(frameHeader[4:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(frameHeader[8:], uint32(5000+i*200))
+		b.Write(frameHeader)
+		b.Write(data)
+	}
+	b.Write([]byte("HXFI"))
+	if err := os.WriteFile(filename, b.Bytes(), 0644); err != nil {
+		t.Fatalf("could not write %s: %v", filename, err)
+	}
+}
+
+func testSegment(t *testing.T, filename string, startTime string, endTime string) Segment {
+	row := angeltrax.GlobalReportAutoDownloadTaskRow{
+		DeviceID:   "D1",
+		Channel:    1,
+		Date:       "2023-04-05",
+		StartTime:  startTime,
+		EndTime:    endTime,
+		FileSource: filepath.Base(filename),
+	}
+	segment, err := NewSegment(row, filename)
+	if err != nil {
+		t.Fatalf("could not make the segment: %v", err)
+	}
+	return segment
+}
+
+func TestCutOverlap(t *testing.T) {
+	directory := t.TempDir()
+	first := filepath.Join(directory, "first.264")
+	second := filepath.Join(directory, "second.264")
+	writeRecording(t, first)
+	writeRecording(t, second)
+
+	segments := []Segment{
+		testSegment(t, second, "06:00:01", "06:00:03"), // This starts a second before the first one ends.
+		testSegment(t, first, "06:00:00", "06:00:02"),
+		testSegment(t, first, "06:00:00", "06:00:02"), // The same recording, listed twice.
+	}
+	from := time.Date(2023, 4, 5, 6, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Second)
+
+	output, err := os.Create(filepath.Join(directory, "clip.mp4"))
+	if err != nil {
+		t.Fatalf("could not create the clip: %v", err)
+	}
+	defer output.Close()
+	clip, err := Cut(segments, from, to, output, CutOptions{GapTolerance: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("could not cut: %v", err)
+	}
+
+	if clip.Start != "2023-04-05 06:00:00.000" || clip.End != "2023-04-05 06:00:03.000" || clip.Duration != 3 {
+		t.Errorf("wrong clip: %s - %s (%fs)", clip.Start, clip.End, clip.Duration)
+	}
+
+	// The duplicate adds nothing, and the second recording picks up at its first key frame after the overlap.
+	expectedSources := []CutSource{
+		{Filename: first, Start: "2023-04-05 06:00:00.000", End: "2023-04-05 06:00:02.000", Offset: 0, VideoFrames: 10},
+		{Filename: second, Start: "2023-04-05 06:00:02.000", End: "2023-04-05 06:00:03.000", Offset: 2, VideoFrames: 5},
+	}
+	if len(clip.Sources) != len(expectedSources) {
+		t.Fatalf("wrong sources: %+v", clip.Sources)
+	}
+	for i, source := range clip.Sources {
+		expected := expectedSources[i]
+		if source.Filename != expected.Filename || source.Start != expected.Start || source.End != expected.End || source.Offset != expected.Offset || source.VideoFrames != expected.VideoFrames {
+			t.Errorf("source %d: wrong source: %+v", i, source)
+		}
+	}
+
+	expectedDiscontinuities := []Discontinuity{
+		{Type: DiscontinuityTypeOverlap, Offset: 0, Start: "2023-04-05 06:00:00", End: "2023-04-05 06:00:02", Duration: 2, Filename: first},
+		{Type: DiscontinuityTypeOverlap, Offset: 1, Start: "2023-04-05 06:00:01", End: "2023-04-05 06:00:02", Duration: 1, Filename: second},
+	}
+	if len(clip.Discontinuities) != len(expectedDiscontinuities) {
+		t.Fatalf("wrong discontinuities: %+v", clip.Discontinuities)
+	}
+	for i, discontinuity := range clip.Discontinuities {
+		if discontinuity != expectedDiscontinuities[i] {
+			t.Errorf("discontinuity %d: wrong discontinuity: %+v", i, discontinuity)
+		}
+	}
+}
+
+func TestCutGap(t *testing.T) {
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "first.264")
+	writeRecording(t, filename)
+
+	segments := []Segment{
+		testSegment(t, filename, "06:00:00", "06:00:02"),
+		testSegment(t, "", "06:00:05", "06:00:07"), // This was never downloaded.
+	}
+	from := time.Date(2023, 4, 5, 6, 0, 1, 0, time.UTC)
+	to := from.Add(9 * time.Second)
+
+	output, err := os.Create(filepath.Join(directory, "clip.mp4"))
+	if err != nil {
+		t.Fatalf("could not create the clip: %v", err)
+	}
+	defer output.Close()
+	clip, err := Cut(segments, from, to, output, CutOptions{})
+	if err != nil {
+		t.Fatalf("could not cut: %v", err)
+	}
+
+	// The clip starts at the key frame at the requested start.
+	if clip.Start != "2023-04-05 06:00:01.000" || clip.End != "2023-04-05 06:00:02.000" {
+		t.Errorf("wrong clip: %s - %s", clip.Start, clip.End)
+	}
+	if len(clip.Sources) != 1 || clip.Sources[0].VideoFrames != 5 {
+		t.Errorf("wrong sources: %+v", clip.Sources)
+	}
+	var types []DiscontinuityType
+	for _, discontinuity := range clip.Discontinuities {
+		types = append(types, discontinuity.Type)
+	}
+	if len(types) != 3 || types[0] != DiscontinuityTypeGap || types[1] != DiscontinuityTypeMissing || types[2] != DiscontinuityTypeGap {
+		t.Errorf("wrong discontinuities: %+v", clip.Discontinuities)
+	}
+}
@@ -0,0 +1,81 @@
+// Package clips turns the recordings of an AutoDownload task into continuous clips.
+package clips
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// Segment is a single recording of a channel.
+type Segment struct {
+	Row      angeltrax.GlobalReportAutoDownloadTaskRow
+	Filename string    // The local file; if this is empty, the segment is treated as missing.
+	Start    time.Time // From the row's Date and StartTime, in the device's local time (but in UTC).
+	End      time.Time // From the row's Date and EndTime; a segment that ends before it starts crosses midnight.
+}
+
+// Duration returns the length of the segment according to the CMS.
+func (s Segment) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// NewSegment parses the times of a row.
+//
+// The device's time zone isn't known, so the times are parsed as UTC; they are only used to order the
+// segments and measure the gaps between them.
+func NewSegment(row angeltrax.GlobalReportAutoDownloadTaskRow, filename string) (Segment, error) {
+	start, err := time.Parse(angeltrax.RecordingTimeFormat, row.Date+" "+row.StartTime)
+	if err != nil {
+		return Segment{}, fmt.Errorf("could not parse start time %q %q: %w", row.Date, row.StartTime, err)
+	}
+	end, err := time.Parse(angeltrax.RecordingTimeFormat, row.Date+" "+row.EndTime)
+	if err != nil {
+		return Segment{}, fmt.Errorf("could not parse end time %q %q: %w", row.Date, row.EndTime, err)
+	}
+	if end.Before(start) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return Segment{
+		Row:      row,
+		Filename: filename,
+		Start:    start,
+		End:      end,
+	}, nil
+}
+
+// OrderSegments groups the rows of a task by channel and orders each channel's segments by date, start time,
+// and end time.
+//
+// The filename function returns the local file for a row (or an empty string if there isn't one).
+func OrderSegments(rows []angeltrax.GlobalReportAutoDownloadTaskRow, filename func(angeltrax.GlobalReportAutoDownloadTaskRow) string) (map[int][]Segment, error) {
+	channels := map[int][]Segment{}
+	for _, row := range rows {
+		segment, err := NewSegment(row, filename(row))
+		if err != nil {
+			return nil, err
+		}
+		channels[row.Channel] = append(channels[row.Channel], segment)
+	}
+	for _, segments := range channels {
+		sort.SliceStable(segments, func(i, j int) bool {
+			if !segments[i].Start.Equal(segments[j].Start) {
+				return segments[i].Start.Before(segments[j].Start)
+			}
+			return segments[i].End.Before(segments[j].End)
+		})
+	}
+	return channels, nil
+}
+
+// Channels returns the channels of the map in order.
+func Channels(channels map[int][]Segment) []int {
+	var output []int
+	for channel := range channels {
+		output = append(output, channel)
+	}
+	sort.Ints(output)
+	return output
+}
@@ -0,0 +1,269 @@
+package clips
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/mdvr"
+	"github.com/tekkamanendless/angeltrax/mp4"
+)
+
+// DefaultGapTolerance is the default for StitchOptions.GapTolerance.
+const DefaultGapTolerance = 2 * time.Second
+
+// defaultFrameDuration is used to space segments when a segment has only a single frame.
+const defaultFrameDuration = 40 * time.Millisecond
+
+// StitchOptions controls Stitch.
+type StitchOptions struct {
+	GapTolerance time.Duration // Gaps (or overlaps) shorter than this are ignored; this defaults to DefaultGapTolerance.
+}
+
+// DiscontinuityType is the kind of discontinuity.
+type DiscontinuityType string
+
+const (
+	DiscontinuityTypeGap          DiscontinuityType = "gap"          // There is no recording for this time.
+	DiscontinuityTypeOverlap      DiscontinuityType = "overlap"      // The segment starts before the previous one ended.
+	DiscontinuityTypeMissing      DiscontinuityType = "missing"      // The segment has not been downloaded (or could not be read).
+	DiscontinuityTypeIncompatible DiscontinuityType = "incompatible" // The segment has a different codec or resolution, so it can't be joined losslessly.
+)
+
+// Discontinuity is a place where the clip does not follow real time.
+type Discontinuity struct {
+	Type     DiscontinuityType `json:"type"`
+	Offset   float64           `json:"offset"`   // The position in the clip, in seconds.
+	Start    string            `json:"start"`    // yyyy-mm-dd hh:mm:ss
+	End      string            `json:"end"`      // yyyy-mm-dd hh:mm:ss
+	Duration float64           `json:"duration"` // In seconds.
+	Filename string            `json:"filename,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+}
+
+// ClipSegment describes where a segment ended up in the clip.
+type ClipSegment struct {
+	Filename      string  `json:"filename"`
+	FileSource    string  `json:"fileSource"`
+	Start         string  `json:"start"`    // yyyy-mm-dd hh:mm:ss
+	End           string  `json:"end"`      // yyyy-mm-dd hh:mm:ss
+	Offset        float64 `json:"offset"`   // The position in the clip, in seconds.
+	Duration      float64 `json:"duration"` // In seconds.
+	VideoFrames   int     `json:"videoFrames"`
+	DroppedFrames int     `json:"droppedFrames"` // Frames before the segment's first key frame can't be decoded, so they are dropped.
+}
+
+// Clip describes a stitched clip.
+type Clip struct {
+	DeviceID        string          `json:"deviceId"`
+	CarLicense      string          `json:"carLicense,omitempty"` // This is up to the caller.
+	Channel         int             `json:"channel"`
+	Filename        string          `json:"filename,omitempty"`
+	Start           string          `json:"start"`    // yyyy-mm-dd hh:mm:ss
+	End             string          `json:"end"`      // yyyy-mm-dd hh:mm:ss
+	Duration        float64         `json:"duration"` // The length of the clip, in seconds.
+	Segments        []ClipSegment   `json:"segments"`
+	Discontinuities []Discontinuity `json:"discontinuities"`
+}
+
+// Manifest describes the clips made from a task.
+type Manifest struct {
+	TaskID string  `json:"taskId"`
+	Clips  []*Clip `json:"clips"`
+}
+
+// Stitch joins the segments of a single channel (in the given order) into one MP4 file, without transcoding.
+//
+// Gaps between segments are not filled in; the next segment picks up right where the previous one left
+// off, and a chapter marker is added so that the jump is easy to find.  Every gap, overlap, and missing
+// segment is recorded in the returned clip.
+func Stitch(segments []Segment, w io.WriteSeeker, options StitchOptions) (*Clip, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("no segments")
+	}
+	if options.GapTolerance <= 0 {
+		options.GapTolerance = DefaultGapTolerance
+	}
+
+	writer, err := mp4.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+
+	clip := &Clip{
+		DeviceID:        segments[0].Row.DeviceID,
+		Channel:         segments[0].Row.Channel,
+		Start:           segments[0].Start.Format(angeltrax.RecordingTimeFormat),
+		Segments:        []ClipSegment{},
+		Discontinuities: []Discontinuity{},
+	}
+	writer.AddChapter(mp4.Chapter{Time: 0, Title: clip.Start})
+
+	var codec mp4.VideoCodec
+	var width, height int
+	var position time.Duration // Where the next segment goes in the clip.
+	var previousEnd time.Time
+	addDiscontinuity := func(discontinuity Discontinuity, title string) {
+		discontinuity.Offset = position.Seconds()
+		clip.Discontinuities = append(clip.Discontinuities, discontinuity)
+		writer.AddChapter(mp4.Chapter{Time: position, Title: title})
+	}
+
+	for i, segment := range segments {
+		if i > 0 {
+			delta := segment.Start.Sub(previousEnd)
+			if delta > options.GapTolerance {
+				addDiscontinuity(Discontinuity{
+					Type:     DiscontinuityTypeGap,
+					Start:    previousEnd.Format(angeltrax.RecordingTimeFormat),
+					End:      segment.Start.Format(angeltrax.RecordingTimeFormat),
+					Duration: delta.Seconds(),
+				}, fmt.Sprintf("Gap: %s - %s (%s)", previousEnd.Format("15:04:05"), segment.Start.Format("15:04:05"), delta))
+			} else if -delta > options.GapTolerance {
+				addDiscontinuity(Discontinuity{
+					Type:     DiscontinuityTypeOverlap,
+					Start:    segment.Start.Format(angeltrax.RecordingTimeFormat),
+					End:      previousEnd.Format(angeltrax.RecordingTimeFormat),
+					Duration: (-delta).Seconds(),
+					Filename: segment.Filename,
+				}, fmt.Sprintf("Overlap: %s - %s (%s)", segment.Start.Format("15:04:05"), previousEnd.Format("15:04:05"), -delta))
+			}
+		}
+		if segment.End.After(previousEnd) {
+			previousEnd = segment.End
+		}
+
+		skip := func(discontinuityType DiscontinuityType, reason string) {
+			addDiscontinuity(Discontinuity{
+				Type:     discontinuityType,
+				Start:    segment.Start.Format(angeltrax.RecordingTimeFormat),
+				End:      segment.End.Format(angeltrax.RecordingTimeFormat),
+				Duration: segment.Duration().Seconds(),
+				Filename: segment.Filename,
+				Reason:   reason,
+			}, fmt.Sprintf("Missing: %s - %s", segment.Start.Format("15:04:05"), segment.End.Format("15:04:05")))
+		}
+
+		if segment.Filename == "" {
+			skip(DiscontinuityTypeMissing, "not downloaded")
+			continue
+		}
+		handle, err := os.Open(segment.Filename)
+		if err != nil {
+			skip(DiscontinuityTypeMissing, err.Error())
+			continue
+		}
+		reader, err := mdvr.NewReader(handle)
+		if err != nil {
+			handle.Close()
+			skip(DiscontinuityTypeMissing, err.Error())
+			continue
+		}
+		if codec == 0 {
+			codec, width, height = reader.VideoCodec, reader.Width, reader.Height
+		} else if reader.VideoCodec != codec || reader.Width != width || reader.Height != height {
+			handle.Close()
+			skip(DiscontinuityTypeIncompatible, fmt.Sprintf("%s %dx%d does not match %s %dx%d", reader.VideoCodec, reader.Width, reader.Height, codec, width, height))
+			continue
+		}
+
+		clipSegment, next, err := stitchSegment(writer, reader, position)
+		handle.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", segment.Filename, err)
+		}
+		if clipSegment.VideoFrames == 0 {
+			skip(DiscontinuityTypeMissing, "no usable video")
+			continue
+		}
+		clipSegment.Filename = segment.Filename
+		clipSegment.FileSource = segment.Row.FileSource
+		clipSegment.Start = segment.Start.Format(angeltrax.RecordingTimeFormat)
+		clipSegment.End = segment.End.Format(angeltrax.RecordingTimeFormat)
+		clip.Segments = append(clip.Segments, clipSegment)
+		position = next
+	}
+
+	clip.End = previousEnd.Format(angeltrax.RecordingTimeFormat)
+	clip.Duration = position.Seconds()
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return clip, nil
+}
+
+// stitchSegment copies the frames of a segment into the clip starting at the given position, and returns
+// where the next segment should start.
+func stitchSegment(writer *mp4.Writer, reader *mdvr.Reader, position time.Duration) (ClipSegment, time.Duration, error) {
+	var clipSegment ClipSegment
+	clipSegment.Offset = position.Seconds()
+
+	var started bool
+	var base time.Duration // The device timestamp of the first key frame.
+	var last, frameDuration time.Duration
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return clipSegment, position, err
+		}
+
+		if !started {
+			if frame.Type != mdvr.FrameTypeVideo {
+				continue
+			}
+			if !mp4.IsKeyFrame(reader.VideoCodec, frame.Data) {
+				clipSegment.DroppedFrames++
+				continue
+			}
+			started = true
+			base = frame.Timestamp
+		}
+
+		offset := frame.Timestamp - base
+		if offset < 0 {
+			offset = 0
+		}
+		timestamp := position + offset
+
+		switch frame.Type {
+		case mdvr.FrameTypeVideo:
+			written, err := writer.WriteVideo(reader.VideoCodec, timestamp, frame.Data)
+			if err != nil {
+				return clipSegment, position, err
+			}
+			if !written {
+				clipSegment.DroppedFrames++
+				continue
+			}
+			if clipSegment.VideoFrames > 0 && offset > last {
+				frameDuration = offset - last
+			}
+			if offset > last {
+				last = offset
+			}
+			clipSegment.VideoFrames++
+		case mdvr.FrameTypeAudio:
+			err = writer.WriteAudio(mp4.AudioCodecG711ALaw, mdvr.AudioSampleRate, timestamp, frame.Data)
+			if err != nil {
+				return clipSegment, position, err
+			}
+		}
+	}
+
+	if clipSegment.VideoFrames == 0 {
+		return clipSegment, position, nil
+	}
+	if frameDuration <= 0 {
+		frameDuration = defaultFrameDuration
+	}
+	length := last + frameDuration
+	clipSegment.Duration = length.Seconds()
+	return clipSegment, position + length, nil
+}
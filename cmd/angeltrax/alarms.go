@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// parseAlarmTypes parses a comma-separated list of alarm type codes.
+func parseAlarmTypes(value string) ([]angeltrax.AlarmType, error) {
+	var alarmTypes []angeltrax.AlarmType
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		alarmType, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alarm type %q: %v", part, err)
+		}
+		alarmTypes = append(alarmTypes, angeltrax.AlarmType(alarmType))
+	}
+	return alarmTypes, nil
+}
+
+// parseAlarmHandleStatus parses "unhandled", "handled", or "acknowledged"; an empty value means any status.
+func parseAlarmHandleStatus(value string) (*angeltrax.AlarmHandleStatus, error) {
+	var status angeltrax.AlarmHandleStatus
+	switch value {
+	case "":
+		return nil, nil
+	case "unhandled":
+		status = angeltrax.AlarmHandleStatusUnhandled
+	case "handled":
+		status = angeltrax.AlarmHandleStatusHandled
+	case "acknowledged":
+		status = angeltrax.AlarmHandleStatusAcknowledged
+	default:
+		return nil, fmt.Errorf("invalid status %q", value)
+	}
+	return &status, nil
+}
+
+// printAlarm prints every detail of an alarm.
+func printAlarm(w io.Writer, alarm angeltrax.Alarm) {
+	fmt.Fprintf(w, "Alarm %s\n", alarm.AlarmID)
+	fmt.Fprintf(w, "   Device: %s (%s)\n", alarm.DeviceID, alarm.CarLicense)
+	if alarm.Channel > 0 {
+		fmt.Fprintf(w, "   Channel: %d\n", alarm.Channel)
+	}
+	fmt.Fprintf(w, "   Type: %d (%s)\n", alarm.AlarmType, alarm.AlarmName)
+	fmt.Fprintf(w, "   Time: %s - %s\n", alarm.StartTime, alarm.EndTime)
+	fmt.Fprintf(w, "   Position: %f, %f (%.0f km/h)\n", alarm.Latitude, alarm.Longitude, alarm.Speed)
+	if alarm.Content != "" {
+		fmt.Fprintf(w, "   Content: %s\n", alarm.Content)
+	}
+	fmt.Fprintf(w, "   Status: %s\n", alarm.HandleStatus)
+	if alarm.HandleStatus != angeltrax.AlarmHandleStatusUnhandled {
+		fmt.Fprintf(w, "   Handled by: %s at %s\n", alarm.HandleUser, alarm.HandleTime)
+		if alarm.HandleRemark != "" {
+			fmt.Fprintf(w, "   Remark: %s\n", alarm.HandleRemark)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/clips"
+	"github.com/tekkamanendless/angeltrax/partfile"
+)
+
+// clipFilename returns the filename for a stitched clip: <directory>/<plate>/task<id>-channel<channel>.mp4.
+func clipFilename(directory string, carLicense string, taskID string, channel int) string {
+	carLicense = strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(carLicense)
+	return filepath.Join(directory, carLicense, fmt.Sprintf("task%s-channel%d.mp4", taskID, channel))
+}
+
+// taskRecordings returns the video rows of the tasks, along with a function that returns the local file (as
+// saved by "task fetch" into the input directory) for a row, or an empty string if it hasn't been fetched.
+func taskRecordings(ctx context.Context, client *angeltrax.Client, taskIDs []string, deviceID string, channel int, date string, inputDirectory string, carLicenses map[string]string) ([]angeltrax.GlobalReportAutoDownloadTaskRow, func(angeltrax.GlobalReportAutoDownloadTaskRow) string, error) {
+	var rows []angeltrax.GlobalReportAutoDownloadTaskRow
+	storedFiles := map[string]angeltrax.StoredFile{}
+	for _, taskID := range taskIDs {
+		reportOutput, err := client.GlobalReportAutoDownloadTask(ctx, angeltrax.GlobalReportAutoDownloadTaskInput{TaskID: taskID, DeviceID: deviceID, Date: date})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range reportOutput.Rows {
+			if channel > 0 && row.Channel != channel {
+				continue
+			}
+			rows = append(rows, row)
+		}
+
+		filesOutput, err := client.ListStoredFiles(ctx, angeltrax.ListStoredFilesInput{TaskID: taskID, DeviceID: deviceID, Channel: channel, Date: date})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, file := range filesOutput.Rows {
+			storedFiles[file.FileSource] = file
+		}
+	}
+
+	localFilename := func(row angeltrax.GlobalReportAutoDownloadTaskRow) string {
+		file, ok := storedFiles[row.FileSource]
+		if !ok {
+			return ""
+		}
+		return filepath.Join(inputDirectory, file.LocalPath(carLicenses[file.DeviceID]))
+	}
+	return rows, localFilename, nil
+}
+
+// groupRowsByDevice splits the rows of a task by device, in order of device ID.
+func groupRowsByDevice(rows []angeltrax.GlobalReportAutoDownloadTaskRow) ([]string, map[string][]angeltrax.GlobalReportAutoDownloadTaskRow) {
+	devices := map[string][]angeltrax.GlobalReportAutoDownloadTaskRow{}
+	var deviceIDs []string
+	for _, row := range rows {
+		if _, ok := devices[row.DeviceID]; !ok {
+			deviceIDs = append(deviceIDs, row.DeviceID)
+		}
+		devices[row.DeviceID] = append(devices[row.DeviceID], row)
+	}
+	sort.Strings(deviceIDs)
+	return deviceIDs, devices
+}
+
+// stitchClip stitches the segments into the given file.
+//
+// The output is written to a temporary file first so that a failed stitch doesn't leave a broken MP4 behind.
+func stitchClip(segments []clips.Segment, filename string, options clips.StitchOptions) (*clips.Clip, error) {
+	var clip *clips.Clip
+	err := partfile.Write(filename, 0644, func(f *os.File) error {
+		var err error
+		clip, err = clips.Stitch(segments, f, options)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	clip.Filename = filename
+	return clip, nil
+}
+
+func writeClipManifest(filename string, manifest clips.Manifest) error {
+	contents, err := json.MarshalIndent(manifest, "", "   ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, contents, 0644)
+}
+
+// parseClipTime parses "yyyy-mm-dd hh:mm:ss" or, given a date (yyyy-mm-dd), "hh:mm:ss".
+//
+// The times are parsed as UTC to match the segments (see clips.NewSegment).
+func parseClipTime(value string, date string) (time.Time, error) {
+	return parseTimeInLocation(value, date, time.UTC)
+}
+
+// parseTimeInLocation parses "yyyy-mm-dd hh:mm:ss" or, given a date (yyyy-mm-dd), "hh:mm:ss" in the given location.
+func parseTimeInLocation(value string, date string, location *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(angeltrax.RecordingTimeFormat, value, location); err == nil {
+		return t, nil
+	}
+	if date == "" {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected \"yyyy-mm-dd hh:mm:ss\" (or \"hh:mm:ss\" with --date)", value)
+	}
+	t, err := time.ParseInLocation(angeltrax.RecordingTimeFormat, date+" "+value, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %v", value, err)
+	}
+	return t, nil
+}
+
+// cutFilename returns the filename for a cut clip: <directory>/<plate>/<plate>-channel<channel>-<yyyymmdd-hhmmss>.mp4.
+func cutFilename(directory string, carLicense string, channel int, from time.Time) string {
+	carLicense = strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(carLicense)
+	return filepath.Join(directory, carLicense, fmt.Sprintf("%s-channel%d-%s.mp4", carLicense, channel, from.Format("20060102-150405")))
+}
+
+// cutClip cuts the window into the given file and writes the sidecar JSON next to it.
+//
+// The output is written to a temporary file first so that a failed cut doesn't leave a broken MP4 behind.
+func cutClip(segments []clips.Segment, from time.Time, to time.Time, filename string, carLicense string, options clips.CutOptions) (*clips.CutClip, string, error) {
+	var clip *clips.CutClip
+	err := partfile.Write(filename, 0644, func(f *os.File) error {
+		var err error
+		clip, err = clips.Cut(segments, from, to, f, options)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	clip.Filename = filename
+	clip.CarLicense = carLicense
+
+	sidecarFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".json"
+	contents, err := json.MarshalIndent(clip, "", "   ")
+	if err != nil {
+		return nil, "", err
+	}
+	err = os.WriteFile(sidecarFilename, contents, 0644)
+	if err != nil {
+		return nil, "", err
+	}
+	return clip, sidecarFilename, nil
+}
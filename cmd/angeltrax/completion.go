@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/partfile"
+)
+
+const (
+	// completionCacheMaxAge is how long the cached devices and groups are used before they're fetched again.
+	completionCacheMaxAge = time.Hour
+	// completionTaskCacheMaxAge is how long the cached tasks are used before they're fetched again.
+	completionTaskCacheMaxAge = 10 * time.Minute
+	// completionTimeout is how long completion may spend talking to the server; shells don't like to wait.
+	completionTimeout = 5 * time.Second
+	// completionParallelism is how many devices' tasks are fetched at once.
+	completionParallelism = 8
+	// completionCacheVersion is the version of the cache file; a cache with any other version is ignored.
+	completionCacheVersion = 2
+)
+
+// completionDevice is a device, as cached for completion; this leaves out the device's credentials.
+type completionDevice struct {
+	DeviceID   string `json:"device_id"`
+	CarLicense string `json:"plate"`
+	GroupID    int    `json:"group_id"`
+}
+
+// completionTask is a task, as cached for completion.
+type completionTask struct {
+	TaskID     int    `json:"task_id"`
+	TaskName   string `json:"task_name"`
+	DeviceID   string `json:"device_id"`
+	CarLicense string `json:"plate"`
+}
+
+// completionCache is what completion knows about the server; it is saved between runs so that completing an
+// argument doesn't have to log in every time.
+type completionCache struct {
+	Version        int                     `json:"version"`
+	Server         string                  `json:"server"`
+	DevicesUpdated time.Time               `json:"devices_updated"`
+	Devices        []completionDevice      `json:"devices"`
+	GroupsUpdated  time.Time               `json:"groups_updated"`
+	Groups         []angeltrax.CenterGroup `json:"groups"`
+	TasksUpdated   time.Time               `json:"tasks_updated"`
+	Tasks          []completionTask        `json:"tasks"`
+}
+
+// completionCacheFilename returns the name of the completion cache file, or an empty string if there's nowhere
+// to put it.
+func completionCacheFilename() string {
+	directory, err := os.UserCacheDir()
+	if err != nil || directory == "" {
+		return ""
+	}
+	return filepath.Join(directory, "angeltrax", "completion.json")
+}
+
+// loadCompletionCache loads the cache for the server; if there is no cache (or it's for a different server or
+// version), an empty one is returned.
+func loadCompletionCache(server string) *completionCache {
+	cache := &completionCache{Version: completionCacheVersion, Server: server}
+	filename := completionCacheFilename()
+	if filename == "" {
+		return cache
+	}
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return cache
+	}
+	var saved completionCache
+	err = json.Unmarshal(contents, &saved)
+	if err != nil || saved.Version != completionCacheVersion || saved.Server != server {
+		return cache
+	}
+	return &saved
+}
+
+// save writes the cache, replacing the old file in one step.
+func (c *completionCache) save() error {
+	filename := completionCacheFilename()
+	if filename == "" {
+		return nil
+	}
+	contents, err := json.MarshalIndent(c, "", "   ")
+	if err != nil {
+		return err
+	}
+	return partfile.WriteFile(filename, contents, 0600)
+}
+
+// setTasks replaces the cached tasks with the rows from MonitorAutoDownload.
+func (c *completionCache) setTasks(rows []angeltrax.MonitorAutoDownloadRow) {
+	c.Tasks = nil
+	for _, row := range rows {
+		c.Tasks = append(c.Tasks, completionTask{TaskID: row.TaskID, TaskName: row.TaskName, DeviceID: row.DeviceID, CarLicense: row.CarLicense})
+	}
+	sort.SliceStable(c.Tasks, func(i, j int) bool {
+		return c.Tasks[i].TaskID > c.Tasks[j].TaskID // The newest first.
+	})
+	c.TasksUpdated = time.Now()
+}
+
+// completer provides the dynamic completions.
+//
+// Completion must never print errors or exit, so anything that goes wrong is only logged at the debug level and
+// whatever is in the cache is used.
+type completer struct {
+	client   *angeltrax.Client
+	cache    *completionCache
+	loggedIn bool
+}
+
+func newCompleter(client *angeltrax.Client) *completer {
+	return &completer{client: client}
+}
+
+func (c *completer) load() {
+	if c.cache == nil {
+		c.cache = loadCompletionCache(c.client.Server)
+	}
+}
+
+func (c *completer) login(ctx context.Context) error {
+	if c.loggedIn {
+		return nil
+	}
+	if c.client.Server == "" || c.client.Username == "" || c.client.Password == "" {
+		return fmt.Errorf("missing credentials")
+	}
+	err := c.client.Login(ctx, c.client.Server, c.client.Username, c.client.Password)
+	if err != nil {
+		return err
+	}
+	c.loggedIn = true
+	return nil
+}
+
+// refresh fetches the devices and groups if the cached ones are too old.
+func (c *completer) refresh() {
+	c.load()
+	if time.Since(c.cache.DevicesUpdated) < completionCacheMaxAge && time.Since(c.cache.GroupsUpdated) < completionCacheMaxAge {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	err := c.login(ctx)
+	if err != nil {
+		logrus.Debugf("Completion: could not log in: %v", err)
+		return
+	}
+	getCenterDevicesResponse, err := c.client.GetCenterDevices(ctx)
+	if err != nil {
+		logrus.Debugf("Completion: could not get the devices: %v", err)
+		return
+	}
+	getCenterGroupsResponse, err := c.client.GetCenterGroups(ctx)
+	if err != nil {
+		logrus.Debugf("Completion: could not get the groups: %v", err)
+		return
+	}
+	c.cache.Devices = nil
+	for _, device := range getCenterDevicesResponse.Data {
+		c.cache.Devices = append(c.cache.Devices, completionDevice{DeviceID: device.DeviceID, CarLicense: device.CarLicense, GroupID: device.GroupID})
+	}
+	c.cache.DevicesUpdated = time.Now()
+	c.cache.Groups = getCenterGroupsResponse.Data
+	c.cache.GroupsUpdated = time.Now()
+	err = c.cache.save()
+	if err != nil {
+		logrus.Debugf("Completion: could not save the cache: %v", err)
+	}
+}
+
+// refreshTasks fetches the tasks if the cached ones are too old.
+func (c *completer) refreshTasks() {
+	c.refresh()
+	if time.Since(c.cache.TasksUpdated) < completionTaskCacheMaxAge {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	err := c.login(ctx)
+	if err != nil {
+		logrus.Debugf("Completion: could not log in: %v", err)
+		return
+	}
+	_, err = c.client.RegisterLogin(ctx)
+	if err != nil {
+		logrus.Debugf("Completion: could not register the login: %v", err)
+		return
+	}
+
+	// Each device takes its own request, so a few are made at once to fit in the timeout.
+	results := make([][]angeltrax.MonitorAutoDownloadRow, len(c.cache.Devices))
+	errs := make([]error, len(c.cache.Devices))
+	var waitGroup sync.WaitGroup
+	semaphore := make(chan struct{}, completionParallelism)
+	for i, device := range c.cache.Devices {
+		waitGroup.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, deviceID string) {
+			defer waitGroup.Done()
+			defer func() { <-semaphore }()
+
+			output, err := c.client.MonitorAutoDownload(ctx, angeltrax.MonitorAutoDownloadInput{DeviceID: deviceID})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = output.Rows
+		}(i, device.DeviceID)
+	}
+	waitGroup.Wait()
+
+	var rows []angeltrax.MonitorAutoDownloadRow
+	for i := range results {
+		if errs[i] != nil {
+			logrus.Debugf("Completion: could not get the tasks: %v", errs[i])
+			return
+		}
+		rows = append(rows, results[i]...)
+	}
+	c.cache.setTasks(rows)
+	err = c.cache.save()
+	if err != nil {
+		logrus.Debugf("Completion: could not save the cache: %v", err)
+	}
+}
+
+// completions returns the values that start with the prefix; each value is "<value>\t<description>".
+func completions(values [][2]string, prefix string) []string {
+	var output []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		if value[0] == "" || seen[value[0]] || !strings.HasPrefix(value[0], prefix) {
+			continue
+		}
+		seen[value[0]] = true
+		if value[1] != "" {
+			output = append(output, value[0]+"\t"+value[1])
+		} else {
+			output = append(output, value[0])
+		}
+	}
+	return output
+}
+
+// deviceIDs completes device IDs.
+func (c *completer) deviceIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	c.refresh()
+	var values [][2]string
+	for _, device := range c.cache.Devices {
+		values = append(values, [2]string{device.DeviceID, device.CarLicense})
+	}
+	return completions(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// deviceNames completes device names (plates).
+func (c *completer) deviceNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	c.refresh()
+	var values [][2]string
+	for _, device := range c.cache.Devices {
+		values = append(values, [2]string{device.CarLicense, device.DeviceID})
+	}
+	return completions(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// devices completes devices given as either an ID or a plate.
+func (c *completer) devices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	c.refresh()
+	var values [][2]string
+	for _, device := range c.cache.Devices {
+		values = append(values, [2]string{device.CarLicense, device.DeviceID})
+	}
+	for _, device := range c.cache.Devices {
+		values = append(values, [2]string{device.DeviceID, device.CarLicense})
+	}
+	return completions(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// groups completes group paths.
+func (c *completer) groups(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	c.refresh()
+	var values [][2]string
+	for _, group := range c.cache.Groups {
+		values = append(values, [2]string{groupPath(c.cache.Groups, group), fmt.Sprintf("#%d", group.GroupID)})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i][0] < values[j][0]
+	})
+	return completions(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// taskIDs completes task IDs, the newest first.
+func (c *completer) taskIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	c.refreshTasks()
+	var values [][2]string
+	for _, task := range c.cache.Tasks {
+		values = append(values, [2]string{fmt.Sprintf("%d", task.TaskID), fmt.Sprintf("%s (%s)", task.TaskName, task.CarLicense)})
+	}
+	return completions(values, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// register adds the completions to every command below the given one, based on the names of their flags and the
+// placeholders in their usage ("${device}", "${group}", and the "${id}" of tasks).
+func (c *completer) register(cmd *cobra.Command) {
+	flagCompletions := map[string]func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective){
+		"device-id":   c.deviceIDs,
+		"device-name": c.deviceNames,
+		"device":      c.devices,
+		"group":       c.groups,
+		"parent":      c.groups,
+	}
+	for name, function := range flagCompletions {
+		if cmd.Flags().Lookup(name) != nil {
+			_ = cmd.RegisterFlagCompletionFunc(name, function)
+		}
+	}
+
+	if cmd.ValidArgsFunction == nil {
+		fields := strings.Fields(cmd.Use)
+		if len(fields) > 1 {
+			argument := strings.Trim(fields[1], "[]")
+			switch {
+			case argument == "${device}":
+				cmd.ValidArgsFunction = c.devices
+			case argument == "${group}":
+				cmd.ValidArgsFunction = c.groups
+			case argument == "${id}" && cmd.Parent() != nil && (cmd.Parent().Name() == "task" || cmd.Parent().Name() == "clips"):
+				cmd.ValidArgsFunction = c.taskIDs
+			}
+		}
+	}
+
+	for _, child := range cmd.Commands() {
+		c.register(child)
+	}
+}
+
+// cacheCompletionTasks saves the tasks from a full MonitorAutoDownload listing so that completion can use them
+// without fetching them again.
+func cacheCompletionTasks(server string, rows []angeltrax.MonitorAutoDownloadRow) {
+	cache := loadCompletionCache(server)
+	cache.setTasks(rows)
+	err := cache.save()
+	if err != nil {
+		logrus.Debugf("Could not save the completion cache: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tekkamanendless/angeltrax/mdvr"
+	"github.com/tekkamanendless/angeltrax/partfile"
+)
+
+// convertOutputFilename returns the MP4 filename for an input file; if the directory is empty, the file goes
+// next to the input.
+func convertOutputFilename(input string, directory string) string {
+	base := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)) + ".mp4"
+	if directory == "" {
+		directory = filepath.Dir(input)
+	}
+	return filepath.Join(directory, base)
+}
+
+// convertFile remuxes an MDVR recording into an MP4 file.
+//
+// The output is written to a temporary file first so that a failed conversion doesn't leave a broken MP4 behind.
+func convertFile(input string, output string) (*mdvr.RemuxStats, error) {
+	inputHandle, err := os.Open(input)
+	if err != nil {
+		return nil, err
+	}
+	defer inputHandle.Close()
+
+	var stats *mdvr.RemuxStats
+	err = partfile.Write(output, 0644, func(f *os.File) error {
+		var err error
+		stats, err = mdvr.Remux(inputHandle, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
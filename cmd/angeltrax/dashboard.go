@@ -0,0 +1,682 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// dashboardPane is one of the panes of the dashboard that can have the focus.
+type dashboardPane int
+
+const (
+	dashboardPaneGroups dashboardPane = iota
+	dashboardPaneDevices
+	dashboardPaneTasks
+	dashboardPaneCount
+)
+
+// dashboardKeyHelp is shown at the bottom of the dashboard.
+const dashboardKeyHelp = "tab: next pane | ↑/↓: move | c: create task | p: pause/resume | r: retry | f: refresh | q: quit"
+
+// dashboardGroupRow is a group in the group tree; the first row (with a zero group) is every device.
+type dashboardGroupRow struct {
+	Group angeltrax.CenterGroup
+	Depth int
+}
+
+// dashboardTask is a task of the selected device, along with the progress of each of its channels.
+type dashboardTask struct {
+	Task     angeltrax.MonitorAutoDownloadRow
+	Channels []angeltrax.GlobalReportAutoDownloadTaskRow
+}
+
+// dashboardPrompt is a line of input that the dashboard is waiting for.
+type dashboardPrompt struct {
+	label  string
+	value  string
+	submit func(value string)
+}
+
+// dashboardLine is a single line of a pane.
+type dashboardLine struct {
+	text     string
+	selected bool
+	failed   bool
+}
+
+// dashboard is the state of "angeltrax dashboard".
+//
+// Everything here is only touched by the dashboard's main loop; the requests to the server happen in the background
+// and their results are applied by functions sent back to the main loop.
+type dashboard struct {
+	client  *angeltrax.Client
+	updates chan func()
+
+	groups  []angeltrax.CenterGroup
+	devices []angeltrax.CenterDevice
+	tasks   []dashboardTask
+
+	groupRows     []dashboardGroupRow
+	groupDevices  []angeltrax.CenterDevice // The devices in the selected group.
+	tasksDeviceID string                   // The device that the tasks are for.
+
+	focus       dashboardPane
+	groupIndex  int
+	deviceIndex int
+	taskIndex   int
+
+	initialGroup string // The group to select once the groups have been loaded.
+	updated      time.Time
+	loading      int // The number of requests in progress.
+	message      string
+	messageError bool
+	prompt       *dashboardPrompt
+	quit         bool
+}
+
+func newDashboard(client *angeltrax.Client) *dashboard {
+	return &dashboard{
+		client:  client,
+		updates: make(chan func(), 16),
+	}
+}
+
+// run shows the dashboard until the user quits.
+//
+// Everything is reloaded at the refresh interval; the screen is also redrawn every second so that it keeps up with
+// the size of the terminal.
+func (d *dashboard) run(ctx context.Context, t *terminal, title string, refreshInterval time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys := make(chan key, 16)
+	go t.readKeys(keys)
+
+	refreshTicker := time.NewTicker(refreshInterval)
+	defer refreshTicker.Stop()
+	redrawTicker := time.NewTicker(time.Second)
+	defer redrawTicker.Stop()
+
+	d.refresh(ctx)
+	for !d.quit {
+		width, height := t.Size()
+		_, err := fmt.Fprint(t.output, d.render(width, height, title))
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case k := <-keys:
+			d.handleKey(ctx, k)
+		case update := <-d.updates:
+			update()
+		case <-refreshTicker.C:
+			if d.loading == 0 {
+				d.refresh(ctx)
+			}
+		case <-redrawTicker.C:
+		}
+	}
+	return nil
+}
+
+// background runs the function outside of the main loop; the function that it returns is then applied in the main
+// loop.
+func (d *dashboard) background(function func() func()) {
+	d.loading++
+	go func() {
+		apply := function()
+		d.updates <- func() {
+			d.loading--
+			apply()
+		}
+	}()
+}
+
+// setMessage shows a message at the bottom of the dashboard.
+func (d *dashboard) setMessage(err error, format string, args ...interface{}) {
+	d.message = fmt.Sprintf(format, args...)
+	d.messageError = err != nil
+	if err != nil {
+		d.message += fmt.Sprintf(": %v", err)
+	}
+}
+
+// refresh reloads the groups and devices, and then the tasks of the selected device.
+func (d *dashboard) refresh(ctx context.Context) {
+	d.background(func() func() {
+		getCenterGroupsResponse, err := d.client.GetCenterGroups(ctx)
+		if err != nil {
+			return func() { d.setMessage(err, "Could not get the groups") }
+		}
+		getCenterDevicesResponse, err := d.client.GetCenterDevices(ctx)
+		if err != nil {
+			return func() { d.setMessage(err, "Could not get the devices") }
+		}
+		return func() {
+			d.groups = getCenterGroupsResponse.Data
+			d.devices = getCenterDevicesResponse.Data
+			d.updated = time.Now()
+			d.rebuild()
+			if d.initialGroup != "" {
+				if group := findGroup(d.groups, d.initialGroup); group != nil {
+					for i, row := range d.groupRows {
+						if row.Group.GroupID == group.GroupID {
+							d.groupIndex = i
+						}
+					}
+					d.rebuild()
+				} else {
+					d.setMessage(nil, "Could not find group %q.", d.initialGroup)
+				}
+				d.initialGroup = ""
+			}
+			d.refreshTasks(ctx)
+		}
+	})
+}
+
+// refreshTasks reloads the tasks of the selected device.
+//
+// Only the tasks that are still running (or that failed, so that they can be retried) are shown.
+func (d *dashboard) refreshTasks(ctx context.Context) {
+	device := d.selectedDevice()
+	if device == nil {
+		d.tasks = nil
+		d.tasksDeviceID = ""
+		return
+	}
+	deviceID := device.DeviceID
+	d.background(func() func() {
+		output, err := d.client.MonitorAutoDownload(ctx, angeltrax.MonitorAutoDownloadInput{DeviceID: deviceID})
+		if err != nil {
+			return func() { d.setMessage(err, "Could not get the tasks of %s", deviceID) }
+		}
+		var tasks []dashboardTask
+		for _, row := range output.Rows {
+			if row.Status.IsTerminal() && !row.Status.IsFailure() {
+				continue
+			}
+			progress, err := d.client.GlobalReportAutoDownloadTask(ctx, angeltrax.GlobalReportAutoDownloadTaskInput{DeviceID: deviceID, TaskID: fmt.Sprintf("%d", row.TaskID)})
+			if err != nil {
+				return func() { d.setMessage(err, "Could not get the progress of task #%d", row.TaskID) }
+			}
+			sort.SliceStable(progress.Rows, func(i, j int) bool {
+				return progress.Rows[i].Channel < progress.Rows[j].Channel
+			})
+			tasks = append(tasks, dashboardTask{Task: row, Channels: progress.Rows})
+		}
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return tasks[i].Task.TaskID > tasks[j].Task.TaskID
+		})
+		return func() {
+			if current := d.selectedDevice(); current == nil || current.DeviceID != deviceID {
+				// The selection changed while this was loading.
+				return
+			}
+			var selectedTaskID int
+			if task := d.selectedTask(); task != nil {
+				selectedTaskID = task.Task.TaskID
+			}
+			d.tasks = tasks
+			d.tasksDeviceID = deviceID
+			d.taskIndex = 0
+			for i, task := range tasks {
+				if task.Task.TaskID == selectedTaskID {
+					d.taskIndex = i
+				}
+			}
+		}
+	})
+}
+
+// rebuild recomputes the group tree and the devices in the selected group, keeping the selections where possible.
+func (d *dashboard) rebuild() {
+	var selectedGroupID int
+	if d.groupIndex < len(d.groupRows) {
+		selectedGroupID = d.groupRows[d.groupIndex].Group.GroupID
+	}
+	var selectedDeviceID string
+	if device := d.selectedDevice(); device != nil {
+		selectedDeviceID = device.DeviceID
+	}
+
+	d.groupRows = dashboardGroupRows(d.groups)
+	d.groupIndex = 0
+	for i, row := range d.groupRows {
+		if row.Group.GroupID == selectedGroupID {
+			d.groupIndex = i
+		}
+	}
+
+	d.groupDevices = nil
+	for _, device := range d.devices {
+		if selectedGroupID == 0 || groupIsWithin(d.groups, device.GroupID, selectedGroupID) {
+			d.groupDevices = append(d.groupDevices, device)
+		}
+	}
+	sort.SliceStable(d.groupDevices, func(i, j int) bool {
+		return d.groupDevices[i].CarLicense < d.groupDevices[j].CarLicense
+	})
+	d.deviceIndex = 0
+	for i, device := range d.groupDevices {
+		if device.DeviceID == selectedDeviceID {
+			d.deviceIndex = i
+		}
+	}
+}
+
+// dashboardGroupRows returns the group tree, depth first and sorted by name, after a row for every device.
+func dashboardGroupRows(groups []angeltrax.CenterGroup) []dashboardGroupRow {
+	rows := []dashboardGroupRow{{Group: angeltrax.CenterGroup{GroupName: "All devices"}}}
+	children := map[int][]angeltrax.CenterGroup{}
+	groupIDs := map[int]bool{}
+	for _, group := range groups {
+		groupIDs[group.GroupID] = true
+	}
+	for _, group := range groups {
+		parentID := group.GroupFatherID
+		if !groupIDs[parentID] {
+			parentID = 0
+		}
+		children[parentID] = append(children[parentID], group)
+	}
+	seen := map[int]bool{}
+	var add func(parentID int, depth int)
+	add = func(parentID int, depth int) {
+		list := children[parentID]
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].GroupName < list[j].GroupName
+		})
+		for _, group := range list {
+			if seen[group.GroupID] {
+				continue
+			}
+			seen[group.GroupID] = true
+			rows = append(rows, dashboardGroupRow{Group: group, Depth: depth})
+			add(group.GroupID, depth+1)
+		}
+	}
+	add(0, 1)
+	return rows
+}
+
+func (d *dashboard) selectedDevice() *angeltrax.CenterDevice {
+	if d.deviceIndex < len(d.groupDevices) {
+		return &d.groupDevices[d.deviceIndex]
+	}
+	return nil
+}
+
+// selectedTask returns the selected task; this is nil until the tasks of the selected device have been loaded.
+func (d *dashboard) selectedTask() *dashboardTask {
+	device := d.selectedDevice()
+	if device == nil || device.DeviceID != d.tasksDeviceID {
+		return nil
+	}
+	if d.taskIndex < len(d.tasks) {
+		return &d.tasks[d.taskIndex]
+	}
+	return nil
+}
+
+// handleKey handles a key press.
+func (d *dashboard) handleKey(ctx context.Context, k key) {
+	if d.prompt != nil {
+		d.handlePromptKey(k)
+		return
+	}
+
+	move := func(delta int) {
+		switch d.focus {
+		case dashboardPaneGroups:
+			d.groupIndex = clampIndex(d.groupIndex+delta, len(d.groupRows))
+			d.rebuild()
+			d.refreshTasks(ctx)
+		case dashboardPaneDevices:
+			d.deviceIndex = clampIndex(d.deviceIndex+delta, len(d.groupDevices))
+			d.refreshTasks(ctx)
+		case dashboardPaneTasks:
+			d.taskIndex = clampIndex(d.taskIndex+delta, len(d.tasks))
+		}
+	}
+
+	switch {
+	case k.name == "ctrl-c" || k.r == 'q':
+		d.quit = true
+	case k.name == "tab" || k.name == "right":
+		d.focus = (d.focus + 1) % dashboardPaneCount
+	case k.name == "backtab" || k.name == "left":
+		d.focus = (d.focus + dashboardPaneCount - 1) % dashboardPaneCount
+	case k.name == "up" || k.r == 'k':
+		move(-1)
+	case k.name == "down" || k.r == 'j':
+		move(1)
+	case k.name == "pgup":
+		move(-10)
+	case k.name == "pgdn":
+		move(10)
+	case k.name == "home":
+		move(-1 << 30)
+	case k.name == "end":
+		move(1 << 30)
+	case k.r == 'f':
+		d.refresh(ctx)
+	case k.r == 'c':
+		d.startCreate(ctx)
+	case k.r == 'p':
+		task := d.selectedTask()
+		if task == nil {
+			d.setMessage(nil, "There is no task selected.")
+			return
+		}
+		if task.Task.Status == angeltrax.TaskStatusPaused {
+			d.taskAction(ctx, "Resumed", task.Task.TaskID, d.client.ResumeAutoDownloadTask)
+		} else {
+			d.taskAction(ctx, "Paused", task.Task.TaskID, d.client.PauseAutoDownloadTask)
+		}
+	case k.r == 'r':
+		task := d.selectedTask()
+		if task == nil {
+			d.setMessage(nil, "There is no task selected.")
+			return
+		}
+		d.taskAction(ctx, "Retried", task.Task.TaskID, d.client.RetryAutoDownloadTask)
+	}
+}
+
+// handlePromptKey handles a key press while a prompt is shown.
+func (d *dashboard) handlePromptKey(k key) {
+	prompt := d.prompt
+	switch {
+	case k.name == "escape" || k.name == "ctrl-c":
+		d.prompt = nil
+		d.setMessage(nil, "Cancelled.")
+	case k.name == "enter":
+		d.prompt = nil
+		prompt.submit(strings.TrimSpace(prompt.value))
+	case k.name == "backspace":
+		if runes := []rune(prompt.value); len(runes) > 0 {
+			prompt.value = string(runes[:len(runes)-1])
+		}
+	case k.name == "" && k.r != 0:
+		prompt.value += string(k.r)
+	}
+}
+
+// clampIndex keeps the index within a list of the given length.
+func clampIndex(index int, length int) int {
+	if index >= length {
+		index = length - 1
+	}
+	if index < 0 {
+		index = 0
+	}
+	return index
+}
+
+// taskAction runs an action on a task and then reloads the tasks.
+func (d *dashboard) taskAction(ctx context.Context, verb string, taskID int, action func(context.Context, string) (*angeltrax.AutoDownloadTaskActionResponse, error)) {
+	d.setMessage(nil, "Working on task #%d...", taskID)
+	d.background(func() func() {
+		output, err := action(ctx, fmt.Sprintf("%d", taskID))
+		if err == nil && !output.Result {
+			err = fmt.Errorf("the server refused")
+		}
+		return func() {
+			if err != nil {
+				d.setMessage(err, "Could not update task #%d", taskID)
+				return
+			}
+			d.setMessage(nil, "%s task #%d.", verb, taskID)
+			d.refreshTasks(ctx)
+		}
+	})
+}
+
+// startCreate prompts for the time window of a new task for the selected device, covering every channel.
+func (d *dashboard) startCreate(ctx context.Context) {
+	device := d.selectedDevice()
+	if device == nil {
+		d.setMessage(nil, "There is no device selected.")
+		return
+	}
+	deviceID := device.DeviceID
+	carLicense := device.CarLicense
+	channelCount := device.ChannelCount
+
+	now := time.Now().Truncate(time.Minute)
+	d.prompt = &dashboardPrompt{
+		label: fmt.Sprintf("New task for %s from (yyyy-mm-dd hh:mm:ss)", carLicense),
+		value: now.Add(-time.Hour).Format(angeltrax.RecordingTimeFormat),
+		submit: func(value string) {
+			from, err := parseTimeInLocation(value, "", time.Local)
+			if err != nil {
+				d.setMessage(err, "Could not create the task")
+				return
+			}
+			d.prompt = &dashboardPrompt{
+				label: fmt.Sprintf("New task for %s to (yyyy-mm-dd hh:mm:ss)", carLicense),
+				value: now.Format(angeltrax.RecordingTimeFormat),
+				submit: func(value string) {
+					to, err := parseTimeInLocation(value, "", time.Local)
+					if err != nil {
+						d.setMessage(err, "Could not create the task")
+						return
+					}
+					if !to.After(from) {
+						d.setMessage(nil, "The end of the task must be after its start.")
+						return
+					}
+					d.createTask(ctx, deviceID, carLicense, channelCount, from, to)
+				},
+			}
+		},
+	}
+}
+
+// createTask creates the tasks (one per day) to download every channel of the device between the two times.
+func (d *dashboard) createTask(ctx context.Context, deviceID string, carLicense string, channelCount int, from time.Time, to time.Time) {
+	var segments []angeltrax.RecordingSegment
+	for channel := 1; channel <= channelCount; channel++ {
+		segments = append(segments, angeltrax.RecordingSegment{Channel: channel, Start: from, End: to})
+	}
+	taskName := fmt.Sprintf("%s %s", carLicense, from.Format("2006-01-02 15:04"))
+	inputs := angeltrax.RecordingTaskInputs(deviceID, taskName, segments, angeltrax.VideoTypeAll)
+	if len(inputs) == 0 {
+		d.setMessage(nil, "%s has no channels.", carLicense)
+		return
+	}
+
+	d.setMessage(nil, "Creating the task for %s...", carLicense)
+	d.background(func() func() {
+		for _, input := range inputs {
+			output, err := d.client.CreateAutoDownloadTask(ctx, input)
+			if err == nil && !output.Result {
+				err = fmt.Errorf("the server refused")
+			}
+			if err != nil {
+				return func() { d.setMessage(err, "Could not create the task for %s", carLicense) }
+			}
+		}
+		return func() {
+			d.setMessage(nil, "Created %d task(s) for %s.", len(inputs), carLicense)
+			d.refreshTasks(ctx)
+		}
+	})
+}
+
+// render draws the whole dashboard for a terminal of the given size.
+func (d *dashboard) render(width int, height int, title string) string {
+	var output strings.Builder
+	output.WriteString(ansiHome)
+	writeLine := func(line dashboardLine, width int) {
+		text := fitText(line.text, width)
+		switch {
+		case line.selected:
+			output.WriteString(ansiReverse + text + ansiReset)
+		case line.failed:
+			output.WriteString(ansiRed + text + ansiReset)
+		default:
+			output.WriteString(text)
+		}
+	}
+	header := func(text string, pane dashboardPane) dashboardLine {
+		if d.focus == pane {
+			text = "» " + text
+		} else {
+			text = "  " + text
+		}
+		return dashboardLine{text: text}
+	}
+
+	status := "updated " + d.updated.Format("15:04:05")
+	if d.updated.IsZero() {
+		status = "loading"
+	}
+	if d.loading > 0 {
+		status += " (refreshing)"
+	}
+	output.WriteString(ansiBold)
+	writeLine(dashboardLine{text: fmt.Sprintf("%s | %s", title, status)}, width)
+	output.WriteString(ansiReset + "\r\n")
+
+	// The groups and devices share the top half, and the tasks get the bottom half.
+	bodyHeight := height - 3
+	if bodyHeight < 4 {
+		bodyHeight = 4
+	}
+	topHeight := bodyHeight / 2
+	taskHeight := bodyHeight - topHeight
+	groupWidth := width / 3
+	if groupWidth > 40 {
+		groupWidth = 40
+	}
+	deviceWidth := width - groupWidth - 3
+
+	var groupLines []dashboardLine
+	for i, row := range d.groupRows {
+		groupLines = append(groupLines, dashboardLine{
+			text:     strings.Repeat("  ", row.Depth) + row.Group.GroupName,
+			selected: i == d.groupIndex && d.focus == dashboardPaneGroups,
+		})
+	}
+	groupLines = paneLines(header("Groups", dashboardPaneGroups), groupLines, d.groupIndex, topHeight)
+
+	var deviceLines []dashboardLine
+	for i, device := range d.groupDevices {
+		deviceLines = append(deviceLines, dashboardLine{
+			text:     fmt.Sprintf("%-16s %-12s %2d ch  %s", device.CarLicense, device.DeviceID, device.ChannelCount, groupPathByID(d.groups, device.GroupID)),
+			selected: i == d.deviceIndex && d.focus == dashboardPaneDevices,
+		})
+	}
+	deviceLines = paneLines(header(fmt.Sprintf("Devices (%d)", len(d.groupDevices)), dashboardPaneDevices), deviceLines, d.deviceIndex, topHeight)
+
+	for i := 0; i < topHeight; i++ {
+		writeLine(groupLines[i], groupWidth)
+		output.WriteString(" │ ")
+		writeLine(deviceLines[i], deviceWidth)
+		output.WriteString(ansiClearLine + "\r\n")
+	}
+
+	var tasks []dashboardTask
+	if device := d.selectedDevice(); device != nil && device.DeviceID == d.tasksDeviceID {
+		tasks = d.tasks
+	}
+	var taskLines []dashboardLine
+	selectedLine := 0
+	for i, task := range tasks {
+		if i == d.taskIndex {
+			selectedLine = len(taskLines)
+		}
+		taskLines = append(taskLines, dashboardLine{
+			text:     fmt.Sprintf("#%-6d %-24s %-20s %s %s-%s", task.Task.TaskID, task.Task.TaskName, strings.TrimPrefix(task.Task.Status.String(), "TaskStatus"), task.Task.Date, task.Task.StartTime, task.Task.EndTime),
+			selected: i == d.taskIndex && d.focus == dashboardPaneTasks,
+			failed:   task.Task.Status.IsFailure(),
+		})
+		for _, channel := range task.Channels {
+			taskLines = append(taskLines, dashboardLine{
+				text:   "    " + dashboardChannelProgress(channel),
+				failed: channel.Status.IsFailure(),
+			})
+		}
+	}
+	taskTitle := "Tasks"
+	if device := d.selectedDevice(); device != nil {
+		taskTitle = fmt.Sprintf("Tasks for %s (%s)", device.CarLicense, device.DeviceID)
+		if d.tasksDeviceID == device.DeviceID && len(d.tasks) == 0 {
+			taskLines = append(taskLines, dashboardLine{text: "No running or failed tasks."})
+		}
+	}
+	taskLines = paneLines(header(taskTitle, dashboardPaneTasks), taskLines, selectedLine, taskHeight)
+	for _, line := range taskLines {
+		writeLine(line, width)
+		output.WriteString(ansiClearLine + "\r\n")
+	}
+
+	if d.prompt != nil {
+		writeLine(dashboardLine{text: fmt.Sprintf("%s: %s█", d.prompt.label, d.prompt.value)}, width)
+		output.WriteString(ansiClearLine + "\r\n")
+		writeLine(dashboardLine{text: "enter: accept | esc: cancel"}, width)
+	} else {
+		writeLine(dashboardLine{text: d.message, failed: d.messageError}, width)
+		output.WriteString(ansiClearLine + "\r\n")
+		writeLine(dashboardLine{text: dashboardKeyHelp}, width)
+	}
+	output.WriteString(ansiClearLine)
+	return output.String()
+}
+
+// paneLines returns exactly the given number of lines for a pane: the header, and then as many of the lines as fit,
+// scrolled so that the selected line is visible.
+func paneLines(header dashboardLine, lines []dashboardLine, selected int, height int) []dashboardLine {
+	output := []dashboardLine{header}
+	rows := height - 1
+	start := 0
+	if selected >= rows {
+		start = selected - rows + 1
+	}
+	for i := start; i < len(lines) && len(output) < height; i++ {
+		output = append(output, lines[i])
+	}
+	for len(output) < height {
+		output = append(output, dashboardLine{})
+	}
+	return output
+}
+
+// groupPathByID returns the full path of the group with the given ID, or an empty string if there is no such group.
+func groupPathByID(groups []angeltrax.CenterGroup, groupID int) string {
+	for _, group := range groups {
+		if group.GroupID == groupID {
+			return groupPath(groups, group)
+		}
+	}
+	return ""
+}
+
+// dashboardChannelProgress formats the progress of a single channel of a task.
+func dashboardChannelProgress(row angeltrax.GlobalReportAutoDownloadTaskRow) string {
+	const barWidth = 20
+	percent := row.PercentValue()
+	filled := int(percent / 100 * barWidth)
+	if filled < 0 {
+		filled = 0
+	}
+	if filled > barWidth {
+		filled = barWidth
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat(" ", barWidth-filled)
+	text := fmt.Sprintf("channel %d [%s] %5.1f%% %.1f / %.1f @ %.1f | %s", row.Channel, bar, percent, row.CurrentSizeValue(), row.TotalSizeValue(), row.SpeedValue(), strings.TrimPrefix(row.Status.String(), "TaskStatus"))
+	if row.Error != "" {
+		text += " | " + row.Error
+	}
+	return text
+}
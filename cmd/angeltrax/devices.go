@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// deviceStatusRow is a device along with its status.
+type deviceStatusRow struct {
+	Device   angeltrax.CenterDevice
+	Status   *angeltrax.DeviceStatus // This is nil if the server had no status for the device.
+	LastSeen time.Time               // This is zero if the device has never been seen.
+}
+
+func (r deviceStatusRow) online() bool {
+	return r.Status != nil && r.Status.IsOnline()
+}
+
+// deviceStatusRows joins the devices with their statuses and sorts them so that the devices that have been gone
+// the longest come first (with the devices that have never been seen at the very top), followed by the online ones.
+func deviceStatusRows(devices []angeltrax.CenterDevice, statuses []angeltrax.DeviceStatus) []deviceStatusRow {
+	statusMap := map[string]angeltrax.DeviceStatus{}
+	for _, status := range statuses {
+		statusMap[status.DeviceID] = status
+	}
+
+	var rows []deviceStatusRow
+	for _, device := range devices {
+		row := deviceStatusRow{Device: device}
+		if status, ok := statusMap[device.DeviceID]; ok {
+			row.Status = &status
+			if lastSeen, err := status.LastSeen(time.Local); err == nil {
+				row.LastSeen = lastSeen
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].online() != rows[j].online() {
+			return !rows[i].online()
+		}
+		if rows[i].LastSeen.IsZero() != rows[j].LastSeen.IsZero() {
+			return rows[i].LastSeen.IsZero()
+		}
+		if !rows[i].LastSeen.Equal(rows[j].LastSeen) {
+			return rows[i].LastSeen.Before(rows[j].LastSeen)
+		}
+		return rows[i].Device.DeviceID < rows[j].Device.DeviceID
+	})
+	return rows
+}
+
+// formatAge formats a duration as days, hours, and minutes (such as "3d 4h 12m").
+func formatAge(d time.Duration) string {
+	if d < time.Minute {
+		return "just now"
+	}
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if days > 0 || hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	parts = append(parts, fmt.Sprintf("%dm", minutes))
+	return strings.Join(parts, " ")
+}
+
+// formatDeviceStatusRow formats a row for "devices status".
+func formatDeviceStatusRow(row deviceStatusRow, now time.Time) string {
+	var state string
+	switch {
+	case row.Status == nil:
+		state = "unknown"
+	case row.online():
+		state = "online"
+	case row.LastSeen.IsZero():
+		state = "offline | never seen"
+	default:
+		state = fmt.Sprintf("offline %s | last seen %s", formatAge(now.Sub(row.LastSeen)), row.Status.LastOnlineTime)
+	}
+	line := fmt.Sprintf("Device #%s: %s | %s", row.Device.DeviceID, row.Device.CarLicense, state)
+	if row.Status != nil {
+		var recording []string
+		for _, channel := range row.Status.RecordingChannels() {
+			recording = append(recording, fmt.Sprintf("%d", channel))
+		}
+		if len(recording) == 0 {
+			recording = []string{"none"}
+		}
+		line += fmt.Sprintf(" | network: %s | storage: %s | recording: %s", row.Status.NetworkType, row.Status.StorageStatus, strings.Join(recording, ","))
+	}
+	return line
+}
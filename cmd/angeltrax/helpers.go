@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// parseChannelList parses a comma-separated list of one-indexed channels, such as "1,2,4".
+func parseChannelList(value string) ([]int, error) {
+	var channels []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		channel, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel %q: %v", part, err)
+		}
+		if channel < 1 {
+			return nil, fmt.Errorf("invalid channel %d: channels start from 1", channel)
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// parseTaskStatus parses a task status given as its number.
+func parseTaskStatus(value string) (angeltrax.TaskStatus, error) {
+	status, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid status %q: %v", value, err)
+	}
+	return angeltrax.TaskStatus(status), nil
+}
+
+// filterDevices returns the devices matching the device ID and device name; empty values match everything.
+func filterDevices(devices []angeltrax.CenterDevice, deviceID string, deviceName string) []angeltrax.CenterDevice {
+	var output []angeltrax.CenterDevice
+	for _, device := range devices {
+		if deviceName != "" && device.CarLicense != deviceName {
+			continue
+		}
+		if deviceID != "" && device.DeviceID != deviceID {
+			continue
+		}
+		output = append(output, device)
+	}
+	return output
+}
+
+// groupPath returns the full path of the group, such as "Company/Region/Yard".
+func groupPath(groups []angeltrax.CenterGroup, group angeltrax.CenterGroup) string {
+	path := group.GroupName
+	seen := map[int]bool{group.GroupID: true}
+	parentID := group.GroupFatherID
+	for {
+		var parent *angeltrax.CenterGroup
+		for i := range groups {
+			if groups[i].GroupID == parentID {
+				parent = &groups[i]
+				break
+			}
+		}
+		if parent == nil || seen[parent.GroupID] {
+			break
+		}
+		seen[parent.GroupID] = true
+		path = parent.GroupName + "/" + path
+		parentID = parent.GroupFatherID
+	}
+	return path
+}
+
+// findGroup finds a group by its ID, its path, or its name (in that order).
+func findGroup(groups []angeltrax.CenterGroup, value string) *angeltrax.CenterGroup {
+	for i := range groups {
+		if fmt.Sprintf("%d", groups[i].GroupID) == value {
+			return &groups[i]
+		}
+	}
+	for i := range groups {
+		if groupPath(groups, groups[i]) == value {
+			return &groups[i]
+		}
+	}
+	for i := range groups {
+		if groups[i].GroupName == value {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// devicesInGroup returns the devices in the given group and all of its subgroups.
+func devicesInGroup(groups []angeltrax.CenterGroup, devices []angeltrax.CenterDevice, groupID int) []angeltrax.CenterDevice {
+	groupIDs := map[int]bool{groupID: true}
+	for changed := true; changed; {
+		changed = false
+		for _, group := range groups {
+			if groupIDs[group.GroupFatherID] && !groupIDs[group.GroupID] {
+				groupIDs[group.GroupID] = true
+				changed = true
+			}
+		}
+	}
+
+	var output []angeltrax.CenterDevice
+	for _, device := range devices {
+		if groupIDs[device.GroupID] {
+			output = append(output, device)
+		}
+	}
+	return output
+}
+
+// parseVideoType parses "all", "normal", or "alarm".
+func parseVideoType(value string) (angeltrax.VideoType, error) {
+	switch value {
+	case "", "all":
+		return angeltrax.VideoTypeAll, nil
+	case "normal":
+		return angeltrax.VideoTypeNormal, nil
+	case "alarm":
+		return angeltrax.VideoTypeAlarm, nil
+	}
+	return 0, fmt.Errorf("invalid video type %q", value)
+}
+
+// selectDevices returns the devices matching the device ID, device name, and group (by ID, path, or name); empty
+// values match everything.
+func selectDevices(ctx context.Context, client *angeltrax.Client, deviceID string, deviceName string, group string) ([]angeltrax.CenterDevice, error) {
+	getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	devices := filterDevices(getCenterDevicesResponse.Data, deviceID, deviceName)
+	if group != "" {
+		getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		g := findGroup(getCenterGroupsResponse.Data, group)
+		if g == nil {
+			return nil, fmt.Errorf("could not find group %q", group)
+		}
+		devices = devicesInGroup(getCenterGroupsResponse.Data, devices, g.GroupID)
+	}
+	return devices, nil
+}
+
+// findDevices returns the devices matching the value, which may be a device ID or a device name.
+func findDevices(devices []angeltrax.CenterDevice, value string) []angeltrax.CenterDevice {
+	output := filterDevices(devices, value, "")
+	if len(output) == 0 {
+		output = filterDevices(devices, "", value)
+	}
+	return output
+}
+
+// groupIsWithin returns true if the group is the ancestor group or one of its subgroups (at any depth).
+func groupIsWithin(groups []angeltrax.CenterGroup, groupID int, ancestorID int) bool {
+	seen := map[int]bool{}
+	for groupID != 0 && !seen[groupID] {
+		if groupID == ancestorID {
+			return true
+		}
+		seen[groupID] = true
+		parentID := 0
+		for _, group := range groups {
+			if group.GroupID == groupID {
+				parentID = group.GroupFatherID
+				break
+			}
+		}
+		groupID = parentID
+	}
+	return false
+}
+
+// centerGroupInput returns the input for editing the group, starting from its current values.
+//
+// The remark is left out so that the edit doesn't change it.
+func centerGroupInput(group angeltrax.CenterGroup) angeltrax.CenterGroupInput {
+	return angeltrax.CenterGroupInput{
+		GroupID:       group.GroupID,
+		GroupFatherID: group.GroupFatherID,
+		GroupName:     group.GroupName,
+	}
+}
+
+// parseCameraName parses a camera name given as "<channel>=<name>", such as "2=Rear".
+func parseCameraName(value string) (int, string, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("invalid camera name %q: expected <channel>=<name>", value)
+	}
+	channel, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid channel %q: %v", parts[0], err)
+	}
+	return channel, strings.TrimSpace(parts[1]), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/store"
+)
+
+// parseHistoryPeriod parses a period for the history commands, relative to now.
+//
+// The period is one of "today", "yesterday", "this-<interval>", "last-<interval>" (the whole interval before the
+// current one, such as last quarter), or "<n>d" or "<n>w" (the last n days or weeks, up to now).
+func parseHistoryPeriod(value string, now time.Time) (time.Time, time.Time, error) {
+	switch value {
+	case "today":
+		start := store.IntervalDay.Start(now)
+		return start, store.IntervalDay.Next(start), nil
+	case "yesterday":
+		end := store.IntervalDay.Start(now)
+		return end.AddDate(0, 0, -1), end, nil
+	}
+	if strings.HasPrefix(value, "this-") || strings.HasPrefix(value, "last-") {
+		interval, err := store.ParseInterval(value[len("this-"):])
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q: %v", value, err)
+		}
+		start := interval.Start(now)
+		if strings.HasPrefix(value, "last-") {
+			// The start of the previous interval is the start of the interval that the day before this one is in.
+			previous := interval.Start(start.AddDate(0, 0, -1))
+			return previous, start, nil
+		}
+		return start, interval.Next(start), nil
+	}
+	if len(value) > 1 {
+		count, err := strconv.Atoi(value[:len(value)-1])
+		if err == nil && count > 0 {
+			switch value[len(value)-1] {
+			case 'd':
+				return now.AddDate(0, 0, -count), now, nil
+			case 'w':
+				return now.AddDate(0, 0, -7*count), now, nil
+			}
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q: expected today, yesterday, this-<interval>, last-<interval>, <n>d, or <n>w", value)
+}
+
+// parseHistoryStatus parses a comma-separated list of statuses for the history commands.
+//
+// Each status is one of "failed", "finished", "no-files", "running", "deleted", or a status number; the tasks with
+// any of them are selected.  An empty value (or "all") selects everything.
+func parseHistoryStatus(value string) (func(angeltrax.TaskStatus) bool, error) {
+	var matchers []func(angeltrax.TaskStatus) bool
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "", "all":
+			return nil, nil
+		case "failed":
+			matchers = append(matchers, angeltrax.TaskStatus.IsFailure)
+		case "finished":
+			matchers = append(matchers, func(status angeltrax.TaskStatus) bool { return status == angeltrax.TaskStatusFinished })
+		case "no-files":
+			matchers = append(matchers, func(status angeltrax.TaskStatus) bool { return status == angeltrax.TaskStatusNoFiles })
+		case "running":
+			matchers = append(matchers, func(status angeltrax.TaskStatus) bool { return !status.IsTerminal() })
+		case "deleted":
+			matchers = append(matchers, func(status angeltrax.TaskStatus) bool { return status == angeltrax.TaskStatusDelete })
+		default:
+			status, err := parseTaskStatus(part)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, func(s angeltrax.TaskStatus) bool { return s == status })
+		}
+	}
+	return func(status angeltrax.TaskStatus) bool {
+		for _, matcher := range matchers {
+			if matcher(status) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// historyDeviceIDs returns the IDs of the devices matching the device ID, the device name, and the group, from
+// what's in the store; if nothing was given, this returns nil (every device).
+//
+// Since a device's plate may have changed, the device name also matches the plates that the tasks were created
+// with.
+func historyDeviceIDs(s *store.Store, deviceID string, deviceName string, group string) ([]string, error) {
+	if deviceID == "" && deviceName == "" && group == "" {
+		return nil, nil
+	}
+
+	devices, _ := s.Devices()
+	matches := map[string]bool{}
+	for _, device := range filterDevices(devices, deviceID, deviceName) {
+		matches[device.DeviceID] = true
+	}
+	for _, task := range s.Tasks() {
+		if deviceName != "" && task.CarLicense != deviceName {
+			continue
+		}
+		if deviceID != "" && task.DeviceID != deviceID {
+			continue
+		}
+		matches[task.DeviceID] = true
+	}
+	if group != "" {
+		groups, _ := s.Groups()
+		g := findGroup(groups, group)
+		if g == nil {
+			return nil, fmt.Errorf("could not find group %q", group)
+		}
+		inGroup := map[string]bool{}
+		for _, device := range devicesInGroup(groups, devices, g.GroupID) {
+			inGroup[device.DeviceID] = true
+		}
+		if deviceID == "" && deviceName == "" {
+			matches = inGroup
+		} else {
+			for id := range matches {
+				if !inGroup[id] {
+					delete(matches, id)
+				}
+			}
+		}
+	}
+
+	var deviceIDs []string
+	for id := range matches {
+		deviceIDs = append(deviceIDs, id)
+	}
+	if len(deviceIDs) == 0 {
+		return nil, fmt.Errorf("no devices match")
+	}
+	return deviceIDs, nil
+}
+
+// formatSyncTime formats when something was synced, for "store status".
+func formatSyncTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(angeltrax.RecordingTimeFormat)
+}
+
+// historyTaskFilter builds the filter for the history commands from their flags.
+//
+// The period and the start and end dates are alternatives; the end date is the last day to include.
+func historyTaskFilter(s *store.Store, deviceID string, deviceName string, group string, status string, period string, startDate string, endDate string, now time.Time) (store.TaskFilter, error) {
+	var filter store.TaskFilter
+	var err error
+
+	filter.DeviceIDs, err = historyDeviceIDs(s, deviceID, deviceName, group)
+	if err != nil {
+		return filter, err
+	}
+	filter.Status, err = parseHistoryStatus(status)
+	if err != nil {
+		return filter, err
+	}
+	if period != "" {
+		if startDate != "" || endDate != "" {
+			return filter, fmt.Errorf("the period can't be used with the start and end dates")
+		}
+		filter.Since, filter.Until, err = parseHistoryPeriod(period, now)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if startDate != "" {
+		filter.Since, err = time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid start date %q: %v", startDate, err)
+		}
+	}
+	if endDate != "" {
+		filter.Until, err = time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid end date %q: %v", endDate, err)
+		}
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
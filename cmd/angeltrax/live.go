@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/mdvr"
+	"github.com/tekkamanendless/angeltrax/mp4"
+)
+
+const (
+	// liveFragmentCount is the number of fragments kept in memory.
+	liveFragmentCount = 10
+	// livePlaylistCount is the number of fragments in the HLS playlist.
+	livePlaylistCount = 6
+)
+
+// liveStream fans a media session out to any number of HTTP clients.
+type liveStream struct {
+	mutex     sync.Mutex
+	changed   chan struct{} // This is closed (and replaced) whenever something changes.
+	init      []byte
+	fragments []*mp4.Fragment
+	done      bool
+	err       error
+}
+
+func newLiveStream() *liveStream {
+	return &liveStream{
+		changed: make(chan struct{}),
+	}
+}
+
+// notify wakes up everyone who is waiting; the mutex must be held.
+func (s *liveStream) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *liveStream) publish(init []byte, fragment *mp4.Fragment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.init = init
+	s.fragments = append(s.fragments, fragment)
+	if len(s.fragments) > liveFragmentCount {
+		s.fragments = s.fragments[len(s.fragments)-liveFragmentCount:]
+	}
+	s.notify()
+}
+
+func (s *liveStream) finish(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.done = true
+	s.err = err
+	s.notify()
+}
+
+// wait returns the fragments after the given sequence number, waiting for new ones if there aren't any yet.  If
+// the sequence number is zero, only the latest fragment is returned.
+func (s *liveStream) wait(ctx context.Context, after uint32) ([]byte, []*mp4.Fragment, bool) {
+	for {
+		s.mutex.Lock()
+		var fragments []*mp4.Fragment
+		if after == 0 {
+			if len(s.fragments) > 0 {
+				fragments = s.fragments[len(s.fragments)-1:]
+			}
+		} else {
+			for _, fragment := range s.fragments {
+				if fragment.Sequence > after {
+					fragments = append(fragments, fragment)
+				}
+			}
+		}
+		init, done, changed := s.init, s.done, s.changed
+		s.mutex.Unlock()
+
+		if len(fragments) > 0 || done {
+			return init, fragments, done
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, true
+		case <-changed:
+		}
+	}
+}
+
+// runLiveStream reads the session and publishes its video as fragments until the session ends.
+func runLiveStream(session *angeltrax.MediaSession, stream *liveStream, minDuration time.Duration) error {
+	fragmenter := mp4.NewFragmenter(session.VideoCodec)
+	fragmenter.MinDuration = minDuration
+	for {
+		frame, err := session.ReadFrame()
+		if err == io.EOF {
+			if fragment := fragmenter.Flush(); fragment != nil {
+				stream.publish(fragmenter.InitSegment(), fragment)
+			}
+			stream.finish(nil)
+			return nil
+		}
+		if err != nil {
+			stream.finish(err)
+			return err
+		}
+		if frame.Type != mdvr.FrameTypeVideo {
+			continue // Browsers can't play the G.711 audio.
+		}
+		fragment, err := fragmenter.WriteVideo(frame.Timestamp, frame.Data)
+		if err != nil {
+			stream.finish(err)
+			return err
+		}
+		if fragment != nil {
+			if fragment.Sequence == 1 {
+				logrus.Infof("Streaming %s (%dx%d).", fragmenter.Codec(), session.Width, session.Height)
+			}
+			logrus.Debugf("Fragment %d: %s", fragment.Sequence, fragment.Duration)
+			stream.publish(fragmenter.InitSegment(), fragment)
+		}
+	}
+}
+
+const livePage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>%s</title></head>
+<body style="margin: 0; background: black;">
+<video id="video" style="width: 100%%; height: 100vh;" controls autoplay muted playsinline></video>
+<script>
+var video = document.getElementById("video");
+video.src = video.canPlayType("application/vnd.apple.mpegurl") ? "live.m3u8" : "live.mp4";
+</script>
+</body>
+</html>
+`
+
+// liveHandler serves the stream: a page to watch it ("/"), progressive fragmented MP4 ("/live.mp4"), and HLS
+// ("/live.m3u8", "/init.mp4", and "/segment/<n>.m4s").
+func liveHandler(stream *liveStream, title string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, livePage, title)
+	})
+
+	mux.HandleFunc("/live.mp4", func(w http.ResponseWriter, r *http.Request) {
+		init, fragments, _ := stream.wait(r.Context(), 0)
+		if init == nil {
+			http.Error(w, "the stream has ended", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Cache-Control", "no-store")
+		flusher, _ := w.(http.Flusher)
+		_, err := w.Write(init)
+		for err == nil {
+			var done bool
+			var last uint32
+			for _, fragment := range fragments {
+				_, err = w.Write(fragment.Data)
+				if err != nil {
+					break
+				}
+				last = fragment.Sequence
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if err != nil {
+				break
+			}
+			_, fragments, done = stream.wait(r.Context(), last)
+			if done && len(fragments) == 0 {
+				break
+			}
+		}
+	})
+
+	mux.HandleFunc("/live.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		// Wait for the first fragment.
+		init, _, _ := stream.wait(r.Context(), 0)
+		if init == nil {
+			http.Error(w, "the stream has ended", http.StatusServiceUnavailable)
+			return
+		}
+
+		stream.mutex.Lock()
+		fragments := stream.fragments
+		if len(fragments) > livePlaylistCount {
+			fragments = fragments[len(fragments)-livePlaylistCount:]
+		}
+		done := stream.done
+		stream.mutex.Unlock()
+
+		var targetDuration float64
+		for _, fragment := range fragments {
+			targetDuration = math.Max(targetDuration, math.Ceil(fragment.Duration.Seconds()))
+		}
+		var builder strings.Builder
+		builder.WriteString("#EXTM3U\n")
+		builder.WriteString("#EXT-X-VERSION:7\n")
+		fmt.Fprintf(&builder, "#EXT-X-TARGETDURATION:%d\n", int(targetDuration))
+		fmt.Fprintf(&builder, "#EXT-X-MEDIA-SEQUENCE:%d\n", fragments[0].Sequence)
+		builder.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+		for _, fragment := range fragments {
+			fmt.Fprintf(&builder, "#EXTINF:%.3f,\n", fragment.Duration.Seconds())
+			fmt.Fprintf(&builder, "segment/%d.m4s\n", fragment.Sequence)
+		}
+		if done {
+			builder.WriteString("#EXT-X-ENDLIST\n")
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = io.WriteString(w, builder.String())
+	})
+
+	mux.HandleFunc("/init.mp4", func(w http.ResponseWriter, r *http.Request) {
+		stream.mutex.Lock()
+		init := stream.init
+		stream.mutex.Unlock()
+		if init == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		_, _ = w.Write(init)
+	})
+
+	mux.HandleFunc("/segment/", func(w http.ResponseWriter, r *http.Request) {
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/segment/"), ".m4s"), 10, 32)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		stream.mutex.Lock()
+		var data []byte
+		for _, fragment := range stream.fragments {
+			if fragment.Sequence == uint32(sequence) {
+				data = fragment.Data
+			}
+		}
+		stream.mutex.Unlock()
+		if data == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "video/iso.segment")
+		_, _ = w.Write(data)
+	})
+
+	return mux
+}
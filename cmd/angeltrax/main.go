@@ -5,13 +5,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/clips"
+	"github.com/tekkamanendless/angeltrax/notify"
+	"github.com/tekkamanendless/angeltrax/partfile"
+	"github.com/tekkamanendless/angeltrax/store"
 )
 
 type Config struct {
@@ -19,10 +28,13 @@ type Config struct {
 	Password string `json:"password"`
 	Server   string `json:"server"`
 	Key      string `json:"key"`
+
+	Notify notify.Config `json:"notify"` // This configures the notifications for failed tasks.
 }
 
 func main() {
 	var defaultConfigFilename string
+	var defaultStoreFilename string
 	{
 		userConfigDirectory, _ := os.UserConfigDir()
 		if userConfigDirectory != "" {
@@ -30,16 +42,26 @@ func main() {
 			_ = os.Mkdir(userConfigDirectory, 0755)
 
 			defaultConfigFilename = userConfigDirectory + string(os.PathSeparator) + "config.json"
+			defaultStoreFilename = userConfigDirectory + string(os.PathSeparator) + "store.json"
 		}
 	}
 
 	var configFilename string
+	var config Config
 	var debug bool
+	var outputFormat string
+	var outputTemplate string
+	var offline bool
+	var storeFilename string
 
 	ctx := context.Background()
 	var client angeltrax.Client
 
 	loginOrFail := func() {
+		if offline {
+			logrus.Errorf("This command needs the server, so it can't be used with --offline.")
+			os.Exit(1)
+		}
 		if client.Server == "" {
 			logrus.Errorf("Missing server.")
 			os.Exit(1)
@@ -60,6 +82,19 @@ func main() {
 		}
 	}
 
+	openStoreOrFail := func() *store.Store {
+		if storeFilename == "" {
+			logrus.Errorf("Missing store file.")
+			os.Exit(1)
+		}
+		s, err := store.Open(storeFilename, client.Server)
+		if err != nil {
+			logrus.Errorf("Could not open the store: %v", err)
+			os.Exit(1)
+		}
+		return s
+	}
+
 	rootCmd := cobra.Command{
 		Use: "angeltrax",
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
@@ -67,6 +102,11 @@ func main() {
 				logrus.SetLevel(logrus.DebugLevel)
 			}
 
+			if err := parseOutputFormat(outputFormat, outputTemplate); err != nil {
+				logrus.Errorf("Error: %v", err)
+				os.Exit(1)
+			}
+
 			if configFilename != "" {
 				_, err := os.Stat(configFilename)
 				if errors.Is(err, os.ErrNotExist) {
@@ -75,7 +115,6 @@ func main() {
 					logrus.Errorf("Could not stat config file %q: %v", configFilename, err)
 					os.Exit(1)
 				} else {
-					var config Config
 					contents, err := os.ReadFile(configFilename)
 					if err != nil {
 						logrus.Errorf("Could not read config file %q: %v", configFilename, err)
@@ -95,7 +134,11 @@ func main() {
 		},
 	}
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enable this to show more verbose logging.")
-	rootCmd.PersistentFlags().StringVar(&configFilename, "config-file", defaultConfigFilename, "Enable this to show output as JSON.")
+	rootCmd.PersistentFlags().StringVar(&configFilename, "config-file", defaultConfigFilename, "The config file to use.")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", "table", "The output format: "+strings.Join(outputFormats, ", ")+".")
+	rootCmd.PersistentFlags().StringVar(&outputTemplate, "template", "", "The Go template for each record when using \"--output template\", such as '{{.task_id}}'.")
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false, "Answer from the local store (see \"store sync\") instead of the server; only center-groups, task monitor, and task global-report support this.")
+	rootCmd.PersistentFlags().StringVar(&storeFilename, "store", defaultStoreFilename, "The local store file to use.")
 
 	{
 		var server string
@@ -135,7 +178,6 @@ func main() {
 				}
 
 				if configFilename != "" {
-					var config Config
 					config.Server = client.Server
 					config.Username = client.Username
 					config.Password = client.Password
@@ -166,49 +208,50 @@ func main() {
 			Short: "",
 			Args:  cobra.ExactArgs(0),
 			Run: func(cmd *cobra.Command, args []string) {
-				loginOrFail()
-
-				getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
-				if err != nil {
-					logrus.Errorf("Error: [%T] %v", err, err)
-					os.Exit(1)
-				}
-
-				getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
-				if err != nil {
-					logrus.Errorf("Error: [%T] %v", err, err)
-					os.Exit(1)
-				}
+				var groups []angeltrax.CenterGroup
+				var devices []angeltrax.CenterDevice
+				if offline {
+					s := openStoreOrFail()
+					groups, _ = s.Groups()
+					devices, _ = s.Devices()
+				} else {
+					loginOrFail()
 
-				for _, group := range getCenterGroupsResponse.Data {
-					var groupName string
-					{
-						currentGroup := &group
-						for currentGroup != nil {
-							if groupName == "" {
-								groupName = currentGroup.GroupName
-							} else {
-								groupName = currentGroup.GroupName + "/" + groupName
-							}
+					getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					groups = getCenterGroupsResponse.Data
 
-							parentID := currentGroup.GroupFatherID
-							currentGroup = nil
-							for _, g := range getCenterGroupsResponse.Data {
-								if g.GroupID == parentID {
-									currentGroup = &g
-									break
-								}
-							}
-						}
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
 					}
+					devices = getCenterDevicesResponse.Data
+				}
 
-					fmt.Printf("Group #%d: %s\n", group.GroupID, groupName)
-					for _, device := range getCenterDevicesResponse.Data {
+				var records []outputRecord
+				for _, group := range groups {
+					path := groupPath(groups, group)
+					var found bool
+					for i := range devices {
+						device := &devices[i]
 						if device.GroupID != group.GroupID {
 							continue
 						}
-						fmt.Printf("   Device #%s: %s | Channels: %d\n", device.DeviceID, device.CarLicense, device.ChannelCount)
+						records = append(records, centerGroupRecord(path, group, device))
+						found = true
 					}
+					if !found {
+						records = append(records, centerGroupRecord(path, group, nil))
+					}
+				}
+				err := writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(centerGroupRecord("", angeltrax.CenterGroup{}, nil)), records)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
 				}
 			},
 		}
@@ -230,6 +273,29 @@ func main() {
 				Short: "Monitor the tasks",
 				Args:  cobra.ExactArgs(0),
 				Run: func(cmd *cobra.Command, args []string) {
+					if offline {
+						// These are the tasks from the last monitor listing of each device.
+						var records []outputRecord
+						for _, task := range openStoreOrFail().Tasks() {
+							if !task.Monitored {
+								continue
+							}
+							if deviceName != "" && task.CarLicense != deviceName {
+								continue
+							}
+							if deviceID != "" && task.DeviceID != deviceID {
+								continue
+							}
+							records = append(records, monitorTaskRecord(task.MonitorRow()))
+						}
+						err := writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(monitorTaskRecord(angeltrax.MonitorAutoDownloadRow{})), records)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						return
+					}
+
 					loginOrFail()
 
 					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
@@ -244,6 +310,8 @@ func main() {
 						os.Exit(1)
 					}
 
+					var records []outputRecord
+					var rows []angeltrax.MonitorAutoDownloadRow
 					for _, device := range getCenterDevicesResponse.Data {
 						logrus.Debugf("Device: %s (%s)", device.DeviceID, device.CarLicense)
 						if deviceName != "" && device.CarLicense != deviceName {
@@ -260,8 +328,18 @@ func main() {
 						}
 						logrus.Debugf("Total: %d", output.Total)
 						for _, task := range output.Rows {
-							fmt.Printf("Task %d: %s (%s): %s | %s %s - %s\n", task.TaskID, task.DeviceID, task.CarLicense, task.TaskName, task.Date, task.StartTime, task.EndTime)
+							records = append(records, monitorTaskRecord(task))
 						}
+						rows = append(rows, output.Rows...)
+					}
+					if deviceID == "" && deviceName == "" {
+						// This is every task, so keep them for completion.
+						cacheCompletionTasks(client.Server, rows)
+					}
+					err = writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(monitorTaskRecord(angeltrax.MonitorAutoDownloadRow{})), records)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
 					}
 				},
 			}
@@ -279,6 +357,10 @@ func main() {
 			var startTime string
 			var endTime string
 			var taskName string
+			var manifestFilename string
+			var concurrency int
+			var reportFilename string
+			var resume bool
 			cmd := &cobra.Command{
 				Use:   "create",
 				Short: "Create a new task",
@@ -298,6 +380,68 @@ func main() {
 						os.Exit(1)
 					}
 
+					if manifestFilename != "" {
+						manifest, err := loadManifest(manifestFilename)
+						if err != nil {
+							logrus.Errorf("Could not load manifest %q: %v", manifestFilename, err)
+							os.Exit(1)
+						}
+
+						getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+
+						tasks, errs := resolveManifest(manifest, getCenterGroupsResponse.Data, getCenterDevicesResponse.Data)
+						if len(errs) > 0 {
+							for _, err := range errs {
+								logrus.Errorf("Invalid manifest: %v", err)
+							}
+							os.Exit(1)
+						}
+
+						var previous []ManifestResult
+						if resume {
+							if reportFilename == "" {
+								logrus.Errorf("Resuming requires a report file.")
+								os.Exit(1)
+							}
+							previous, err = loadManifestReport(reportFilename)
+							if err != nil {
+								logrus.Errorf("Error: %v", err)
+								os.Exit(1)
+							}
+						}
+
+						results, err := createManifestTasks(ctx, &client, tasks, concurrency, previous, func(results []ManifestResult) {
+							if reportFilename != "" {
+								err := writeManifestReport(reportFilename, results)
+								if err != nil {
+									logrus.Warnf("Could not write report %q: %v", reportFilename, err)
+								}
+							}
+						})
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						if reportFilename != "" {
+							err = writeManifestReport(reportFilename, results)
+							if err != nil {
+								logrus.Errorf("Could not write report %q: %v", reportFilename, err)
+								os.Exit(1)
+							}
+						}
+						printManifestResults(os.Stdout, results)
+						for _, result := range results {
+							if result.Status == manifestStatusFailed {
+								os.Exit(1)
+							}
+						}
+						return
+					}
+
 					input := angeltrax.CreateAutoDownloadTaskInput{
 						TaskName:      taskName,
 						StartExecute:  startDate,
@@ -344,6 +488,10 @@ func main() {
 			cmd.Flags().StringVar(&startTime, "start-time", "", "The start time (hh:mm:ss)")
 			cmd.Flags().StringVar(&endTime, "end-time", "", "The end time (hh:mm:ss)")
 			cmd.Flags().StringVar(&taskName, "task-name", "", "The task name")
+			cmd.Flags().StringVar(&manifestFilename, "from", "", "Create the tasks listed in this YAML or CSV manifest instead")
+			cmd.Flags().IntVar(&concurrency, "concurrency", 4, "The maximum number of tasks to submit at once (with --from)")
+			cmd.Flags().StringVar(&reportFilename, "report", "", "Write the per-row results to this JSON file (with --from)")
+			cmd.Flags().BoolVar(&resume, "resume", false, "Skip the rows that the report file says were already done (with --from)")
 			// TODO: Add a flag for cameras (right now we just do them all).
 			groupCmd.AddCommand(cmd)
 		}
@@ -369,8 +517,12 @@ func main() {
 						logrus.Errorf("Error: [%T] %v", err, err)
 						os.Exit(1)
 					}
-					fmt.Printf("Task #%d (%s) - %s (%s)\n", output.TaskID, output.TaskName, output.DeviceID, output.CarLicense)
-					fmt.Printf("   %s - %s, %s - %s\n", output.StartExecute, output.EndExecute, output.StartTime, output.EndTime)
+					record := monitorTaskDetailRecord(*output)
+					err = writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(record), []outputRecord{record})
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
 				},
 			}
 			groupCmd.AddCommand(cmd)
@@ -387,6 +539,32 @@ func main() {
 				Short: "Global report",
 				Args:  cobra.ExactArgs(0),
 				Run: func(cmd *cobra.Command, args []string) {
+					if offline {
+						// The status only filters the tasks if it's set; the default (0) is every status.
+						var records []outputRecord
+						for _, task := range openStoreOrFail().Tasks() {
+							if deviceName != "" && task.CarLicense != deviceName {
+								continue
+							}
+							if deviceID != "" && task.DeviceID != deviceID {
+								continue
+							}
+							if status != 0 && task.Status != angeltrax.TaskStatus(status) {
+								continue
+							}
+							if (startDate != "" && task.Date < startDate) || (endDate != "" && task.Date > endDate) {
+								continue
+							}
+							records = append(records, globalReportRecord(task.GlobalReportRow()))
+						}
+						err := writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(globalReportRecord(angeltrax.GlobalReportAutoDownloadRow{})), records)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						return
+					}
+
 					loginOrFail()
 
 					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
@@ -401,6 +579,7 @@ func main() {
 						os.Exit(1)
 					}
 
+					var records []outputRecord
 					for _, device := range getCenterDevicesResponse.Data {
 						logrus.Debugf("Device: %s (%s)", device.DeviceID, device.CarLicense)
 						if deviceName != "" && device.CarLicense != deviceName {
@@ -422,11 +601,14 @@ func main() {
 							os.Exit(1)
 						}
 						for _, task := range output.Rows {
-							fmt.Printf("Task #%d (%s) - %s (%s)\n", task.TaskID, task.TaskName, task.DeviceID, task.CarLicense)
-							fmt.Printf("   %s - %s\n", task.StartTime, task.EndTime)
-							fmt.Printf("   %s (by %s)\n", task.Status, task.Username)
+							records = append(records, globalReportRecord(task))
 						}
 					}
+					err = writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(globalReportRecord(angeltrax.GlobalReportAutoDownloadRow{})), records)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
 				},
 			}
 			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (you may omit this if you use --device-name)")
@@ -465,11 +647,14 @@ func main() {
 						logrus.Errorf("Error: [%T] %v", err, err)
 						os.Exit(1)
 					}
+					var records []outputRecord
 					for _, task := range output.Rows {
-						fmt.Printf("%s, %s of %s (%s%%)\n", task.FileSource, task.CurrentSize, task.TotalSize, task.Percent)
-						fmt.Printf("   %s, %s - %s\n", task.Date, task.StartTime, task.EndTime)
-						fmt.Printf("   status: %s\n", task.Status)
-						fmt.Printf("   speed: %s\n", task.Speed)
+						records = append(records, globalReportTaskRecord(task))
+					}
+					err = writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(globalReportTaskRecord(angeltrax.GlobalReportAutoDownloadTaskRow{})), records)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
 					}
 				},
 			}
@@ -477,33 +662,2755 @@ func main() {
 			cmd.Flags().StringVar(&date, "date", "", "The date (yyyy-mm-dd) (optional)")
 			groupCmd.AddCommand(cmd)
 		}
-	}
 
-	{
-		var service string
-		var method string
-		cmd := &cobra.Command{
-			Use:   "raw-request [--service ${service}] [--method ${method}] ${path}",
-			Short: "Perform a raw request",
-			Args:  cobra.ExactArgs(1),
-			Run: func(cmd *cobra.Command, args []string) {
-				loginOrFail()
+		{
+			var deviceID string
+			var date string
+			var interval time.Duration
+			var timeout time.Duration
+			var notifyFailures bool
+			cmd := &cobra.Command{
+				Use:   "watch ${id} [${id} ...]",
+				Short: "Watch the progress of tasks until they are done",
+				Long:  "Watch the progress of tasks until every channel is done.\n\nThe exit code is 0 if the tasks finished, 2 if any channel failed, 3 if any channel (or the watch itself) timed out, and 4 if there were no files.",
+				Args:  cobra.MinimumNArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					if deviceID != "" && len(args) > 1 {
+						// Each task is looked up on its own device otherwise.
+						logrus.Errorf("The device ID can only be given when watching a single task.")
+						os.Exit(watchExitError)
+					}
 
-				path := args[0]
+					loginOrFail()
 
-				var output json.RawMessage
-				err := client.RawServiceRequest(ctx, service, method, path, nil, nil, &output)
+					_, err := client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(watchExitError)
+					}
+
+					watchContext := ctx
+					if timeout > 0 {
+						var cancel context.CancelFunc
+						watchContext, cancel = context.WithTimeout(ctx, timeout)
+						defer cancel()
+					}
+
+					var tracker *notify.Tracker
+					if notifyFailures {
+						notifiers, err := config.Notify.Notifiers()
+						if err != nil {
+							logrus.Errorf("Invalid notification configuration: %v", err)
+							os.Exit(watchExitError)
+						}
+						if len(notifiers) == 0 {
+							logrus.Errorf("No notifiers are configured.")
+							os.Exit(watchExitError)
+						}
+						tracker = &notify.Tracker{
+							Notifiers:     notifiers,
+							NotifyInitial: true,
+						}
+					}
+
+					var mutex sync.Mutex
+					var waitGroup sync.WaitGroup
+					var channels []angeltrax.TaskChannelSummary
+					var timedOut bool
+					var failed bool
+					for _, taskID := range args {
+						waitGroup.Add(1)
+						go func(taskID string) {
+							defer waitGroup.Done()
+
+							options := angeltrax.WaitForTaskOptions{
+								DeviceID:    deviceID,
+								Date:        date,
+								Interval:    interval,
+								MaxInterval: interval,
+								OnProgress: func(event angeltrax.TaskProgressEvent) {
+									mutex.Lock()
+									renderTaskProgress(os.Stdout, event)
+									mutex.Unlock()
+
+									if tracker != nil {
+										err := tracker.Observe(ctx, notify.FromProgressEvent(event))
+										if err != nil {
+											logrus.Warnf("Could not send notification: %v", err)
+										}
+									}
+								},
+							}
+							summary, err := client.WaitForTask(watchContext, taskID, options)
+
+							mutex.Lock()
+							defer mutex.Unlock()
+							if summary != nil {
+								channels = append(channels, summary.Channels...)
+								if len(summary.Channels) == 0 && summary.StatusKnown {
+									// The task ended without any channels, so its own status is all there is.
+									channels = append(channels, angeltrax.TaskChannelSummary{Status: summary.Status})
+								}
+							}
+							if errors.Is(err, context.DeadlineExceeded) {
+								logrus.Errorf("Task %s: timed out.", taskID)
+								timedOut = true
+							} else if err != nil {
+								logrus.Errorf("Task %s: [%T] %v", taskID, err, err)
+								failed = true
+							}
+						}(taskID)
+					}
+					waitGroup.Wait()
+
+					exitCode := watchExitCode(channels)
+					if failed {
+						exitCode = watchExitError
+					} else if timedOut && exitCode != watchExitFailed {
+						exitCode = watchExitTimedOut
+					}
+					os.Exit(exitCode)
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (optional; by default, it is looked up from the task; only allowed with a single task)")
+			cmd.Flags().StringVar(&date, "date", "", "The date (yyyy-mm-dd) (optional)")
+			cmd.Flags().DurationVar(&interval, "interval", 10*time.Second, "How often to check the progress")
+			cmd.Flags().DurationVar(&timeout, "timeout", 0, "Give up after this long (zero means never)")
+			cmd.Flags().BoolVar(&notifyFailures, "notify", false, "Send the configured notifications when a channel fails")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var deviceID string
+			var channel int
+			var date string
+			var directory string
+			var parallelism int
+			var listOnly bool
+			cmd := &cobra.Command{
+				Use:   "fetch ${id}",
+				Short: "Download the finished recordings of a task",
+				Long:  "Download the finished recordings of a task.\n\nThe files are saved as <directory>/<plate>/<date>/<channel>/<file>.  Partial downloads are resumed, and files that are already present and intact are skipped (if the server gives their size or MD5; otherwise they are downloaded again).",
+				Args:  cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					taskID := args[0]
+
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					carLicenses := map[string]string{}
+					for _, device := range getCenterDevicesResponse.Data {
+						carLicenses[device.DeviceID] = device.CarLicense
+					}
+
+					_, err = client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					input := angeltrax.ListStoredFilesInput{
+						TaskID:   taskID,
+						DeviceID: deviceID,
+						Channel:  channel,
+						Date:     date,
+					}
+					output, err := client.ListStoredFiles(ctx, input)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					if listOnly {
+						for _, file := range output.Rows {
+							fmt.Printf("%s | channel %d | %s %s - %s | %d bytes\n", file.LocalPath(carLicenses[file.DeviceID]), file.Channel, file.Date, file.StartTime, file.EndTime, file.FileSize)
+						}
+						return
+					}
+
+					options := angeltrax.FetchStoredFilesOptions{
+						Directory:   directory,
+						Parallelism: parallelism,
+						CarLicenses: carLicenses,
+						OnResult: func(result angeltrax.FetchStoredFileResult) {
+							if result.Error != nil {
+								logrus.Errorf("%s: %v", result.Filename, result.Error)
+								return
+							}
+							fmt.Printf("%s\n", result.Filename)
+						},
+					}
+					results := client.FetchStoredFiles(ctx, output.Rows, options)
+					for _, result := range results {
+						if result.Error != nil {
+							os.Exit(1)
+						}
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (optional)")
+			cmd.Flags().IntVar(&channel, "channel", 0, "Only fetch this channel, starting from 1 (optional)")
+			cmd.Flags().StringVar(&date, "date", "", "The date (yyyy-mm-dd) (optional)")
+			cmd.Flags().StringVar(&directory, "output-dir", ".", "The directory to save the files in")
+			cmd.Flags().IntVar(&parallelism, "parallelism", 4, "The maximum number of simultaneous downloads")
+			cmd.Flags().BoolVar(&listOnly, "list", false, "Only list the files; don't download them")
+			groupCmd.AddCommand(cmd)
+		}
+
+		// resolveTaskIDs returns the task IDs given as arguments plus the IDs of any tasks matching the filter.
+		resolveTaskIDs := func(args []string, deviceID string, deviceName string, status string) []string {
+			taskIDs := append([]string{}, args...)
+			if deviceID == "" && deviceName == "" && status == "" {
+				return taskIDs
+			}
+
+			var statusFilter *angeltrax.TaskStatus
+			if status != "" {
+				value, err := parseTaskStatus(status)
+				if err != nil {
+					logrus.Errorf("Error: %v", err)
+					os.Exit(1)
+				}
+				statusFilter = &value
+			}
+
+			getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+			if err != nil {
+				logrus.Errorf("Error: [%T] %v", err, err)
+				os.Exit(1)
+			}
+
+			for _, device := range getCenterDevicesResponse.Data {
+				logrus.Debugf("Device: %s (%s)", device.DeviceID, device.CarLicense)
+				if deviceName != "" && device.CarLicense != deviceName {
+					continue
+				}
+				if deviceID != "" && device.DeviceID != deviceID {
+					continue
+				}
+
+				output, err := client.MonitorAutoDownload(ctx, angeltrax.MonitorAutoDownloadInput{DeviceID: device.DeviceID})
 				if err != nil {
 					logrus.Errorf("Error: [%T] %v", err, err)
 					os.Exit(1)
 				}
-				fmt.Printf("%s\n", output)
-			},
+				for _, task := range output.Rows {
+					if statusFilter != nil && task.Status != *statusFilter {
+						continue
+					}
+					taskIDs = append(taskIDs, fmt.Sprintf("%d", task.TaskID))
+				}
+			}
+			return taskIDs
 		}
-		cmd.Flags().StringVar(&service, "service", "webclient", "The service")
-		cmd.Flags().StringVar(&method, "method", http.MethodGet, "The method")
-		rootCmd.AddCommand(cmd)
-	}
+
+		{
+			var deviceID string
+			var deviceName string
+			var status string
+			var effectiveDays int
+			var startDate string
+			var endDate string
+			var startTime string
+			var endTime string
+			var taskName string
+			var channels string
+			cmd := &cobra.Command{
+				Use:   "edit [${id} ...]",
+				Short: "Edit existing tasks",
+				Args:  cobra.ArbitraryArgs,
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					_, err := client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					taskIDs := resolveTaskIDs(args, deviceID, deviceName, status)
+					if len(taskIDs) == 0 {
+						logrus.Errorf("No tasks given.")
+						os.Exit(1)
+					}
+
+					var taskChannels []int
+					if cmd.Flags().Changed("channels") {
+						taskChannels, err = parseChannelList(channels)
+						if err != nil {
+							logrus.Errorf("Error: %v", err)
+							os.Exit(1)
+						}
+					}
+
+					var failed bool
+					for _, taskID := range taskIDs {
+						task, err := client.MonitorAutoDownloadTask(ctx, taskID)
+						if err != nil {
+							logrus.Errorf("Task %s: [%T] %v", taskID, err, err)
+							failed = true
+							continue
+						}
+
+						input := task.UpdateInput()
+						if cmd.Flags().Changed("task-name") {
+							input.TaskName = taskName
+						}
+						if cmd.Flags().Changed("effective-days") {
+							input.EffectiveDays = effectiveDays
+						}
+						if cmd.Flags().Changed("start-date") {
+							input.StartExecute = startDate
+						}
+						if cmd.Flags().Changed("end-date") {
+							input.EndExecute = endDate
+						}
+						if cmd.Flags().Changed("start-time") {
+							input.StartTime = startTime
+						}
+						if cmd.Flags().Changed("end-time") {
+							input.EndTime = endTime
+						}
+						if cmd.Flags().Changed("channels") {
+							input.TaskChannels = taskChannels
+						}
+
+						output, err := client.UpdateAutoDownloadTask(ctx, input)
+						if err != nil {
+							logrus.Errorf("Task %s: [%T] %v", taskID, err, err)
+							failed = true
+							continue
+						}
+						fmt.Printf("Task %s: success: %t\n", taskID, output.Result)
+						if !output.Result {
+							failed = true
+						}
+					}
+					if failed {
+						os.Exit(1)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "Edit the tasks for this device ID (optional)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "Edit the tasks for this device name (optional)")
+			cmd.Flags().StringVar(&status, "status", "", "Edit the tasks with this status (optional)")
+			cmd.Flags().IntVar(&effectiveDays, "effective-days", 7, "The new effective days")
+			cmd.Flags().StringVar(&startDate, "start-date", "", "The new start date (yyyy-mm-dd)")
+			cmd.Flags().StringVar(&endDate, "end-date", "", "The new end date (yyyy-mm-dd)")
+			cmd.Flags().StringVar(&startTime, "start-time", "", "The new start time (hh:mm:ss)")
+			cmd.Flags().StringVar(&endTime, "end-time", "", "The new end time (hh:mm:ss)")
+			cmd.Flags().StringVar(&taskName, "task-name", "", "The new task name")
+			cmd.Flags().StringVar(&channels, "channels", "", "The new comma-separated list of channels, starting from 1")
+			groupCmd.AddCommand(cmd)
+		}
+
+		for _, action := range []struct {
+			use    string
+			short  string
+			action func(context.Context, string) (*angeltrax.AutoDownloadTaskActionResponse, error)
+		}{
+			{use: "pause", short: "Pause tasks", action: client.PauseAutoDownloadTask},
+			{use: "resume", short: "Resume paused tasks", action: client.ResumeAutoDownloadTask},
+			{use: "retry", short: "Retry failed tasks", action: client.RetryAutoDownloadTask},
+			{use: "delete", short: "Delete tasks", action: client.DeleteAutoDownloadTask},
+		} {
+			action := action
+
+			var deviceID string
+			var deviceName string
+			var status string
+			cmd := &cobra.Command{
+				Use:   action.use + " [${id} ...]",
+				Short: action.short,
+				Args:  cobra.ArbitraryArgs,
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					_, err := client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					taskIDs := resolveTaskIDs(args, deviceID, deviceName, status)
+					if len(taskIDs) == 0 {
+						logrus.Errorf("No tasks given.")
+						os.Exit(1)
+					}
+
+					var failed bool
+					for _, taskID := range taskIDs {
+						output, err := action.action(ctx, taskID)
+						if err != nil {
+							logrus.Errorf("Task %s: [%T] %v", taskID, err, err)
+							failed = true
+							continue
+						}
+						fmt.Printf("Task %s: success: %t\n", taskID, output.Result)
+						if !output.Result {
+							failed = true
+						}
+					}
+					if failed {
+						os.Exit(1)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "Select the tasks for this device ID (optional)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "Select the tasks for this device name (optional)")
+			cmd.Flags().StringVar(&status, "status", "", "Select the tasks with this status (optional)")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "tasks",
+			Short: "Declarative task management",
+			Long:  "Declarative task management.\n\nThe desired tasks are given in the same format as the manifest for \"task create --from\".  Tasks are matched up by device and task name, so every row needs a task name; changing anything else about a task (such as its window or channels) updates it in place.  Only the devices named in the file are considered.",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		// planTasks loads the desired tasks and compares them to the tasks on the server.
+		planTasks := func(filename string) ([]angeltrax.AutoDownloadTaskChange, map[string]string) {
+			manifest, err := loadManifest(filename)
+			if err != nil {
+				logrus.Errorf("Could not load %q: %v", filename, err)
+				os.Exit(1)
+			}
+
+			getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+			if err != nil {
+				logrus.Errorf("Error: [%T] %v", err, err)
+				os.Exit(1)
+			}
+
+			getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+			if err != nil {
+				logrus.Errorf("Error: [%T] %v", err, err)
+				os.Exit(1)
+			}
+
+			_, err = client.RegisterLogin(ctx)
+			if err != nil {
+				logrus.Errorf("Error: [%T] %v", err, err)
+				os.Exit(1)
+			}
+
+			tasks, errs := resolveManifest(manifest, getCenterGroupsResponse.Data, getCenterDevicesResponse.Data)
+			errs = append(errs, checkTaskIdentities(manifest, tasks)...)
+			if len(errs) > 0 {
+				for _, err := range errs {
+					logrus.Errorf("Invalid file: %v", err)
+				}
+				os.Exit(1)
+			}
+
+			carLicenses := map[string]string{}
+			var deviceIDs []string
+			var desired []angeltrax.CreateAutoDownloadTaskInput
+			for _, task := range tasks {
+				if _, ok := carLicenses[task.Input.DeviceID]; !ok {
+					deviceIDs = append(deviceIDs, task.Input.DeviceID)
+				}
+				carLicenses[task.Input.DeviceID] = task.CarLicense
+				desired = append(desired, task.Input)
+			}
+
+			current, err := currentAutoDownloadTasks(ctx, &client, deviceIDs)
+			if err != nil {
+				logrus.Errorf("Error: [%T] %v", err, err)
+				os.Exit(1)
+			}
+
+			changes, err := angeltrax.PlanAutoDownloadTasks(desired, current)
+			if err != nil {
+				logrus.Errorf("Error: [%T] %v", err, err)
+				os.Exit(1)
+			}
+			return changes, carLicenses
+		}
+
+		{
+			var filename string
+			var prune bool
+			cmd := &cobra.Command{
+				Use:   "plan -f ${file}",
+				Short: "Show the changes needed to make the tasks on the server match the file",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					changes, carLicenses := planTasks(filename)
+					printTaskPlan(os.Stdout, changes, carLicenses, prune)
+				},
+			}
+			cmd.Flags().StringVarP(&filename, "file", "f", "", "The YAML or CSV file with the desired tasks")
+			cmd.Flags().BoolVar(&prune, "prune", false, "Plan to delete the tasks that are not in the file (and any duplicates of the ones that are)")
+			_ = cmd.MarkFlagRequired("file")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var filename string
+			var prune bool
+			cmd := &cobra.Command{
+				Use:   "apply -f ${file}",
+				Short: "Make the tasks on the server match the file",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					changes, carLicenses := planTasks(filename)
+					printTaskPlan(os.Stdout, changes, carLicenses, prune)
+
+					var failed bool
+					for _, change := range changes {
+						var output *angeltrax.AutoDownloadTaskActionResponse
+						var err error
+						switch change.Action {
+						case angeltrax.AutoDownloadTaskChangeCreate:
+							var createOutput *angeltrax.CreateAutoDownloadTaskResponse
+							createOutput, err = client.CreateAutoDownloadTask(ctx, *change.Desired)
+							if err == nil {
+								output = &angeltrax.AutoDownloadTaskActionResponse{Result: createOutput.Result}
+							}
+						case angeltrax.AutoDownloadTaskChangeUpdate:
+							output, err = client.UpdateAutoDownloadTask(ctx, change.UpdateInput())
+						case angeltrax.AutoDownloadTaskChangeDelete:
+							if !prune {
+								continue
+							}
+							output, err = client.DeleteAutoDownloadTask(ctx, fmt.Sprintf("%d", change.Current.TaskID))
+						}
+
+						deviceID := changeDeviceID(change)
+						if err != nil {
+							logrus.Errorf("Could not %s task for %s (%s): [%T] %v", change.Action, deviceID, carLicenses[deviceID], err, err)
+							failed = true
+							continue
+						}
+						fmt.Printf("%s %s (%s): success: %t\n", change.Action, deviceID, carLicenses[deviceID], output.Result)
+						if !output.Result {
+							failed = true
+						}
+					}
+					if failed {
+						os.Exit(1)
+					}
+				},
+			}
+			cmd.Flags().StringVarP(&filename, "file", "f", "", "The YAML or CSV file with the desired tasks")
+			cmd.Flags().BoolVar(&prune, "prune", false, "Delete the tasks that are not in the file (and any duplicates of the ones that are)")
+			_ = cmd.MarkFlagRequired("file")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "recordings",
+			Short: "Recording-related commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var deviceID string
+			var deviceName string
+			var channels string
+			var from string
+			var to string
+			var videoType string
+			var tolerance time.Duration
+			var create bool
+			var taskName string
+			cmd := &cobra.Command{
+				Use:   "search",
+				Short: "Search the recordings on a device",
+				Long:  "Search the recordings on a device and show the gaps in coverage.\n\nWith --create, AutoDownload tasks are created to download the segments that were found.",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					start, err := time.ParseInLocation(angeltrax.RecordingTimeFormat, from, time.Local)
+					if err != nil {
+						logrus.Errorf("Invalid start time %q: %v", from, err)
+						os.Exit(1)
+					}
+					end, err := time.ParseInLocation(angeltrax.RecordingTimeFormat, to, time.Local)
+					if err != nil {
+						logrus.Errorf("Invalid end time %q: %v", to, err)
+						os.Exit(1)
+					}
+					parsedVideoType, err := parseVideoType(videoType)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					channelList, err := parseChannelList(channels)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					devices := filterDevices(getCenterDevicesResponse.Data, deviceID, deviceName)
+					if len(devices) != 1 {
+						logrus.Errorf("Could not find device.")
+						os.Exit(1)
+					}
+					device := devices[0]
+					if len(channelList) == 0 {
+						for i := 0; i < device.ChannelCount; i++ {
+							channelList = append(channelList, i+1)
+						}
+					}
+
+					_, err = client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					input := angeltrax.SearchRecordingsInput{
+						DeviceID:  device.DeviceID,
+						Channels:  channelList,
+						Start:     start,
+						End:       end,
+						VideoType: parsedVideoType,
+					}
+					output, err := client.SearchRecordings(ctx, input)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					for _, segment := range output.Rows {
+						fmt.Printf("Channel %d: %s - %s (%s) | type %d | %d bytes\n", segment.Channel, segment.StartTime, segment.EndTime, segment.End.Sub(segment.Start), segment.VideoType, segment.FileSize)
+					}
+
+					gaps := angeltrax.RecordingGaps(output.Rows, channelList, start, end, tolerance)
+					for _, channel := range channelList {
+						for _, gap := range gaps[channel] {
+							fmt.Printf("Gap: channel %d: %s - %s (%s)\n", channel, gap.Start.Format(angeltrax.RecordingTimeFormat), gap.End.Format(angeltrax.RecordingTimeFormat), gap.Duration())
+						}
+					}
+
+					if create {
+						if len(output.Rows) == 0 {
+							logrus.Errorf("There are no recordings to download.")
+							os.Exit(1)
+						}
+						if taskName == "" {
+							taskName = fmt.Sprintf("%s %s", device.CarLicense, from)
+						}
+						var failed bool
+						for _, taskInput := range angeltrax.RecordingTaskInputs(device.DeviceID, taskName, output.Rows, parsedVideoType) {
+							taskOutput, err := client.CreateAutoDownloadTask(ctx, taskInput)
+							if err != nil {
+								logrus.Errorf("Could not create task %q: [%T] %v", taskInput.TaskName, err, err)
+								failed = true
+								continue
+							}
+							fmt.Printf("Task %q (%s %s - %s, channels %v): success: %t\n", taskInput.TaskName, taskInput.StartExecute, taskInput.StartTime, taskInput.EndTime, taskInput.TaskChannels, taskOutput.Result)
+							if !taskOutput.Result {
+								failed = true
+							}
+						}
+						if failed {
+							os.Exit(1)
+						}
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (you may omit this if you use --device-name)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "The device name (you may omit this if you use --device-id)")
+			cmd.Flags().StringVar(&channels, "channels", "", "The comma-separated list of channels, starting from 1 (default: all)")
+			cmd.Flags().StringVar(&from, "from", "", "The start time (yyyy-mm-dd hh:mm:ss)")
+			cmd.Flags().StringVar(&to, "to", "", "The end time (yyyy-mm-dd hh:mm:ss)")
+			cmd.Flags().StringVar(&videoType, "video-type", "all", "The type of video: all, normal, or alarm")
+			cmd.Flags().DurationVar(&tolerance, "gap-tolerance", 5*time.Second, "Ignore gaps shorter than this")
+			cmd.Flags().BoolVar(&create, "create", false, "Create tasks to download the segments that were found")
+			cmd.Flags().StringVar(&taskName, "task-name", "", "The task name (with --create)")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "notify",
+			Short: "Notification-related commands",
+			Long:  "Notification-related commands.\n\nNotifiers are configured in the \"notify\" section of the config file, which has \"webhooks\" (with \"url\" and \"secret\") and \"smtp\" (with \"address\", \"username\", \"password\", \"from\", and \"to\").",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			cmd := &cobra.Command{
+				Use:   "test",
+				Short: "Send a sample notification to every configured notifier",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					notifiers, err := config.Notify.Notifiers()
+					if err != nil {
+						logrus.Errorf("Invalid notification configuration: %v", err)
+						os.Exit(1)
+					}
+					if len(notifiers) == 0 {
+						logrus.Errorf("No notifiers are configured.")
+						os.Exit(1)
+					}
+
+					notification := notify.Notification{
+						Time:           time.Now(),
+						TaskID:         0,
+						TaskName:       "Test notification",
+						DeviceID:       "00000",
+						CarLicense:     "TEST",
+						Channel:        1,
+						Status:         angeltrax.TaskStatusDownloadFailed,
+						StatusName:     angeltrax.TaskStatusDownloadFailed.String(),
+						PreviousStatus: angeltrax.TaskStatusDownloading,
+						Error:          "This is a test.",
+					}
+					var failed bool
+					for _, notifier := range notifiers {
+						err := notifier.Notify(ctx, notification)
+						if err != nil {
+							logrus.Errorf("Notifier %T: %v", notifier, err)
+							failed = true
+							continue
+						}
+						fmt.Printf("Notifier %T: sent\n", notifier)
+					}
+					if failed {
+						os.Exit(1)
+					}
+				},
+			}
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		var channelList string
+		var outputDirectory string
+		var timeout time.Duration
+		cmd := &cobra.Command{
+			Use:   "snapshot ${device} [${device} ...]",
+			Short: "Capture still images from the cameras of one or more devices",
+			Long:  "Capture a still image from each camera of one or more devices (by ID or plate).\n\nThe images are saved as <output directory>/<plate>/<plate>-<camera>-<timestamp>.jpg.  The devices are captured at the same time, and each one has its own timeout.",
+			Args:  cobra.MinimumNArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				channels, err := parseChannelList(channelList)
+				if err != nil {
+					logrus.Errorf("Error: %v", err)
+					os.Exit(1)
+				}
+
+				loginOrFail()
+
+				getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+				var devices []angeltrax.CenterDevice
+				for _, arg := range args {
+					matches := findDevices(getCenterDevicesResponse.Data, arg)
+					if len(matches) != 1 {
+						logrus.Errorf("Could not find exactly one device matching %q (found %d).", arg, len(matches))
+						os.Exit(1)
+					}
+					devices = append(devices, matches[0])
+				}
+
+				_, err = client.RegisterLogin(ctx)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+
+				results := make([][]angeltrax.Snapshot, len(devices))
+				errs := make([]error, len(devices))
+				var waitGroup sync.WaitGroup
+				for i, device := range devices {
+					i := i
+					device := device
+					deviceChannels := channels
+					if len(deviceChannels) == 0 {
+						for channel := 1; channel <= device.ChannelCount; channel++ {
+							deviceChannels = append(deviceChannels, channel)
+						}
+					}
+					waitGroup.Add(1)
+					go func() {
+						defer waitGroup.Done()
+						deviceCtx, cancel := context.WithTimeout(ctx, timeout)
+						defer cancel()
+						logrus.Infof("Capturing channels %v of device %s (%s)...", deviceChannels, device.DeviceID, device.CarLicense)
+						results[i], errs[i] = client.Snapshot(deviceCtx, device.DeviceID, deviceChannels)
+					}()
+				}
+				waitGroup.Wait()
+
+				var failed bool
+				for i, device := range devices {
+					if errs[i] != nil {
+						logrus.Errorf("Device %s (%s): [%T] %v", device.DeviceID, device.CarLicense, errs[i], errs[i])
+						failed = true
+						continue
+					}
+					for _, snapshot := range results[i] {
+						if snapshot.Err != nil {
+							logrus.Errorf("Device %s (%s): channel %d: [%T] %v", device.DeviceID, device.CarLicense, snapshot.Channel, snapshot.Err, snapshot.Err)
+							failed = true
+							continue
+						}
+						captureTime := snapshot.Time
+						if captureTime.IsZero() {
+							captureTime = time.Now()
+						}
+						filename := snapshotFilename(outputDirectory, device, snapshot.Channel, captureTime)
+						// A failed write doesn't leave a broken image behind.
+						err := partfile.WriteFile(filename, snapshot.Data, 0644)
+						if err != nil {
+							logrus.Errorf("Could not save %s: [%T] %v", filename, err, err)
+							failed = true
+							continue
+						}
+						fmt.Printf("%s\n", filename)
+					}
+				}
+				if failed {
+					os.Exit(1)
+				}
+			},
+		}
+		cmd.Flags().StringVar(&channelList, "channel", "", "The channels, such as \"1,2,4\" (optional; the default is every channel)")
+		cmd.Flags().StringVarP(&outputDirectory, "output-dir", "o", ".", "The directory to save the images in")
+		cmd.Flags().DurationVar(&timeout, "timeout", time.Minute, "How long to wait for each device")
+		rootCmd.AddCommand(cmd)
+	}
+
+	{
+		var device string
+		var channel int
+		var subStream bool
+		var date string
+		var from string
+		var to string
+		var listen string
+		var fragmentDuration time.Duration
+		cmd := &cobra.Command{
+			Use:   "live --device ${device} --channel ${channel}",
+			Short: "Watch a camera in a browser",
+			Long:  "Stream a camera from a device and serve it locally over HTTP, as fragmented MP4 (/live.mp4) and HLS (/live.m3u8); open the listen address in a browser to watch it.\n\nIf a start and end time are given, the recording is played back instead.  The times are in the local time zone.  Audio is not served, since browsers can't play it.",
+			Args:  cobra.ExactArgs(0),
+			Run: func(cmd *cobra.Command, args []string) {
+				if device == "" {
+					logrus.Errorf("Missing device.")
+					os.Exit(1)
+				}
+				input := angeltrax.MediaSessionInput{
+					Channel:   channel,
+					SubStream: subStream,
+				}
+				if from != "" || to != "" {
+					var err error
+					input.Playback = true
+					input.Start, err = parseTimeInLocation(from, date, time.Local)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					input.End, err = parseTimeInLocation(to, date, time.Local)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					if !input.End.After(input.Start) {
+						logrus.Errorf("The end time must be after the start time.")
+						os.Exit(1)
+					}
+				}
+
+				loginOrFail()
+
+				getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+				devices := findDevices(getCenterDevicesResponse.Data, device)
+				if len(devices) != 1 {
+					logrus.Errorf("Could not find exactly one device matching %q (found %d).", device, len(devices))
+					os.Exit(1)
+				}
+
+				_, err = client.RegisterLogin(ctx)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+
+				session, err := client.OpenMediaSession(ctx, devices[0], input)
+				if err != nil {
+					logrus.Errorf("Could not open the stream: [%T] %v", err, err)
+					os.Exit(1)
+				}
+				defer session.Close()
+
+				listener, err := net.Listen("tcp", listen)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+				stream := newLiveStream()
+				title := fmt.Sprintf("%s: channel %d", devices[0].CarLicense, channel)
+				serverErrors := make(chan error, 1)
+				go func() {
+					serverErrors <- http.Serve(listener, liveHandler(stream, title))
+				}()
+				logrus.Infof("Serving %s at http://%s/", title, listener.Addr())
+
+				err = runLiveStream(session, stream, fragmentDuration)
+				if err != nil {
+					logrus.Errorf("Stream error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+				logrus.Infof("The stream has ended; press Ctrl+C to stop serving it.")
+				err = <-serverErrors
+				logrus.Errorf("Error: [%T] %v", err, err)
+				os.Exit(1)
+			},
+		}
+		cmd.Flags().StringVar(&device, "device", "", "The device ID or plate")
+		cmd.Flags().IntVar(&channel, "channel", 1, "The channel (starting from 1)")
+		cmd.Flags().BoolVar(&subStream, "sub-stream", false, "Use the lower-quality sub stream")
+		cmd.Flags().StringVar(&date, "date", "", "The date (yyyy-mm-dd), if the times don't have one (optional)")
+		cmd.Flags().StringVar(&from, "from", "", "The playback start time (yyyy-mm-dd hh:mm:ss) (optional)")
+		cmd.Flags().StringVar(&to, "to", "", "The playback end time (yyyy-mm-dd hh:mm:ss) (optional)")
+		cmd.Flags().StringVar(&listen, "listen", "127.0.0.1:8080", "The address to serve the stream on")
+		cmd.Flags().DurationVar(&fragmentDuration, "fragment-duration", 2*time.Second, "The minimum length of each fragment")
+		rootCmd.AddCommand(cmd)
+	}
+
+	{
+		var probe bool
+		cmd := &cobra.Command{
+			Use:   "capabilities",
+			Short: "List what the server supports",
+			Long:  "List what the server supports, based on the features that it lists.  Anything that the server doesn't clearly rule out is assumed to be supported.\n\nWith --probe, the features that the server didn't list are checked by trying their endpoints; only an endpoint that isn't there rules a feature out.",
+			Args:  cobra.ExactArgs(0),
+			Run: func(cmd *cobra.Command, args []string) {
+				loginOrFail()
+
+				capabilities := client.Capabilities()
+				if probe {
+					_, err := client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					capabilities, err = client.ProbeCapabilities(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+				}
+
+				fmt.Printf("Version: %s\n", capabilities.Version)
+				fmt.Printf("Support: %s\n", strings.Join(client.Servers().Support, ", "))
+				for _, status := range capabilities.List() {
+					state := "supported"
+					if !status.Supported {
+						state = "not supported"
+					}
+					fmt.Printf("   %s: %s (%s)\n", status.Capability, state, status.Source)
+				}
+			},
+		}
+		cmd.Flags().BoolVar(&probe, "probe", false, "Try the endpoints of the features that the server didn't list")
+		rootCmd.AddCommand(cmd)
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "server",
+			Short: "CMS server commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var warnDays int
+			cmd := &cobra.Command{
+				Use:   "info",
+				Short: "Show the CMS version, license, and services",
+				Long:  "Show the CMS version, license, and services.\n\nWith --warn-days, this exits with an error if the license expires within that many days (or has already expired), which makes it suitable for a cron job or a monitoring check.",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					if client.Server == "" {
+						logrus.Errorf("Missing server.")
+						os.Exit(1)
+					}
+
+					// This doesn't need to log in, so it still works if the credentials (or the license) are bad.
+					servers, err := client.GetServers(ctx, client.Server)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					now := time.Now()
+					if serverTime, err := servers.ServerTime(time.Local); err == nil {
+						now = serverTime
+					}
+
+					fmt.Printf("Server: %s\n", client.Server)
+					fmt.Printf("Version: %s\n", servers.Version)
+					fmt.Printf("Server date: %s\n", servers.ServerDate)
+					fmt.Printf("Upgrade: %d\n", servers.Upgrade)
+					fmt.Printf("Support: %s\n", strings.Join(servers.Support, ", "))
+
+					expiry, licenseErr := servers.LicenseExpiry(time.Local)
+					switch {
+					case licenseErr != nil:
+						fmt.Printf("License: %s (could not parse: %v)\n", servers.LicenseTimeout, licenseErr)
+					case expiry.IsZero():
+						fmt.Printf("License: does not expire\n")
+					default:
+						days := licenseDays(expiry, now)
+						if days < 0 {
+							fmt.Printf("License: expired on %s (%d days ago)\n", expiry.Format("2006-01-02"), -days)
+						} else {
+							fmt.Printf("License: expires on %s (in %d days)\n", expiry.Format("2006-01-02"), days)
+						}
+					}
+					fmt.Printf("License warning: %d days\n", servers.LicenseTimeoutTip)
+
+					fmt.Printf("Services:\n")
+					for _, name := range servers.ServiceNames() {
+						service := servers.ServiceMap[name]
+						state := "enabled"
+						if service.Enable == 0 {
+							state = "disabled"
+						}
+						fmt.Printf("   %s: %s (%s)\n", name, service.BaseURL(client.Server), state)
+					}
+
+					if warnDays > 0 {
+						if licenseErr != nil {
+							logrus.Errorf("Could not check the license: %v", licenseErr)
+							os.Exit(1)
+						}
+						if !expiry.IsZero() {
+							days := licenseDays(expiry, now)
+							if days < 0 {
+								logrus.Errorf("The CMS license expired %d days ago.", -days)
+								os.Exit(1)
+							}
+							if days <= warnDays {
+								logrus.Errorf("The CMS license expires in %d days.", days)
+								os.Exit(1)
+							}
+						}
+					}
+				},
+			}
+			cmd.Flags().IntVar(&warnDays, "warn-days", 0, "Exit with an error if the license expires within this many days (optional)")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "users",
+			Short: "CMS user commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			cmd := &cobra.Command{
+				Use:   "list",
+				Short: "List the users, with their roles and permissions",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					listUsersResponse, err := client.ListUsers(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					for _, user := range listUsersResponse.Data {
+						fmt.Printf("%s\n", formatUser(user, getCenterGroupsResponse.Data))
+					}
+				},
+			}
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var passwordStdin bool
+			var realName string
+			var roleID int
+			var groupValues []string
+			var deviceValues []string
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   "create ${username} --role ${role}",
+				Short: "Create a user",
+				Long:  "Create a user.\n\nThe password is read from standard input (with --password-stdin), from $" + newPasswordEnvironmentVariable + ", or from a prompt.",
+				Args:  cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					if roleID < 1 {
+						logrus.Errorf("Invalid role ID %d.", roleID)
+						os.Exit(1)
+					}
+
+					password, err := readNewPassword(passwordStdin)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+
+					loginOrFail()
+
+					input := angeltrax.CreateUserInput{
+						UserName: args[0],
+						Password: password,
+						RealName: realName,
+						RoleID:   roleID,
+					}
+					if len(groupValues) > 0 {
+						getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						for _, value := range groupValues {
+							group := findGroup(getCenterGroupsResponse.Data, value)
+							if group == nil {
+								logrus.Errorf("Could not find group %q.", value)
+								os.Exit(1)
+							}
+							input.GroupIDs = append(input.GroupIDs, group.GroupID)
+						}
+					}
+					if len(deviceValues) > 0 {
+						getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						for _, value := range deviceValues {
+							devices := findDevices(getCenterDevicesResponse.Data, value)
+							if len(devices) != 1 {
+								logrus.Errorf("Could not find exactly one device matching %q (found %d).", value, len(devices))
+								os.Exit(1)
+							}
+							input.DeviceIDs = append(input.DeviceIDs, devices[0].DeviceID)
+						}
+					}
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					output, err := client.CreateUser(ctx, input)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if !dryRun {
+						fmt.Printf("Created user #%d: %s\n", output.Data.UserID, input.UserName)
+					}
+				},
+			}
+			cmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "Read the password from the first line of standard input")
+			cmd.Flags().StringVar(&realName, "real-name", "", "The user's real name (optional)")
+			cmd.Flags().IntVar(&roleID, "role", 0, "The role ID")
+			cmd.Flags().StringArrayVar(&groupValues, "group", nil, "A group that the user can see, by ID, path, or name (optional; this may be given more than once)")
+			cmd.Flags().StringArrayVar(&deviceValues, "device", nil, "A device that the user can see, by ID or plate (optional; this may be given more than once)")
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the request instead of sending it")
+			_ = cmd.MarkFlagRequired("role")
+			groupCmd.AddCommand(cmd)
+		}
+
+		for _, action := range []struct {
+			use     string
+			short   string
+			verb    string
+			enabled bool
+		}{
+			{use: "enable", short: "Allow users to log in again", verb: "Enabled", enabled: true},
+			{use: "disable", short: "Stop users from logging in", verb: "Disabled", enabled: false},
+		} {
+			action := action
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   action.use + " ${user} [${user} ...]",
+				Short: action.short,
+				Args:  cobra.MinimumNArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					listUsersResponse, err := client.ListUsers(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					var users []angeltrax.User
+					for _, arg := range args {
+						user := findUser(listUsersResponse.Data, arg)
+						if user == nil {
+							logrus.Errorf("Could not find user %q.", arg)
+							os.Exit(1)
+						}
+						users = append(users, *user)
+					}
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					for _, user := range users {
+						if action.enabled {
+							_, err = client.EnableUser(ctx, user.UserID)
+						} else {
+							_, err = client.DisableUser(ctx, user.UserID)
+						}
+						if err != nil {
+							logrus.Errorf("User %s: [%T] %v", user.UserName, err, err)
+							os.Exit(1)
+						}
+						if !dryRun {
+							fmt.Printf("%s user #%d: %s\n", action.verb, user.UserID, user.UserName)
+						}
+					}
+				},
+			}
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the requests instead of sending them")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var passwordStdin bool
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   "reset-password ${user}",
+				Short: "Set a new password for a user",
+				Long:  "Set a new password for a user.\n\nThe password is read from standard input (with --password-stdin), from $" + newPasswordEnvironmentVariable + ", or from a prompt.",
+				Args:  cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					password, err := readNewPassword(passwordStdin)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+
+					loginOrFail()
+
+					listUsersResponse, err := client.ListUsers(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					user := findUser(listUsersResponse.Data, args[0])
+					if user == nil {
+						logrus.Errorf("Could not find user %q.", args[0])
+						os.Exit(1)
+					}
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					_, err = client.ResetUserPassword(ctx, user.UserID, password)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if !dryRun {
+						fmt.Printf("Reset the password of user #%d: %s\n", user.UserID, user.UserName)
+					}
+				},
+			}
+			cmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "Read the new password from the first line of standard input")
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the request instead of sending it")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var userValue string
+			var deviceValue string
+			var asCSV bool
+			cmd := &cobra.Command{
+				Use:   "audit",
+				Short: "Show which users can see which vehicles",
+				Long:  "Show which users can see which vehicles, through their groups (and subgroups) and their individual devices.\n\nUse --csv for a spreadsheet that can be attached to an access review.",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					listUsersResponse, err := client.ListUsers(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					users := listUsersResponse.Data
+					if userValue != "" {
+						user := findUser(users, userValue)
+						if user == nil {
+							logrus.Errorf("Could not find user %q.", userValue)
+							os.Exit(1)
+						}
+						users = []angeltrax.User{*user}
+					}
+					devices := getCenterDevicesResponse.Data
+					if deviceValue != "" {
+						devices = findDevices(devices, deviceValue)
+						if len(devices) != 1 {
+							logrus.Errorf("Could not find exactly one device matching %q (found %d).", deviceValue, len(devices))
+							os.Exit(1)
+						}
+					}
+
+					rows := userAccessRows(users, getCenterGroupsResponse.Data, devices)
+					if asCSV {
+						err = writeUserAccessCSV(os.Stdout, rows, getCenterGroupsResponse.Data)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						return
+					}
+					for _, row := range rows {
+						state := ""
+						if !row.User.IsEnabled() {
+							state = " (disabled)"
+						}
+						fmt.Printf("User %s%s: Device #%s: %s\n", row.User.UserName, state, row.Device.DeviceID, row.Device.CarLicense)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&userValue, "user", "", "Only show this user, by ID or user name (optional)")
+			cmd.Flags().StringVar(&deviceValue, "device", "", "Only show this device, by ID or plate (optional)")
+			cmd.Flags().BoolVar(&asCSV, "csv", false, "Write the output as CSV")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "groups",
+			Short: "Group management commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var parent string
+			var remark string
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   "create ${name}",
+				Short: "Create a group",
+				Args:  cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					input := angeltrax.CenterGroupInput{
+						GroupName: args[0],
+					}
+					if remark != "" {
+						input.Remark = &remark
+					}
+					if parent != "" {
+						getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						parentGroup := findGroup(getCenterGroupsResponse.Data, parent)
+						if parentGroup == nil {
+							logrus.Errorf("Could not find group %q.", parent)
+							os.Exit(1)
+						}
+						input.GroupFatherID = parentGroup.GroupID
+					}
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					output, err := client.CreateCenterGroup(ctx, input)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if !dryRun {
+						fmt.Printf("Created group #%d: %s\n", output.Data.GroupID, input.GroupName)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&parent, "parent", "", "The parent group, by ID, path, or name (optional; the default is a top-level group)")
+			cmd.Flags().StringVar(&remark, "remark", "", "The remark (optional)")
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the request instead of sending it")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   "rename ${group} ${name}",
+				Short: "Rename a group",
+				Args:  cobra.ExactArgs(2),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					group := findGroup(getCenterGroupsResponse.Data, args[0])
+					if group == nil {
+						logrus.Errorf("Could not find group %q.", args[0])
+						os.Exit(1)
+					}
+
+					input := centerGroupInput(*group)
+					input.GroupName = args[1]
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					_, err = client.UpdateCenterGroup(ctx, input)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if !dryRun {
+						fmt.Printf("Renamed group #%d: %s -> %s\n", group.GroupID, group.GroupName, input.GroupName)
+					}
+				},
+			}
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the request instead of sending it")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var parent string
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   "move ${group} --parent ${group}",
+				Short: "Move a group under a different parent group",
+				Args:  cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					groups := getCenterGroupsResponse.Data
+					group := findGroup(groups, args[0])
+					if group == nil {
+						logrus.Errorf("Could not find group %q.", args[0])
+						os.Exit(1)
+					}
+
+					input := centerGroupInput(*group)
+					input.GroupFatherID = 0
+					parentPath := "(top level)"
+					if parent != "" {
+						parentGroup := findGroup(groups, parent)
+						if parentGroup == nil {
+							logrus.Errorf("Could not find group %q.", parent)
+							os.Exit(1)
+						}
+						if groupIsWithin(groups, parentGroup.GroupID, group.GroupID) {
+							logrus.Errorf("Cannot move group %q into itself or one of its subgroups.", groupPath(groups, *group))
+							os.Exit(1)
+						}
+						input.GroupFatherID = parentGroup.GroupID
+						parentPath = groupPath(groups, *parentGroup)
+					}
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					_, err = client.UpdateCenterGroup(ctx, input)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if !dryRun {
+						fmt.Printf("Moved group #%d: %s -> %s\n", group.GroupID, groupPath(groups, *group), parentPath)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&parent, "parent", "", "The new parent group, by ID, path, or name (leave this empty to make it a top-level group)")
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the request instead of sending it")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   "delete ${group}",
+				Short: "Delete an empty group",
+				Args:  cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					groups := getCenterGroupsResponse.Data
+					group := findGroup(groups, args[0])
+					if group == nil {
+						logrus.Errorf("Could not find group %q.", args[0])
+						os.Exit(1)
+					}
+
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if devices := devicesInGroup(groups, getCenterDevicesResponse.Data, group.GroupID); len(devices) > 0 {
+						logrus.Errorf("Group %q still has %d devices; move them first.", groupPath(groups, *group), len(devices))
+						os.Exit(1)
+					}
+					for _, g := range groups {
+						if g.GroupFatherID == group.GroupID {
+							logrus.Errorf("Group %q still has subgroups (such as %q); move or delete them first.", groupPath(groups, *group), g.GroupName)
+							os.Exit(1)
+						}
+					}
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					_, err = client.DeleteCenterGroup(ctx, group.GroupID)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if !dryRun {
+						fmt.Printf("Deleted group #%d: %s\n", group.GroupID, groupPath(groups, *group))
+					}
+				},
+			}
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the request instead of sending it")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "devices",
+			Short: "Device-related commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var deviceID string
+			var deviceName string
+			var group string
+			var all bool
+			var offlineFor time.Duration
+			cmd := &cobra.Command{
+				Use:   "status",
+				Short: "List the offline devices",
+				Long:  "List the offline devices, starting with the ones that have been gone the longest.\n\nWith --all, the online devices are listed too (after the offline ones).",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					devices, err := selectDevices(ctx, &client, deviceID, deviceName, group)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if len(devices) == 0 {
+						logrus.Errorf("No devices match.")
+						os.Exit(1)
+					}
+
+					var deviceIDs []string
+					if deviceID != "" || deviceName != "" || group != "" {
+						for _, device := range devices {
+							deviceIDs = append(deviceIDs, device.DeviceID)
+						}
+					}
+					output, err := client.GetDeviceStatus(ctx, deviceIDs...)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					now := time.Now()
+					for _, row := range deviceStatusRows(devices, output.Data) {
+						if row.online() && !all {
+							continue
+						}
+						if offlineFor > 0 && !row.online() && !row.LastSeen.IsZero() && now.Sub(row.LastSeen) < offlineFor {
+							continue
+						}
+						fmt.Printf("%s\n", formatDeviceStatusRow(row, now))
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (optional)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "The device name (optional)")
+			cmd.Flags().StringVar(&group, "group", "", "The group ID, path, or name (optional)")
+			cmd.Flags().BoolVar(&all, "all", false, "Include the online devices")
+			cmd.Flags().DurationVar(&offlineFor, "offline-for", 0, "Only list the devices that have been offline for at least this long (optional)")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var group string
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   "move ${device} [${device} ...] --group ${group}",
+				Short: "Move devices to a different group",
+				Args:  cobra.MinimumNArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					if group == "" {
+						logrus.Errorf("Missing group.")
+						os.Exit(1)
+					}
+
+					loginOrFail()
+
+					getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					targetGroup := findGroup(getCenterGroupsResponse.Data, group)
+					if targetGroup == nil {
+						logrus.Errorf("Could not find group %q.", group)
+						os.Exit(1)
+					}
+
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					var devices []angeltrax.CenterDevice
+					for _, arg := range args {
+						matches := findDevices(getCenterDevicesResponse.Data, arg)
+						if len(matches) != 1 {
+							logrus.Errorf("Could not find exactly one device matching %q (found %d).", arg, len(matches))
+							os.Exit(1)
+						}
+						devices = append(devices, matches[0])
+					}
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					for _, device := range devices {
+						if device.GroupID == targetGroup.GroupID {
+							logrus.Infof("Device %s (%s) is already in group %q.", device.DeviceID, device.CarLicense, groupPath(getCenterGroupsResponse.Data, *targetGroup))
+							continue
+						}
+						groupID := targetGroup.GroupID
+						_, err := client.UpdateCenterDevice(ctx, angeltrax.UpdateCenterDeviceInput{DeviceID: device.DeviceID, GroupID: &groupID})
+						if err != nil {
+							logrus.Errorf("Could not move device %s (%s): [%T] %v", device.DeviceID, device.CarLicense, err, err)
+							os.Exit(1)
+						}
+						if !dryRun {
+							fmt.Printf("Moved device #%s: %s -> %s\n", device.DeviceID, device.CarLicense, groupPath(getCenterGroupsResponse.Data, *targetGroup))
+						}
+					}
+				},
+			}
+			cmd.Flags().StringVar(&group, "group", "", "The group, by ID, path, or name")
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the requests instead of sending them")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var plate string
+			var remark string
+			var cameraNames []string
+			var dryRun bool
+			cmd := &cobra.Command{
+				Use:   "edit ${device}",
+				Short: "Change the plate, remark, or camera names of a device",
+				Long:  "Change the plate, remark, or camera names of a device.\n\nOnly the values that are given are changed.  Camera names are given as <channel>=<name>, such as \"--camera-name 2=Rear\"; an empty name clears it.",
+				Args:  cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					devices := findDevices(getCenterDevicesResponse.Data, args[0])
+					if len(devices) != 1 {
+						logrus.Errorf("Could not find exactly one device matching %q (found %d).", args[0], len(devices))
+						os.Exit(1)
+					}
+					device := devices[0]
+
+					input := angeltrax.UpdateCenterDeviceInput{DeviceID: device.DeviceID}
+					if cmd.Flags().Changed("plate") {
+						input.CarLicense = &plate
+					}
+					if cmd.Flags().Changed("remark") {
+						input.Remark = &remark
+					}
+					if len(cameraNames) > 0 {
+						for _, value := range cameraNames {
+							channel, name, err := parseCameraName(value)
+							if err != nil {
+								logrus.Errorf("Error: %v", err)
+								os.Exit(1)
+							}
+							device.CameraNames, err = device.CameraNamesWith(channel, name)
+							if err != nil {
+								logrus.Errorf("Error: %v", err)
+								os.Exit(1)
+							}
+						}
+						input.CameraNames = &device.CameraNames
+					}
+					if input.CarLicense == nil && input.Remark == nil && input.CameraNames == nil {
+						logrus.Errorf("Nothing to change.")
+						os.Exit(1)
+					}
+
+					if dryRun {
+						client.DryRunOutput = os.Stdout
+					}
+					_, err = client.UpdateCenterDevice(ctx, input)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if !dryRun {
+						fmt.Printf("Updated device #%s: %s\n", device.DeviceID, device.CarLicense)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&plate, "plate", "", "The new plate (optional)")
+			cmd.Flags().StringVar(&remark, "remark", "", "The new remark (optional)")
+			cmd.Flags().StringArrayVar(&cameraNames, "camera-name", nil, "A camera name, as <channel>=<name> (optional; this may be given more than once)")
+			cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the request instead of sending it")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "events",
+			Short: "Event-related commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var deviceID string
+			var deviceName string
+			var group string
+			var eventTypes string
+			var cursor string
+			cmd := &cobra.Command{
+				Use:   "tail",
+				Short: "Print events as they happen",
+				Long:  "Print events (alarms, devices going online or offline, and GPS positions) as they happen, one JSON object per line.\n\nEach event has a cursor; pass the last one to --cursor to pick up where a previous run left off.",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					filter := angeltrax.SubscribeFilter{
+						Cursor: cursor,
+						Buffer: 100,
+						OnError: func(err error) {
+							logrus.Warnf("Event stream: [%T] %v", err, err)
+						},
+					}
+					for _, part := range strings.Split(eventTypes, ",") {
+						part = strings.TrimSpace(part)
+						if part != "" {
+							filter.EventTypes = append(filter.EventTypes, angeltrax.EventType(part))
+						}
+					}
+
+					loginOrFail()
+
+					if deviceID != "" || deviceName != "" || group != "" {
+						devices, err := selectDevices(ctx, &client, deviceID, deviceName, group)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						if len(devices) == 0 {
+							logrus.Errorf("No devices match.")
+							os.Exit(1)
+						}
+						for _, device := range devices {
+							filter.DeviceIDs = append(filter.DeviceIDs, device.DeviceID)
+						}
+					}
+
+					_, err := client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					events, err := client.Subscribe(ctx, filter)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					encoder := json.NewEncoder(os.Stdout)
+					for event := range events {
+						err = encoder.Encode(event)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (optional)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "The device name (optional)")
+			cmd.Flags().StringVar(&group, "group", "", "The group ID, path, or name (optional)")
+			cmd.Flags().StringVar(&eventTypes, "type", "", "A comma-separated list of event types: alarm, online, offline, gps (optional)")
+			cmd.Flags().StringVar(&cursor, "cursor", "", "Resume after the event with this cursor (optional)")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "alarms",
+			Short: "Alarm-related commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var deviceID string
+			var deviceName string
+			var group string
+			var alarmTypes string
+			var status string
+			var date string
+			var from string
+			var to string
+			var limit int
+			cmd := &cobra.Command{
+				Use:   "list",
+				Short: "List the alarms",
+				Long:  "List the alarms.\n\nThe devices may be selected by ID, name, or group (by ID, path, or name); the times are in the device's time zone.",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					input := angeltrax.QueryAlarmsInput{}
+
+					var err error
+					input.AlarmTypes, err = parseAlarmTypes(alarmTypes)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					input.HandleStatus, err = parseAlarmHandleStatus(status)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					if from != "" {
+						input.Start, err = parseTimeInLocation(from, date, time.Local)
+						if err != nil {
+							logrus.Errorf("Error: %v", err)
+							os.Exit(1)
+						}
+					}
+					if to != "" {
+						input.End, err = parseTimeInLocation(to, date, time.Local)
+						if err != nil {
+							logrus.Errorf("Error: %v", err)
+							os.Exit(1)
+						}
+					}
+
+					loginOrFail()
+
+					if deviceID != "" || deviceName != "" || group != "" {
+						devices, err := selectDevices(ctx, &client, deviceID, deviceName, group)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						if len(devices) == 0 {
+							logrus.Errorf("No devices match.")
+							os.Exit(1)
+						}
+						for _, device := range devices {
+							input.DeviceIDs = append(input.DeviceIDs, device.DeviceID)
+						}
+					}
+
+					_, err = client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					alarms, err := client.QueryAllAlarms(ctx, input, limit)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					for _, alarm := range alarms {
+						fmt.Printf("Alarm %s: %s (%s): %d %s | %s - %s | %s\n", alarm.AlarmID, alarm.DeviceID, alarm.CarLicense, alarm.AlarmType, alarm.AlarmName, alarm.StartTime, alarm.EndTime, alarm.HandleStatus)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (optional)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "The device name (optional)")
+			cmd.Flags().StringVar(&group, "group", "", "The group ID, path, or name (optional)")
+			cmd.Flags().StringVar(&alarmTypes, "type", "", "A comma-separated list of alarm type codes (optional)")
+			cmd.Flags().StringVar(&status, "status", "", "Only show alarms with this status: unhandled, handled, or acknowledged (optional)")
+			cmd.Flags().StringVar(&date, "date", "", "The date (yyyy-mm-dd), if the times don't have one (optional)")
+			cmd.Flags().StringVar(&from, "from", "", "The start time (yyyy-mm-dd hh:mm:ss) (optional)")
+			cmd.Flags().StringVar(&to, "to", "", "The end time (yyyy-mm-dd hh:mm:ss) (optional)")
+			cmd.Flags().IntVar(&limit, "limit", 0, "The maximum number of alarms to show (optional)")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			cmd := &cobra.Command{
+				Use:   "show ${id} [${id} ...]",
+				Short: "Show the details of alarms",
+				Args:  cobra.MinimumNArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					_, err := client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					for _, alarmID := range args {
+						alarm, err := client.GetAlarm(ctx, alarmID)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						printAlarm(os.Stdout, *alarm)
+					}
+				},
+			}
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var remark string
+			var handle bool
+			cmd := &cobra.Command{
+				Use:   "ack ${id} [${id} ...]",
+				Short: "Acknowledge alarms",
+				Long:  "Acknowledge alarms.\n\nWith --handle, the alarms are marked as handled instead (which should come with a remark saying what was done).",
+				Args:  cobra.MinimumNArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					if handle && remark == "" {
+						logrus.Errorf("Handling alarms requires a remark.")
+						os.Exit(1)
+					}
+
+					loginOrFail()
+
+					_, err := client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					var output *angeltrax.AlarmActionResponse
+					if handle {
+						output, err = client.HandleAlarms(ctx, args, remark)
+					} else {
+						output, err = client.AcknowledgeAlarms(ctx, args, remark)
+					}
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if !output.Result {
+						logrus.Errorf("The server did not accept the change.")
+						os.Exit(1)
+					}
+					for _, alarmID := range args {
+						fmt.Printf("Alarm %s: ok\n", alarmID)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&remark, "remark", "", "A remark to record with the alarms")
+			cmd.Flags().BoolVar(&handle, "handle", false, "Mark the alarms as handled rather than acknowledged")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "track",
+			Short: "GPS track-related commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var device string
+			var date string
+			var from string
+			var to string
+			var format string
+			var outputFilename string
+			cmd := &cobra.Command{
+				Use:   "export --device ${device} --from ${time} --to ${time}",
+				Short: "Export the GPS track of a vehicle",
+				Long:  "Export the GPS track of a vehicle as GPX, KML, or GeoJSON.\n\nThe times are in the local time zone.  If no format is given, it is taken from the output filename.",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					if device == "" {
+						logrus.Errorf("Missing device.")
+						os.Exit(1)
+					}
+					fromTime, err := parseTimeInLocation(from, date, time.Local)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					toTime, err := parseTimeInLocation(to, date, time.Local)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					if !toTime.After(fromTime) {
+						logrus.Errorf("The end time must be after the start time.")
+						os.Exit(1)
+					}
+					if outputFilename == "" || outputFilename == "-" {
+						if format == "" {
+							format = "geojson"
+						}
+					}
+					format, err = trackFormat(format, outputFilename)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+
+					loginOrFail()
+
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					devices := findDevices(getCenterDevicesResponse.Data, device)
+					if len(devices) != 1 {
+						logrus.Errorf("Could not find exactly one device matching %q (found %d).", device, len(devices))
+						os.Exit(1)
+					}
+
+					points, err := client.GetTrack(ctx, devices[0].DeviceID, fromTime, toTime)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					logrus.Infof("Found %d points.", len(points))
+
+					name := devices[0].CarLicense
+					if name == "" {
+						name = devices[0].DeviceID
+					}
+					output := os.Stdout
+					if outputFilename != "" && outputFilename != "-" {
+						output, err = os.Create(outputFilename)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+					}
+					err = writeTrack(output, format, name, points)
+					if output != os.Stdout {
+						closeErr := output.Close()
+						if err == nil {
+							err = closeErr
+						}
+					}
+					if err != nil {
+						logrus.Errorf("Could not write the track: [%T] %v", err, err)
+						os.Exit(1)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&device, "device", "", "The device ID or plate")
+			cmd.Flags().StringVar(&date, "date", "", "The date (yyyy-mm-dd), if the times don't have one (optional)")
+			cmd.Flags().StringVar(&from, "from", "", "The start time (yyyy-mm-dd hh:mm:ss)")
+			cmd.Flags().StringVar(&to, "to", "", "The end time (yyyy-mm-dd hh:mm:ss)")
+			cmd.Flags().StringVar(&format, "format", "", "The format: gpx, kml, or geojson (optional)")
+			cmd.Flags().StringVarP(&outputFilename, "output-file", "o", "-", "The file to write to (\"-\" for standard output)")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "clips",
+			Short: "Clip-related commands",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var deviceID string
+			var channel int
+			var date string
+			var inputDirectory string
+			var outputDirectory string
+			var manifestFilename string
+			var gapTolerance time.Duration
+			cmd := &cobra.Command{
+				Use:   "stitch ${id}",
+				Short: "Join the recordings of a task into one clip per camera",
+				Long:  "Join the recordings of a task into one clip per camera.\n\nThe recordings must already have been downloaded with \"task fetch\" (into the input directory).  The clips are saved as <output directory>/<plate>/task<id>-channel<channel>.mp4.  Gaps are skipped over and marked with chapters, and every discontinuity is listed in the manifest.",
+				Args:  cobra.ExactArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					taskID := args[0]
+
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					carLicenses := map[string]string{}
+					for _, device := range getCenterDevicesResponse.Data {
+						carLicenses[device.DeviceID] = device.CarLicense
+					}
+
+					_, err = client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					rows, localFilename, err := taskRecordings(ctx, &client, []string{taskID}, deviceID, channel, date, inputDirectory, carLicenses)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					if len(rows) == 0 {
+						logrus.Errorf("The task has no recordings.")
+						os.Exit(1)
+					}
+
+					manifest := clips.Manifest{TaskID: taskID}
+					var failed bool
+					deviceIDs, deviceRows := groupRowsByDevice(rows)
+					for _, rowDeviceID := range deviceIDs {
+						channelSegments, err := clips.OrderSegments(deviceRows[rowDeviceID], localFilename)
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						carLicense := carLicenses[rowDeviceID]
+						if carLicense == "" {
+							carLicense = rowDeviceID
+						}
+						for _, segmentChannel := range clips.Channels(channelSegments) {
+							filename := clipFilename(outputDirectory, carLicense, taskID, segmentChannel)
+							clip, err := stitchClip(channelSegments[segmentChannel], filename, clips.StitchOptions{GapTolerance: gapTolerance})
+							if err != nil {
+								logrus.Errorf("%s: [%T] %v", filename, err, err)
+								failed = true
+								continue
+							}
+							clip.CarLicense = carLicenses[rowDeviceID]
+							manifest.Clips = append(manifest.Clips, clip)
+							fmt.Printf("%s (%d segments, %d discontinuities, %.0fs)\n", filename, len(clip.Segments), len(clip.Discontinuities), clip.Duration)
+						}
+					}
+
+					if manifestFilename == "" {
+						manifestFilename = filepath.Join(outputDirectory, fmt.Sprintf("task%s.json", taskID))
+					}
+					err = writeClipManifest(manifestFilename, manifest)
+					if err != nil {
+						logrus.Errorf("Could not write the manifest: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					fmt.Printf("Manifest: %s\n", manifestFilename)
+					if failed {
+						os.Exit(1)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (optional)")
+			cmd.Flags().IntVar(&channel, "channel", 0, "Only stitch this channel, starting from 1 (optional)")
+			cmd.Flags().StringVar(&date, "date", "", "The date (yyyy-mm-dd) (optional)")
+			cmd.Flags().StringVar(&inputDirectory, "input-dir", ".", "The directory that the recordings were fetched into")
+			cmd.Flags().StringVar(&outputDirectory, "output-dir", ".", "The directory to save the clips in")
+			cmd.Flags().StringVar(&manifestFilename, "manifest", "", "The manifest file (default: <output directory>/task<id>.json)")
+			cmd.Flags().DurationVar(&gapTolerance, "gap-tolerance", clips.DefaultGapTolerance, "Ignore gaps shorter than this")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var device string
+			var channel int
+			var date string
+			var from string
+			var to string
+			var inputDirectory string
+			var outputDirectory string
+			var gapTolerance time.Duration
+			cmd := &cobra.Command{
+				Use:   "cut [${id} ...] --device ${device} --channel ${channel} --from ${time} --to ${time}",
+				Short: "Cut a window of time out of the recordings of a camera",
+				Long:  "Cut a window of time out of the recordings of a camera.\n\nThe recordings must already have been downloaded with \"task fetch\" (into the input directory).  If no task IDs are given, all of the device's tasks are searched.  Nothing is re-encoded, so the clip starts at the key frame at or just before the start time.  The clip is saved as <output directory>/<plate>/<plate>-channel<channel>-<start>.mp4, along with a JSON file that describes where it came from.",
+				Args:  cobra.ArbitraryArgs,
+				Run: func(cmd *cobra.Command, args []string) {
+					if device == "" {
+						logrus.Errorf("Missing device.")
+						os.Exit(1)
+					}
+					if channel < 1 {
+						logrus.Errorf("Missing channel.")
+						os.Exit(1)
+					}
+					fromTime, err := parseClipTime(from, date)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					toTime, err := parseClipTime(to, date)
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					if !toTime.After(fromTime) {
+						logrus.Errorf("The end time must be after the start time.")
+						os.Exit(1)
+					}
+
+					loginOrFail()
+
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					devices := findDevices(getCenterDevicesResponse.Data, device)
+					if len(devices) != 1 {
+						logrus.Errorf("Could not find exactly one device matching %q (found %d).", device, len(devices))
+						os.Exit(1)
+					}
+					carLicenses := map[string]string{}
+					for _, d := range getCenterDevicesResponse.Data {
+						carLicenses[d.DeviceID] = d.CarLicense
+					}
+
+					_, err = client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					taskIDs := args
+					if len(taskIDs) == 0 {
+						output, err := client.MonitorAutoDownload(ctx, angeltrax.MonitorAutoDownloadInput{DeviceID: devices[0].DeviceID})
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						for _, task := range output.Rows {
+							taskIDs = append(taskIDs, fmt.Sprintf("%d", task.TaskID))
+						}
+					}
+
+					rows, localFilename, err := taskRecordings(ctx, &client, taskIDs, devices[0].DeviceID, channel, "", inputDirectory, carLicenses)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					channelSegments, err := clips.OrderSegments(rows, localFilename)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					carLicense := devices[0].CarLicense
+					if carLicense == "" {
+						carLicense = devices[0].DeviceID
+					}
+					filename := cutFilename(outputDirectory, carLicense, channel, fromTime)
+					clip, sidecarFilename, err := cutClip(channelSegments[channel], fromTime, toTime, filename, devices[0].CarLicense, clips.CutOptions{GapTolerance: gapTolerance})
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					fmt.Printf("%s (%s - %s, %.1fs, %d sources)\n", filename, clip.Start, clip.End, clip.Duration, len(clip.Sources))
+					fmt.Printf("%s\n", sidecarFilename)
+					for _, discontinuity := range clip.Discontinuities {
+						logrus.Warnf("%s: %s - %s %s", discontinuity.Type, discontinuity.Start, discontinuity.End, discontinuity.Reason)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&device, "device", "", "The device ID or plate")
+			cmd.Flags().IntVar(&channel, "channel", 0, "The channel, starting from 1")
+			cmd.Flags().StringVar(&date, "date", "", "The date (yyyy-mm-dd), if the times don't have one (optional)")
+			cmd.Flags().StringVar(&from, "from", "", "The start time (yyyy-mm-dd hh:mm:ss)")
+			cmd.Flags().StringVar(&to, "to", "", "The end time (yyyy-mm-dd hh:mm:ss)")
+			cmd.Flags().StringVar(&inputDirectory, "input-dir", ".", "The directory that the recordings were fetched into")
+			cmd.Flags().StringVar(&outputDirectory, "output-dir", ".", "The directory to save the clip in")
+			cmd.Flags().DurationVar(&gapTolerance, "gap-tolerance", clips.DefaultGapTolerance, "Ignore gaps shorter than this")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		var outputDirectory string
+		var force bool
+		cmd := &cobra.Command{
+			Use:   "convert ${file} [${file} ...]",
+			Short: "Convert downloaded recordings to MP4",
+			Long:  "Convert downloaded recordings to MP4.\n\nThe video and audio are copied as-is (there is no transcoding), so this is fast.  By default, each MP4 is written next to its recording.",
+			Args:  cobra.MinimumNArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				var failed bool
+				for _, input := range args {
+					output := convertOutputFilename(input, outputDirectory)
+					if !force {
+						if _, err := os.Stat(output); err == nil {
+							logrus.Warnf("Skipping %s: %s already exists.", input, output)
+							continue
+						}
+					}
+
+					stats, err := convertFile(input, output)
+					if err != nil {
+						logrus.Errorf("Could not convert %s: [%T] %v", input, err, err)
+						failed = true
+						continue
+					}
+					fmt.Printf("%s -> %s (%s %dx%d, %s, %d video frames, %d audio frames)\n", input, output, stats.VideoCodec, stats.Width, stats.Height, stats.Duration.Round(time.Second), stats.VideoFrames, stats.AudioFrames)
+					if stats.DroppedFrames > 0 || stats.SkippedBytes > 0 {
+						logrus.Warnf("%s: dropped %d frames before the first key frame and skipped %d damaged bytes.", input, stats.DroppedFrames, stats.SkippedBytes)
+					}
+				}
+				if failed {
+					os.Exit(1)
+				}
+			},
+		}
+		cmd.Flags().StringVarP(&outputDirectory, "output-dir", "o", "", "The directory to write the MP4 files to (optional)")
+		cmd.Flags().BoolVar(&force, "force", false, "Overwrite existing MP4 files")
+		rootCmd.AddCommand(cmd)
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "store",
+			Short: "Local store commands",
+			Long:  "Local store commands.\n\nThe store is a local copy of the server's groups, devices, and tasks.  It keeps the tasks (and every status that they were seen in) even after the server prunes them, so that the history commands can answer questions about them later.  The commands that support --offline answer from the store instead of the server.",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var deviceID string
+			var deviceName string
+			var group string
+			var startDate string
+			var endDate string
+			cmd := &cobra.Command{
+				Use:   "sync",
+				Short: "Copy the groups, devices, and tasks from the server into the store",
+				Long:  "Copy the groups, devices, and tasks from the server into the store.\n\nThe groups and devices are replaced.  The tasks are added to the ones already in the store, and every change of status is recorded.  The tasks come from the monitor (the current tasks of each device) and from the global report between the start and end dates; by default, the report starts the day before the end of the last full sync (or 90 days ago, for the first one) and ends today.",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					loginOrFail()
+
+					s := openStoreOrFail()
+
+					now := time.Now()
+					getCenterGroupsResponse, err := client.GetCenterGroups(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					getCenterDevicesResponse, err := client.GetCenterDevices(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+					s.SetGroups(getCenterGroupsResponse.Data, now)
+					s.SetDevices(getCenterDevicesResponse.Data, now)
+
+					devices := filterDevices(getCenterDevicesResponse.Data, deviceID, deviceName)
+					if group != "" {
+						g := findGroup(getCenterGroupsResponse.Data, group)
+						if g == nil {
+							logrus.Errorf("Could not find group %q.", group)
+							os.Exit(1)
+						}
+						devices = devicesInGroup(getCenterGroupsResponse.Data, devices, g.GroupID)
+					}
+					if len(devices) == 0 {
+						logrus.Errorf("No devices match.")
+						os.Exit(1)
+					}
+					// Only a sync of every device counts toward the default start date of the next one.
+					var through string
+					if deviceID == "" && deviceName == "" && group == "" {
+						through = endDate
+					}
+
+					if startDate == "" {
+						startDate = now.AddDate(0, 0, -90).Format("2006-01-02")
+						if _, _, previousThrough := s.SyncTimes(); previousThrough != "" {
+							if t, err := time.ParseInLocation("2006-01-02", previousThrough, time.Local); err == nil {
+								startDate = t.AddDate(0, 0, -1).Format("2006-01-02")
+							}
+						}
+					}
+
+					_, err = client.RegisterLogin(ctx)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+
+					var monitorResult, globalReportResult store.SyncResult
+					for _, device := range devices {
+						logrus.Debugf("Device: %s (%s)", device.DeviceID, device.CarLicense)
+
+						monitorOutput, err := client.MonitorAutoDownload(ctx, angeltrax.MonitorAutoDownloadInput{DeviceID: device.DeviceID})
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						monitorResult.Add(s.SetMonitorTasks(device.DeviceID, monitorOutput.Rows, time.Now()))
+
+						globalReportRows, err := client.GlobalReportAllAutoDownload(ctx, angeltrax.GlobalReportAutoDownloadInput{
+							DeviceID:  device.DeviceID,
+							StartDate: startDate,
+							EndDate:   endDate,
+						})
+						if err != nil {
+							logrus.Errorf("Error: [%T] %v", err, err)
+							os.Exit(1)
+						}
+						globalReportResult.Add(s.AddGlobalReportTasks(globalReportRows, through, time.Now()))
+					}
+
+					err = s.Save()
+					if err != nil {
+						logrus.Errorf("Could not save the store: %v", err)
+						os.Exit(1)
+					}
+					fmt.Printf("Groups: %d\n", len(getCenterGroupsResponse.Data))
+					fmt.Printf("Devices: %d\n", len(getCenterDevicesResponse.Data))
+					fmt.Printf("Monitor: %d tasks (%d new, %d changed)\n", monitorResult.Seen, monitorResult.New, monitorResult.Changed)
+					fmt.Printf("Global report (%s to %s): %d tasks (%d new, %d changed)\n", startDate, endDate, globalReportResult.Seen, globalReportResult.New, globalReportResult.Changed)
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "Only sync the tasks of this device ID (optional)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "Only sync the tasks of this device name (optional)")
+			cmd.Flags().StringVar(&group, "group", "", "Only sync the tasks of the devices in this group ID, path, or name (optional)")
+			cmd.Flags().StringVar(&startDate, "start-date", "", "The start date of the global report (yyyy-mm-dd) (optional)")
+			cmd.Flags().StringVar(&endDate, "end-date", time.Now().Format("2006-01-02"), "The end date of the global report (yyyy-mm-dd)")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			cmd := &cobra.Command{
+				Use:   "status",
+				Short: "Show what's in the store and when it was synced",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					s := openStoreOrFail()
+
+					groups, groupsSynced := s.Groups()
+					devices, devicesSynced := s.Devices()
+					monitorSynced, globalReportSynced, globalReportThrough := s.SyncTimes()
+					tasks := s.Tasks()
+					var monitored int
+					for _, task := range tasks {
+						if task.Monitored {
+							monitored++
+						}
+					}
+					fmt.Printf("Store: %s\n", s.Filename())
+					fmt.Printf("Server: %s\n", s.Server())
+					fmt.Printf("Groups: %d (synced: %s)\n", len(groups), formatSyncTime(groupsSynced))
+					fmt.Printf("Devices: %d (synced: %s)\n", len(devices), formatSyncTime(devicesSynced))
+					fmt.Printf("Tasks: %d (%d in the monitor)\n", len(tasks), monitored)
+					fmt.Printf("Monitor synced: %s\n", formatSyncTime(monitorSynced))
+					fmt.Printf("Global report synced: %s", formatSyncTime(globalReportSynced))
+					if globalReportThrough != "" {
+						fmt.Printf(" (through %s)", globalReportThrough)
+					}
+					fmt.Printf("\n")
+				},
+			}
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		groupCmd := &cobra.Command{
+			Use:   "history",
+			Short: "Task history commands (from the local store)",
+			Long:  "Task history commands.\n\nThese answer from the local store (see \"store sync\"), so they work without the server and cover the tasks that the server has since pruned.  A task's time is when it reached its current status: its finish time (if the server reported one), or else when the store first saw it in that status.\n\nThe tasks can be narrowed down by device, group, status, and time.  The statuses are \"failed\", \"finished\", \"no-files\", \"running\", \"deleted\", and status numbers (any of a comma-separated list).  The time is either a period (\"today\", \"yesterday\", \"this-<interval>\", \"last-<interval>\", \"<n>d\", or \"<n>w\", where the interval is day, week, month, quarter, or year) or start and end dates.",
+		}
+		rootCmd.AddCommand(groupCmd)
+
+		{
+			var deviceID string
+			var deviceName string
+			var group string
+			var status string
+			var period string
+			var startDate string
+			var endDate string
+			cmd := &cobra.Command{
+				Use:   "tasks",
+				Short: "List the tasks in the store",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					s := openStoreOrFail()
+
+					filter, err := historyTaskFilter(s, deviceID, deviceName, group, status, period, startDate, endDate, time.Now())
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+
+					var records []outputRecord
+					for _, task := range s.FindTasks(filter) {
+						records = append(records, historyTaskRecord(task))
+					}
+					err = writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(historyTaskRecord(store.Task{})), records)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (optional)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "The device name (optional)")
+			cmd.Flags().StringVar(&group, "group", "", "The group ID, path, or name (optional)")
+			cmd.Flags().StringVar(&status, "status", "", "The statuses, such as \"failed\" (optional)")
+			cmd.Flags().StringVar(&period, "period", "", "The period, such as \"last-quarter\" or \"30d\" (optional)")
+			cmd.Flags().StringVar(&startDate, "start-date", "", "The first day (yyyy-mm-dd) (optional)")
+			cmd.Flags().StringVar(&endDate, "end-date", "", "The last day (yyyy-mm-dd) (optional)")
+			groupCmd.AddCommand(cmd)
+		}
+
+		{
+			var deviceID string
+			var deviceName string
+			var group string
+			var status string
+			var period string
+			var startDate string
+			var endDate string
+			var by string
+			cmd := &cobra.Command{
+				Use:   "count",
+				Short: "Count the tasks in the store, by status",
+				Long:  "Count the tasks in the store, by status.\n\nWith --by, there is a row for every interval (even the empty ones); otherwise, there is a single row.\n\nFor example, to see how many downloads failed for the truck with the plate \"52\" last quarter:\n\n   angeltrax history count --device-name 52 --status failed --period last-quarter",
+				Args:  cobra.ExactArgs(0),
+				Run: func(cmd *cobra.Command, args []string) {
+					s := openStoreOrFail()
+
+					filter, err := historyTaskFilter(s, deviceID, deviceName, group, status, period, startDate, endDate, time.Now())
+					if err != nil {
+						logrus.Errorf("Error: %v", err)
+						os.Exit(1)
+					}
+					tasks := s.FindTasks(filter)
+
+					var buckets []store.Bucket
+					if by != "" {
+						interval, err := store.ParseInterval(by)
+						if err != nil {
+							logrus.Errorf("Error: %v", err)
+							os.Exit(1)
+						}
+						buckets = store.CountTasks(tasks, interval, filter.Since, filter.Until, time.Local)
+					} else {
+						bucket := store.Bucket{Start: filter.Since, End: filter.Until}
+						for _, task := range tasks {
+							bucket.Add(task.Status)
+						}
+						buckets = append(buckets, bucket)
+					}
+
+					var records []outputRecord
+					for _, bucket := range buckets {
+						records = append(records, historyBucketRecord(bucket))
+					}
+					err = writeOutput(os.Stdout, outputFormat, outputTemplate, outputColumns(historyBucketRecord(store.Bucket{})), records)
+					if err != nil {
+						logrus.Errorf("Error: [%T] %v", err, err)
+						os.Exit(1)
+					}
+				},
+			}
+			cmd.Flags().StringVar(&deviceID, "device-id", "", "The device ID (optional)")
+			cmd.Flags().StringVar(&deviceName, "device-name", "", "The device name (optional)")
+			cmd.Flags().StringVar(&group, "group", "", "The group ID, path, or name (optional)")
+			cmd.Flags().StringVar(&status, "status", "", "The statuses, such as \"failed\" (optional)")
+			cmd.Flags().StringVar(&period, "period", "", "The period, such as \"last-quarter\" or \"30d\" (optional)")
+			cmd.Flags().StringVar(&startDate, "start-date", "", "The first day (yyyy-mm-dd) (optional)")
+			cmd.Flags().StringVar(&endDate, "end-date", "", "The last day (yyyy-mm-dd) (optional)")
+			cmd.Flags().StringVar(&by, "by", "", "Count by this interval: day, week, month, quarter, or year (optional)")
+			groupCmd.AddCommand(cmd)
+		}
+	}
+
+	{
+		var refreshInterval time.Duration
+		var group string
+		cmd := &cobra.Command{
+			Use:   "dashboard",
+			Short: "Show the groups, devices, and tasks in a full-screen dashboard",
+			Long:  "Show the groups, devices, and tasks in a full-screen dashboard.\n\nThe group tree and the devices in the selected group are shown at the top, and the running (or failed) tasks of the selected device are shown at the bottom, with the progress of each channel.  Everything is reloaded at the refresh interval.\n\nKeys:\n   tab, shift+tab   Switch between the groups, the devices, and the tasks.\n   up, down         Move the selection.\n   c                Create a task for the selected device (you will be asked for the time window).\n   p                Pause (or resume) the selected task.\n   r                Retry the selected task.\n   f                Reload everything now.\n   q                Quit.",
+			Args:  cobra.ExactArgs(0),
+			Run: func(cmd *cobra.Command, args []string) {
+				if refreshInterval <= 0 {
+					logrus.Errorf("The refresh interval must be positive.")
+					os.Exit(1)
+				}
+
+				loginOrFail()
+
+				_, err := client.RegisterLogin(ctx)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+
+				t, err := openTerminal(os.Stdin, os.Stdout)
+				if err != nil {
+					logrus.Errorf("Error: %v", err)
+					os.Exit(1)
+				}
+
+				// Anything logged would draw over the dashboard.
+				logrus.SetOutput(io.Discard)
+				d := newDashboard(&client)
+				d.initialGroup = group
+				err = d.run(ctx, t, "angeltrax dashboard | "+client.Server, refreshInterval)
+				closeErr := t.Close()
+				logrus.SetOutput(os.Stderr)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+				if closeErr != nil {
+					logrus.Errorf("Could not restore the terminal: %v", closeErr)
+					os.Exit(1)
+				}
+			},
+		}
+		cmd.Flags().DurationVar(&refreshInterval, "refresh", 30*time.Second, "How often to reload everything")
+		cmd.Flags().StringVar(&group, "group", "", "The group to select at first (optional)")
+		rootCmd.AddCommand(cmd)
+	}
+
+	{
+		cmd := &cobra.Command{
+			Use:       "completion bash|zsh|fish|powershell",
+			Short:     "Generate the shell completion script",
+			Long:      "Generate the shell completion script.\n\nBash:\n   source <(angeltrax completion bash)\n\nZsh:\n   angeltrax completion zsh > \"${fpath[1]}/_angeltrax\"\n\nFish:\n   angeltrax completion fish > ~/.config/fish/completions/angeltrax.fish\n\nPowerShell:\n   angeltrax completion powershell | Out-String | Invoke-Expression\n\nDevices and groups are completed from a cache of the last listing (refreshed every hour), and task IDs from the last \"task monitor\" of every device (refreshed every 10 minutes).",
+			Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+			ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+			Run: func(cmd *cobra.Command, args []string) {
+				var err error
+				switch args[0] {
+				case "bash":
+					err = rootCmd.GenBashCompletionV2(os.Stdout, true)
+				case "zsh":
+					err = rootCmd.GenZshCompletion(os.Stdout)
+				case "fish":
+					err = rootCmd.GenFishCompletion(os.Stdout, true)
+				case "powershell":
+					err = rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+				}
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+			},
+		}
+		rootCmd.AddCommand(cmd)
+	}
+
+	{
+		var service string
+		var method string
+		cmd := &cobra.Command{
+			Use:   "raw-request [--service ${service}] [--method ${method}] ${path}",
+			Short: "Perform a raw request",
+			Args:  cobra.ExactArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				loginOrFail()
+
+				path := args[0]
+
+				var output json.RawMessage
+				err := client.RawServiceRequest(ctx, service, method, path, nil, nil, &output)
+				if err != nil {
+					logrus.Errorf("Error: [%T] %v", err, err)
+					os.Exit(1)
+				}
+				fmt.Printf("%s\n", output)
+			},
+		}
+		cmd.Flags().StringVar(&service, "service", "webclient", "The service")
+		cmd.Flags().StringVar(&method, "method", http.MethodGet, "The method")
+		rootCmd.AddCommand(cmd)
+	}
+
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	newCompleter(&client).register(&rootCmd)
 
 	err := rootCmd.Execute()
 	if err != nil {
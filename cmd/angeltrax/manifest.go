@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is a list of tasks to create.
+//
+// YAML manifests look like this:
+//
+//	tasks:
+//	  - device-name: "52"
+//	    start-date: 2023-01-02
+//	    start-time: "14:00:00"
+//	    end-time: "14:30:00"
+//	    channels: [1, 2]
+//
+// CSV manifests have a header row using the same names as the YAML keys.
+type Manifest struct {
+	Tasks []ManifestRow `yaml:"tasks"`
+}
+
+// ManifestRow describes the task (or tasks, if a group is given) for one device.
+type ManifestRow struct {
+	DeviceID      string      `yaml:"device-id"`   // The device ID.
+	DeviceName    string      `yaml:"device-name"` // The device name (the license plate).
+	Group         string      `yaml:"group"`       // A group ID, name, or path; every device in the group (and its subgroups) is used.
+	TaskName      string      `yaml:"task-name"`   // If empty, a name is generated.
+	StartDate     string      `yaml:"start-date"`  // yyyy-mm-dd
+	EndDate       string      `yaml:"end-date"`    // yyyy-mm-dd; if empty, this is the start date.
+	StartTime     string      `yaml:"start-time"`  // hh:mm:ss
+	EndTime       string      `yaml:"end-time"`    // hh:mm:ss
+	Channels      channelList `yaml:"channels"`    // If empty, all of the device's channels are used.
+	EffectiveDays int         `yaml:"effective-days"`
+	Period        string      `yaml:"period"`    // One of "once" (the default), "every-day", "every-week", "every-month", or "manual".
+	TaskType      string      `yaml:"task-type"` // One of "video" (the default), "black-box", or "black-box-video".
+}
+
+var manifestPeriods = map[string]angeltrax.TaskPeriod{
+	"":            angeltrax.TaskPeriodOnce,
+	"once":        angeltrax.TaskPeriodOnce,
+	"every-day":   angeltrax.TaskPeriodEveryDay,
+	"every-week":  angeltrax.TaskPeriodEveryWeek,
+	"every-month": angeltrax.TaskPeriodEveryMonth,
+	"manual":      angeltrax.TaskPeriodManual,
+}
+
+var manifestTaskTypes = map[string]angeltrax.TaskType{
+	"":                angeltrax.TaskTypeVideo,
+	"video":           angeltrax.TaskTypeVideo,
+	"black-box":       angeltrax.TaskTypeBlackBox,
+	"black-box-video": angeltrax.TaskTypeBlackBoxVideo,
+}
+
+// channelList is a list of one-indexed channels; in YAML, it may be a list or a comma-separated string.
+type channelList []int
+
+func (l *channelList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		channels, err := parseChannelList(value.Value)
+		if err != nil {
+			return err
+		}
+		*l = channels
+		return nil
+	}
+	var channels []int
+	err := value.Decode(&channels)
+	if err != nil {
+		return err
+	}
+	*l = channels
+	return nil
+}
+
+// ManifestTask is a single task to create, resolved from a manifest row.
+type ManifestTask struct {
+	Row        int // The one-indexed row in the manifest.
+	CarLicense string
+	Input      angeltrax.CreateAutoDownloadTaskInput
+}
+
+// Key uniquely identifies the device, window, and channels of the task.
+func (t ManifestTask) Key() string {
+	channels := append([]int{}, t.Input.TaskChannels...)
+	sort.Ints(channels)
+	var channelStrings []string
+	for _, channel := range channels {
+		channelStrings = append(channelStrings, strconv.Itoa(channel))
+	}
+	return strings.Join([]string{t.Input.DeviceID, t.Input.StartExecute, t.Input.EndExecute, t.Input.StartTime, t.Input.EndTime, strings.Join(channelStrings, ",")}, "|")
+}
+
+// ManifestResult is the outcome of a single manifest task.
+type ManifestResult struct {
+	Row        int    `json:"row"`
+	Key        string `json:"key"`
+	DeviceID   string `json:"deviceId"`
+	CarLicense string `json:"carLicense"`
+	TaskName   string `json:"taskName"`
+	Status     string `json:"status"` // One of the manifestStatus constants.
+	Error      string `json:"error,omitempty"`
+}
+
+const (
+	manifestStatusCreated = "created"
+	manifestStatusSkipped = "skipped"
+	manifestStatusFailed  = "failed"
+)
+
+// loadManifest loads a manifest from a YAML or CSV file; the format is determined by the file extension.
+func loadManifest(filename string) (*Manifest, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &manifest)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse YAML: %v", err)
+		}
+	case ".csv":
+		reader := csv.NewReader(strings.NewReader(string(contents)))
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse CSV: %v", err)
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("missing CSV header")
+		}
+		header := records[0]
+		for r, record := range records[1:] {
+			var row ManifestRow
+			for i, value := range record {
+				value = strings.TrimSpace(value)
+				switch strings.ToLower(strings.TrimSpace(header[i])) {
+				case "device-id":
+					row.DeviceID = value
+				case "device-name":
+					row.DeviceName = value
+				case "group":
+					row.Group = value
+				case "task-name":
+					row.TaskName = value
+				case "start-date":
+					row.StartDate = value
+				case "end-date":
+					row.EndDate = value
+				case "start-time":
+					row.StartTime = value
+				case "end-time":
+					row.EndTime = value
+				case "channels":
+					row.Channels, err = parseChannelList(value)
+					if err != nil {
+						return nil, fmt.Errorf("row %d: %v", r+1, err)
+					}
+				case "period":
+					row.Period = value
+				case "task-type":
+					row.TaskType = value
+				case "effective-days":
+					if value != "" {
+						row.EffectiveDays, err = strconv.Atoi(value)
+						if err != nil {
+							return nil, fmt.Errorf("row %d: invalid effective days %q: %v", r+1, value, err)
+						}
+					}
+				default:
+					return nil, fmt.Errorf("unknown CSV column: %q", header[i])
+				}
+			}
+			manifest.Tasks = append(manifest.Tasks, row)
+		}
+	default:
+		return nil, fmt.Errorf("unknown manifest format: %q", filepath.Ext(filename))
+	}
+	return &manifest, nil
+}
+
+// resolveManifest validates every row of the manifest against the devices and groups and returns the tasks to create.
+//
+// All of the problems are returned at once so that the manifest can be fixed in a single pass.
+func resolveManifest(manifest *Manifest, groups []angeltrax.CenterGroup, devices []angeltrax.CenterDevice) ([]ManifestTask, []error) {
+	var tasks []ManifestTask
+	var errs []error
+	for r, row := range manifest.Tasks {
+		rowNumber := r + 1
+		rowErrorf := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("row %d: %s", rowNumber, fmt.Sprintf(format, args...)))
+		}
+
+		if row.EndDate == "" {
+			row.EndDate = row.StartDate
+		}
+		if row.EffectiveDays == 0 {
+			row.EffectiveDays = 7
+		}
+
+		startDate, err := time.Parse("2006-01-02", row.StartDate)
+		if err != nil {
+			rowErrorf("invalid start date %q", row.StartDate)
+		}
+		endDate, err := time.Parse("2006-01-02", row.EndDate)
+		if err != nil {
+			rowErrorf("invalid end date %q", row.EndDate)
+		} else if endDate.Before(startDate) {
+			rowErrorf("end date %s is before start date %s", row.EndDate, row.StartDate)
+		}
+		startTime, err := time.Parse("15:04:05", row.StartTime)
+		if err != nil {
+			rowErrorf("invalid start time %q", row.StartTime)
+		}
+		endTime, err := time.Parse("15:04:05", row.EndTime)
+		if err != nil {
+			rowErrorf("invalid end time %q", row.EndTime)
+		} else if !endTime.After(startTime) {
+			rowErrorf("end time %s is not after start time %s", row.EndTime, row.StartTime)
+		}
+
+		period, ok := manifestPeriods[row.Period]
+		if !ok {
+			rowErrorf("invalid period %q", row.Period)
+		}
+		taskType, ok := manifestTaskTypes[row.TaskType]
+		if !ok {
+			rowErrorf("invalid task type %q", row.TaskType)
+		}
+
+		var selected int
+		for _, value := range []string{row.DeviceID, row.DeviceName, row.Group} {
+			if value != "" {
+				selected++
+			}
+		}
+		if selected != 1 {
+			rowErrorf("exactly one of device-id, device-name, or group is required")
+			continue
+		}
+
+		var rowDevices []angeltrax.CenterDevice
+		switch {
+		case row.DeviceID != "":
+			rowDevices = filterDevices(devices, row.DeviceID, "")
+		case row.DeviceName != "":
+			rowDevices = filterDevices(devices, "", row.DeviceName)
+		case row.Group != "":
+			group := findGroup(groups, row.Group)
+			if group == nil {
+				rowErrorf("unknown group %q", row.Group)
+				continue
+			}
+			rowDevices = devicesInGroup(groups, devices, group.GroupID)
+		}
+		if len(rowDevices) == 0 {
+			rowErrorf("no devices found")
+			continue
+		}
+
+		for _, device := range rowDevices {
+			channels := []int(row.Channels)
+			if len(channels) == 0 {
+				for i := 0; i < device.ChannelCount; i++ {
+					channels = append(channels, i+1)
+				}
+			}
+			for _, channel := range channels {
+				if channel > device.ChannelCount {
+					rowErrorf("device %s (%s) has no channel %d", device.DeviceID, device.CarLicense, channel)
+				}
+			}
+
+			taskName := row.TaskName
+			if taskName == "" {
+				taskName = fmt.Sprintf("%s %s %s-%s", device.CarLicense, row.StartDate, row.StartTime, row.EndTime)
+			}
+			tasks = append(tasks, ManifestTask{
+				Row:        rowNumber,
+				CarLicense: device.CarLicense,
+				Input: angeltrax.CreateAutoDownloadTaskInput{
+					TaskName:      taskName,
+					DeviceID:      device.DeviceID,
+					StartExecute:  row.StartDate,
+					EndExecute:    row.EndDate,
+					StartTime:     row.StartTime,
+					EndTime:       row.EndTime,
+					EffectiveDays: row.EffectiveDays,
+					TaskChannels:  channels,
+					TaskType:      taskType,
+					Period:        period,
+				},
+			})
+		}
+	}
+	return tasks, errs
+}
+
+// loadManifestReport loads the results of a previous run; a missing file is not an error.
+func loadManifestReport(filename string) ([]ManifestResult, error) {
+	contents, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var results []ManifestResult
+	err = json.Unmarshal(contents, &results)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse report %q: %v", filename, err)
+	}
+	return results, nil
+}
+
+// writeManifestReport writes the results as a JSON array.
+func writeManifestReport(filename string, results []ManifestResult) error {
+	contents, err := json.MarshalIndent(results, "", "   ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, contents, 0644)
+}
+
+// printManifestResults prints a human-readable summary of the results.
+func printManifestResults(w io.Writer, results []ManifestResult) {
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+		if result.Error != "" {
+			fmt.Fprintf(w, "Row %d: %s (%s): %s: %s\n", result.Row, result.DeviceID, result.CarLicense, result.Status, result.Error)
+		} else {
+			fmt.Fprintf(w, "Row %d: %s (%s): %s\n", result.Row, result.DeviceID, result.CarLicense, result.Status)
+		}
+	}
+	fmt.Fprintf(w, "Created: %d | Skipped: %d | Failed: %d\n", counts[manifestStatusCreated], counts[manifestStatusSkipped], counts[manifestStatusFailed])
+}
+
+// createManifestTasks creates the tasks, at most `concurrency` at a time.
+//
+// Tasks that already succeeded in a previous run (as given by `previous`) are carried over, and tasks that
+// match an existing task for the same device and window are skipped.  Every time a result comes in,
+// `progress` is called with a snapshot of all of the results so far.
+func createManifestTasks(ctx context.Context, client *angeltrax.Client, tasks []ManifestTask, concurrency int, previous []ManifestResult, progress func([]ManifestResult)) ([]ManifestResult, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	previousMap := map[string]ManifestResult{}
+	for _, result := range previous {
+		previousMap[result.Key] = result
+	}
+
+	// Find the existing tasks for every device so that we don't create duplicates.
+	existingMap := map[string]bool{}
+	seenDevices := map[string]bool{}
+	for _, task := range tasks {
+		if seenDevices[task.Input.DeviceID] {
+			continue
+		}
+		seenDevices[task.Input.DeviceID] = true
+
+		output, err := client.MonitorAutoDownload(ctx, angeltrax.MonitorAutoDownloadInput{DeviceID: task.Input.DeviceID})
+		if err != nil {
+			return nil, fmt.Errorf("could not get the tasks for device %s: %w", task.Input.DeviceID, err)
+		}
+		for _, row := range output.Rows {
+			existingMap[strings.Join([]string{row.DeviceID, row.Date, row.StartTime, row.EndTime}, "|")] = true
+		}
+	}
+
+	results := make([]ManifestResult, len(tasks))
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for i, task := range tasks {
+		result := ManifestResult{
+			Row:        task.Row,
+			Key:        task.Key(),
+			DeviceID:   task.Input.DeviceID,
+			CarLicense: task.CarLicense,
+			TaskName:   task.Input.TaskName,
+		}
+
+		if previousResult, ok := previousMap[result.Key]; ok && previousResult.Status != manifestStatusFailed {
+			result.Status = previousResult.Status
+			results[i] = result
+			continue
+		}
+		if existingMap[strings.Join([]string{task.Input.DeviceID, task.Input.StartExecute, task.Input.StartTime, task.Input.EndTime}, "|")] {
+			result.Status = manifestStatusSkipped
+			result.Error = "a task already exists for this device and window"
+			results[i] = result
+			continue
+		}
+
+		waitGroup.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, task ManifestTask, result ManifestResult) {
+			defer waitGroup.Done()
+			defer func() { <-semaphore }()
+
+			output, err := client.CreateAutoDownloadTask(ctx, task.Input)
+			if err != nil {
+				result.Status = manifestStatusFailed
+				result.Error = err.Error()
+			} else if !output.Result {
+				result.Status = manifestStatusFailed
+				result.Error = "the server did not accept the task"
+			} else {
+				result.Status = manifestStatusCreated
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[i] = result
+			if progress != nil {
+				progress(append([]ManifestResult{}, results...))
+			}
+		}(i, task, result)
+	}
+	waitGroup.Wait()
+
+	return results, nil
+}
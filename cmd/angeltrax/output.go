@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormats are the values for the global --output flag.
+var outputFormats = []string{"table", "json", "ndjson", "csv", "yaml", "template"}
+
+// outputField is a single named value in an output record.
+type outputField struct {
+	Name  string
+	Value interface{}
+}
+
+// outputRecord is a single row of output.
+//
+// The fields are kept in order (rather than in a map) so that every format lists them the same way every time;
+// every record of a command should have the same fields in the same order.
+type outputRecord []outputField
+
+// MarshalJSON encodes the record as a JSON object with the fields in order.
+func (r outputRecord) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("{")
+	for i, field := range r {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		name, err := json.Marshal(field.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode field %q: %w", field.Name, err)
+		}
+		buffer.Write(name)
+		buffer.WriteString(":")
+		buffer.Write(value)
+	}
+	buffer.WriteString("}")
+	return buffer.Bytes(), nil
+}
+
+// MarshalYAML encodes the record as a YAML mapping with the fields in order.
+func (r outputRecord) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, field := range r {
+		var value yaml.Node
+		err := value.Encode(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode field %q: %w", field.Name, err)
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: field.Name}, &value)
+	}
+	return node, nil
+}
+
+// fieldMap returns the record as a map, for templates.
+func (r outputRecord) fieldMap() map[string]interface{} {
+	m := map[string]interface{}{}
+	for _, field := range r {
+		m[field.Name] = field.Value
+	}
+	return m
+}
+
+// formatOutputValue formats a value for the table and CSV formats.
+func formatOutputValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []int:
+		var parts []string
+		for _, i := range v {
+			parts = append(parts, fmt.Sprintf("%d", i))
+		}
+		return strings.Join(parts, ",")
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// parseOutputFormat checks the --output flag (and the --template flag that goes with it).
+func parseOutputFormat(format string, templateText string) error {
+	for _, f := range outputFormats {
+		if format == f {
+			if format == "template" && templateText == "" {
+				return fmt.Errorf("the template format needs --template")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid output format %q: expected one of: %s", format, strings.Join(outputFormats, ", "))
+}
+
+// writeOutput writes the records in the given format.
+//
+// The columns are needed so that the table and CSV formats still have a header when there are no records.
+func writeOutput(w io.Writer, format string, templateText string, columns []string, records []outputRecord) error {
+	switch format {
+	case "", "table":
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		var headers []string
+		for _, column := range columns {
+			headers = append(headers, strings.ToUpper(column))
+		}
+		fmt.Fprintln(writer, strings.Join(headers, "\t"))
+		for _, record := range records {
+			var values []string
+			for _, field := range record {
+				values = append(values, formatOutputValue(field.Value))
+			}
+			fmt.Fprintln(writer, strings.Join(values, "\t"))
+		}
+		return writer.Flush()
+	case "json":
+		if records == nil {
+			records = []outputRecord{}
+		}
+		contents, err := json.MarshalIndent(records, "", "   ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", contents)
+		return err
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			err := encoder.Encode(record)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		writer := csv.NewWriter(w)
+		err := writer.Write(columns)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			var values []string
+			for _, field := range record {
+				values = append(values, formatOutputValue(field.Value))
+			}
+			err := writer.Write(values)
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "yaml":
+		if records == nil {
+			records = []outputRecord{}
+		}
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(3)
+		err := encoder.Encode(records)
+		if err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "template":
+		t, err := template.New("output").Option("missingkey=error").Parse(templateText)
+		if err != nil {
+			return fmt.Errorf("could not parse the template: %w", err)
+		}
+		for _, record := range records {
+			err := t.Execute(w, record.fieldMap())
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(w)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q", format)
+}
+
+// outputColumns returns the field names of a record.
+func outputColumns(record outputRecord) []string {
+	var columns []string
+	for _, field := range record {
+		columns = append(columns, field.Name)
+	}
+	return columns
+}
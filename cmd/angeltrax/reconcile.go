@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// currentAutoDownloadTasks returns the details of every task on the given devices that has not been deleted.
+func currentAutoDownloadTasks(ctx context.Context, client *angeltrax.Client, deviceIDs []string) ([]angeltrax.MonitorAutoDownloadTaskResponse, error) {
+	var tasks []angeltrax.MonitorAutoDownloadTaskResponse
+	for _, deviceID := range deviceIDs {
+		output, err := client.MonitorAutoDownload(ctx, angeltrax.MonitorAutoDownloadInput{DeviceID: deviceID})
+		if err != nil {
+			return nil, fmt.Errorf("could not get the tasks for device %s: %w", deviceID, err)
+		}
+		for _, row := range output.Rows {
+			if row.Status == angeltrax.TaskStatusDelete {
+				continue
+			}
+			task, err := client.MonitorAutoDownloadTask(ctx, fmt.Sprintf("%d", row.TaskID))
+			if err != nil {
+				return nil, fmt.Errorf("could not get task %d: %w", row.TaskID, err)
+			}
+			tasks = append(tasks, *task)
+		}
+	}
+	return tasks, nil
+}
+
+// checkTaskIdentities checks that every desired task can be matched up with the task on the server.
+//
+// Tasks are matched up by device and task name, so every row needs an explicit task name; a generated name
+// includes the window, so changing the window would turn into a create and a delete instead of an update.
+func checkTaskIdentities(manifest *Manifest, tasks []ManifestTask) []error {
+	var errs []error
+	for i, row := range manifest.Tasks {
+		if row.TaskName == "" {
+			errs = append(errs, fmt.Errorf("row %d: a task name is required", i+1))
+		}
+	}
+
+	rows := map[string]int{}
+	for _, task := range tasks {
+		key := task.Input.DeviceID + "|" + task.Input.TaskName
+		if row, ok := rows[key]; ok && task.Input.TaskName != "" {
+			errs = append(errs, fmt.Errorf("row %d: task name %q is already used by row %d for device %s (%s)", task.Row, task.Input.TaskName, row, task.Input.DeviceID, task.CarLicense))
+			continue
+		}
+		rows[key] = task.Row
+	}
+	return errs
+}
+
+// printTaskPlan prints the changes in a "plan" format; deletes are marked as skipped unless pruning.
+func printTaskPlan(w io.Writer, changes []angeltrax.AutoDownloadTaskChange, carLicenses map[string]string, prune bool) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changeDeviceID(changes[i]) < changeDeviceID(changes[j])
+	})
+
+	var creates, updates, deletes int
+	for _, change := range changes {
+		deviceID := changeDeviceID(change)
+		switch change.Action {
+		case angeltrax.AutoDownloadTaskChangeCreate:
+			creates++
+			fmt.Fprintf(w, "+ create %s (%s): %q %s - %s, %s - %s, channels %v\n", deviceID, carLicenses[deviceID], change.Desired.TaskName, change.Desired.StartExecute, change.Desired.EndExecute, change.Desired.StartTime, change.Desired.EndTime, change.Desired.TaskChannels)
+		case angeltrax.AutoDownloadTaskChangeUpdate:
+			updates++
+			fmt.Fprintf(w, "~ update %s (%s): task #%d %q\n", deviceID, carLicenses[deviceID], change.Current.TaskID, change.Current.TaskName)
+			for _, difference := range change.Differences {
+				fmt.Fprintf(w, "     %s\n", difference)
+			}
+		case angeltrax.AutoDownloadTaskChangeDelete:
+			if prune {
+				deletes++
+				fmt.Fprintf(w, "- delete %s (%s): task #%d %q\n", deviceID, carLicenses[deviceID], change.Current.TaskID, change.Current.TaskName)
+			} else {
+				fmt.Fprintf(w, "  (undeclared) %s (%s): task #%d %q; use --prune to delete it\n", deviceID, carLicenses[deviceID], change.Current.TaskID, change.Current.TaskName)
+			}
+		}
+	}
+	fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to delete.\n", creates, updates, deletes)
+}
+
+func changeDeviceID(change angeltrax.AutoDownloadTaskChange) string {
+	if change.Desired != nil {
+		return change.Desired.DeviceID
+	}
+	return change.Current.DeviceID
+}
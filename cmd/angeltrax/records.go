@@ -0,0 +1,135 @@
+package main
+
+import (
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/store"
+)
+
+// These build the output records for the commands that support --output.  The field names are part of the
+// interface (people feed them to jq and spreadsheets), so don't rename or reorder them; only add new ones at the end.
+
+func centerGroupRecord(groupPath string, group angeltrax.CenterGroup, device *angeltrax.CenterDevice) outputRecord {
+	record := outputRecord{
+		{"group_id", group.GroupID},
+		{"group", groupPath},
+		{"device_id", nil},
+		{"plate", nil},
+		{"channels", nil},
+	}
+	if device != nil {
+		record[2].Value = device.DeviceID
+		record[3].Value = device.CarLicense
+		record[4].Value = device.ChannelCount
+	}
+	return record
+}
+
+func monitorTaskRecord(task angeltrax.MonitorAutoDownloadRow) outputRecord {
+	return outputRecord{
+		{"task_id", task.TaskID},
+		{"device_id", task.DeviceID},
+		{"plate", task.CarLicense},
+		{"task_name", task.TaskName},
+		{"status", int(task.Status)},
+		{"status_name", task.Status.String()},
+		{"date", task.Date},
+		{"start_time", task.StartTime},
+		{"end_time", task.EndTime},
+		{"channels", task.ChannelList},
+		{"created", task.CreateTime},
+	}
+}
+
+func monitorTaskDetailRecord(task angeltrax.MonitorAutoDownloadTaskResponse) outputRecord {
+	return outputRecord{
+		{"task_id", task.TaskID},
+		{"device_id", task.DeviceID},
+		{"plate", task.CarLicense},
+		{"task_name", task.TaskName},
+		{"start_execute", task.StartExecute},
+		{"end_execute", task.EndExecute},
+		{"start_time", task.StartTime},
+		{"end_time", task.EndTime},
+		{"channels", task.TaskChannel},
+	}
+}
+
+func globalReportRecord(task angeltrax.GlobalReportAutoDownloadRow) outputRecord {
+	return outputRecord{
+		{"task_id", task.TaskID},
+		{"device_id", task.DeviceID},
+		{"plate", task.CarLicense},
+		{"task_name", task.TaskName},
+		{"status", int(task.Status)},
+		{"status_name", task.Status.String()},
+		{"date", task.Date},
+		{"start_time", task.StartTime},
+		{"end_time", task.EndTime},
+		{"channels", task.ChannelList},
+		{"created", task.CreateTime},
+		{"finished", task.FinishTime},
+		{"user", task.Username},
+	}
+}
+
+func globalReportTaskRecord(row angeltrax.GlobalReportAutoDownloadTaskRow) outputRecord {
+	return outputRecord{
+		{"task_id", row.TaskID},
+		{"device_id", row.DeviceID},
+		{"channel", row.Channel},
+		{"file", row.FileSource},
+		{"status", int(row.Status)},
+		{"status_name", row.Status.String()},
+		{"date", row.Date},
+		{"start_time", row.StartTime},
+		{"end_time", row.EndTime},
+		{"percent", row.PercentValue()},
+		{"current_size", row.CurrentSizeValue()},
+		{"total_size", row.TotalSizeValue()},
+		{"speed", row.SpeedValue()},
+		{"error", row.Error},
+	}
+}
+
+func historyTaskRecord(task store.Task) outputRecord {
+	return outputRecord{
+		{"task_id", task.TaskID},
+		{"device_id", task.DeviceID},
+		{"plate", task.CarLicense},
+		{"task_name", task.TaskName},
+		{"status", int(task.Status)},
+		{"status_name", task.Status.String()},
+		{"time", task.Time(time.Local).Format(angeltrax.RecordingTimeFormat)},
+		{"date", task.Date},
+		{"start_time", task.StartTime},
+		{"end_time", task.EndTime},
+		{"channels", task.ChannelList},
+		{"created", task.CreateTime},
+		{"finished", task.FinishTime},
+		{"first_seen", task.FirstSeen.Local().Format(angeltrax.RecordingTimeFormat)},
+		{"last_seen", task.LastSeen.Local().Format(angeltrax.RecordingTimeFormat)},
+		{"statuses", len(task.History)},
+	}
+}
+
+func historyBucketRecord(bucket store.Bucket) outputRecord {
+	record := outputRecord{
+		{"start", nil},
+		{"end", nil},
+		{"total", bucket.Total},
+		{"finished", bucket.Finished},
+		{"no_files", bucket.NoFiles},
+		{"failed", bucket.Failed},
+		{"running", bucket.Running},
+		{"other", bucket.Other},
+	}
+	if !bucket.Start.IsZero() {
+		record[0].Value = bucket.Start.Format(angeltrax.RecordingTimeFormat)
+	}
+	if !bucket.End.IsZero() {
+		record[1].Value = bucket.End.Format(angeltrax.RecordingTimeFormat)
+	}
+	return record
+}
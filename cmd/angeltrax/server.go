@@ -0,0 +1,12 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// licenseDays returns the number of whole days from now until the license expires; this is negative if it has
+// already expired.
+func licenseDays(expiry time.Time, now time.Time) int {
+	return int(math.Floor(expiry.Sub(now).Hours() / 24))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// snapshotFilename returns the filename for a snapshot: <directory>/<plate>/<plate>-<camera>-<timestamp>.jpg.
+//
+// The camera is the channel's name from the device, or "channel<n>" if it doesn't have one.
+func snapshotFilename(directory string, device angeltrax.CenterDevice, channel int, captureTime time.Time) string {
+	replacer := strings.NewReplacer("/", "_", "\\", "_", ":", "_", " ", "_")
+	carLicense := device.CarLicense
+	if carLicense == "" {
+		carLicense = device.DeviceID
+	}
+	carLicense = replacer.Replace(carLicense)
+	camera := replacer.Replace(device.CameraName(channel))
+	if camera == "" {
+		camera = fmt.Sprintf("channel%d", channel)
+	}
+	return filepath.Join(directory, carLicense, fmt.Sprintf("%s-%s-%s.jpg", carLicense, camera, captureTime.Format("20060102-150405")))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"unicode/utf8"
+)
+
+// These are the ANSI escape sequences used by the dashboard.
+const (
+	ansiReset           = "\x1b[0m"
+	ansiBold            = "\x1b[1m"
+	ansiReverse         = "\x1b[7m"
+	ansiRed             = "\x1b[31m"
+	ansiHome            = "\x1b[H"
+	ansiClearLine       = "\x1b[K"
+	ansiClearScreen     = "\x1b[2J"
+	ansiHideCursor      = "\x1b[?25l"
+	ansiShowCursor      = "\x1b[?25h"
+	ansiAlternateScreen = "\x1b[?1049h"
+	ansiMainScreen      = "\x1b[?1049l"
+)
+
+// terminal is a full-screen terminal session; Close puts the terminal back the way it was.
+type terminal struct {
+	input   *os.File
+	output  *os.File
+	restore func() error
+}
+
+// openTerminal puts the terminal into raw mode and switches to the alternate screen.
+func openTerminal(input *os.File, output *os.File) (*terminal, error) {
+	restore, err := makeRaw(input, output)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up the terminal: %w", err)
+	}
+	t := &terminal{
+		input:   input,
+		output:  output,
+		restore: restore,
+	}
+	_, err = fmt.Fprint(output, ansiAlternateScreen+ansiHideCursor+ansiClearScreen)
+	if err != nil {
+		_ = restore()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Close switches back to the main screen and restores the terminal's mode.
+func (t *terminal) Close() error {
+	_, _ = fmt.Fprint(t.output, ansiReset+ansiShowCursor+ansiMainScreen)
+	return t.restore()
+}
+
+// Size returns the width and height of the terminal; if that can't be determined, 80x24 is assumed.
+func (t *terminal) Size() (int, int) {
+	width, height, err := terminalSize(t.output)
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// key is a single key press.  Special keys have a name; everything else is the rune that was typed.
+type key struct {
+	name string
+	r    rune
+}
+
+// escapeKeys are the escape sequences of the special keys (without the leading escape).
+var escapeKeys = map[string]string{
+	"[A":  "up",
+	"[B":  "down",
+	"[C":  "right",
+	"[D":  "left",
+	"OA":  "up",
+	"OB":  "down",
+	"OC":  "right",
+	"OD":  "left",
+	"[H":  "home",
+	"[F":  "end",
+	"OH":  "home",
+	"OF":  "end",
+	"[1~": "home",
+	"[4~": "end",
+	"[5~": "pgup",
+	"[6~": "pgdn",
+	"[Z":  "backtab",
+}
+
+// parseKeys splits what was read from the terminal into key presses.
+//
+// Unrecognized escape sequences are dropped; an escape on its own is the escape key.
+func parseKeys(data []byte) []key {
+	var keys []key
+	for len(data) > 0 {
+		switch data[0] {
+		case 0x1b:
+			if len(data) == 1 || (data[1] != '[' && data[1] != 'O') {
+				keys = append(keys, key{name: "escape"})
+				data = data[1:]
+				continue
+			}
+			// The sequence ends with the first letter (or "~").
+			end := 2
+			for end < len(data) && !(data[end] >= 'A' && data[end] <= 'Z' || data[end] >= 'a' && data[end] <= 'z' || data[end] == '~') {
+				end++
+			}
+			if end == len(data) {
+				data = nil
+				continue
+			}
+			if name, ok := escapeKeys[string(data[1:end+1])]; ok {
+				keys = append(keys, key{name: name})
+			}
+			data = data[end+1:]
+			continue
+		case '\r', '\n':
+			keys = append(keys, key{name: "enter"})
+		case '\t':
+			keys = append(keys, key{name: "tab"})
+		case 0x7f, 0x08:
+			keys = append(keys, key{name: "backspace"})
+		case 0x03:
+			keys = append(keys, key{name: "ctrl-c"})
+		default:
+			r, size := utf8.DecodeRune(data)
+			if r >= ' ' {
+				keys = append(keys, key{r: r})
+			}
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return keys
+}
+
+// readKeys reads key presses from the terminal until it fails.
+func (t *terminal) readKeys(keys chan<- key) {
+	buffer := make([]byte, 256)
+	for {
+		n, err := t.input.Read(buffer)
+		if err != nil {
+			return
+		}
+		for _, k := range parseKeys(buffer[:n]) {
+			keys <- k
+		}
+	}
+}
+
+// fitText truncates or pads the text to exactly the given number of runes.
+func fitText(text string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	count := utf8.RuneCountInString(text)
+	if count > width {
+		runes := []rune(text)
+		if width == 1 {
+			return string(runes[:1])
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	for ; count < width; count++ {
+		text += " "
+	}
+	return text
+}
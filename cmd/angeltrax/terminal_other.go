@@ -0,0 +1,17 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func makeRaw(input *os.File, output *os.File) (func() error, error) {
+	return nil, fmt.Errorf("terminal raw mode is not supported on %s", runtime.GOOS)
+}
+
+func terminalSize(output *os.File) (int, int, error) {
+	return 0, 0, fmt.Errorf("terminal size is not supported on %s", runtime.GOOS)
+}
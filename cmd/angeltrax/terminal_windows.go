@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// makeRaw puts the console into raw mode: keys are read as they're pressed, without being echoed, and both the input
+// and the output use the same escape sequences as a Unix terminal.
+func makeRaw(input *os.File, output *os.File) (func() error, error) {
+	inputHandle := windows.Handle(input.Fd())
+	outputHandle := windows.Handle(output.Fd())
+
+	var originalInputMode uint32
+	err := windows.GetConsoleMode(inputHandle, &originalInputMode)
+	if err != nil {
+		return nil, err
+	}
+	var originalOutputMode uint32
+	err = windows.GetConsoleMode(outputHandle, &originalOutputMode)
+	if err != nil {
+		return nil, err
+	}
+
+	inputMode := originalInputMode
+	inputMode &^= windows.ENABLE_ECHO_INPUT | windows.ENABLE_PROCESSED_INPUT | windows.ENABLE_LINE_INPUT
+	inputMode |= windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	err = windows.SetConsoleMode(inputHandle, inputMode)
+	if err != nil {
+		return nil, err
+	}
+	err = windows.SetConsoleMode(outputHandle, originalOutputMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	if err != nil {
+		_ = windows.SetConsoleMode(inputHandle, originalInputMode)
+		return nil, err
+	}
+
+	return func() error {
+		err := windows.SetConsoleMode(inputHandle, originalInputMode)
+		if err != nil {
+			return err
+		}
+		return windows.SetConsoleMode(outputHandle, originalOutputMode)
+	}, nil
+}
+
+// terminalSize returns the width and height of the console window.
+func terminalSize(output *os.File) (int, int, error) {
+	var info windows.ConsoleScreenBufferInfo
+	err := windows.GetConsoleScreenBufferInfo(windows.Handle(output.Fd()), &info)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// trackFormats are the formats that "track export" can write.
+var trackFormats = []string{"gpx", "kml", "geojson"}
+
+// trackFormat returns the format to use, given the --format flag and the output filename.
+func trackFormat(format string, filename string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".gpx":
+			format = "gpx"
+		case ".kml":
+			format = "kml"
+		case ".geojson", ".json":
+			format = "geojson"
+		default:
+			return "", fmt.Errorf("could not tell the format from the filename %q; use --format", filename)
+		}
+	}
+	format = strings.ToLower(format)
+	for _, f := range trackFormats {
+		if f == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("invalid format %q; expected one of: %s", format, strings.Join(trackFormats, ", "))
+}
+
+// writeTrack writes the track in the given format.
+func writeTrack(w io.Writer, format string, name string, points []angeltrax.TrackPoint) error {
+	switch format {
+	case "gpx":
+		return writeTrackGPX(w, name, points)
+	case "kml":
+		return writeTrackKML(w, name, points)
+	case "geojson":
+		return writeTrackGeoJSON(w, name, points)
+	}
+	return fmt.Errorf("invalid format %q", format)
+}
+
+type gpxDocument struct {
+	XMLName xml.Name `xml:"gpx"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string          `xml:"name"`
+	Segment gpxTrackSegment `xml:"trkseg"`
+}
+
+type gpxTrackSegment struct {
+	Points []gpxTrackPoint `xml:"trkpt"`
+}
+
+type gpxTrackPoint struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+	Elevation float64 `xml:"ele"`
+	Time      string  `xml:"time"`
+}
+
+func writeTrackGPX(w io.Writer, name string, points []angeltrax.TrackPoint) error {
+	document := gpxDocument{
+		XMLNS:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "angeltrax",
+		Track:   gpxTrack{Name: name},
+	}
+	for _, point := range points {
+		document.Track.Segment.Points = append(document.Track.Segment.Points, gpxTrackPoint{
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+			Elevation: point.Altitude,
+			Time:      point.Time.UTC().Format(time.RFC3339),
+		})
+	}
+	return writeXML(w, document)
+}
+
+type kmlDocument struct {
+	XMLName  xml.Name     `xml:"kml"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Document kmlContainer `xml:"Document"`
+}
+
+type kmlContainer struct {
+	Name      string       `xml:"name"`
+	Placemark kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name       string        `xml:"name"`
+	TimeSpan   *kmlTimeSpan  `xml:"TimeSpan,omitempty"`
+	LineString kmlLineString `xml:"LineString"`
+}
+
+type kmlTimeSpan struct {
+	Begin string `xml:"begin"`
+	End   string `xml:"end"`
+}
+
+type kmlLineString struct {
+	Tessellate  int    `xml:"tessellate"`
+	Coordinates string `xml:"coordinates"`
+}
+
+func writeTrackKML(w io.Writer, name string, points []angeltrax.TrackPoint) error {
+	var coordinates []string
+	for _, point := range points {
+		coordinates = append(coordinates, fmt.Sprintf("%f,%f,%f", point.Longitude, point.Latitude, point.Altitude))
+	}
+	document := kmlDocument{
+		XMLNS: "http://www.opengis.net/kml/2.2",
+		Document: kmlContainer{
+			Name: name,
+			Placemark: kmlPlacemark{
+				Name: name,
+				LineString: kmlLineString{
+					Tessellate:  1,
+					Coordinates: strings.Join(coordinates, " "),
+				},
+			},
+		},
+	}
+	if len(points) > 0 {
+		document.Document.Placemark.TimeSpan = &kmlTimeSpan{
+			Begin: points[0].Time.UTC().Format(time.RFC3339),
+			End:   points[len(points)-1].Time.UTC().Format(time.RFC3339),
+		}
+	}
+	return writeXML(w, document)
+}
+
+func writeXML(w io.Writer, document interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "   ")
+	err = encoder.Encode(document)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// writeTrackGeoJSON writes the track as a single LineString feature; the per-point values are in the properties,
+// in the same order as the coordinates (the "coordTimes" convention).
+func writeTrackGeoJSON(w io.Writer, name string, points []angeltrax.TrackPoint) error {
+	coordinates := [][]float64{}
+	times := []string{}
+	speeds := []float64{}
+	headings := []float64{}
+	statuses := []angeltrax.TrackStatus{}
+	for _, point := range points {
+		coordinates = append(coordinates, []float64{point.Longitude, point.Latitude, point.Altitude})
+		times = append(times, point.Time.UTC().Format(time.RFC3339))
+		speeds = append(speeds, point.Speed)
+		headings = append(headings, point.Heading)
+		statuses = append(statuses, point.Status)
+	}
+	document := map[string]interface{}{
+		"type": "FeatureCollection",
+		"features": []interface{}{
+			map[string]interface{}{
+				"type": "Feature",
+				"geometry": map[string]interface{}{
+					"type":        "LineString",
+					"coordinates": coordinates,
+				},
+				"properties": map[string]interface{}{
+					"name":       name,
+					"coordTimes": times,
+					"speeds":     speeds,
+					"headings":   headings,
+					"statuses":   statuses,
+				},
+			},
+		},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "   ")
+	return encoder.Encode(document)
+}
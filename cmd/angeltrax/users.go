@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// findUser finds a user by their ID or their user name.
+func findUser(users []angeltrax.User, value string) *angeltrax.User {
+	for i := range users {
+		if fmt.Sprintf("%d", users[i].UserID) == value {
+			return &users[i]
+		}
+	}
+	for i := range users {
+		if users[i].UserName == value {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// formatUser formats a user for "users list".
+func formatUser(user angeltrax.User, groups []angeltrax.CenterGroup) string {
+	state := "enabled"
+	if !user.IsEnabled() {
+		state = "disabled"
+	}
+	var access []string
+	if user.AllDevices == 1 {
+		access = append(access, "all devices")
+	}
+	for _, groupID := range user.GroupIDs {
+		name := fmt.Sprintf("#%d", groupID)
+		for _, group := range groups {
+			if group.GroupID == groupID {
+				name = groupPath(groups, group)
+				break
+			}
+		}
+		access = append(access, "group "+name)
+	}
+	for _, deviceID := range user.DeviceIDs {
+		access = append(access, "device "+deviceID)
+	}
+	if len(access) == 0 {
+		access = []string{"none"}
+	}
+	lastLogin := user.LastLoginTime
+	if lastLogin == "" {
+		lastLogin = "never"
+	}
+	return fmt.Sprintf("User #%d: %s (%s) | role: %s | %s | last login: %s | access: %s", user.UserID, user.UserName, user.RealName, user.RoleName, state, lastLogin, strings.Join(access, ", "))
+}
+
+// userAccessRow is a single user that can see a single device.
+type userAccessRow struct {
+	User   angeltrax.User
+	Device angeltrax.CenterDevice
+}
+
+// userAccessRows returns every user and device that the user can see, sorted by user name and then device ID.
+func userAccessRows(users []angeltrax.User, groups []angeltrax.CenterGroup, devices []angeltrax.CenterDevice) []userAccessRow {
+	var rows []userAccessRow
+	for _, user := range users {
+		for _, device := range user.VisibleDevices(groups, devices) {
+			rows = append(rows, userAccessRow{User: user, Device: device})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].User.UserName != rows[j].User.UserName {
+			return rows[i].User.UserName < rows[j].User.UserName
+		}
+		return rows[i].Device.DeviceID < rows[j].Device.DeviceID
+	})
+	return rows
+}
+
+// writeUserAccessCSV writes the access rows as CSV, with a header row.
+func writeUserAccessCSV(w io.Writer, rows []userAccessRow, groups []angeltrax.CenterGroup) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"user_id", "user_name", "real_name", "role", "enabled", "device_id", "plate", "group"})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		var groupName string
+		for _, group := range groups {
+			if group.GroupID == row.Device.GroupID {
+				groupName = groupPath(groups, group)
+				break
+			}
+		}
+		err := writer.Write([]string{
+			fmt.Sprintf("%d", row.User.UserID),
+			row.User.UserName,
+			row.User.RealName,
+			row.User.RoleName,
+			fmt.Sprintf("%t", row.User.IsEnabled()),
+			row.Device.DeviceID,
+			row.Device.CarLicense,
+			groupName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// newPasswordEnvironmentVariable is the environment variable that "users create" and "users reset-password" read
+// the new password from.
+const newPasswordEnvironmentVariable = "ANGELTRAX_NEW_PASSWORD"
+
+// readNewPassword returns the new password for "users create" and "users reset-password".
+//
+// The password is never taken from the command line (where anyone can see it); it comes from the first line of
+// standard input (if fromStdin is set), then the environment, and then a prompt on the terminal.
+func readNewPassword(fromStdin bool) (string, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("could not read the password: %w", err)
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return "", fmt.Errorf("missing password")
+		}
+		return password, nil
+	}
+	if password := os.Getenv(newPasswordEnvironmentVariable); password != "" {
+		return password, nil
+	}
+
+	password, err := promptPassword("New password: ")
+	if err != nil {
+		return "", fmt.Errorf("missing password (use --password-stdin or set %s): %w", newPasswordEnvironmentVariable, err)
+	}
+	confirmation, err := promptPassword("Confirm the new password: ")
+	if err != nil {
+		return "", err
+	}
+	if password != confirmation {
+		return "", fmt.Errorf("the passwords don't match")
+	}
+	if password == "" {
+		return "", fmt.Errorf("missing password")
+	}
+	return password, nil
+}
+
+// promptPassword asks for a password on the terminal without echoing it.
+func promptPassword(prompt string) (string, error) {
+	restore, err := makeRaw(os.Stdin, os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("could not prompt for it: %w", err)
+	}
+	defer func() { _ = restore() }()
+
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprint(os.Stderr, "\r\n")
+
+	var password []rune
+	buffer := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buffer)
+		if err != nil {
+			return "", err
+		}
+		for _, k := range parseKeys(buffer[:n]) {
+			switch k.name {
+			case "enter":
+				return string(password), nil
+			case "backspace":
+				if len(password) > 0 {
+					password = password[:len(password)-1]
+				}
+			case "ctrl-c", "escape":
+				return "", fmt.Errorf("cancelled")
+			case "":
+				password = append(password, k.r)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// These are the exit codes for "task watch".
+const (
+	watchExitFinished = 0
+	watchExitError    = 1
+	watchExitFailed   = 2
+	watchExitTimedOut = 3
+	watchExitNoFiles  = 4
+)
+
+// watchExitCode returns the exit code for the final channels of the watched tasks.
+//
+// Failures take precedence over timeouts, and "no files" is only reported when no channel had any files.
+func watchExitCode(channels []angeltrax.TaskChannelSummary) int {
+	var failed, timedOut bool
+	noFiles := len(channels) > 0
+	for _, channel := range channels {
+		switch channel.Status {
+		case angeltrax.TaskStatusTimeout:
+			timedOut = true
+		case angeltrax.TaskStatusNoFiles:
+			// This doesn't change anything.
+		case angeltrax.TaskStatusFinished:
+			noFiles = false
+		default:
+			if channel.Status.IsFailure() || channel.Status == angeltrax.TaskStatusDelete {
+				failed = true
+			}
+			noFiles = false
+		}
+	}
+	switch {
+	case failed:
+		return watchExitFailed
+	case timedOut:
+		return watchExitTimedOut
+	case noFiles:
+		return watchExitNoFiles
+	}
+	return watchExitFinished
+}
+
+// renderTaskProgress prints a progress bar for a progress event.
+func renderTaskProgress(w io.Writer, event angeltrax.TaskProgressEvent) {
+	if event.Channel == 0 {
+		// The task has no channels yet, so there is no progress to show.
+		fmt.Fprintf(w, "%s Task #%d: %s\n", event.Time.Format("15:04:05"), event.TaskID, event.Status)
+		return
+	}
+
+	const barWidth = 20
+	filled := int(event.Percent / 100 * barWidth)
+	if filled < 0 {
+		filled = 0
+	}
+	if filled > barWidth {
+		filled = barWidth
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat(" ", barWidth-filled)
+	fmt.Fprintf(w, "%s Task #%d channel %d (%s %s - %s): [%s] %5.1f%% %.1f / %.1f @ %.1f | %s", event.Time.Format("15:04:05"), event.TaskID, event.Channel, event.Row.Date, event.Row.StartTime, event.Row.EndTime, bar, event.Percent, event.CurrentSize, event.TotalSize, event.Speed, event.Status)
+	if event.Error != "" {
+		fmt.Fprintf(w, " | %s", event.Error)
+	}
+	fmt.Fprintf(w, "\n")
+}
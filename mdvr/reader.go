@@ -0,0 +1,243 @@
+// Package mdvr reads the proprietary container that MDVR recordings are stored in.
+//
+// The container starts with a 16-byte header ("HXVS" for H.264 or "HXVT" for H.265, then the width and
+// height) followed by a sequence of tagged frames, all little-endian:
+//
+//	"HXVF" length timestamp reserved <Annex-B video>
+//	"HXAF" length timestamp reserved <4-byte audio header> <G.711 A-law audio>
+//	"HXFI" ...                       (the index; this ends the stream)
+package mdvr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/angeltrax/mp4"
+)
+
+const (
+	headerSize      = 16
+	frameHeaderSize = 16
+	audioHeaderSize = 4
+
+	// maxFrameSize guards against garbage lengths; no real frame comes close to this.
+	maxFrameSize = 16 * 1024 * 1024
+
+	// AudioSampleRate is the sample rate of the audio frames.
+	AudioSampleRate = 8000
+)
+
+var (
+	tagH264  = []byte("HXVS")
+	tagH265  = []byte("HXVT")
+	tagVideo = []byte("HXVF")
+	tagAudio = []byte("HXAF")
+	tagIndex = []byte("HXFI")
+)
+
+// ErrNotMDVR is returned when the input does not start with a known header.
+var ErrNotMDVR = errors.New("not an MDVR recording")
+
+// FrameType is the type of a frame.
+type FrameType int
+
+const (
+	FrameTypeVideo FrameType = 1
+	FrameTypeAudio FrameType = 2
+)
+
+// Frame is a single frame from the recording.
+type Frame struct {
+	Type      FrameType
+	Timestamp time.Duration // From the device's clock; only the differences are meaningful.
+	Data      []byte        // Annex-B for video; the raw G.711 samples for audio.
+}
+
+// Reader reads the frames of a recording.
+type Reader struct {
+	r          *bufio.Reader
+	VideoCodec mp4.VideoCodec
+	Width      int
+	Height     int
+
+	// Skipped is the number of bytes that were skipped while looking for the next frame.
+	Skipped int64
+	done    bool
+}
+
+// NewReader reads the header of the recording.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		r: bufio.NewReaderSize(r, 64*1024),
+	}
+
+	var header [headerSize]byte
+	_, err := io.ReadFull(reader.r, header[:])
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotMDVR
+		}
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(header[0:4], tagH264):
+		reader.VideoCodec = mp4.VideoCodecH264
+	case bytes.Equal(header[0:4], tagH265):
+		reader.VideoCodec = mp4.VideoCodecH265
+	default:
+		return nil, ErrNotMDVR
+	}
+	reader.Width = int(binary.LittleEndian.Uint32(header[4:8]))
+	reader.Height = int(binary.LittleEndian.Uint32(header[8:12]))
+	return reader, nil
+}
+
+// ReadFrame returns the next frame, or io.EOF at the end of the recording.
+//
+// Anything that doesn't look like a frame is skipped; recordings that were cut off while the device was
+// writing them are common, and the rest of the file is still worth having.
+func (r *Reader) ReadFrame() (*Frame, error) {
+	for {
+		if r.done {
+			return nil, io.EOF
+		}
+
+		tag, err := r.r.Peek(4)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				r.done = true
+				r.Skipped += int64(len(tag))
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		var frameType FrameType
+		switch {
+		case bytes.Equal(tag, tagVideo):
+			frameType = FrameTypeVideo
+		case bytes.Equal(tag, tagAudio):
+			frameType = FrameTypeAudio
+		case bytes.Equal(tag, tagIndex):
+			r.done = true
+			return nil, io.EOF
+		default:
+			_, _ = r.r.Discard(1)
+			r.Skipped++
+			continue
+		}
+
+		header, err := r.r.Peek(frameHeaderSize)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				r.done = true
+				r.Skipped += int64(len(header))
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		length := binary.LittleEndian.Uint32(header[4:8])
+		timestamp := binary.LittleEndian.Uint32(header[8:12])
+		if length > maxFrameSize || (frameType == FrameTypeAudio && length < audioHeaderSize) {
+			logrus.Debugf("Skipping frame with bad length %d", length)
+			_, _ = r.r.Discard(1)
+			r.Skipped++
+			continue
+		}
+		_, _ = r.r.Discard(frameHeaderSize)
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(r.r, data)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// The recording was cut off in the middle of a frame.
+				r.done = true
+				r.Skipped += int64(frameHeaderSize) + int64(length)
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		frame := &Frame{
+			Type:      frameType,
+			Timestamp: time.Duration(timestamp) * time.Millisecond,
+			Data:      data,
+		}
+		if frameType == FrameTypeAudio {
+			frame.Data = data[audioHeaderSize:]
+		}
+		return frame, nil
+	}
+}
+
+// RemuxStats describes what Remux did.
+type RemuxStats struct {
+	VideoCodec    mp4.VideoCodec
+	Width         int
+	Height        int
+	VideoFrames   int // The number of video frames written.
+	DroppedFrames int // The number of video frames dropped because they came before the first key frame.
+	AudioFrames   int
+	SkippedBytes  int64
+	Duration      time.Duration
+}
+
+// Remux copies the video and audio of a recording into an MP4 file, without transcoding.
+func Remux(r io.Reader, w io.WriteSeeker) (*RemuxStats, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := mp4.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RemuxStats{
+		VideoCodec: reader.VideoCodec,
+		Width:      reader.Width,
+		Height:     reader.Height,
+	}
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch frame.Type {
+		case FrameTypeVideo:
+			written, err := writer.WriteVideo(reader.VideoCodec, frame.Timestamp, frame.Data)
+			if err != nil {
+				return nil, err
+			}
+			if written {
+				stats.VideoFrames++
+			} else {
+				stats.DroppedFrames++
+			}
+		case FrameTypeAudio:
+			err = writer.WriteAudio(mp4.AudioCodecG711ALaw, AudioSampleRate, frame.Timestamp, frame.Data)
+			if err != nil {
+				return nil, err
+			}
+			stats.AudioFrames++
+		}
+	}
+	stats.SkippedBytes = reader.Skipped
+	stats.Duration = writer.Duration()
+
+	err = writer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not finish the MP4 file: %w", err)
+	}
+	return stats, nil
+}
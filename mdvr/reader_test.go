@@ -0,0 +1,212 @@
+package mdvr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/mp4"
+)
+
+// These are the NAL units of a 320x240 H.264 baseline stream (with Annex-B start codes).
+var (
+	testSPS    = []byte{0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4}
+	testPPS    = []byte{0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80}
+	testIDR    = []byte{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00, 0x33}
+	testNonIDR = []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x04}
+	testAudio  = bytes.Repeat([]byte{0xd5}, 160)
+)
+
+// recording builds a recording by hand.
+type recording struct {
+	bytes.Buffer
+}
+
+func newRecording(tag string, width, height int) *recording {
+	var r recording
+	header := make([]byte, headerSize)
+	copy(header, tag)
+	binary.LittleEndian.PutUint32(header[4:], uint32(width))
+	binary.LittleEndian.PutUint32(header[8:], uint32(height))
+	r.Write(header)
+	return &r
+}
+
+func (r *recording) frame(tag string, timestamp time.Duration, data []byte) {
+	header := make([]byte, frameHeaderSize)
+	copy(header, tag)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[8:], uint32(timestamp/time.Millisecond))
+	r.Write(header)
+	r.Write(data)
+}
+
+func (r *recording) video(timestamp time.Duration, nalus ...[]byte) {
+	r.frame("HXVF", timestamp, bytes.Join(nalus, nil))
+}
+
+func (r *recording) audio(timestamp time.Duration, samples []byte) {
+	r.frame("HXAF", timestamp, append([]byte{1, 2, 3, 4}, samples...))
+}
+
+// readFrames reads every frame of the recording.
+func readFrames(t *testing.T, reader *Reader) []*Frame {
+	var frames []*Frame
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("could not read a frame: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func TestReader(t *testing.T) {
+	r := newRecording("HXVS", 1920, 1080)
+	r.video(1000*time.Millisecond, testSPS, testPPS, testIDR)
+	r.Write([]byte("junk"))
+	r.audio(1010*time.Millisecond, testAudio)
+	r.frame("HXAF", 1020*time.Millisecond, []byte{1, 2}) // Too short to have the audio header.
+	r.video(1040*time.Millisecond, testNonIDR)
+	r.Write([]byte("HXFI"))
+	r.video(1080*time.Millisecond, testNonIDR) // This is after the index.
+
+	reader, err := NewReader(bytes.NewReader(r.Bytes()))
+	if err != nil {
+		t.Fatalf("could not read the header: %v", err)
+	}
+	if reader.VideoCodec != mp4.VideoCodecH264 || reader.Width != 1920 || reader.Height != 1080 {
+		t.Errorf("wrong header: %s %dx%d", reader.VideoCodec, reader.Width, reader.Height)
+	}
+
+	frames := readFrames(t, reader)
+	expected := []Frame{
+		{Type: FrameTypeVideo, Timestamp: 1000 * time.Millisecond, Data: bytes.Join([][]byte{testSPS, testPPS, testIDR}, nil)},
+		{Type: FrameTypeAudio, Timestamp: 1010 * time.Millisecond, Data: testAudio},
+		{Type: FrameTypeVideo, Timestamp: 1040 * time.Millisecond, Data: testNonIDR},
+	}
+	if len(frames) != len(expected) {
+		t.Fatalf("wrong number of frames: %d", len(frames))
+	}
+	for i, frame := range frames {
+		if frame.Type != expected[i].Type || frame.Timestamp != expected[i].Timestamp || !bytes.Equal(frame.Data, expected[i].Data) {
+			t.Errorf("frame %d: wrong frame: %d %s %x", i, frame.Type, frame.Timestamp, frame.Data)
+		}
+	}
+	// The junk and the whole of the bad frame were skipped.
+	if reader.Skipped != int64(len("junk")+frameHeaderSize+2) {
+		t.Errorf("wrong number of skipped bytes: %d", reader.Skipped)
+	}
+	if _, err := reader.ReadFrame(); err != io.EOF {
+		t.Errorf("expected the end of the recording; got: %v", err)
+	}
+}
+
+func TestReaderH265(t *testing.T) {
+	r := newRecording("HXVT", 640, 360)
+	reader, err := NewReader(bytes.NewReader(r.Bytes()))
+	if err != nil {
+		t.Fatalf("could not read the header: %v", err)
+	}
+	if reader.VideoCodec != mp4.VideoCodecH265 || reader.Width != 640 || reader.Height != 360 {
+		t.Errorf("wrong header: %s %dx%d", reader.VideoCodec, reader.Width, reader.Height)
+	}
+	if frames := readFrames(t, reader); len(frames) != 0 {
+		t.Errorf("unexpected frames: %d", len(frames))
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	r := newRecording("HXVS", 320, 240)
+	r.video(1000*time.Millisecond, testSPS, testPPS, testIDR)
+	r.video(1040*time.Millisecond, testNonIDR)
+	data := r.Bytes()[:r.Len()-3] // The recording was cut off in the middle of the last frame.
+
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not read the header: %v", err)
+	}
+	if frames := readFrames(t, reader); len(frames) != 1 {
+		t.Errorf("wrong number of frames: %d", len(frames))
+	}
+	if reader.Skipped != int64(frameHeaderSize+len(testNonIDR)) {
+		t.Errorf("wrong number of skipped bytes: %d", reader.Skipped)
+	}
+}
+
+func TestReaderNotMDVR(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("HXVS"),
+		append([]byte("RIFF"), make([]byte, 12)...),
+	} {
+		if _, err := NewReader(bytes.NewReader(data)); !errors.Is(err, ErrNotMDVR) {
+			t.Errorf("%q: expected ErrNotMDVR; got: %v", data, err)
+		}
+	}
+}
+
+func TestRemux(t *testing.T) {
+	r := newRecording("HXVS", 320, 240)
+	r.video(960*time.Millisecond, testNonIDR) // Before the first key frame.
+	r.video(1000*time.Millisecond, testSPS, testPPS, testIDR)
+	r.audio(1000*time.Millisecond, testAudio)
+	r.video(1040*time.Millisecond, testNonIDR)
+	r.audio(1020*time.Millisecond, testAudio)
+	r.video(1080*time.Millisecond, testNonIDR)
+	r.Write([]byte("HXFI"))
+
+	output, err := os.Create(filepath.Join(t.TempDir(), "output.mp4"))
+	if err != nil {
+		t.Fatalf("could not create the output: %v", err)
+	}
+	defer output.Close()
+
+	stats, err := Remux(bytes.NewReader(r.Bytes()), output)
+	if err != nil {
+		t.Fatalf("could not remux: %v", err)
+	}
+	expected := RemuxStats{
+		VideoCodec:    mp4.VideoCodecH264,
+		Width:         320,
+		Height:        240,
+		VideoFrames:   3,
+		DroppedFrames: 1,
+		AudioFrames:   2,
+		Duration:      120 * time.Millisecond,
+	}
+	if *stats != expected {
+		t.Errorf("wrong stats: %+v", *stats)
+	}
+
+	contents, err := os.ReadFile(output.Name())
+	if err != nil {
+		t.Fatalf("could not read the output: %v", err)
+	}
+	if len(contents) < 8 || string(contents[4:8]) != "ftyp" || !bytes.Contains(contents, []byte("moov")) {
+		t.Errorf("bad output: %x", contents)
+	}
+}
+
+func TestRemuxNoKeyFrame(t *testing.T) {
+	r := newRecording("HXVS", 320, 240)
+	r.video(1000*time.Millisecond, testNonIDR)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "output.mp4"))
+	if err != nil {
+		t.Fatalf("could not create the output: %v", err)
+	}
+	defer file.Close()
+	_, err = Remux(bytes.NewReader(r.Bytes()), file)
+	if !errors.Is(err, mp4.ErrNoSamples) {
+		t.Errorf("expected no samples; got: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// boxBuilder builds (possibly nested) boxes in memory.
+type boxBuilder struct {
+	buffer bytes.Buffer
+	starts []int
+}
+
+// start begins a box; every call must be matched by a call to end.
+func (b *boxBuilder) start(boxType string) {
+	b.starts = append(b.starts, b.buffer.Len())
+	b.u32(0) // The size is filled in by end.
+	b.buffer.WriteString(boxType)
+}
+
+// startFull begins a "full" box, which has a version and flags.
+func (b *boxBuilder) startFull(boxType string, version uint8, flags uint32) {
+	b.start(boxType)
+	b.u32(uint32(version)<<24 | flags&0xffffff)
+}
+
+func (b *boxBuilder) end() {
+	start := b.starts[len(b.starts)-1]
+	b.starts = b.starts[:len(b.starts)-1]
+	binary.BigEndian.PutUint32(b.buffer.Bytes()[start:], uint32(b.buffer.Len()-start))
+}
+
+func (b *boxBuilder) u8(value uint8) {
+	b.buffer.WriteByte(value)
+}
+
+func (b *boxBuilder) u16(value uint16) {
+	var bytes [2]byte
+	binary.BigEndian.PutUint16(bytes[:], value)
+	b.buffer.Write(bytes[:])
+}
+
+func (b *boxBuilder) u32(value uint32) {
+	var bytes [4]byte
+	binary.BigEndian.PutUint32(bytes[:], value)
+	b.buffer.Write(bytes[:])
+}
+
+func (b *boxBuilder) u64(value uint64) {
+	var bytes [8]byte
+	binary.BigEndian.PutUint64(bytes[:], value)
+	b.buffer.Write(bytes[:])
+}
+
+func (b *boxBuilder) write(data []byte) {
+	b.buffer.Write(data)
+}
+
+func (b *boxBuilder) zeros(count int) {
+	b.buffer.Write(make([]byte, count))
+}
+
+// matrix writes the identity transformation matrix.
+func (b *boxBuilder) matrix() {
+	for _, value := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		b.u32(value)
+	}
+}
+
+func (b *boxBuilder) bytes() []byte {
+	return b.buffer.Bytes()
+}
@@ -0,0 +1,286 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type VideoCodec int
+
+const (
+	VideoCodecH264 VideoCodec = 1
+	VideoCodecH265 VideoCodec = 2
+)
+
+func (c VideoCodec) String() string {
+	switch c {
+	case VideoCodecH264:
+		return "H.264"
+	case VideoCodecH265:
+		return "H.265"
+	}
+	return "unknown"
+}
+
+type AudioCodec int
+
+const (
+	AudioCodecG711ALaw AudioCodec = 1
+	AudioCodecG711ULaw AudioCodec = 2
+)
+
+func (c AudioCodec) String() string {
+	switch c {
+	case AudioCodecG711ALaw:
+		return "G.711 A-law"
+	case AudioCodecG711ULaw:
+		return "G.711 mu-law"
+	}
+	return "unknown"
+}
+
+// videoConfig collects the parameter sets of a video stream.
+type videoConfig struct {
+	codec  VideoCodec
+	vps    []byte // H.265 only.
+	sps    []byte
+	pps    []byte
+	width  int
+	height int
+}
+
+// observe records any parameter sets in the access unit and returns whether it is a key frame.
+func (c *videoConfig) observe(nalus [][]byte) bool {
+	var key bool
+	for _, nalu := range nalus {
+		switch c.codec {
+		case VideoCodecH264:
+			switch h264NALType(nalu) {
+			case h264NALTypeIDR:
+				key = true
+			case h264NALTypeSPS:
+				// A damaged SPS is ignored; the stream isn't ready until a good one shows up.
+				if c.sps == nil {
+					if width, height, err := h264SPSSize(nalu); err == nil {
+						c.sps = append([]byte{}, nalu...)
+						c.width, c.height = width, height
+					}
+				}
+			case h264NALTypePPS:
+				if c.pps == nil {
+					c.pps = append([]byte{}, nalu...)
+				}
+			}
+		case VideoCodecH265:
+			nalType := h265NALType(nalu)
+			switch {
+			case nalType >= h265NALTypeBLAWLP && nalType <= h265NALTypeCRANUT:
+				key = true
+			case nalType == h265NALTypeVPS:
+				if c.vps == nil {
+					c.vps = append([]byte{}, nalu...)
+				}
+			case nalType == h265NALTypeSPS:
+				if c.sps == nil {
+					if info, err := parseH265SPS(nalu); err == nil {
+						c.sps = append([]byte{}, nalu...)
+						c.width, c.height = info.width, info.height
+					}
+				}
+			case nalType == h265NALTypePPS:
+				if c.pps == nil {
+					c.pps = append([]byte{}, nalu...)
+				}
+			}
+		}
+	}
+	return key
+}
+
+// IsKeyFrame returns whether an access unit (in Annex-B format) can be decoded on its own.
+func IsKeyFrame(codec VideoCodec, annexB []byte) bool {
+	for _, nalu := range SplitAnnexB(annexB) {
+		switch codec {
+		case VideoCodecH264:
+			if h264NALType(nalu) == h264NALTypeIDR {
+				return true
+			}
+		case VideoCodecH265:
+			if nalType := h265NALType(nalu); nalType >= h265NALTypeBLAWLP && nalType <= h265NALTypeCRANUT {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ready returns true once all of the parameter sets needed for the sample entry have been seen.
+func (c *videoConfig) ready() bool {
+	if c.sps == nil || c.pps == nil {
+		return false
+	}
+	if c.codec == VideoCodecH265 && c.vps == nil {
+		return false
+	}
+	return true
+}
+
+// sample converts the NAL units of an access unit into an MP4 sample (with four-byte lengths).
+//
+// Access unit delimiters and filler data are dropped, since they have no place in an MP4 sample.
+func (c *videoConfig) sample(nalus [][]byte) []byte {
+	var size int
+	for _, nalu := range nalus {
+		size += 4 + len(nalu)
+	}
+	output := make([]byte, 0, size)
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch c.codec {
+		case VideoCodecH264:
+			if nalType := h264NALType(nalu); nalType == h264NALTypeAUD || nalType == h264NALTypeFiller {
+				continue
+			}
+		case VideoCodecH265:
+			if nalType := h265NALType(nalu); nalType == h265NALTypeAUD || nalType == h265NALTypeFillerEnd {
+				continue
+			}
+		}
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(nalu)))
+		output = append(output, length[:]...)
+		output = append(output, nalu...)
+	}
+	return output
+}
+
+// avcProfile returns the profile, the constraint flags, and the level from the H.264 SPS.
+func (c *videoConfig) avcProfile() ([3]byte, error) {
+	var profile [3]byte
+	if len(c.sps) < 4 {
+		return profile, fmt.Errorf("SPS is too short")
+	}
+	copy(profile[:], c.sps[1:4])
+	return profile, nil
+}
+
+// writeSampleEntry writes the "avc1" or "hvc1" sample entry.
+func (c *videoConfig) writeSampleEntry(b *boxBuilder) error {
+	if c.codec == VideoCodecH264 {
+		// Check this before anything is written.
+		if _, err := c.avcProfile(); err != nil {
+			return err
+		}
+	}
+
+	if c.codec == VideoCodecH265 {
+		b.start("hvc1")
+	} else {
+		b.start("avc1")
+	}
+	b.zeros(6)
+	b.u16(1) // data_reference_index
+	b.zeros(16)
+	b.u16(uint16(c.width))
+	b.u16(uint16(c.height))
+	b.u32(0x00480000) // 72 dpi
+	b.u32(0x00480000)
+	b.u32(0)
+	b.u16(1) // frame_count
+	b.zeros(32)
+	b.u16(0x0018) // depth
+	b.u16(0xffff) // pre_defined = -1
+
+	if c.codec == VideoCodecH265 {
+		c.writeHVCC(b)
+	} else {
+		c.writeAVCC(b)
+	}
+	b.end()
+	return nil
+}
+
+func (c *videoConfig) writeAVCC(b *boxBuilder) {
+	profile, _ := c.avcProfile()
+
+	b.start("avcC")
+	b.u8(1)
+	b.u8(profile[0]) // profile_idc
+	b.u8(profile[1]) // constraint flags
+	b.u8(profile[2]) // level_idc
+	b.u8(0xff)       // Four-byte NAL unit lengths.
+	b.u8(0xe1)       // One SPS.
+	b.u16(uint16(len(c.sps)))
+	b.write(c.sps)
+	b.u8(1) // One PPS.
+	b.u16(uint16(len(c.pps)))
+	b.write(c.pps)
+	b.end()
+}
+
+func (c *videoConfig) writeHVCC(b *boxBuilder) {
+	info, err := parseH265SPS(c.sps)
+	if err != nil {
+		// Use the most common values: Main profile, 4:2:0, 8-bit.
+		info = &h265SPSInfo{
+			profileTierLevel: []byte{0x01, 0x60, 0, 0, 0, 0x90, 0, 0, 0, 0, 0, 0x5d},
+			chromaFormatIDC:  1,
+		}
+	}
+
+	b.start("hvcC")
+	b.u8(1)
+	b.write(info.profileTierLevel)
+	b.u16(0xf000) // min_spatial_segmentation_idc
+	b.u8(0xfc)    // parallelismType
+	b.u8(0xfc | uint8(info.chromaFormatIDC&0x03))
+	b.u8(0xf8 | uint8(info.bitDepthLumaMinus8&0x07))
+	b.u8(0xf8 | uint8(info.bitDepthChromaMinus8&0x07))
+	b.u16(0) // avgFrameRate
+	var nesting uint8
+	if info.temporalIDNesting {
+		nesting = 1
+	}
+	b.u8(uint8(info.maxSubLayersMinus1+1)<<3 | nesting<<2 | 0x03)
+	b.u8(3) // VPS, SPS, PPS.
+	for _, parameterSet := range []struct {
+		nalType int
+		data    []byte
+	}{
+		{h265NALTypeVPS, c.vps},
+		{h265NALTypeSPS, c.sps},
+		{h265NALTypePPS, c.pps},
+	} {
+		b.u8(0x80 | uint8(parameterSet.nalType))
+		b.u16(1)
+		b.u16(uint16(len(parameterSet.data)))
+		b.write(parameterSet.data)
+	}
+	b.end()
+}
+
+// audioConfig describes an audio stream.
+type audioConfig struct {
+	codec      AudioCodec
+	sampleRate int
+}
+
+// writeSampleEntry writes the "alaw" or "ulaw" sample entry.
+func (c audioConfig) writeSampleEntry(b *boxBuilder) {
+	if c.codec == AudioCodecG711ULaw {
+		b.start("ulaw")
+	} else {
+		b.start("alaw")
+	}
+	b.zeros(6)
+	b.u16(1) // data_reference_index
+	b.zeros(8)
+	b.u16(1)  // channelcount
+	b.u16(16) // samplesize
+	b.u16(0)
+	b.u16(0)
+	b.u32(uint32(c.sampleRate) << 16)
+	b.end()
+}
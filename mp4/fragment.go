@@ -0,0 +1,277 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Fragment is a self-contained piece of a fragmented MP4 stream ("moof" and "mdat").
+type Fragment struct {
+	Sequence uint32        // This starts at 1.
+	Start    time.Duration // The time of the first frame, relative to the first frame of the stream.
+	Duration time.Duration
+	Data     []byte
+}
+
+type fragmentSample struct {
+	data  []byte
+	ticks uint64 // In videoTimescale ticks since the start of the stream.
+	key   bool
+}
+
+// Fragmenter turns a live video stream into fragmented MP4, suitable for streaming to a browser or for HLS.
+//
+// Each fragment starts with a key frame.  Only video is supported, since browsers won't play G.711 audio.
+type Fragmenter struct {
+	// MinDuration is the shortest fragment to produce; fragments are cut at the first key frame after this
+	// much time.  If this is zero, every group of pictures is its own fragment.
+	MinDuration time.Duration
+
+	config   videoConfig
+	init     []byte
+	base     time.Duration
+	haveBase bool
+	sequence uint32
+	samples  []fragmentSample
+	last     uint64 // The ticks of the last sample.
+	delta    uint64 // The duration of the last sample, in ticks.
+}
+
+// NewFragmenter returns a fragmenter for the given codec.
+func NewFragmenter(codec VideoCodec) *Fragmenter {
+	return &Fragmenter{
+		config: videoConfig{codec: codec},
+	}
+}
+
+// InitSegment returns the initialization segment ("ftyp" and "moov"); this is nil until the parameter sets
+// have been seen.
+func (f *Fragmenter) InitSegment() []byte {
+	return f.init
+}
+
+// WriteVideo adds a video access unit (in Annex-B format).  If this completes a fragment, then the fragment
+// is returned.
+//
+// Frames before the first key frame (with its parameter sets) are dropped.
+func (f *Fragmenter) WriteVideo(timestamp time.Duration, annexB []byte) (*Fragment, error) {
+	nalus := SplitAnnexB(annexB)
+	key := f.config.observe(nalus)
+	if f.init == nil {
+		if !key || !f.config.ready() {
+			return nil, nil
+		}
+		init, err := f.initSegment()
+		if err != nil {
+			return nil, fmt.Errorf("could not write the initialization segment: %w", err)
+		}
+		f.init = init
+	}
+
+	sample := f.config.sample(nalus)
+	if len(sample) == 0 {
+		return nil, nil
+	}
+
+	if !f.haveBase {
+		f.base = timestamp
+		f.haveBase = true
+	}
+	ticks := durationToTicks(timestamp-f.base, videoTimescale)
+	if len(f.samples) > 0 || f.sequence > 0 {
+		if ticks <= f.last {
+			// Timestamps must increase; nudge this one forward.
+			ticks = f.last + 1
+		}
+		f.delta = ticks - f.last
+	}
+
+	var fragment *Fragment
+	if key && len(f.samples) > 0 {
+		start := f.samples[0].ticks
+		if ticksToDuration(ticks-start, videoTimescale) >= f.MinDuration {
+			fragment = f.fragment(ticks)
+		}
+	}
+
+	f.samples = append(f.samples, fragmentSample{data: sample, ticks: ticks, key: key})
+	f.last = ticks
+	return fragment, nil
+}
+
+// Flush returns whatever is left as a final fragment (or nil if there is nothing left).
+func (f *Fragmenter) Flush() *Fragment {
+	if len(f.samples) == 0 {
+		return nil
+	}
+	delta := f.delta
+	if delta == 0 {
+		delta = defaultFrameDuration
+	}
+	return f.fragment(f.last + delta)
+}
+
+// fragment builds a fragment out of the pending samples; the end is the time of the next sample.
+func (f *Fragmenter) fragment(end uint64) *Fragment {
+	samples := f.samples
+	f.samples = nil
+	f.sequence++
+
+	var b boxBuilder
+	b.start("moof")
+
+	b.startFull("mfhd", 0, 0)
+	b.u32(f.sequence)
+	b.end()
+
+	b.start("traf")
+	b.startFull("tfhd", 0, 0x020000) // default-base-is-moof
+	b.u32(1)
+	b.end()
+
+	b.startFull("tfdt", 1, 0)
+	b.u64(samples[0].ticks)
+	b.end()
+
+	b.startFull("trun", 0, 0x000001|0x000100|0x000200|0x000400) // Data offset, and per-sample durations, sizes, and flags.
+	b.u32(uint32(len(samples)))
+	dataOffsetPosition := b.buffer.Len()
+	b.u32(0) // The data offset is filled in below.
+	var mdatSize int
+	for i, sample := range samples {
+		next := end
+		if i+1 < len(samples) {
+			next = samples[i+1].ticks
+		}
+		b.u32(uint32(next - sample.ticks))
+		b.u32(uint32(len(sample.data)))
+		if sample.key {
+			b.u32(0x02000000) // Depends on no other sample.
+		} else {
+			b.u32(0x01010000) // Depends on other samples; not a sync sample.
+		}
+		mdatSize += len(sample.data)
+	}
+	b.end() // trun
+
+	b.end() // traf
+	b.end() // moof
+
+	moofSize := b.buffer.Len()
+	binary.BigEndian.PutUint32(b.buffer.Bytes()[dataOffsetPosition:], uint32(moofSize+8))
+
+	b.u32(uint32(8 + mdatSize))
+	b.write([]byte("mdat"))
+	for _, sample := range samples {
+		b.write(sample.data)
+	}
+
+	return &Fragment{
+		Sequence: f.sequence,
+		Start:    ticksToDuration(samples[0].ticks, videoTimescale),
+		Duration: ticksToDuration(end-samples[0].ticks, videoTimescale),
+		Data:     b.bytes(),
+	}
+}
+
+func (f *Fragmenter) initSegment() ([]byte, error) {
+	var b boxBuilder
+	b.start("ftyp")
+	b.write([]byte("iso5"))
+	b.u32(0x200)
+	for _, brand := range []string{"iso5", "iso6", "mp41"} {
+		b.write([]byte(brand))
+	}
+	b.end()
+
+	b.start("moov")
+	writeMVHD(&b, 0, 2)
+
+	b.start("trak")
+	b.startFull("tkhd", 1, 0x000003)
+	b.u64(0)
+	b.u64(0)
+	b.u32(1)
+	b.u32(0)
+	b.u64(0)
+	b.zeros(8)
+	b.u16(0)
+	b.u16(0)
+	b.u16(0)
+	b.u16(0)
+	b.matrix()
+	b.u32(uint32(f.config.width) << 16)
+	b.u32(uint32(f.config.height) << 16)
+	b.end()
+
+	b.start("mdia")
+	b.startFull("mdhd", 1, 0)
+	b.u64(0)
+	b.u64(0)
+	b.u32(videoTimescale)
+	b.u64(0)
+	b.u16(0x55c4) // "und"
+	b.u16(0)
+	b.end()
+	writeHDLR(&b, "vide")
+	b.start("minf")
+	b.startFull("vmhd", 0, 1)
+	b.zeros(8)
+	b.end()
+	writeDINF(&b)
+	b.start("stbl")
+	b.startFull("stsd", 0, 0)
+	b.u32(1)
+	err := f.config.writeSampleEntry(&b)
+	if err != nil {
+		return nil, err
+	}
+	b.end()
+	// The sample tables are empty; the samples are described by the fragments.
+	for _, boxType := range []string{"stts", "stsc", "stco"} {
+		b.startFull(boxType, 0, 0)
+		b.u32(0)
+		b.end()
+	}
+	b.startFull("stsz", 0, 0)
+	b.u32(0)
+	b.u32(0)
+	b.end()
+	b.end() // stbl
+	b.end() // minf
+	b.end() // mdia
+	b.end() // trak
+
+	b.start("mvex")
+	b.startFull("trex", 0, 0)
+	b.u32(1) // track_ID
+	b.u32(1) // default_sample_description_index
+	b.u32(0)
+	b.u32(0)
+	b.u32(0)
+	b.end()
+	b.end()
+
+	b.end() // moov
+	return b.bytes(), nil
+}
+
+// Codec returns the codec string for the stream (as used in HLS playlists and MIME types), such as
+// "avc1.64001f"; this is empty until the parameter sets have been seen.
+func (f *Fragmenter) Codec() string {
+	if f.init == nil {
+		return ""
+	}
+	switch f.config.codec {
+	case VideoCodecH264:
+		profile, err := f.config.avcProfile()
+		if err != nil {
+			return "avc1"
+		}
+		return fmt.Sprintf("avc1.%02x%02x%02x", profile[0], profile[1], profile[2])
+	case VideoCodecH265:
+		return "hvc1"
+	}
+	return ""
+}
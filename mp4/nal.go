@@ -0,0 +1,385 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SplitAnnexB splits an Annex-B byte stream (with 00 00 01 or 00 00 00 01 start codes) into NAL units.
+func SplitAnnexB(data []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	i := 0
+	for i+2 < len(data) {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				end := i
+				// A four-byte start code has an extra leading zero.
+				for end > start && data[end-1] == 0 {
+					end--
+				}
+				if end > start {
+					nalus = append(nalus, data[start:end])
+				}
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 && start < len(data) {
+		nalus = append(nalus, data[start:])
+	} else if start < 0 && len(data) > 0 {
+		// There were no start codes, so the whole thing is a single NAL unit.
+		nalus = append(nalus, data)
+	}
+	return nalus
+}
+
+// These are the H.264 NAL unit types that we care about.
+const (
+	h264NALTypeIDR    = 5
+	h264NALTypeSPS    = 7
+	h264NALTypePPS    = 8
+	h264NALTypeAUD    = 9
+	h264NALTypeFiller = 12
+)
+
+// These are the H.265 NAL unit types that we care about.
+const (
+	h265NALTypeBLAWLP    = 16
+	h265NALTypeCRANUT    = 21
+	h265NALTypeVPS       = 32
+	h265NALTypeSPS       = 33
+	h265NALTypePPS       = 34
+	h265NALTypeAUD       = 35
+	h265NALTypeFillerEnd = 38
+)
+
+func h264NALType(nalu []byte) int {
+	if len(nalu) == 0 {
+		return -1
+	}
+	return int(nalu[0] & 0x1f)
+}
+
+func h265NALType(nalu []byte) int {
+	if len(nalu) == 0 {
+		return -1
+	}
+	return int(nalu[0]>>1) & 0x3f
+}
+
+// unescapeRBSP removes the emulation prevention bytes (the 03 in 00 00 03).
+func unescapeRBSP(data []byte) []byte {
+	output := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		output = append(output, b)
+	}
+	return output
+}
+
+var errBitReaderEOF = errors.New("unexpected end of bitstream")
+
+// bitReader reads the bits of an RBSP.
+type bitReader struct {
+	data []byte
+	pos  int // In bits.
+}
+
+func (r *bitReader) bit() (uint, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errBitReaderEOF
+	}
+	value := uint(r.data[r.pos/8]>>(7-uint(r.pos%8))) & 1
+	r.pos++
+	return value, nil
+}
+
+func (r *bitReader) bits(n int) (uint, error) {
+	var value uint
+	for i := 0; i < n; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<1 | b
+	}
+	return value, nil
+}
+
+func (r *bitReader) skip(n int) error {
+	if r.pos+n > len(r.data)*8 {
+		return errBitReaderEOF
+	}
+	r.pos += n
+	return nil
+}
+
+// ue reads an unsigned Exp-Golomb value.
+func (r *bitReader) ue() (uint, error) {
+	zeros := 0
+	for {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, fmt.Errorf("invalid exp-golomb value")
+		}
+	}
+	value, err := r.bits(zeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1 << uint(zeros)) - 1 + value, nil
+}
+
+// se reads a signed Exp-Golomb value.
+func (r *bitReader) se() (int, error) {
+	value, err := r.ue()
+	if err != nil {
+		return 0, err
+	}
+	if value%2 == 1 {
+		return int((value + 1) / 2), nil
+	}
+	return -int(value / 2), nil
+}
+
+// h264SPSSize returns the picture size described by an H.264 SPS (including its NAL header).
+func h264SPSSize(sps []byte) (int, int, error) {
+	if len(sps) < 4 {
+		return 0, 0, fmt.Errorf("SPS is too short")
+	}
+	r := &bitReader{data: unescapeRBSP(sps[1:])}
+	profileIDC, _ := r.bits(8)
+	_ = r.skip(16)                    // constraint flags and level
+	if _, err := r.ue(); err != nil { // seq_parameter_set_id
+		return 0, 0, err
+	}
+
+	chromaFormatIDC := uint(1)
+	switch profileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		var err error
+		chromaFormatIDC, err = r.ue()
+		if err != nil {
+			return 0, 0, err
+		}
+		if chromaFormatIDC == 3 {
+			_ = r.skip(1) // separate_colour_plane_flag
+		}
+		_, _ = r.ue() // bit_depth_luma_minus8
+		_, _ = r.ue() // bit_depth_chroma_minus8
+		_ = r.skip(1) // qpprime_y_zero_transform_bypass_flag
+		scalingMatrixPresent, err := r.bit()
+		if err != nil {
+			return 0, 0, err
+		}
+		if scalingMatrixPresent == 1 {
+			count := 8
+			if chromaFormatIDC == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				present, err := r.bit()
+				if err != nil {
+					return 0, 0, err
+				}
+				if present == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				lastScale, nextScale := 8, 8
+				for j := 0; j < size; j++ {
+					if nextScale != 0 {
+						delta, err := r.se()
+						if err != nil {
+							return 0, 0, err
+						}
+						nextScale = (lastScale + delta + 256) % 256
+					}
+					if nextScale != 0 {
+						lastScale = nextScale
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := r.ue(); err != nil { // log2_max_frame_num_minus4
+		return 0, 0, err
+	}
+	picOrderCntType, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+	switch picOrderCntType {
+	case 0:
+		_, _ = r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		_ = r.skip(1) // delta_pic_order_always_zero_flag
+		_, _ = r.se() // offset_for_non_ref_pic
+		_, _ = r.se() // offset_for_top_to_bottom_field
+		cycle, err := r.ue()
+		if err != nil {
+			return 0, 0, err
+		}
+		for i := uint(0); i < cycle; i++ {
+			_, _ = r.se()
+		}
+	}
+	_, _ = r.ue() // max_num_ref_frames
+	_ = r.skip(1) // gaps_in_frame_num_value_allowed_flag
+	widthInMBsMinus1, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+	heightInMapUnitsMinus1, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+	frameMBsOnly, err := r.bit()
+	if err != nil {
+		return 0, 0, err
+	}
+	if frameMBsOnly == 0 {
+		_ = r.skip(1) // mb_adaptive_frame_field_flag
+	}
+	_ = r.skip(1) // direct_8x8_inference_flag
+
+	width := int(widthInMBsMinus1+1) * 16
+	height := int(2-frameMBsOnly) * int(heightInMapUnitsMinus1+1) * 16
+
+	cropping, err := r.bit()
+	if err == nil && cropping == 1 {
+		left, _ := r.ue()
+		right, _ := r.ue()
+		top, _ := r.ue()
+		bottom, _ := r.ue()
+		cropUnitX, cropUnitY := 1, int(2-frameMBsOnly)
+		if chromaFormatIDC == 1 {
+			cropUnitX, cropUnitY = 2, 2*int(2-frameMBsOnly)
+		} else if chromaFormatIDC == 2 {
+			cropUnitX, cropUnitY = 2, int(2-frameMBsOnly)
+		}
+		width -= int(left+right) * cropUnitX
+		height -= int(top+bottom) * cropUnitY
+	}
+	return width, height, nil
+}
+
+// h265SPSInfo is what we need from an H.265 SPS.
+type h265SPSInfo struct {
+	width                int
+	height               int
+	maxSubLayersMinus1   int
+	temporalIDNesting    bool
+	profileTierLevel     []byte // The 12 bytes of the general profile, tier, and level.
+	chromaFormatIDC      int
+	bitDepthLumaMinus8   int
+	bitDepthChromaMinus8 int
+}
+
+// parseH265SPS parses an H.265 SPS (including its two-byte NAL header).
+func parseH265SPS(sps []byte) (*h265SPSInfo, error) {
+	if len(sps) < 15 {
+		return nil, fmt.Errorf("SPS is too short")
+	}
+	rbsp := unescapeRBSP(sps[2:])
+	if len(rbsp) < 13 {
+		return nil, fmt.Errorf("SPS is too short")
+	}
+	info := &h265SPSInfo{}
+	info.maxSubLayersMinus1 = int(rbsp[0]>>1) & 0x07
+	info.temporalIDNesting = rbsp[0]&0x01 == 1
+	info.profileTierLevel = append([]byte{}, rbsp[1:13]...)
+
+	r := &bitReader{data: rbsp, pos: 13 * 8}
+	// Skip the sub-layer profile and level information.
+	var subLayerProfilePresent, subLayerLevelPresent []uint
+	for i := 0; i < info.maxSubLayersMinus1; i++ {
+		profilePresent, _ := r.bit()
+		levelPresent, _ := r.bit()
+		subLayerProfilePresent = append(subLayerProfilePresent, profilePresent)
+		subLayerLevelPresent = append(subLayerLevelPresent, levelPresent)
+	}
+	if info.maxSubLayersMinus1 > 0 {
+		for i := info.maxSubLayersMinus1; i < 8; i++ {
+			_ = r.skip(2) // reserved_zero_2bits
+		}
+	}
+	for i := 0; i < info.maxSubLayersMinus1; i++ {
+		if subLayerProfilePresent[i] == 1 {
+			_ = r.skip(88)
+		}
+		if subLayerLevelPresent[i] == 1 {
+			_ = r.skip(8)
+		}
+	}
+
+	if _, err := r.ue(); err != nil { // sps_seq_parameter_set_id
+		return nil, err
+	}
+	chromaFormatIDC, err := r.ue()
+	if err != nil {
+		return nil, err
+	}
+	info.chromaFormatIDC = int(chromaFormatIDC)
+	if chromaFormatIDC == 3 {
+		_ = r.skip(1) // separate_colour_plane_flag
+	}
+	width, err := r.ue()
+	if err != nil {
+		return nil, err
+	}
+	height, err := r.ue()
+	if err != nil {
+		return nil, err
+	}
+	info.width = int(width)
+	info.height = int(height)
+
+	conformanceWindow, err := r.bit()
+	if err != nil {
+		return nil, err
+	}
+	if conformanceWindow == 1 {
+		left, _ := r.ue()
+		right, _ := r.ue()
+		top, _ := r.ue()
+		bottom, _ := r.ue()
+		subWidth, subHeight := 1, 1
+		if chromaFormatIDC == 1 {
+			subWidth, subHeight = 2, 2
+		} else if chromaFormatIDC == 2 {
+			subWidth = 2
+		}
+		info.width -= int(left+right) * subWidth
+		info.height -= int(top+bottom) * subHeight
+	}
+	bitDepthLuma, _ := r.ue()
+	bitDepthChroma, _ := r.ue()
+	info.bitDepthLumaMinus8 = int(bitDepthLuma)
+	info.bitDepthChromaMinus8 = int(bitDepthChroma)
+	return info, nil
+}
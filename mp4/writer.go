@@ -0,0 +1,618 @@
+// Package mp4 writes H.264/H.265 video and G.711 audio into MP4 files without transcoding.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	movieTimescale = 1000
+	videoTimescale = 90000
+
+	// defaultFrameDuration is used for the last frame when there is nothing else to go on.
+	defaultFrameDuration = videoTimescale / 25
+
+	// audioGapTolerance is how far an audio packet may be from where the packets before it end before it counts as
+	// a gap; anything shorter is just timestamp jitter.
+	audioGapTolerance = 10 * time.Millisecond
+)
+
+// ErrNoSamples is returned by Close if nothing usable was written.
+var ErrNoSamples = errors.New("no samples were written")
+
+// Chapter marks a point in the file.
+type Chapter struct {
+	Time  time.Duration // This uses the same clock as the sample timestamps.
+	Title string
+}
+
+// Writer writes a progressive (non-fragmented) MP4 file.
+//
+// The sample data is written as it comes in; the index ("moov") is written by Close, which is why the
+// destination must be seekable.  Timestamps may use any base; the earliest one becomes the start of the file.
+type Writer struct {
+	w         io.WriteSeeker
+	mdatStart int64
+	offset    int64
+	base      time.Duration
+	haveBase  bool
+	video     *videoTrack
+	audio     *audioTrack
+	chapters  []Chapter
+	closed    bool
+}
+
+type videoTrack struct {
+	config  videoConfig
+	start   time.Duration // The timestamp of the first sample.
+	times   []uint64      // In videoTimescale ticks since the start.
+	sizes   []uint32
+	offsets []uint64
+	keys    []uint32 // One-indexed sample numbers.
+}
+
+type audioTrack struct {
+	config       audioConfig
+	start        time.Duration // The timestamp of the first sample.
+	chunkOffsets []uint64
+	chunkSamples []uint32
+	chunkGaps    []uint64 // The silence after each chunk (before the next one), in samples.
+	total        uint64   // The total number of samples (for G.711, this is also the number of bytes).
+	end          uint64   // The time that the last chunk ends, in samples since the start (including the gaps).
+}
+
+// NewWriter starts a new MP4 file.
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	var b boxBuilder
+	b.start("ftyp")
+	b.write([]byte("isom"))
+	b.u32(0x200)
+	for _, brand := range []string{"isom", "iso2", "avc1", "mp41"} {
+		b.write([]byte(brand))
+	}
+	b.end()
+	// The "mdat" box always uses a 64-bit size so that Close can patch it in.
+	b.u32(1)
+	b.write([]byte("mdat"))
+	b.u64(0)
+
+	_, err = w.Write(b.bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &Writer{
+		w:      w,
+		offset: start + int64(len(b.bytes())),
+	}
+	writer.mdatStart = writer.offset - 16
+	return writer, nil
+}
+
+func (w *Writer) setBase(timestamp time.Duration) {
+	if !w.haveBase {
+		w.base = timestamp
+		w.haveBase = true
+	}
+}
+
+func (w *Writer) writeData(data []byte) (uint64, error) {
+	offset := w.offset
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(offset), nil
+}
+
+// WriteVideo writes a single video access unit, given in Annex-B format.
+//
+// Frames before the first key frame (with its parameter sets) cannot be decoded, so they are dropped.  The
+// returned value indicates whether the frame was written.
+func (w *Writer) WriteVideo(codec VideoCodec, timestamp time.Duration, annexB []byte) (bool, error) {
+	if w.closed {
+		return false, fmt.Errorf("writer is closed")
+	}
+	nalus := SplitAnnexB(annexB)
+
+	if w.video == nil {
+		w.video = &videoTrack{config: videoConfig{codec: codec}}
+	}
+	track := w.video
+	if track.config.codec != codec {
+		return false, fmt.Errorf("video codec changed from %s to %s", track.config.codec, codec)
+	}
+	key := track.config.observe(nalus)
+	if len(track.times) == 0 && (!key || !track.config.ready()) {
+		return false, nil
+	}
+
+	sample := track.config.sample(nalus)
+	if len(sample) == 0 {
+		return false, nil
+	}
+
+	if len(track.times) == 0 {
+		w.setBase(timestamp)
+		track.start = timestamp
+	}
+	ticks := durationToTicks(timestamp-track.start, videoTimescale)
+	if len(track.times) > 0 && ticks <= track.times[len(track.times)-1] {
+		// Timestamps must increase; nudge this one forward.
+		ticks = track.times[len(track.times)-1] + 1
+	}
+
+	offset, err := w.writeData(sample)
+	if err != nil {
+		return false, err
+	}
+	track.times = append(track.times, ticks)
+	track.sizes = append(track.sizes, uint32(len(sample)))
+	track.offsets = append(track.offsets, offset)
+	if key {
+		track.keys = append(track.keys, uint32(len(track.times)))
+	}
+	return true, nil
+}
+
+// WriteAudio writes a packet of G.711 audio (one byte per sample).
+//
+// Packets are expected to follow each other, but if a packet starts noticeably after the one before it ends (such
+// as when a packet was dropped), then the time in between is left as silence so that the audio stays in sync.
+func (w *Writer) WriteAudio(codec AudioCodec, sampleRate int, timestamp time.Duration, data []byte) error {
+	if w.closed {
+		return fmt.Errorf("writer is closed")
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	// The first packet is the start of the track (and, if it comes before any video, the start of the file).
+	if w.audio == nil {
+		w.audio = &audioTrack{
+			config: audioConfig{codec: codec, sampleRate: sampleRate},
+			start:  timestamp,
+		}
+		w.setBase(timestamp)
+	}
+	track := w.audio
+	if track.config.codec != codec || track.config.sampleRate != sampleRate {
+		return fmt.Errorf("audio format changed")
+	}
+
+	// The last sample before a gap lasts until this packet starts.
+	position := durationToTicks(timestamp-track.start, uint32(sampleRate))
+	if len(track.chunkSamples) > 0 && position > track.end+durationToTicks(audioGapTolerance, uint32(sampleRate)) {
+		gap := position - track.end
+		track.chunkGaps[len(track.chunkGaps)-1] += gap
+		track.end += gap
+	}
+
+	offset, err := w.writeData(data)
+	if err != nil {
+		return err
+	}
+	track.chunkOffsets = append(track.chunkOffsets, offset)
+	track.chunkSamples = append(track.chunkSamples, uint32(len(data)))
+	track.chunkGaps = append(track.chunkGaps, 0)
+	track.total += uint64(len(data))
+	track.end += uint64(len(data))
+	return nil
+}
+
+// AddChapter adds a chapter marker; these are written as a Nero-style "chpl" box, which most players understand.
+func (w *Writer) AddChapter(chapter Chapter) {
+	w.chapters = append(w.chapters, chapter)
+}
+
+// Close writes the index and finishes the file.  It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	hasVideo := w.video != nil && len(w.video.times) > 0
+	hasAudio := w.audio != nil && w.audio.total > 0
+
+	// Patch the size of the "mdat" box.
+	_, err := w.w.Seek(w.mdatStart+8, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(w.offset-w.mdatStart))
+	_, err = w.w.Write(size[:])
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Seek(w.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	if !hasVideo && !hasAudio {
+		return ErrNoSamples
+	}
+
+	var b boxBuilder
+	b.start("moov")
+
+	var movieDuration uint64
+	var tracks []func(trackID uint32) error
+	if hasVideo {
+		track := w.video
+		mediaDuration := track.duration()
+		delay := durationToTicks(track.start-w.base, movieTimescale)
+		total := delay + mediaDuration*movieTimescale/videoTimescale
+		if total > movieDuration {
+			movieDuration = total
+		}
+		tracks = append(tracks, func(trackID uint32) error {
+			return writeTrak(&b, trackID, "vide", videoTimescale, mediaDuration, delay, track.config.width, track.config.height, func() error {
+				return track.config.writeSampleEntry(&b)
+			}, func() {
+				track.writeSampleTables(&b)
+			})
+		})
+	}
+	if hasAudio {
+		track := w.audio
+		mediaDuration := track.end
+		delay := durationToTicks(track.start-w.base, movieTimescale)
+		total := delay + mediaDuration*movieTimescale/uint64(track.config.sampleRate)
+		if total > movieDuration {
+			movieDuration = total
+		}
+		tracks = append(tracks, func(trackID uint32) error {
+			return writeTrak(&b, trackID, "soun", uint32(track.config.sampleRate), mediaDuration, delay, 0, 0, func() error {
+				track.config.writeSampleEntry(&b)
+				return nil
+			}, func() {
+				track.writeSampleTables(&b)
+			})
+		})
+	}
+
+	writeMVHD(&b, movieDuration, uint32(len(tracks)+1))
+	for i, writeTrack := range tracks {
+		err = writeTrack(uint32(i + 1))
+		if err != nil {
+			return err
+		}
+	}
+	if len(w.chapters) > 0 {
+		w.writeChapters(&b)
+	}
+	b.end()
+
+	_, err = w.w.Write(b.bytes())
+	return err
+}
+
+// Duration returns the duration of the video track written so far (or the audio track, if there is no video).
+func (w *Writer) Duration() time.Duration {
+	if w.video != nil && len(w.video.times) > 0 {
+		return ticksToDuration(w.video.duration(), videoTimescale)
+	}
+	if w.audio != nil && w.audio.total > 0 {
+		return ticksToDuration(w.audio.end, uint32(w.audio.config.sampleRate))
+	}
+	return 0
+}
+
+func (w *Writer) writeChapters(b *boxBuilder) {
+	chapters := w.chapters
+	if len(chapters) > 255 {
+		chapters = chapters[:255]
+	}
+	b.start("udta")
+	b.startFull("chpl", 1, 0)
+	b.u32(0)
+	b.u8(uint8(len(chapters)))
+	for _, chapter := range chapters {
+		start := chapter.Time - w.base
+		if start < 0 {
+			start = 0
+		}
+		b.u64(uint64(start / 100)) // In 100-nanosecond units.
+		title := chapter.Title
+		if len(title) > 255 {
+			title = title[:255]
+		}
+		b.u8(uint8(len(title)))
+		b.write([]byte(title))
+	}
+	b.end()
+	b.end()
+}
+
+// duration returns the total duration, in ticks; the last sample lasts as long as the one before it.
+func (t *videoTrack) duration() uint64 {
+	return t.times[len(t.times)-1] + t.lastSampleDuration()
+}
+
+func (t *videoTrack) lastSampleDuration() uint64 {
+	if len(t.times) >= 2 {
+		return t.times[len(t.times)-1] - t.times[len(t.times)-2]
+	}
+	return defaultFrameDuration
+}
+
+func (t *videoTrack) writeSampleTables(b *boxBuilder) {
+	// Time to sample, run-length encoded.
+	type run struct {
+		count uint32
+		delta uint32
+	}
+	var runs []run
+	for i := range t.times {
+		var delta uint64
+		if i+1 < len(t.times) {
+			delta = t.times[i+1] - t.times[i]
+		} else {
+			delta = t.lastSampleDuration()
+		}
+		if len(runs) > 0 && runs[len(runs)-1].delta == uint32(delta) {
+			runs[len(runs)-1].count++
+		} else {
+			runs = append(runs, run{count: 1, delta: uint32(delta)})
+		}
+	}
+	b.startFull("stts", 0, 0)
+	b.u32(uint32(len(runs)))
+	for _, r := range runs {
+		b.u32(r.count)
+		b.u32(r.delta)
+	}
+	b.end()
+
+	b.startFull("stss", 0, 0)
+	b.u32(uint32(len(t.keys)))
+	for _, key := range t.keys {
+		b.u32(key)
+	}
+	b.end()
+
+	// Every sample is its own chunk.
+	b.startFull("stsc", 0, 0)
+	b.u32(1)
+	b.u32(1)
+	b.u32(1)
+	b.u32(1)
+	b.end()
+
+	b.startFull("stsz", 0, 0)
+	b.u32(0)
+	b.u32(uint32(len(t.sizes)))
+	for _, size := range t.sizes {
+		b.u32(size)
+	}
+	b.end()
+
+	writeChunkOffsets(b, t.offsets)
+}
+
+func (t *audioTrack) writeSampleTables(b *boxBuilder) {
+	// Time to sample, run-length encoded: every sample lasts one tick, except for the last one before a gap.
+	type timeRun struct {
+		count uint32
+		delta uint32
+	}
+	var timeRuns []timeRun
+	addTimeRun := func(count uint32, delta uint64) {
+		if count == 0 {
+			return
+		}
+		if len(timeRuns) > 0 && timeRuns[len(timeRuns)-1].delta == uint32(delta) {
+			timeRuns[len(timeRuns)-1].count += count
+		} else {
+			timeRuns = append(timeRuns, timeRun{count: count, delta: uint32(delta)})
+		}
+	}
+	for i, samples := range t.chunkSamples {
+		if t.chunkGaps[i] == 0 {
+			addTimeRun(samples, 1)
+			continue
+		}
+		addTimeRun(samples-1, 1)
+		addTimeRun(1, 1+t.chunkGaps[i])
+	}
+	b.startFull("stts", 0, 0)
+	b.u32(uint32(len(timeRuns)))
+	for _, r := range timeRuns {
+		b.u32(r.count)
+		b.u32(r.delta)
+	}
+	b.end()
+
+	// Each packet is a chunk; consecutive chunks with the same number of samples share an entry.
+	type run struct {
+		firstChunk uint32
+		samples    uint32
+	}
+	var runs []run
+	for i, samples := range t.chunkSamples {
+		if len(runs) > 0 && runs[len(runs)-1].samples == samples {
+			continue
+		}
+		runs = append(runs, run{firstChunk: uint32(i + 1), samples: samples})
+	}
+	b.startFull("stsc", 0, 0)
+	b.u32(uint32(len(runs)))
+	for _, r := range runs {
+		b.u32(r.firstChunk)
+		b.u32(r.samples)
+		b.u32(1)
+	}
+	b.end()
+
+	// G.711 samples are always one byte.
+	b.startFull("stsz", 0, 0)
+	b.u32(1)
+	b.u32(uint32(t.total))
+	b.end()
+
+	writeChunkOffsets(b, t.chunkOffsets)
+}
+
+func writeChunkOffsets(b *boxBuilder, offsets []uint64) {
+	large := len(offsets) > 0 && offsets[len(offsets)-1] > 0xffffffff
+	if large {
+		b.startFull("co64", 0, 0)
+	} else {
+		b.startFull("stco", 0, 0)
+	}
+	b.u32(uint32(len(offsets)))
+	for _, offset := range offsets {
+		if large {
+			b.u64(offset)
+		} else {
+			b.u32(uint32(offset))
+		}
+	}
+	b.end()
+}
+
+func writeMVHD(b *boxBuilder, duration uint64, nextTrackID uint32) {
+	b.startFull("mvhd", 1, 0)
+	b.u64(0) // creation_time
+	b.u64(0) // modification_time
+	b.u32(movieTimescale)
+	b.u64(duration)
+	b.u32(0x00010000) // rate
+	b.u16(0x0100)     // volume
+	b.zeros(10)
+	b.matrix()
+	b.zeros(24)
+	b.u32(nextTrackID)
+	b.end()
+}
+
+// writeTrak writes a complete track box.  The delay (in movie ticks) becomes an empty edit so that the
+// tracks stay in sync when they don't start at the same time.
+func writeTrak(b *boxBuilder, trackID uint32, handler string, timescale uint32, mediaDuration uint64, delay uint64, width int, height int, writeSampleEntry func() error, writeSampleTables func()) error {
+	movieDuration := mediaDuration * movieTimescale / uint64(timescale)
+
+	b.start("trak")
+
+	b.startFull("tkhd", 1, 0x000003) // Enabled and in the movie.
+	b.u64(0)
+	b.u64(0)
+	b.u32(trackID)
+	b.u32(0)
+	b.u64(delay + movieDuration)
+	b.zeros(8)
+	b.u16(0) // layer
+	b.u16(0) // alternate_group
+	if handler == "soun" {
+		b.u16(0x0100)
+	} else {
+		b.u16(0)
+	}
+	b.u16(0)
+	b.matrix()
+	b.u32(uint32(width) << 16)
+	b.u32(uint32(height) << 16)
+	b.end()
+
+	b.start("edts")
+	b.startFull("elst", 1, 0)
+	if delay > 0 {
+		b.u32(2)
+		b.u64(delay)
+		b.u64(0xffffffffffffffff) // media_time = -1; an empty edit.
+		b.u32(0x00010000)
+	} else {
+		b.u32(1)
+	}
+	b.u64(movieDuration)
+	b.u64(0)
+	b.u32(0x00010000)
+	b.end()
+	b.end()
+
+	b.start("mdia")
+
+	b.startFull("mdhd", 1, 0)
+	b.u64(0)
+	b.u64(0)
+	b.u32(timescale)
+	b.u64(mediaDuration)
+	b.u16(0x55c4) // "und"
+	b.u16(0)
+	b.end()
+
+	writeHDLR(b, handler)
+
+	b.start("minf")
+	if handler == "soun" {
+		b.startFull("smhd", 0, 0)
+		b.u32(0)
+		b.end()
+	} else {
+		b.startFull("vmhd", 0, 1)
+		b.zeros(8)
+		b.end()
+	}
+	writeDINF(b)
+
+	b.start("stbl")
+	b.startFull("stsd", 0, 0)
+	b.u32(1)
+	err := writeSampleEntry()
+	if err != nil {
+		return err
+	}
+	b.end()
+	writeSampleTables()
+	b.end() // stbl
+
+	b.end() // minf
+	b.end() // mdia
+	b.end() // trak
+	return nil
+}
+
+func writeHDLR(b *boxBuilder, handler string) {
+	b.startFull("hdlr", 0, 0)
+	b.u32(0)
+	b.write([]byte(handler))
+	b.zeros(12)
+	if handler == "soun" {
+		b.write([]byte("SoundHandler\x00"))
+	} else {
+		b.write([]byte("VideoHandler\x00"))
+	}
+	b.end()
+}
+
+func writeDINF(b *boxBuilder) {
+	b.start("dinf")
+	b.startFull("dref", 0, 0)
+	b.u32(1)
+	b.startFull("url ", 0, 1) // The data is in this file.
+	b.end()
+	b.end()
+	b.end()
+}
+
+func durationToTicks(d time.Duration, timescale uint32) uint64 {
+	if d < 0 {
+		return 0
+	}
+	return uint64(d) * uint64(timescale) / uint64(time.Second)
+}
+
+func ticksToDuration(ticks uint64, timescale uint32) time.Duration {
+	return time.Duration(ticks * uint64(time.Second) / uint64(timescale))
+}
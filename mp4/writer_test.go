@@ -0,0 +1,320 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// These are the NAL units of a 320x240 H.264 baseline stream (with Annex-B start codes).
+var (
+	testSPS    = []byte{0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4}
+	testPPS    = []byte{0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80}
+	testIDR    = []byte{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00, 0x33}
+	testNonIDR = []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x04}
+)
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	data     []byte
+	position int64
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if end := b.position + int64(len(p)); end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	copy(b.data[b.position:], p)
+	b.position += int64(len(p))
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.position = offset
+	case io.SeekCurrent:
+		b.position += offset
+	case io.SeekEnd:
+		b.position = int64(len(b.data)) + offset
+	}
+	return b.position, nil
+}
+
+type box struct {
+	boxType string
+	payload []byte
+}
+
+// readBoxes splits data into its boxes.
+func readBoxes(t *testing.T, data []byte) []box {
+	var boxes []box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated box header: %x", data)
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerSize := uint64(8)
+		if size == 1 {
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			t.Fatalf("bad size for %q: %d", data[4:8], size)
+		}
+		boxes = append(boxes, box{boxType: string(data[4:8]), payload: data[headerSize:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+// findBox returns the payload of the first box along the path.
+func findBox(t *testing.T, data []byte, path ...string) []byte {
+	for _, boxType := range path {
+		var found bool
+		for _, b := range readBoxes(t, data) {
+			if b.boxType == boxType {
+				data = b.payload
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("no %q box in the path %v", boxType, path)
+		}
+		// Skip the fields that come before the child boxes.
+		switch boxType {
+		case "stsd":
+			data = data[8:]
+		case "avc1", "hvc1":
+			data = data[78:]
+		}
+	}
+	return data
+}
+
+// findTrack returns the payload of the "stbl" box of the track with the given handler.
+func findTrack(t *testing.T, file []byte, handler string) []byte {
+	for _, b := range readBoxes(t, findBox(t, file, "moov")) {
+		if b.boxType != "trak" {
+			continue
+		}
+		if string(findBox(t, b.payload, "mdia", "hdlr")[8:12]) == handler {
+			return findBox(t, b.payload, "mdia", "minf", "stbl")
+		}
+	}
+	t.Fatalf("no %q track", handler)
+	return nil
+}
+
+// tableValues returns the 32-bit values of a sample table, after its version and flags.
+func tableValues(t *testing.T, stbl []byte, boxType string) []uint32 {
+	payload := findBox(t, stbl, boxType)[4:]
+	values := make([]uint32, len(payload)/4)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint32(payload[i*4:])
+	}
+	return values
+}
+
+func checkValues(t *testing.T, name string, actual []uint32, expected ...uint32) {
+	t.Helper()
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("wrong %s: %v (expected %v)", name, actual, expected)
+	}
+}
+
+// mp4Sample returns the MP4 sample for an access unit.
+func mp4Sample(nalus ...[]byte) []byte {
+	var sample []byte
+	for _, nalu := range nalus {
+		nalu = bytes.TrimPrefix(nalu, []byte{0, 0, 0, 1})
+		sample = binary.BigEndian.AppendUint32(sample, uint32(len(nalu)))
+		sample = append(sample, nalu...)
+	}
+	return sample
+}
+
+func TestWriter(t *testing.T) {
+	var output seekBuffer
+	writer, err := NewWriter(&output)
+	if err != nil {
+		t.Fatalf("could not start the file: %v", err)
+	}
+
+	video := []struct {
+		timestamp time.Duration
+		nalus     [][]byte
+		written   bool
+	}{
+		{960 * time.Millisecond, [][]byte{testNonIDR}, false}, // Before the first key frame.
+		{1000 * time.Millisecond, [][]byte{testSPS, testPPS, testIDR}, true},
+		{1040 * time.Millisecond, [][]byte{testNonIDR}, true},
+		{1080 * time.Millisecond, [][]byte{testNonIDR}, true},
+		{1120 * time.Millisecond, [][]byte{testSPS, testPPS, testIDR}, true},
+		{1160 * time.Millisecond, [][]byte{testNonIDR}, true},
+	}
+	audio := bytes.Repeat([]byte{0xd5}, 160) // 20ms at 8kHz.
+	for i, frame := range video {
+		written, err := writer.WriteVideo(VideoCodecH264, frame.timestamp, bytes.Join(frame.nalus, nil))
+		if err != nil {
+			t.Fatalf("frame %d: could not write: %v", i, err)
+		}
+		if written != frame.written {
+			t.Errorf("frame %d: written: %t (expected %t)", i, written, frame.written)
+		}
+		if !frame.written {
+			continue
+		}
+		for _, offset := range []time.Duration{0, 20 * time.Millisecond} {
+			err = writer.WriteAudio(AudioCodecG711ALaw, 8000, frame.timestamp+offset, audio)
+			if err != nil {
+				t.Fatalf("frame %d: could not write the audio: %v", i, err)
+			}
+		}
+	}
+	if duration := writer.Duration(); duration != 200*time.Millisecond {
+		t.Errorf("wrong duration: %s", duration)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+	file := output.data
+
+	if boxes := readBoxes(t, file); len(boxes) != 3 || boxes[0].boxType != "ftyp" || boxes[1].boxType != "mdat" || boxes[2].boxType != "moov" {
+		t.Fatalf("wrong top-level boxes")
+	}
+
+	stbl := findTrack(t, file, "vide")
+	avc1 := findBox(t, stbl, "stsd")
+	if len(avc1) < 8 || string(avc1[4:8]) != "avc1" {
+		t.Fatalf("missing avc1 sample entry")
+	}
+	if width, height := binary.BigEndian.Uint16(avc1[8+24:]), binary.BigEndian.Uint16(avc1[8+26:]); width != 320 || height != 240 {
+		t.Errorf("wrong size: %dx%d", width, height)
+	}
+	avcC := findBox(t, stbl, "stsd", "avc1", "avcC")
+	expectedAVCC := []byte{1, 0x42, 0xc0, 0x1e, 0xff, 0xe1, 0, 8}
+	expectedAVCC = append(expectedAVCC, testSPS[4:]...)
+	expectedAVCC = append(expectedAVCC, 1, 0, 4)
+	expectedAVCC = append(expectedAVCC, testPPS[4:]...)
+	if !bytes.Equal(avcC, expectedAVCC) {
+		t.Errorf("wrong avcC: %x (expected %x)", avcC, expectedAVCC)
+	}
+	checkValues(t, "video stts", tableValues(t, stbl, "stts"), 1, 5, 40*90)
+	checkValues(t, "video stss", tableValues(t, stbl, "stss"), 2, 1, 4)
+
+	stsz := tableValues(t, stbl, "stsz")
+	stco := tableValues(t, stbl, "stco")
+	checkValues(t, "video stsz header", stsz[:2], 0, 5)
+	if len(stco) != 6 || stco[0] != 5 {
+		t.Fatalf("wrong video stco: %v", stco)
+	}
+	for i, frame := range video[1:] {
+		sample := file[stco[i+1] : stco[i+1]+stsz[i+2]]
+		if expected := mp4Sample(frame.nalus...); !bytes.Equal(sample, expected) {
+			t.Errorf("sample %d: wrong data: %x (expected %x)", i, sample, expected)
+		}
+	}
+
+	stbl = findTrack(t, file, "soun")
+	checkValues(t, "audio stts", tableValues(t, stbl, "stts"), 1, 1600, 1)
+	checkValues(t, "audio stsc", tableValues(t, stbl, "stsc"), 1, 1, 160, 1)
+	checkValues(t, "audio stsz", tableValues(t, stbl, "stsz"), 1, 1600)
+	stco = tableValues(t, stbl, "stco")
+	if len(stco) != 11 || stco[0] != 10 {
+		t.Fatalf("wrong audio stco: %v", stco)
+	}
+	for i, offset := range stco[1:] {
+		if !bytes.Equal(file[offset:offset+160], audio) {
+			t.Errorf("chunk %d: wrong data", i)
+		}
+	}
+}
+
+func TestWriterAudioGap(t *testing.T) {
+	var output seekBuffer
+	writer, err := NewWriter(&output)
+	if err != nil {
+		t.Fatalf("could not start the file: %v", err)
+	}
+
+	audio := bytes.Repeat([]byte{0xd5}, 160) // 20ms at 8kHz.
+	for _, timestamp := range []time.Duration{
+		5000 * time.Millisecond,
+		5020 * time.Millisecond,
+		5100 * time.Millisecond, // Three packets are missing.
+		5125 * time.Millisecond, // This is only jitter.
+	} {
+		err = writer.WriteAudio(AudioCodecG711ALaw, 8000, timestamp, audio)
+		if err != nil {
+			t.Fatalf("could not write the audio: %v", err)
+		}
+	}
+	if duration := writer.Duration(); duration != 140*time.Millisecond {
+		t.Errorf("wrong duration: %s", duration)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+
+	// The last sample before the gap lasts until the next packet.
+	stbl := findTrack(t, output.data, "soun")
+	checkValues(t, "stts", tableValues(t, stbl, "stts"), 3, 319, 1, 1, 481, 320, 1)
+	checkValues(t, "stsz", tableValues(t, stbl, "stsz"), 1, 640)
+	if stco := tableValues(t, stbl, "stco"); len(stco) != 5 || stco[0] != 4 {
+		t.Errorf("wrong stco: %v", stco)
+	}
+	if mdhd := findBox(t, output.data, "moov", "trak", "mdia", "mdhd"); binary.BigEndian.Uint64(mdhd[24:]) != 1120 {
+		t.Errorf("wrong media duration: %d", binary.BigEndian.Uint64(mdhd[24:]))
+	}
+}
+
+func TestWriterTruncatedSPS(t *testing.T) {
+	// A damaged SPS never starts the stream.
+	var output seekBuffer
+	writer, err := NewWriter(&output)
+	if err != nil {
+		t.Fatalf("could not start the file: %v", err)
+	}
+	written, err := writer.WriteVideo(VideoCodecH264, 0, bytes.Join([][]byte{testSPS[:6], testPPS, testIDR}, nil))
+	if err != nil || written {
+		t.Errorf("a frame with a damaged SPS was written: %t, %v", written, err)
+	}
+	if err := writer.Close(); !errors.Is(err, ErrNoSamples) {
+		t.Errorf("expected no samples; got: %v", err)
+	}
+
+	// No amount of truncation is a panic.
+	for length := 4; length < len(testSPS); length++ {
+		sps := testSPS[:length]
+		if _, _, err := h264SPSSize(sps[4:]); err == nil {
+			t.Errorf("SPS of length %d: expected an error", length)
+		}
+		annexB := bytes.Join([][]byte{sps, testPPS, testIDR}, nil)
+
+		var output seekBuffer
+		writer, err := NewWriter(&output)
+		if err != nil {
+			t.Fatalf("could not start the file: %v", err)
+		}
+		if _, err := writer.WriteVideo(VideoCodecH264, 0, annexB); err != nil {
+			t.Errorf("SPS of length %d: could not write: %v", length, err)
+		}
+		_ = writer.Close()
+
+		fragmenter := NewFragmenter(VideoCodecH264)
+		if _, err := fragmenter.WriteVideo(0, annexB); err != nil {
+			t.Errorf("SPS of length %d: could not fragment: %v", length, err)
+		}
+		if fragmenter.InitSegment() != nil {
+			t.Errorf("SPS of length %d: the stream started", length)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package notify
+
+import "fmt"
+
+// Config describes the notifiers to use; it is meant to be stored in a JSON configuration file.
+type Config struct {
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+	SMTP     []SMTPConfig    `json:"smtp,omitempty"`
+}
+
+type WebhookConfig struct {
+	URL             string `json:"url"`
+	Secret          string `json:"secret,omitempty"`
+	MessageTemplate string `json:"messageTemplate,omitempty"`
+}
+
+type SMTPConfig struct {
+	Address         string   `json:"address"`
+	Username        string   `json:"username,omitempty"`
+	Password        string   `json:"password,omitempty"`
+	From            string   `json:"from"`
+	To              []string `json:"to"`
+	SubjectTemplate string   `json:"subjectTemplate,omitempty"`
+	MessageTemplate string   `json:"messageTemplate,omitempty"`
+}
+
+// Notifiers returns the notifiers described by the configuration.
+func (c Config) Notifiers() ([]Notifier, error) {
+	var notifiers []Notifier
+	for i, webhook := range c.Webhooks {
+		if webhook.URL == "" {
+			return nil, fmt.Errorf("webhook %d: missing URL", i)
+		}
+		notifiers = append(notifiers, &WebhookNotifier{
+			URL:             webhook.URL,
+			Secret:          webhook.Secret,
+			MessageTemplate: webhook.MessageTemplate,
+		})
+	}
+	for i, smtp := range c.SMTP {
+		if smtp.Address == "" {
+			return nil, fmt.Errorf("smtp %d: missing address", i)
+		}
+		if smtp.From == "" {
+			return nil, fmt.Errorf("smtp %d: missing from address", i)
+		}
+		if len(smtp.To) == 0 {
+			return nil, fmt.Errorf("smtp %d: missing to addresses", i)
+		}
+		notifiers = append(notifiers, &SMTPNotifier{
+			Address:         smtp.Address,
+			Username:        smtp.Username,
+			Password:        smtp.Password,
+			From:            smtp.From,
+			To:              smtp.To,
+			SubjectTemplate: smtp.SubjectTemplate,
+			MessageTemplate: smtp.MessageTemplate,
+		})
+	}
+	return notifiers, nil
+}
@@ -0,0 +1,136 @@
+// Package notify sends notifications when AutoDownload tasks fail.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// Notification describes a task channel (or a task without any channels) that has entered a failure state.
+type Notification struct {
+	Time           time.Time            `json:"time"`
+	TaskID         int                  `json:"taskId"`
+	TaskName       string               `json:"taskName"`
+	DeviceID       string               `json:"deviceId"`
+	CarLicense     string               `json:"carLicense"`
+	Channel        int                  `json:"channel"`               // The one-index of the channel; zero for the task as a whole.
+	ChannelName    string               `json:"channelName,omitempty"` // The name of the channel, if it has one.
+	Date           string               `json:"date,omitempty"`        // yyyy-mm-dd
+	StartTime      string               `json:"startTime,omitempty"`   // hh:mm:ss
+	EndTime        string               `json:"endTime,omitempty"`     // hh:mm:ss
+	Status         angeltrax.TaskStatus `json:"status"`
+	StatusName     string               `json:"statusName"`
+	PreviousStatus angeltrax.TaskStatus `json:"previousStatus"`
+	Error          string               `json:"error,omitempty"`
+	Message        string               `json:"message,omitempty"` // This is filled in from the message template.
+}
+
+// Notifier sends notifications somewhere.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// ShouldNotify returns true if the status is one that people need to hear about.
+//
+// In addition to the failures, this includes TaskStatusNoFiles, since that usually means that the task was set up wrong.
+func ShouldNotify(status angeltrax.TaskStatus) bool {
+	return status.IsFailure() || status == angeltrax.TaskStatusNoFiles
+}
+
+const (
+	DefaultSubjectTemplate = `[angeltrax] {{.CarLicense}}: task "{{.TaskName}}"{{if .Channel}} channel {{.Channel}}{{end}}: {{.StatusName}}`
+	DefaultMessageTemplate = `Task #{{.TaskID}} ("{{.TaskName}}") for device {{.DeviceID}} ({{.CarLicense}}) is now {{.StatusName}}.
+
+{{if .Channel}}Channel: {{.Channel}}{{if .ChannelName}} ({{.ChannelName}}){{end}}
+{{end}}{{if .Date}}Window: {{.Date}} {{.StartTime}} - {{.EndTime}}
+{{end}}{{if .Error}}Error: {{.Error}}
+{{end}}`
+)
+
+// render executes a template against the notification.
+func render(name string, text string, notification Notification) (string, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("could not parse %s template: %w", name, err)
+	}
+	var buffer bytes.Buffer
+	err = t.Execute(&buffer, notification)
+	if err != nil {
+		return "", fmt.Errorf("could not execute %s template: %w", name, err)
+	}
+	return buffer.String(), nil
+}
+
+// Tracker remembers the status of every task channel and notifies when one transitions into a state
+// for which ShouldNotify is true.
+type Tracker struct {
+	Notifiers []Notifier
+	// NotifyInitial controls whether a channel that is already failed the first time that it is seen should
+	// result in a notification.
+	NotifyInitial bool
+
+	mutex    sync.Mutex
+	statuses map[string]angeltrax.TaskStatus
+}
+
+// Observe records the latest status of a task channel and sends the notification if needed.
+//
+// All of the notifiers are tried; the first error (if any) is returned.
+func (t *Tracker) Observe(ctx context.Context, notification Notification) error {
+	key := fmt.Sprintf("%d|%d|%s|%s", notification.TaskID, notification.Channel, notification.Date, notification.StartTime)
+
+	t.mutex.Lock()
+	if t.statuses == nil {
+		t.statuses = map[string]angeltrax.TaskStatus{}
+	}
+	previousStatus, seen := t.statuses[key]
+	t.statuses[key] = notification.Status
+	t.mutex.Unlock()
+
+	if seen && previousStatus == notification.Status {
+		return nil
+	}
+	if !seen && !t.NotifyInitial {
+		return nil
+	}
+	if !ShouldNotify(notification.Status) {
+		return nil
+	}
+	if seen {
+		notification.PreviousStatus = previousStatus
+	}
+
+	var firstErr error
+	for _, notifier := range t.Notifiers {
+		err := notifier.Notify(ctx, notification)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// FromProgressEvent creates a notification from a progress event.
+func FromProgressEvent(event angeltrax.TaskProgressEvent) Notification {
+	return Notification{
+		Time:           event.Time,
+		TaskID:         event.TaskID,
+		TaskName:       event.TaskName,
+		DeviceID:       event.DeviceID,
+		CarLicense:     event.CarLicense,
+		Channel:        event.Channel,
+		Date:           event.Row.Date,
+		StartTime:      event.Row.StartTime,
+		EndTime:        event.Row.EndTime,
+		Status:         event.Status,
+		StatusName:     event.Status.String(),
+		PreviousStatus: event.PreviousStatus,
+		Error:          event.Error,
+	}
+}
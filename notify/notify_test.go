@@ -0,0 +1,159 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+type recordingNotifier struct {
+	notifications []Notification
+	err           error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.notifications = append(n.notifications, notification)
+	return n.err
+}
+
+func TestShouldNotify(t *testing.T) {
+	for _, status := range []angeltrax.TaskStatus{
+		angeltrax.TaskStatusConnectionLimit,
+		angeltrax.TaskStatusInsufficientDisk,
+		angeltrax.TaskStatusNoFiles,
+		angeltrax.TaskStatusDownloadFailed,
+		angeltrax.TaskStatusDownloadFailed2,
+		angeltrax.TaskStatusTimeout,
+	} {
+		if !ShouldNotify(status) {
+			t.Errorf("expected a notification for %s", status)
+		}
+	}
+	for _, status := range []angeltrax.TaskStatus{
+		angeltrax.TaskStatusPaused,
+		angeltrax.TaskStatusWaiting,
+		angeltrax.TaskStatusAnalyzing,
+		angeltrax.TaskStatusDownloading,
+		angeltrax.TaskStatusFinished,
+		angeltrax.TaskStatusDelete,
+	} {
+		if ShouldNotify(status) {
+			t.Errorf("expected no notification for %s", status)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	ctx := context.Background()
+	notifier := &recordingNotifier{}
+	tracker := &Tracker{Notifiers: []Notifier{notifier}}
+
+	channel := func(channel int, status angeltrax.TaskStatus) Notification {
+		return Notification{TaskID: 1, Channel: channel, Date: "2023-04-05", StartTime: "06:00:00", Status: status}
+	}
+	steps := []struct {
+		notification Notification
+		notify       bool
+	}{
+		{channel(1, angeltrax.TaskStatusDownloadFailed), false}, // Already failed when first seen.
+		{channel(2, angeltrax.TaskStatusDownloading), false},
+		{channel(2, angeltrax.TaskStatusDownloadFailed), true},
+		{channel(2, angeltrax.TaskStatusDownloadFailed), false}, // No change.
+		{channel(2, angeltrax.TaskStatusDownloading), false},    // Recovered.
+		{channel(2, angeltrax.TaskStatusTimeout), true},
+		{channel(2, angeltrax.TaskStatusFinished), false},
+		{channel(3, angeltrax.TaskStatusWaiting), false},
+		{channel(3, angeltrax.TaskStatusNoFiles), true},
+	}
+	for i, step := range steps {
+		before := len(notifier.notifications)
+		err := tracker.Observe(ctx, step.notification)
+		if err != nil {
+			t.Fatalf("step %d: could not observe: %v", i, err)
+		}
+		if notified := len(notifier.notifications) > before; notified != step.notify {
+			t.Errorf("step %d: notified: %t (expected %t)", i, notified, step.notify)
+		}
+	}
+
+	if len(notifier.notifications) != 3 {
+		t.Fatalf("wrong number of notifications: %d", len(notifier.notifications))
+	}
+	if previous := notifier.notifications[0].PreviousStatus; previous != angeltrax.TaskStatusDownloading {
+		t.Errorf("wrong previous status: %s", previous)
+	}
+	if previous := notifier.notifications[2].PreviousStatus; previous != angeltrax.TaskStatusWaiting {
+		t.Errorf("wrong previous status: %s", previous)
+	}
+}
+
+func TestTrackerNotifyInitial(t *testing.T) {
+	ctx := context.Background()
+	notifier := &recordingNotifier{}
+	tracker := &Tracker{Notifiers: []Notifier{notifier}, NotifyInitial: true}
+
+	err := tracker.Observe(ctx, Notification{TaskID: 1, Channel: 1, Status: angeltrax.TaskStatusDownloading})
+	if err != nil {
+		t.Fatalf("could not observe: %v", err)
+	}
+	err = tracker.Observe(ctx, Notification{TaskID: 1, Channel: 2, Status: angeltrax.TaskStatusTimeout})
+	if err != nil {
+		t.Fatalf("could not observe: %v", err)
+	}
+	if len(notifier.notifications) != 1 || notifier.notifications[0].Channel != 2 {
+		t.Errorf("wrong notifications: %+v", notifier.notifications)
+	}
+}
+
+func TestTrackerErrors(t *testing.T) {
+	first := &recordingNotifier{err: errors.New("first")}
+	second := &recordingNotifier{err: errors.New("second")}
+	tracker := &Tracker{Notifiers: []Notifier{first, second}, NotifyInitial: true}
+
+	err := tracker.Observe(context.Background(), Notification{TaskID: 1, Channel: 1, Status: angeltrax.TaskStatusTimeout})
+	if err == nil || err.Error() != "first" {
+		t.Errorf("expected the first error; got: %v", err)
+	}
+	if len(first.notifications) != 1 || len(second.notifications) != 1 {
+		t.Errorf("not every notifier was tried")
+	}
+}
+
+func TestTrackerTaskWithoutChannels(t *testing.T) {
+	ctx := context.Background()
+	notifier := &recordingNotifier{}
+	tracker := &Tracker{Notifiers: []Notifier{notifier}, NotifyInitial: true}
+
+	// The device never came online, so the task failed without starting any channels.
+	for _, event := range []angeltrax.TaskProgressEvent{
+		{TaskID: 1, TaskName: "nightly", CarLicense: "BUS-1", Status: angeltrax.TaskStatusWaiting, Initial: true},
+		{TaskID: 1, TaskName: "nightly", CarLicense: "BUS-1", Status: angeltrax.TaskStatusTimeout, PreviousStatus: angeltrax.TaskStatusWaiting},
+	} {
+		err := tracker.Observe(ctx, FromProgressEvent(event))
+		if err != nil {
+			t.Fatalf("could not observe: %v", err)
+		}
+	}
+	if len(notifier.notifications) != 1 {
+		t.Fatalf("wrong number of notifications: %d", len(notifier.notifications))
+	}
+
+	notification := notifier.notifications[0]
+	subject, err := render("subject", DefaultSubjectTemplate, notification)
+	if err != nil {
+		t.Fatalf("could not render the subject: %v", err)
+	}
+	if subject != `[angeltrax] BUS-1: task "nightly": TaskStatusTimeout(8)` {
+		t.Errorf("wrong subject: %s", subject)
+	}
+	message, err := render("message", DefaultMessageTemplate, notification)
+	if err != nil {
+		t.Fatalf("could not render the message: %v", err)
+	}
+	if strings.Contains(message, "Channel") {
+		t.Errorf("the message names a channel: %q", message)
+	}
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPNotifier sends notifications as plain-text email.
+type SMTPNotifier struct {
+	Address         string // host:port
+	Username        string // If empty, no authentication is done.
+	Password        string
+	From            string
+	To              []string
+	SubjectTemplate string // If empty, DefaultSubjectTemplate is used.
+	MessageTemplate string // If empty, DefaultMessageTemplate is used.
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, notification Notification) error {
+	subjectTemplate := n.SubjectTemplate
+	if subjectTemplate == "" {
+		subjectTemplate = DefaultSubjectTemplate
+	}
+	messageTemplate := n.MessageTemplate
+	if messageTemplate == "" {
+		messageTemplate = DefaultMessageTemplate
+	}
+
+	subject, err := render("subject", subjectTemplate, notification)
+	if err != nil {
+		return err
+	}
+	message, err := render("message", messageTemplate, notification)
+	if err != nil {
+		return err
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", n.From)
+	fmt.Fprintf(&builder, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&builder, "Subject: %s\r\n", strings.NewReplacer("\r", "", "\n", " ").Replace(subject))
+	fmt.Fprintf(&builder, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&builder, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&builder, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&builder, "\r\n")
+	builder.WriteString(strings.ReplaceAll(strings.ReplaceAll(message, "\r\n", "\n"), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Address)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %q: %w", n.Address, err)
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	// smtp.SendMail doesn't take a context, so run it in the background and give up on it if the context ends.
+	result := make(chan error, 1)
+	go func() {
+		result <- smtp.SendMail(n.Address, auth, n.From, n.To, []byte(builder.String()))
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
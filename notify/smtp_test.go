@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpServer is a stand-in for a mail server; it accepts a single message.
+type smtpServer struct {
+	listener net.Listener
+	rejectTo string // If set, this recipient is refused.
+
+	done     chan struct{}
+	commands []string
+	auth     string // The decoded PLAIN credentials.
+	data     string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return &smtpServer{listener: listener, done: make(chan struct{})}
+}
+
+func (s *smtpServer) serve(t *testing.T) {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		t.Errorf("could not accept: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.commands = append(s.commands, line)
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				reply("501 bad auth")
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(fields[2])
+			if err != nil {
+				reply("501 bad auth")
+				continue
+			}
+			s.auth = string(decoded)
+			reply("235 ok")
+		case "MAIL":
+			reply("250 ok")
+		case "RCPT":
+			if s.rejectTo != "" && strings.Contains(line, "<"+s.rejectTo+">") {
+				reply("550 no such user")
+				continue
+			}
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data = data.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := newSMTPServer(t)
+	go server.serve(t)
+
+	notifier := &SMTPNotifier{
+		Address:  server.listener.Addr().String(),
+		Username: "user",
+		Password: "pass",
+		From:     "angeltrax@example.com",
+		To:       []string{"a@example.com", "b@example.com"},
+	}
+	err := notifier.Notify(context.Background(), testNotification())
+	if err != nil {
+		t.Fatalf("could not notify: %v", err)
+	}
+	<-server.done
+
+	if server.auth != "\x00user\x00pass" {
+		t.Errorf("wrong credentials: %q", server.auth)
+	}
+	var recipients []string
+	for _, command := range server.commands {
+		if strings.HasPrefix(command, "RCPT TO:") {
+			recipients = append(recipients, strings.TrimPrefix(command, "RCPT TO:"))
+		}
+	}
+	if strings.Join(recipients, ",") != "<a@example.com>,<b@example.com>" {
+		t.Errorf("wrong recipients: %v", recipients)
+	}
+
+	headers, body, found := strings.Cut(server.data, "\r\n\r\n")
+	if !found {
+		t.Fatalf("the message has no body: %q", server.data)
+	}
+	for _, header := range []string{
+		"From: angeltrax@example.com",
+		"To: a@example.com, b@example.com",
+		`Subject: [angeltrax] BUS-1: task "nightly" channel 2: TaskStatusDownloadFailed(4)`,
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(headers+"\r\n", header+"\r\n") {
+			t.Errorf("missing header %q in: %q", header, headers)
+		}
+	}
+	if !strings.Contains(body, "Error: the device is offline\r\n") {
+		t.Errorf("wrong body: %q", body)
+	}
+	if strings.Contains(strings.ReplaceAll(body, "\r\n", ""), "\n") {
+		t.Errorf("the body has bare line feeds: %q", body)
+	}
+}
+
+func TestSMTPNotifierRejected(t *testing.T) {
+	server := newSMTPServer(t)
+	server.rejectTo = "b@example.com"
+	go server.serve(t)
+
+	notifier := &SMTPNotifier{
+		Address: server.listener.Addr().String(),
+		From:    "angeltrax@example.com",
+		To:      []string{"a@example.com", "b@example.com"},
+	}
+	err := notifier.Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("expected the recipient to be refused; got: %v", err)
+	}
+	<-server.done
+}
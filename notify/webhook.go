@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// SignatureHeader holds the hex-encoded HMAC-SHA256 of the timestamp, a period, and the body, prefixed with "sha256=".
+	SignatureHeader = "X-Angeltrax-Signature"
+	// TimestampHeader holds the Unix time at which the webhook was sent.
+	TimestampHeader = "X-Angeltrax-Timestamp"
+)
+
+// WebhookNotifier posts notifications as JSON to a URL.
+//
+// If a secret is set, then every request is signed; see Sign.
+type WebhookNotifier struct {
+	URL             string
+	Secret          string
+	MessageTemplate string       // If empty, DefaultMessageTemplate is used.
+	HTTPClient      *http.Client // If nil, http.DefaultClient is used.
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	messageTemplate := n.MessageTemplate
+	if messageTemplate == "" {
+		messageTemplate = DefaultMessageTemplate
+	}
+	var err error
+	notification.Message, err = render("message", messageTemplate, notification)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		timestamp := strconv.FormatInt(notification.Time.Unix(), 10)
+		request.Header.Set(TimestampHeader, timestamp)
+		request.Header.Set(SignatureHeader, "sha256="+Sign(n.Secret, timestamp, body))
+	}
+
+	httpClient := n.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: http status %d", n.URL, response.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 signature of a webhook.
+//
+// Receivers should compute this from the timestamp header and the raw body and compare it (in constant
+// time) to the signature header.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if the signature header value matches the timestamp and body.
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	expected := "sha256=" + Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
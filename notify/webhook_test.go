@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"taskId":1}`)
+	signature := "sha256=" + Sign("secret", "1680674828", body)
+
+	if !Verify("secret", "1680674828", body, signature) {
+		t.Errorf("the signature did not verify")
+	}
+	if Verify("other", "1680674828", body, signature) {
+		t.Errorf("the signature verified with the wrong secret")
+	}
+	if Verify("secret", "1680674829", body, signature) {
+		t.Errorf("the signature verified with the wrong timestamp")
+	}
+	if Verify("secret", "1680674828", []byte(`{"taskId":2}`), signature) {
+		t.Errorf("the signature verified with the wrong body")
+	}
+	if Verify("secret", "1680674828", body, strings.TrimPrefix(signature, "sha256=")) {
+		t.Errorf("the signature verified without its prefix")
+	}
+}
+
+func testNotification() Notification {
+	return Notification{
+		Time:           time.Unix(1680674828, 0),
+		TaskID:         12,
+		TaskName:       "nightly",
+		DeviceID:       "D1",
+		CarLicense:     "BUS-1",
+		Channel:        2,
+		Date:           "2023-04-05",
+		StartTime:      "06:00:00",
+		EndTime:        "07:00:00",
+		Status:         angeltrax.TaskStatusDownloadFailed,
+		StatusName:     angeltrax.TaskStatusDownloadFailed.String(),
+		PreviousStatus: angeltrax.TaskStatusDownloading,
+		Error:          "the device is offline",
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received Notification
+	var verified bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("could not read the body: %v", err)
+			return
+		}
+		if r.Method != http.MethodPost {
+			t.Errorf("wrong method: %s", r.Method)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("wrong content type: %s", contentType)
+		}
+		verified = Verify("secret", r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader))
+		err = json.Unmarshal(body, &received)
+		if err != nil {
+			t.Errorf("could not decode the body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{
+		URL:             server.URL,
+		Secret:          "secret",
+		MessageTemplate: "{{.CarLicense}} channel {{.Channel}}: {{.Error}}",
+	}
+	err := notifier.Notify(context.Background(), testNotification())
+	if err != nil {
+		t.Fatalf("could not notify: %v", err)
+	}
+	if !verified {
+		t.Errorf("the signature did not verify")
+	}
+	if received.TaskID != 12 || received.Channel != 2 || received.Status != angeltrax.TaskStatusDownloadFailed {
+		t.Errorf("wrong notification: %+v", received)
+	}
+	if received.Message != "BUS-1 channel 2: the device is offline" {
+		t.Errorf("wrong message: %q", received.Message)
+	}
+}
+
+func TestWebhookNotifierUnsigned(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL}
+	err := notifier.Notify(context.Background(), testNotification())
+	if err != nil {
+		t.Fatalf("could not notify: %v", err)
+	}
+	if headers.Get(SignatureHeader) != "" || headers.Get(TimestampHeader) != "" {
+		t.Errorf("the webhook was signed without a secret: %v", headers)
+	}
+}
+
+func TestWebhookNotifierTimestamp(t *testing.T) {
+	var timestamp string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp = r.Header.Get(TimestampHeader)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL, Secret: "secret"}
+	err := notifier.Notify(context.Background(), testNotification())
+	if err != nil {
+		t.Fatalf("could not notify: %v", err)
+	}
+	if timestamp != strconv.FormatInt(testNotification().Time.Unix(), 10) {
+		t.Errorf("wrong timestamp: %q", timestamp)
+	}
+}
+
+func TestWebhookNotifierStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL}
+	err := notifier.Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("expected an http status error; got: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Package partfile writes files by way of a temporary ".part" file, so that a write that fails part of the way
+// through never leaves a broken file (or a missing one, if there was one before) behind.
+package partfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Write creates the file's directory (if needed) and calls write with a new "<filename>.part" file.  If write
+// succeeds, then the part file replaces the file; otherwise, the part file is removed and the file is left alone.
+func Write(filename string, perm os.FileMode, write func(f *os.File) error) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	partFilename := filename + ".part"
+	handle, err := os.OpenFile(partFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	err = write(handle)
+	closeErr := handle.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partFilename)
+		return err
+	}
+	return os.Rename(partFilename, filename)
+}
+
+// WriteFile is like os.WriteFile, but by way of a part file.
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return Write(filename, perm, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,59 @@
+package partfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a", "b", "file.txt")
+	err := WriteFile(filename, []byte("first"), 0600)
+	if err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	err = WriteFile(filename, []byte("second"), 0600)
+	if err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("could not read: %v", err)
+	}
+	if string(contents) != "second" {
+		t.Errorf("wrong contents: %q", contents)
+	}
+	if _, err := os.Stat(filename + ".part"); !os.IsNotExist(err) {
+		t.Errorf("the part file was left behind")
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	err := WriteFile(filename, []byte("first"), 0644)
+	if err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+
+	failure := errors.New("failure")
+	err = Write(filename, 0644, func(f *os.File) error {
+		_, _ = f.Write([]byte("half of the sec"))
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the failure; got: %v", err)
+	}
+
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("could not read: %v", err)
+	}
+	if string(contents) != "first" {
+		t.Errorf("the file was replaced: %q", contents)
+	}
+	if _, err := os.Stat(filename + ".part"); !os.IsNotExist(err) {
+		t.Errorf("the part file was left behind")
+	}
+}
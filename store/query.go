@@ -0,0 +1,203 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+// TaskFilter selects tasks; the zero value selects every task.
+type TaskFilter struct {
+	DeviceIDs []string                        // If set, only the tasks of these devices are selected.
+	Status    func(angeltrax.TaskStatus) bool // If set, only the tasks whose status this returns true for are selected.
+	Since     time.Time                       // If set, only the tasks whose time (see Task.Time) is at or after this are selected.
+	Until     time.Time                       // If set, only the tasks whose time is before this are selected.
+	Location  *time.Location                  // The location of the server's times; this defaults to the local time zone.
+}
+
+// Match returns true if the filter selects the task.
+func (f TaskFilter) Match(task Task) bool {
+	if len(f.DeviceIDs) > 0 {
+		var found bool
+		for _, deviceID := range f.DeviceIDs {
+			if task.DeviceID == deviceID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Status != nil && !f.Status(task.Status) {
+		return false
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		t := task.Time(f.location())
+		if !f.Since.IsZero() && t.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && !t.Before(f.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f TaskFilter) location() *time.Location {
+	if f.Location == nil {
+		return time.Local
+	}
+	return f.Location
+}
+
+// FindTasks returns the tasks that the filter selects, sorted by ID.
+func (s *Store) FindTasks(filter TaskFilter) []Task {
+	var output []Task
+	for _, task := range s.Tasks() {
+		if filter.Match(task) {
+			output = append(output, task)
+		}
+	}
+	return output
+}
+
+// Interval is the length of the buckets of CountTasks.
+type Interval string
+
+const (
+	IntervalDay     Interval = "day"
+	IntervalWeek    Interval = "week" // Weeks start on Monday.
+	IntervalMonth   Interval = "month"
+	IntervalQuarter Interval = "quarter"
+	IntervalYear    Interval = "year"
+)
+
+// Intervals are the known intervals.
+var Intervals = []Interval{IntervalDay, IntervalWeek, IntervalMonth, IntervalQuarter, IntervalYear}
+
+// ParseInterval parses the name of an interval.
+func ParseInterval(value string) (Interval, error) {
+	for _, interval := range Intervals {
+		if string(interval) == value {
+			return interval, nil
+		}
+	}
+	return "", fmt.Errorf("invalid interval %q", value)
+}
+
+// Start returns the start of the interval that the time is in (in the time's location).
+func (i Interval) Start(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch i {
+	case IntervalWeek:
+		weekday := (int(t.Weekday()) + 6) % 7 // Monday is zero.
+		return time.Date(year, month, day-weekday, 0, 0, 0, 0, t.Location())
+	case IntervalMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case IntervalQuarter:
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, t.Location())
+	case IntervalYear:
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// Next returns the start of the interval after the one that starts at the given time.
+func (i Interval) Next(start time.Time) time.Time {
+	switch i {
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	case IntervalQuarter:
+		return start.AddDate(0, 3, 0)
+	case IntervalYear:
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Bucket is the number of tasks in an interval, broken down by status.
+type Bucket struct {
+	Start    time.Time
+	End      time.Time
+	Total    int
+	Finished int // TaskStatusFinished
+	NoFiles  int // TaskStatusNoFiles
+	Failed   int // Any status for which TaskStatus.IsFailure is true.
+	Running  int // Any other status that isn't terminal.
+	Other    int // Everything else (such as deleted tasks).
+}
+
+// Add counts a task in the bucket.
+func (b *Bucket) Add(status angeltrax.TaskStatus) {
+	b.Total++
+	switch {
+	case status == angeltrax.TaskStatusFinished:
+		b.Finished++
+	case status == angeltrax.TaskStatusNoFiles:
+		b.NoFiles++
+	case status.IsFailure():
+		b.Failed++
+	case !status.IsTerminal():
+		b.Running++
+	default:
+		b.Other++
+	}
+}
+
+// CountTasks counts the tasks in each interval between the two times (by Task.Time, in the given location).
+//
+// Every interval between the two times has a bucket, even if it's empty, so that the result can be charted as is;
+// the first and last buckets are cut off at the two times.  If either time is zero, the buckets only cover the
+// tasks.
+func CountTasks(tasks []Task, interval Interval, since time.Time, until time.Time, location *time.Location) []Bucket {
+	if location == nil {
+		location = time.Local
+	}
+	times := make([]time.Time, len(tasks))
+	for i, task := range tasks {
+		times[i] = task.Time(location).In(location)
+	}
+	if since.IsZero() {
+		for _, t := range times {
+			if since.IsZero() || t.Before(since) {
+				since = interval.Start(t)
+			}
+		}
+	}
+	if until.IsZero() {
+		for _, t := range times {
+			if until.IsZero() || !t.Before(until) {
+				until = interval.Next(interval.Start(t))
+			}
+		}
+	}
+	if since.IsZero() || until.IsZero() {
+		return nil
+	}
+
+	var buckets []Bucket
+	for start := interval.Start(since.In(location)); start.Before(until); start = interval.Next(start) {
+		bucket := Bucket{Start: start, End: interval.Next(start)}
+		if bucket.Start.Before(since) {
+			bucket.Start = since
+		}
+		if bucket.End.After(until) {
+			bucket.End = until
+		}
+		buckets = append(buckets, bucket)
+	}
+	for i, t := range times {
+		j := sort.Search(len(buckets), func(j int) bool {
+			return t.Before(buckets[j].End)
+		})
+		if j < len(buckets) && !t.Before(buckets[j].Start) {
+			buckets[j].Add(tasks[i].Status)
+		}
+	}
+	return buckets
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+func date(year int, month time.Month, day int, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestIntervalStart(t *testing.T) {
+	rows := []struct {
+		interval Interval
+		t        time.Time
+		start    time.Time
+	}{
+		{IntervalDay, date(2023, 4, 5, 13), date(2023, 4, 5, 0)},
+		{IntervalWeek, date(2023, 4, 3, 0), date(2023, 4, 3, 0)},    // A Monday.
+		{IntervalWeek, date(2023, 4, 5, 13), date(2023, 4, 3, 0)},   // A Wednesday.
+		{IntervalWeek, date(2023, 4, 9, 23), date(2023, 4, 3, 0)},   // A Sunday.
+		{IntervalWeek, date(2023, 1, 1, 12), date(2022, 12, 26, 0)}, // A Sunday in the last week of the year before.
+		{IntervalMonth, date(2023, 4, 30, 23), date(2023, 4, 1, 0)},
+		{IntervalQuarter, date(2023, 1, 1, 0), date(2023, 1, 1, 0)},
+		{IntervalQuarter, date(2023, 2, 15, 12), date(2023, 1, 1, 0)},
+		{IntervalQuarter, date(2023, 6, 30, 23), date(2023, 4, 1, 0)},
+		{IntervalQuarter, date(2023, 9, 1, 0), date(2023, 7, 1, 0)},
+		{IntervalQuarter, date(2023, 12, 31, 23), date(2023, 10, 1, 0)},
+		{IntervalYear, date(2023, 12, 31, 23), date(2023, 1, 1, 0)},
+	}
+	for _, row := range rows {
+		if start := row.interval.Start(row.t); !start.Equal(row.start) {
+			t.Errorf("%s of %s: got %s (expected %s)", row.interval, row.t, start, row.start)
+		}
+	}
+
+	// The start is in the time's own location.
+	location := time.FixedZone("UTC-5", -5*60*60)
+	if start := IntervalDay.Start(time.Date(2023, 4, 5, 22, 0, 0, 0, location)); !start.Equal(time.Date(2023, 4, 5, 0, 0, 0, 0, location)) {
+		t.Errorf("wrong start: %s", start)
+	}
+}
+
+// finishedTask returns a task that finished at the given time.
+func finishedTask(taskID int, deviceID string, status angeltrax.TaskStatus, finished time.Time) Task {
+	return Task{TaskID: taskID, DeviceID: deviceID, Status: status, FinishTime: finished.Format(angeltrax.RecordingTimeFormat)}
+}
+
+func TestCountTasks(t *testing.T) {
+	tasks := []Task{
+		finishedTask(1, "D1", angeltrax.TaskStatusFinished, date(2023, 4, 5, 11)), // Before the start.
+		finishedTask(2, "D1", angeltrax.TaskStatusFinished, date(2023, 4, 5, 12)), // Right at the start.
+		finishedTask(3, "D1", angeltrax.TaskStatusDownloadFailed, date(2023, 4, 12, 0)),
+		finishedTask(4, "D1", angeltrax.TaskStatusDownloading, date(2023, 4, 16, 23)),
+		finishedTask(5, "D1", angeltrax.TaskStatusNoFiles, date(2023, 4, 18, 23)),
+		finishedTask(6, "D1", angeltrax.TaskStatusFinished, date(2023, 4, 19, 0)), // Right at the end.
+	}
+	since := date(2023, 4, 5, 12) // A Wednesday.
+	until := date(2023, 4, 19, 0) // The next Wednesday but one.
+
+	// The first and last weeks are cut off at the two times.
+	buckets := CountTasks(tasks, IntervalWeek, since, until, time.UTC)
+	expected := []Bucket{
+		{Start: since, End: date(2023, 4, 10, 0), Total: 1, Finished: 1},
+		{Start: date(2023, 4, 10, 0), End: date(2023, 4, 17, 0), Total: 2, Failed: 1, Running: 1},
+		{Start: date(2023, 4, 17, 0), End: until, Total: 1, NoFiles: 1},
+	}
+	if len(buckets) != len(expected) {
+		t.Fatalf("wrong number of buckets: %+v", buckets)
+	}
+	for i, bucket := range buckets {
+		if bucket != expected[i] {
+			t.Errorf("bucket %d: got %+v (expected %+v)", i, bucket, expected[i])
+		}
+	}
+
+	// Without the times, the buckets cover the tasks, including the empty week between them.
+	buckets = CountTasks([]Task{tasks[0], tasks[5]}, IntervalWeek, time.Time{}, time.Time{}, time.UTC)
+	if len(buckets) != 3 || !buckets[0].Start.Equal(date(2023, 4, 3, 0)) || !buckets[2].End.Equal(date(2023, 4, 24, 0)) {
+		t.Fatalf("wrong buckets: %+v", buckets)
+	}
+	if buckets[0].Total != 1 || buckets[1].Total != 0 || buckets[2].Total != 1 {
+		t.Errorf("wrong counts: %+v", buckets)
+	}
+
+	if buckets := CountTasks(nil, IntervalWeek, time.Time{}, time.Time{}, time.UTC); buckets != nil {
+		t.Errorf("expected no buckets: %+v", buckets)
+	}
+}
+
+func TestTaskFilter(t *testing.T) {
+	since := date(2023, 4, 5, 0)
+	until := date(2023, 4, 6, 0)
+	rows := []struct {
+		name   string
+		filter TaskFilter
+		task   Task
+		match  bool
+	}{
+		{"zero filter", TaskFilter{}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, since), true},
+		{"at the start", TaskFilter{Since: since, Until: until}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, since), true},
+		{"before the start", TaskFilter{Since: since, Until: until}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, since.Add(-time.Second)), false},
+		{"just before the end", TaskFilter{Since: since, Until: until}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, until.Add(-time.Second)), true},
+		{"at the end", TaskFilter{Since: since, Until: until}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, until), false},
+		{"only the end", TaskFilter{Until: until}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, until), false},
+		{"device", TaskFilter{DeviceIDs: []string{"D2", "D1"}}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, since), true},
+		{"other device", TaskFilter{DeviceIDs: []string{"D2"}}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, since), false},
+		{"status", TaskFilter{Status: angeltrax.TaskStatus.IsFailure}, finishedTask(1, "D1", angeltrax.TaskStatusFinished, since), false},
+		{
+			name:   "the end in another location",
+			filter: TaskFilter{Until: until, Location: time.FixedZone("UTC+2", 2*60*60)},
+			task:   finishedTask(1, "D1", angeltrax.TaskStatusFinished, date(2023, 4, 6, 1)), // 23:00 UTC.
+			match:  true,
+		},
+	}
+	for _, row := range rows {
+		if match := row.filter.Match(row.task); match != row.match {
+			t.Errorf("%s: match: %t (expected %t)", row.name, match, row.match)
+		}
+	}
+}
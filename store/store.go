@@ -0,0 +1,357 @@
+// Package store keeps a local copy of a server's groups, devices, and tasks in a file.
+//
+// The tasks are kept even after the server prunes them, along with every status that they were seen in, so that
+// the history of the tasks can be queried long after the fact (and without the server).
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+	"github.com/tekkamanendless/angeltrax/partfile"
+)
+
+// fileVersion is the version of the file format.
+const fileVersion = 1
+
+// Store is a local copy of a server's groups, devices, and tasks.
+//
+// Changes are only kept in memory until Save is called.
+type Store struct {
+	filename string
+	mutex    sync.Mutex // This protects the data so that the store may be used concurrently.
+	data     storeData
+	index    map[int]int // This maps a task ID to its index in the tasks; it is built when it's first needed.
+}
+
+// storeData is what's in the file.
+type storeData struct {
+	Version             int                      `json:"version"`
+	Server              string                   `json:"server"`
+	GroupsSynced        time.Time                `json:"groupsSynced"`
+	Groups              []angeltrax.CenterGroup  `json:"groups"`
+	DevicesSynced       time.Time                `json:"devicesSynced"`
+	Devices             []angeltrax.CenterDevice `json:"devices"`
+	MonitorSynced       time.Time                `json:"monitorSynced"`
+	GlobalReportSynced  time.Time                `json:"globalReportSynced"`
+	GlobalReportThrough string                   `json:"globalReportThrough,omitempty"` // yyyy-mm-dd; the last day of the last global report.
+	Tasks               []Task                   `json:"tasks"`
+}
+
+// Task is what the store knows about a task.
+type Task struct {
+	TaskID      int                  `json:"taskId"`
+	DeviceID    string               `json:"deviceId"`
+	CarLicense  string               `json:"carLicense"`
+	TaskName    string               `json:"taskName"`
+	Period      angeltrax.TaskPeriod `json:"period"`
+	TaskType    angeltrax.TaskType   `json:"taskType"`
+	Date        string               `json:"date,omitempty"`       // yyyy-mm-dd
+	StartTime   string               `json:"startTime,omitempty"`  // hh:mm:ss
+	EndTime     string               `json:"endTime,omitempty"`    // hh:mm:ss
+	ChannelList string               `json:"channels,omitempty"`   // CSV of channel numbers, starting from "1".
+	CreateTime  string               `json:"createTime,omitempty"` // yyyy-mm-dd hh:mm:ss
+	FinishTime  string               `json:"finishTime,omitempty"` // yyyy-mm-dd hh:mm:ss
+	Username    string               `json:"username,omitempty"`
+	Status      angeltrax.TaskStatus `json:"status"`
+	Monitored   bool                 `json:"monitored"` // This is true if the task was in the last monitor listing of its device.
+	FirstSeen   time.Time            `json:"firstSeen"`
+	LastSeen    time.Time            `json:"lastSeen"`
+	History     []StatusChange       `json:"history"` // Every status that the task was seen in, oldest first.
+}
+
+// StatusChange is when a task was first seen in a status.
+type StatusChange struct {
+	Time   time.Time            `json:"time"`
+	Status angeltrax.TaskStatus `json:"status"`
+}
+
+// Time returns when the task reached its current status: the finish time (if the server has one), or else when the
+// store first saw the status.  The server's times are parsed in the given location.
+func (t Task) Time(location *time.Location) time.Time {
+	if t.FinishTime != "" {
+		if finishTime, err := time.ParseInLocation(angeltrax.RecordingTimeFormat, t.FinishTime, location); err == nil {
+			return finishTime
+		}
+	}
+	if len(t.History) > 0 {
+		return t.History[len(t.History)-1].Time
+	}
+	return t.FirstSeen
+}
+
+// MonitorRow returns the task as the server's monitor would list it.
+func (t Task) MonitorRow() angeltrax.MonitorAutoDownloadRow {
+	return angeltrax.MonitorAutoDownloadRow{
+		TaskID:      t.TaskID,
+		Status:      t.Status,
+		DeviceID:    t.DeviceID,
+		CarLicense:  t.CarLicense,
+		TaskName:    t.TaskName,
+		Period:      t.Period,
+		TaskType:    t.TaskType,
+		Date:        t.Date,
+		StartTime:   t.StartTime,
+		EndTime:     t.EndTime,
+		ChannelList: t.ChannelList,
+		CreateTime:  t.CreateTime,
+	}
+}
+
+// GlobalReportRow returns the task as the server's global report would list it.
+func (t Task) GlobalReportRow() angeltrax.GlobalReportAutoDownloadRow {
+	return angeltrax.GlobalReportAutoDownloadRow{
+		TaskID:      t.TaskID,
+		Status:      t.Status,
+		DeviceID:    t.DeviceID,
+		CarLicense:  t.CarLicense,
+		TaskName:    t.TaskName,
+		Period:      t.Period,
+		TaskType:    t.TaskType,
+		Date:        t.Date,
+		StartTime:   t.StartTime,
+		EndTime:     t.EndTime,
+		ChannelList: t.ChannelList,
+		CreateTime:  t.CreateTime,
+		FinishTime:  t.FinishTime,
+		Username:    t.Username,
+	}
+}
+
+// Open loads the store from the file; if there is no such file, the store starts out empty (and the file is
+// created by Save).
+//
+// A store belongs to a single server; if the server is given and the file is for a different one, this fails.
+func Open(filename string, server string) (*Store, error) {
+	s := &Store{
+		filename: filename,
+		data: storeData{
+			Version: fileVersion,
+			Server:  server,
+		},
+	}
+
+	contents, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data storeData
+	err = json.Unmarshal(contents, &data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse store %q: %w", filename, err)
+	}
+	if data.Version > fileVersion {
+		return nil, fmt.Errorf("store %q is version %d; this only understands up to version %d", filename, data.Version, fileVersion)
+	}
+	if server != "" && data.Server != "" && data.Server != server {
+		return nil, fmt.Errorf("store %q is for server %q, not %q", filename, data.Server, server)
+	}
+	if data.Server == "" {
+		data.Server = server
+	}
+	data.Version = fileVersion
+	s.data = data
+	return s, nil
+}
+
+// Filename returns the name of the store's file.
+func (s *Store) Filename() string {
+	return s.filename
+}
+
+// Server returns the server that the store belongs to.
+func (s *Store) Server() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.data.Server
+}
+
+// Save writes the store to its file.
+//
+// The file is written to a temporary file first so that a failed save doesn't lose the history.
+func (s *Store) Save() error {
+	s.mutex.Lock()
+	contents, err := json.MarshalIndent(s.data, "", "   ")
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return partfile.WriteFile(s.filename, contents, 0600)
+}
+
+// Groups returns the groups, along with when they were synced (this is zero if they never were).
+func (s *Store) Groups() ([]angeltrax.CenterGroup, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]angeltrax.CenterGroup{}, s.data.Groups...), s.data.GroupsSynced
+}
+
+// SetGroups replaces the groups.
+func (s *Store) SetGroups(groups []angeltrax.CenterGroup, synced time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Groups = append([]angeltrax.CenterGroup{}, groups...)
+	s.data.GroupsSynced = synced
+}
+
+// Devices returns the devices, along with when they were synced (this is zero if they never were).
+func (s *Store) Devices() ([]angeltrax.CenterDevice, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]angeltrax.CenterDevice{}, s.data.Devices...), s.data.DevicesSynced
+}
+
+// SetDevices replaces the devices.
+func (s *Store) SetDevices(devices []angeltrax.CenterDevice, synced time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Devices = append([]angeltrax.CenterDevice{}, devices...)
+	s.data.DevicesSynced = synced
+}
+
+// Tasks returns every task, sorted by ID.
+func (s *Store) Tasks() []Task {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Task{}, s.data.Tasks...)
+}
+
+// SyncTimes returns when the monitor and the global report were last synced, and the last day of the last global
+// report (yyyy-mm-dd).
+func (s *Store) SyncTimes() (time.Time, time.Time, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.data.MonitorSynced, s.data.GlobalReportSynced, s.data.GlobalReportThrough
+}
+
+// SyncResult is what a sync of tasks changed.
+type SyncResult struct {
+	Seen    int // The number of tasks in the results.
+	New     int // The number of tasks that the store didn't have.
+	Changed int // The number of tasks that the store had, but with a different status.
+}
+
+// Add adds another result to this one.
+func (r *SyncResult) Add(other SyncResult) {
+	r.Seen += other.Seen
+	r.New += other.New
+	r.Changed += other.Changed
+}
+
+// SetMonitorTasks records the monitor listing of a device.
+//
+// The device's tasks that aren't in the listing any more are kept, but they're no longer marked as monitored.
+func (s *Store) SetMonitorTasks(deviceID string, rows []angeltrax.MonitorAutoDownloadRow, synced time.Time) SyncResult {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	listed := map[int]bool{}
+	var result SyncResult
+	for _, row := range rows {
+		listed[row.TaskID] = true
+		task := s.task(row.TaskID, synced, &result)
+		task.DeviceID = row.DeviceID
+		task.CarLicense = row.CarLicense
+		task.TaskName = row.TaskName
+		task.Period = row.Period
+		task.TaskType = row.TaskType
+		task.Date = row.Date
+		task.StartTime = row.StartTime
+		task.EndTime = row.EndTime
+		task.ChannelList = row.ChannelList
+		task.CreateTime = row.CreateTime
+		task.Monitored = true
+		observe(task, row.Status, synced, &result)
+	}
+	for i := range s.data.Tasks {
+		task := &s.data.Tasks[i]
+		if task.DeviceID == deviceID && !listed[task.TaskID] {
+			task.Monitored = false
+		}
+	}
+	s.sortTasks()
+	s.data.MonitorSynced = synced
+	return result
+}
+
+// AddGlobalReportTasks records the global report of a device; through is the last day of the report (yyyy-mm-dd).
+func (s *Store) AddGlobalReportTasks(rows []angeltrax.GlobalReportAutoDownloadRow, through string, synced time.Time) SyncResult {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var result SyncResult
+	for _, row := range rows {
+		task := s.task(row.TaskID, synced, &result)
+		task.DeviceID = row.DeviceID
+		task.CarLicense = row.CarLicense
+		task.TaskName = row.TaskName
+		task.Period = row.Period
+		task.TaskType = row.TaskType
+		task.Date = row.Date
+		task.StartTime = row.StartTime
+		task.EndTime = row.EndTime
+		task.ChannelList = row.ChannelList
+		task.CreateTime = row.CreateTime
+		if row.FinishTime != "" {
+			task.FinishTime = row.FinishTime
+		}
+		if row.Username != "" {
+			task.Username = row.Username
+		}
+		observe(task, row.Status, synced, &result)
+	}
+	s.sortTasks()
+	s.data.GlobalReportSynced = synced
+	if through > s.data.GlobalReportThrough {
+		s.data.GlobalReportThrough = through
+	}
+	return result
+}
+
+// task returns the task with the given ID, adding it if it's new.
+//
+// The pointer is only good until the next task is added.
+func (s *Store) task(taskID int, seen time.Time, result *SyncResult) *Task {
+	result.Seen++
+	if s.index == nil {
+		s.index = map[int]int{}
+		for i, task := range s.data.Tasks {
+			s.index[task.TaskID] = i
+		}
+	}
+	if i, ok := s.index[taskID]; ok {
+		return &s.data.Tasks[i]
+	}
+	result.New++
+	s.data.Tasks = append(s.data.Tasks, Task{TaskID: taskID, FirstSeen: seen})
+	s.index[taskID] = len(s.data.Tasks) - 1
+	return &s.data.Tasks[len(s.data.Tasks)-1]
+}
+
+// observe records the status that the task was seen in.
+func observe(task *Task, status angeltrax.TaskStatus, seen time.Time, result *SyncResult) {
+	if len(task.History) > 0 && task.History[len(task.History)-1].Status != status {
+		result.Changed++
+	}
+	if len(task.History) == 0 || task.History[len(task.History)-1].Status != status {
+		task.History = append(task.History, StatusChange{Time: seen, Status: status})
+	}
+	task.Status = status
+	task.LastSeen = seen
+}
+
+func (s *Store) sortTasks() {
+	sort.SliceStable(s.data.Tasks, func(i, j int) bool {
+		return s.data.Tasks[i].TaskID < s.data.Tasks[j].TaskID
+	})
+	s.index = nil
+}
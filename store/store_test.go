@@ -0,0 +1,102 @@
+package store
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tekkamanendless/angeltrax/angeltrax"
+)
+
+func TestObserve(t *testing.T) {
+	first := time.Date(2023, 4, 5, 6, 0, 0, 0, time.UTC)
+	var task Task
+	steps := []struct {
+		status  angeltrax.TaskStatus
+		changed int
+		history int
+	}{
+		{angeltrax.TaskStatusWaiting, 0, 1}, // The first status isn't a change.
+		{angeltrax.TaskStatusWaiting, 0, 1},
+		{angeltrax.TaskStatusDownloading, 1, 2},
+		{angeltrax.TaskStatusDownloading, 0, 2},
+		{angeltrax.TaskStatusWaiting, 1, 3}, // Going back is still a change.
+	}
+	for i, step := range steps {
+		seen := first.Add(time.Duration(i) * time.Minute)
+		var result SyncResult
+		observe(&task, step.status, seen, &result)
+		if result.Changed != step.changed {
+			t.Errorf("step %d: wrong change count: %d", i, result.Changed)
+		}
+		if len(task.History) != step.history {
+			t.Errorf("step %d: wrong history: %+v", i, task.History)
+		}
+		if task.Status != step.status || !task.LastSeen.Equal(seen) {
+			t.Errorf("step %d: wrong task: %+v", i, task)
+		}
+	}
+	// Each entry is when the status was first seen.
+	for i, expected := range []time.Time{first, first.Add(2 * time.Minute), first.Add(4 * time.Minute)} {
+		if !task.History[i].Time.Equal(expected) {
+			t.Errorf("history %d: wrong time: %s", i, task.History[i].Time)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "store.json")
+	s, err := Open(filename, "cms.example.com")
+	if err != nil {
+		t.Fatalf("could not open the store: %v", err)
+	}
+
+	synced := time.Date(2023, 4, 5, 6, 0, 0, 0, time.UTC)
+	row := func(taskID int, status angeltrax.TaskStatus) angeltrax.MonitorAutoDownloadRow {
+		return angeltrax.MonitorAutoDownloadRow{TaskID: taskID, DeviceID: "D1", CarLicense: "BUS-1", TaskName: "nightly", Status: status}
+	}
+	result := s.SetMonitorTasks("D1", []angeltrax.MonitorAutoDownloadRow{row(2, angeltrax.TaskStatusWaiting), row(1, angeltrax.TaskStatusWaiting)}, synced)
+	if result != (SyncResult{Seen: 2, New: 2}) {
+		t.Errorf("wrong result: %+v", result)
+	}
+	result = s.SetMonitorTasks("D1", []angeltrax.MonitorAutoDownloadRow{row(2, angeltrax.TaskStatusDownloading)}, synced.Add(time.Minute))
+	if result != (SyncResult{Seen: 1, Changed: 1}) {
+		t.Errorf("wrong result: %+v", result)
+	}
+	result = s.AddGlobalReportTasks([]angeltrax.GlobalReportAutoDownloadRow{
+		{TaskID: 1, DeviceID: "D1", CarLicense: "BUS-1", TaskName: "nightly", Status: angeltrax.TaskStatusFinished, FinishTime: "2023-04-05 06:30:00"},
+	}, "2023-04-05", synced.Add(time.Hour))
+	if result != (SyncResult{Seen: 1, Changed: 1}) {
+		t.Errorf("wrong result: %+v", result)
+	}
+
+	err = s.Save()
+	if err != nil {
+		t.Fatalf("could not save the store: %v", err)
+	}
+	s, err = Open(filename, "cms.example.com")
+	if err != nil {
+		t.Fatalf("could not open the store again: %v", err)
+	}
+
+	tasks := s.Tasks()
+	if len(tasks) != 2 || tasks[0].TaskID != 1 || tasks[1].TaskID != 2 {
+		t.Fatalf("wrong tasks: %+v", tasks)
+	}
+	// Task 1 fell out of the monitor listing, but the global report still found it.
+	if task := tasks[0]; task.Monitored || task.Status != angeltrax.TaskStatusFinished || task.FinishTime != "2023-04-05 06:30:00" || len(task.History) != 2 {
+		t.Errorf("wrong task 1: %+v", task)
+	}
+	if task := tasks[1]; !task.Monitored || task.Status != angeltrax.TaskStatusDownloading || len(task.History) != 2 || !task.FirstSeen.Equal(synced) {
+		t.Errorf("wrong task 2: %+v", task)
+	}
+	if _, _, through := s.SyncTimes(); through != "2023-04-05" {
+		t.Errorf("wrong global report day: %s", through)
+	}
+
+	_, err = Open(filename, "other.example.com")
+	if err == nil || !strings.Contains(err.Error(), "other.example.com") {
+		t.Errorf("expected a server mismatch; got: %v", err)
+	}
+}